@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/eugenepaniot/mysql-tables-to-gcs/pkg/backup"
+)
+
+func benchUploadCommand(args []string) int {
+	fs := flag.NewFlagSet("bench-upload", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s bench-upload [options]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	var (
+		bucketName   string
+		transports   string
+		workers      uint
+		sizeMiB      uint
+		chunkSizeMiB uint
+	)
+	fs.StringVar(&bucketName, "bucketName", "", "GCS bucket name")
+	gcs := gcsFlags(fs)
+	fs.StringVar(&transports, "transports", "http,grpc", "Comma-separated GCS transports to measure, overriding -gcsTransport")
+	fs.UintVar(&workers, "workers", 4, "Number of objects uploaded at the same time")
+	fs.UintVar(&sizeMiB, "sizeMiB", 256, "MiB of synthetic data per object")
+	fs.UintVar(&chunkSizeMiB, "chunkSizeMiB", 0, "MiB buffered per upload request (default: the client's 16 MiB)")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return exitConfigError
+	}
+
+	if bucketName == "" || workers == 0 || sizeMiB == 0 || len(positional) != 0 {
+		fs.Usage()
+		return exitConfigError
+	}
+
+	ctx := context.Background()
+	host, _ := os.Hostname()
+	fmt.Printf("transport\tobjects\tsize\telapsed\tMB/s\tslowest MB/s\n")
+
+	code := exitSuccess
+	for _, transport := range strings.Split(transports, ",") {
+		options := *gcs
+		options.transport = strings.TrimSpace(transport)
+
+		client, err := newStorageClient(ctx, int(workers), &options)
+		if err != nil {
+			log.Printf("Failed to create GCS client: %v\n", err)
+			return exitConfigError
+		}
+
+		result, err := backup.BenchUpload(ctx, backup.NewGCSStore(client.Bucket(bucketName)), backup.BenchConfig{
+			Prefix:    fmt.Sprintf("_bench/%s/%s", host, backup.NewRunID(time.Now())),
+			Objects:   int(workers),
+			Size:      int64(sizeMiB) << 20,
+			ChunkSize: int(chunkSizeMiB) << 20,
+		})
+		client.Close()
+		if err != nil {
+			log.Printf("Benchmark of the %s transport failed: %v\n", options.transport, err)
+			code = exitFailure
+			continue
+		}
+		fmt.Printf("%s\t%d\t%s\t%s\t%.1f\t%.1f\n", options.transport, workers, backup.FormatBytes(result.Bytes), result.Elapsed.Round(time.Millisecond), result.ThroughputMBps, result.SlowestMBps)
+	}
+	return code
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/eugenepaniot/mysql-tables-to-gcs/pkg/backup"
+)
+
+func cloneCommand(args []string) int {
+	fs := flag.NewFlagSet("clone", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s clone [options]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	var (
+		dbUser     string
+		dbPass     string
+		dbHost     string
+		dbPort     string
+		dbTLS      string
+		bucketName string
+		host       string
+		cloneDir   string
+		keepDir    bool
+	)
+	fs.StringVar(&dbUser, "dbUser", "", "MySQL database username")
+	fs.StringVar(&dbPass, "dbPass", "", "MySQL database password")
+	fs.StringVar(&dbHost, "dbHost", "localhost", "MySQL database host")
+	fs.StringVar(&dbPort, "dbPort", "3306", "MySQL database port")
+	fs.StringVar(&dbTLS, "dbTLS", "", "TLS mode for MySQL connections: preferred, skip-verify or true (verify the server certificate)")
+	fs.StringVar(&bucketName, "bucketName", "", "GCS bucket name")
+	gcs := gcsFlags(fs)
+	fs.StringVar(&host, "host", "", "Host name to store the clone under (default: this host)")
+	fs.StringVar(&cloneDir, "cloneDir", "", "Absolute path, not yet existing, the server clones its data directory into; it must be readable here")
+	fs.BoolVar(&keepDir, "keepDir", false, "Keep the clone directory after it was uploaded")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return exitConfigError
+	}
+
+	if dbUser == "" || dbPass == "" || bucketName == "" || cloneDir == "" || len(positional) != 0 {
+		fs.Usage()
+		return exitConfigError
+	}
+
+	if !validTLSMode(dbTLS) {
+		log.Printf("Invalid -dbTLS %q: must be preferred, skip-verify or true\n", dbTLS)
+		return exitConfigError
+	}
+
+	if host == "" {
+		if host, err = os.Hostname(); err != nil {
+			log.Printf("Failed to get hostname: %v\n", err)
+			return exitFailure
+		}
+	}
+
+	ctx := context.Background()
+	client, err := newStorageClient(ctx, 1, gcs)
+	if err != nil {
+		log.Printf("Failed to create GCS client: %v\n", err)
+		return exitConfigError
+	}
+	defer client.Close()
+
+	result, err := backup.Clone(ctx, backup.CloneConfig{
+		Connection: backup.Connection{
+			User:     dbUser,
+			Password: dbPass,
+			Host:     dbHost,
+			Port:     dbPort,
+			TLS:      dbTLS,
+		},
+		Store:    backup.NewGCSStore(client.Bucket(bucketName)),
+		Hostname: host,
+		Dir:      cloneDir,
+		KeepDir:  keepDir,
+	})
+	if err != nil {
+		log.Printf("Clone failed: %v\n", err)
+		return exitFailure
+	}
+
+	log.Printf("Clone of %d files uploaded to gs://%s/%s in %s, %d bytes compressed to %d\n", result.Files, bucketName, result.Object, result.Elapsed.Round(time.Second), result.UncompressedBytes, result.CompressedBytes)
+
+	return exitSuccess
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/eugenepaniot/mysql-tables-to-gcs/pkg/backup"
+)
+
+func compactCommand(args []string) int {
+	fs := flag.NewFlagSet("compact", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s compact [options] <host>/<generation>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	var (
+		bucketName    string
+		workers       uint
+		signingKey    string
+		kmsSigningKey string
+	)
+	fs.StringVar(&bucketName, "bucketName", "", "GCS bucket name")
+	gcs := gcsFlags(fs)
+	fs.UintVar(&workers, "workers", 4, "Number of dumps rewritten at the same time")
+	fs.StringVar(&signingKey, "signingKey", "", "PEM private key file to re-sign signed manifests with")
+	fs.StringVar(&kmsSigningKey, "kmsSigningKey", "", "Cloud KMS asymmetric signing key version to re-sign signed manifests with")
+
+	paths, err := parseArgs(fs, args)
+	if err != nil {
+		return exitConfigError
+	}
+
+	if bucketName == "" || len(paths) == 0 {
+		fs.Usage()
+		return exitConfigError
+	}
+
+	ctx := context.Background()
+	signer, err := newManifestSigner(ctx, gcs, signingKey, kmsSigningKey)
+	if err != nil {
+		log.Printf("%v\n", err)
+		return exitConfigError
+	}
+
+	client, err := newStorageClient(ctx, int(workers), gcs)
+	if err != nil {
+		log.Printf("Failed to create GCS client: %v\n", err)
+		return exitConfigError
+	}
+	defer client.Close()
+
+	store := backup.NewGCSStore(client.Bucket(bucketName))
+	cfg := backup.CompactConfig{Workers: int(workers), Signer: signer}
+
+	code := exitSuccess
+	for _, path := range paths {
+		path = strings.Trim(path, "/")
+		compacted, err := backup.Compact(ctx, store, path, cfg)
+		if err != nil {
+			log.Printf("Failed to compact %s: %v\n", path, err)
+			code = exitFailure
+			continue
+		}
+		fmt.Printf("%s: %d deduplicated dump(s) compacted\n", path, compacted)
+	}
+	return code
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/eugenepaniot/mysql-tables-to-gcs/pkg/backup"
+)
+
+func downloadCommand(args []string) int {
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s download [options] <database> <table>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	var (
+		bucketName string
+		host       string
+		generation string
+		output     string
+	)
+	fs.StringVar(&bucketName, "bucketName", "", "GCS bucket name")
+	gcs := gcsFlags(fs)
+	fs.StringVar(&host, "host", "", "Host name the backup was taken on (default: this host)")
+	fs.StringVar(&generation, "generation", "", "Backup generation (YYYY-MM-DD-HH) to download from (default: latest)")
+	fs.StringVar(&output, "output", "-", "File to write the decompressed dump to, - for stdout")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return exitConfigError
+	}
+
+	if bucketName == "" || len(positional) != 2 {
+		fs.Usage()
+		return exitConfigError
+	}
+
+	if host == "" {
+		if host, err = os.Hostname(); err != nil {
+			log.Printf("Failed to get hostname: %v\n", err)
+			return exitFailure
+		}
+	}
+
+	ctx := context.Background()
+	client, err := newStorageClient(ctx, 1, gcs)
+	if err != nil {
+		log.Printf("Failed to create GCS client: %v\n", err)
+		return exitConfigError
+	}
+	defer client.Close()
+
+	store := backup.NewGCSStore(client.Bucket(bucketName))
+
+	object, err := backup.FindTableObject(ctx, store, host, generation, positional[0], positional[1])
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+
+	w := os.Stdout
+	if output != "-" {
+		if w, err = os.Create(output); err != nil {
+			log.Printf("Failed to create %s: %v\n", output, err)
+			return exitFailure
+		}
+		defer w.Close()
+	}
+
+	log.Printf("Downloading %s\n", object.Name())
+
+	n, err := backup.Download(ctx, store, object.Name(), w)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+
+	if err := w.Sync(); err != nil && output != "-" {
+		log.Printf("Failed to write %s: %v\n", output, err)
+		return exitFailure
+	}
+
+	log.Printf("Downloaded %s (%d bytes)\n", object.Name(), n)
+
+	return exitSuccess
+}
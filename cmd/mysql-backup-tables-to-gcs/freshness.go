@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/eugenepaniot/mysql-tables-to-gcs/pkg/backup"
+)
+
+func freshnessCommand(args []string) int {
+	fs := flag.NewFlagSet("check-freshness", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s check-freshness [options]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	var (
+		bucketName string
+		host       string
+		allHosts   bool
+		database   string
+		maxAge     string
+		alertHook  string
+		format     string
+	)
+	fs.StringVar(&bucketName, "bucketName", "", "GCS bucket name")
+	gcs := gcsFlags(fs)
+	fs.StringVar(&host, "host", "", "Host name the backups were taken on (default: this host)")
+	fs.BoolVar(&allHosts, "allHosts", false, "Check the backups of every host")
+	fs.StringVar(&database, "db", "", "Only check backups of this database")
+	fs.StringVar(&maxAge, "maxAge", "26h", "Age beyond which the latest successful backup of a database is stale, e.g. 26h or 2d")
+	fs.StringVar(&alertHook, "alertHook", "", "Shell command run when a backup is stale, with BACKUP_STALE_DATABASES set to the stale <host>/<database> list")
+	fs.StringVar(&format, "format", "table", "Output format: table or json")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return exitConfigError
+	}
+
+	if bucketName == "" || len(positional) != 0 {
+		fs.Usage()
+		return exitConfigError
+	}
+
+	if format != "table" && format != "json" {
+		log.Printf("Invalid -format %q: must be table or json\n", format)
+		return exitConfigError
+	}
+
+	age, err := parseAge(maxAge)
+	if err != nil {
+		log.Printf("Invalid -maxAge %q: %v\n", maxAge, err)
+		return exitConfigError
+	}
+
+	prefix := ""
+	if !allHosts {
+		if host == "" {
+			if host, err = os.Hostname(); err != nil {
+				log.Printf("Failed to get hostname: %v\n", err)
+				return exitFailure
+			}
+		}
+		prefix = host + "/"
+	}
+
+	ctx := context.Background()
+	client, err := newStorageClient(ctx, 1, gcs)
+	if err != nil {
+		log.Printf("Failed to create GCS client: %v\n", err)
+		return exitConfigError
+	}
+	defer client.Close()
+
+	now := time.Now()
+	results, err := backup.CheckFreshness(ctx, backup.FreshnessConfig{
+		Store:     backup.NewGCSStore(client.Bucket(bucketName)),
+		Prefix:    prefix,
+		Database:  database,
+		MaxAge:    age,
+		AlertHook: alertHook,
+	}, now)
+	if results == nil && err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+
+	if err := printFreshness(os.Stdout, format, results, now); err != nil {
+		log.Printf("Failed to print backups: %v\n", err)
+		return exitFailure
+	}
+	if err != nil {
+		log.Println(err)
+	}
+
+	if len(results) == 0 {
+		log.Printf("No backups found under gs://%s/%s\n", bucketName, prefix)
+		return exitStaleBackups
+	}
+	for _, result := range results {
+		if result.Stale {
+			return exitStaleBackups
+		}
+	}
+	if err != nil {
+		return exitFailure
+	}
+
+	return exitSuccess
+}
+
+func printFreshness(w io.Writer, format string, results []backup.DatabaseFreshness, now time.Time) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tDATABASE\tGENERATION\tLAST BACKUP\tAGE\tSTATUS")
+	for _, r := range results {
+		status, generation, last, age := "ok", r.Generation, "-", "-"
+		if r.Stale {
+			status = "stale"
+		}
+		if generation == "" {
+			generation = "-"
+		} else {
+			last = r.LastBackup.Format(time.RFC3339)
+			age = now.Sub(r.LastBackup).Round(time.Minute).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Host, r.Database, generation, last, age, status)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/eugenepaniot/mysql-tables-to-gcs/pkg/backup"
+)
+
+func gcCommand(args []string) int {
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s gc [options]\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "\nUnreferenced chunks are kept while a -dedup run of the host is in progress, as it may be about to reuse them.\n\n")
+		fs.PrintDefaults()
+	}
+
+	var (
+		bucketName string
+		host       string
+		olderThan  string
+		dryRun     bool
+	)
+	fs.StringVar(&bucketName, "bucketName", "", "GCS bucket name")
+	gcs := gcsFlags(fs)
+	fs.StringVar(&host, "host", "", "Host name whose leftovers are deleted (default: this host)")
+	fs.StringVar(&olderThan, "olderThan", "7d", "Only delete objects older than this age, e.g. 7d or 48h, so that running backups are not disturbed")
+	fs.BoolVar(&dryRun, "dryRun", false, "Only print the objects that would be deleted")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return exitConfigError
+	}
+
+	if bucketName == "" || len(positional) != 0 {
+		fs.Usage()
+		return exitConfigError
+	}
+
+	age, err := parseAge(olderThan)
+	if err != nil {
+		log.Printf("Invalid -olderThan %q: %v\n", olderThan, err)
+		return exitConfigError
+	}
+
+	if host == "" {
+		if host, err = os.Hostname(); err != nil {
+			log.Printf("Failed to get hostname: %v\n", err)
+			return exitFailure
+		}
+	}
+
+	ctx := context.Background()
+	client, err := newStorageClient(ctx, 1, gcs)
+	if err != nil {
+		log.Printf("Failed to create GCS client: %v\n", err)
+		return exitConfigError
+	}
+	defer client.Close()
+
+	garbage, err := backup.CollectGarbage(ctx, backup.GCConfig{
+		Store:  backup.NewGCSStore(client.Bucket(bucketName)),
+		Host:   host,
+		Before: time.Now().Add(-age),
+		DryRun: dryRun,
+	})
+
+	action := "deleted"
+	if dryRun {
+		action = "would delete"
+	}
+	for _, object := range garbage {
+		fmt.Printf("%s\t%s\t%s\t%s\n", object.Name, backup.FormatBytes(object.Size), object.Reason, action)
+	}
+
+	if err != nil {
+		log.Printf("Garbage collection failed: %v\n", err)
+		return exitFailure
+	}
+
+	return exitSuccess
+}
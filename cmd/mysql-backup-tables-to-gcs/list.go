@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/eugenepaniot/mysql-tables-to-gcs/pkg/backup"
+)
+
+func listCommand(args []string) int {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s list [options]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	var (
+		bucketName string
+		host       string
+		allHosts   bool
+		database   string
+		since      string
+		format     string
+	)
+	fs.StringVar(&bucketName, "bucketName", "", "GCS bucket name")
+	gcs := gcsFlags(fs)
+	fs.StringVar(&host, "host", "", "Host name the backups were taken on (default: this host)")
+	fs.BoolVar(&allHosts, "allHosts", false, "List the backups of every host")
+	fs.StringVar(&database, "db", "", "Only list backups of this database")
+	fs.StringVar(&since, "since", "", "Only list backups created within this age, e.g. 7d or 12h (default: all)")
+	fs.StringVar(&format, "format", "table", "Output format: table or json")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return exitConfigError
+	}
+
+	if bucketName == "" || len(positional) != 0 {
+		fs.Usage()
+		return exitConfigError
+	}
+
+	if format != "table" && format != "json" {
+		log.Printf("Invalid -format %q: must be table or json\n", format)
+		return exitConfigError
+	}
+
+	var cutoff time.Time
+	if since != "" {
+		age, err := parseAge(since)
+		if err != nil {
+			log.Printf("Invalid -since %q: %v\n", since, err)
+			return exitConfigError
+		}
+		cutoff = time.Now().Add(-age)
+	}
+
+	prefix := ""
+	if !allHosts {
+		if host == "" {
+			if host, err = os.Hostname(); err != nil {
+				log.Printf("Failed to get hostname: %v\n", err)
+				return exitFailure
+			}
+		}
+		prefix = host + "/"
+	}
+
+	ctx := context.Background()
+	client, err := newStorageClient(ctx, 1, gcs)
+	if err != nil {
+		log.Printf("Failed to create GCS client: %v\n", err)
+		return exitConfigError
+	}
+	defer client.Close()
+
+	backups, err := backup.ListDatabaseBackups(ctx, backup.NewGCSStore(client.Bucket(bucketName)), prefix)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+
+	selected := []backup.DatabaseBackup{}
+	for _, b := range backups {
+		if database != "" && b.Database != database {
+			continue
+		}
+		if b.Created.Before(cutoff) {
+			continue
+		}
+		selected = append(selected, b)
+	}
+
+	if err := printBackups(os.Stdout, format, selected); err != nil {
+		log.Printf("Failed to print backups: %v\n", err)
+		return exitFailure
+	}
+
+	return exitSuccess
+}
+
+func printBackups(w io.Writer, format string, backups []backup.DatabaseBackup) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(backups)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tGENERATION\tDATABASE\tTABLES\tSIZE\tCREATED")
+	for _, b := range backups {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", b.Host, b.Generation, b.Database, b.Tables, backup.FormatBytes(b.Bytes), b.Created.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+// parseAge parses a duration that may also be given in days, such as 7d.
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days %q", days)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	age, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if age < 0 {
+		return 0, fmt.Errorf("negative age %s", s)
+	}
+	return age, nil
+}
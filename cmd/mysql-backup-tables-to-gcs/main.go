@@ -0,0 +1,721 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/firestore/v1"
+
+	"github.com/eugenepaniot/mysql-tables-to-gcs/pkg/backup"
+)
+
+const (
+	exitSuccess            = 0
+	exitFailure            = 1
+	exitConfigError        = 2
+	exitEnumerationFailure = 3
+	exitPartialFailure     = 4
+	exitStaleBackups       = 5
+)
+
+// Set via -ldflags "-X main.version=... -X main.commit=... -X main.date=...",
+// as done by goreleaser and the Makefile.
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+var commands = map[string]func(args []string) int{
+	"bench-upload":    benchUploadCommand,
+	"check-freshness": freshnessCommand,
+	"clone":           cloneCommand,
+	"compact":         compactCommand,
+	"download":        downloadCommand,
+	"gc":              gcCommand,
+	"list":            listCommand,
+	"prune":           pruneCommand,
+	"rekey":           rekeyCommand,
+	"report":          reportCommand,
+	"restore":         restoreCommand,
+	"sign":            signCommand,
+	"verify-manifest": verifyManifestCommand,
+}
+
+func main() {
+	backup.Version, backup.Commit, backup.Date = version, commit, date
+
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
+	backupCommand()
+}
+
+func backupCommand() {
+	var (
+		dbUser           string
+		dbPass           string
+		dbHost           string
+		dbPort           string
+		dbTLS            string
+		secondary        bool
+		allowPrimary     bool
+		shards           string
+		charset          string
+		mysqldumpPath    string
+		pureGo           bool
+		consistent       bool
+		bucketName       string
+		dest             string
+		workers          uint
+		dbLimit          uint
+		tableLimit       uint
+		skipDBs          string
+		ignoreTables     = listFlag{}
+		systemSchemas    bool
+		probeTables      bool
+		splitPartitions  bool
+		dedup            bool
+		archivePerDB     bool
+		archiveFormat    string
+		chunkRows        int64
+		nonTransactional string
+		htmlReport       bool
+		progressInterval time.Duration
+		dumpTimeout      time.Duration
+		connectTimeout   time.Duration
+		enumTimeout      time.Duration
+		killLongDumps    bool
+		killOnCancel     bool
+		deadline         time.Duration
+		window           string
+		windowMustFinish bool
+		maxRunning       uint
+		maxConnected     uint
+		loadInterval     time.Duration
+		costEstimate     bool
+		storageClass     string
+		storagePrice     float64
+		requireVersions  bool
+		minRetention     string
+		requirePAP       bool
+		bucketPolicy     string
+		checkPermissions bool
+		checkPrivileges  bool
+		showVersion      bool
+		pprofAddr        string
+		cpuProfile       string
+		memProfile       string
+		hooks            backup.Hooks
+		configFile       string
+		messageTemplate  string
+		replicaBucket    string
+		fallbackBucket   string
+		fallbackAfter    uint
+		environment      string
+		triggeredBy      string
+		schedule         string
+		cluster          string
+		writeIndex       bool
+		granularity      string
+		serverInfo       bool
+		tableChanges     bool
+		autoIncrements   bool
+		compositeMiB     uint
+		compositeParts   uint
+		maxObjectMiB     uint
+		dictTableMiB     uint
+		maxRunMiB        uint
+		maxMemoryMiB     uint
+		uploadWorkers    uint
+		spoolDir         string
+		spoolMinFreeMiB  uint
+		minSizeRatio     float64
+		failSmallDumps   bool
+		checksumTables   bool
+		rowCountCheck    string
+		firestoreProject string
+		firestoreDB      string
+		firestoreColl    string
+		statusConfigMap  string
+		signingKey       string
+		kmsSigningKey    string
+		completionMarker bool
+		routines         string
+		triggers         bool
+		extendedInsert   bool
+		hexBlob          bool
+	)
+	flag.StringVar(&dbUser, "dbUser", "", "MySQL database username")
+	flag.StringVar(&dbPass, "dbPass", "", "MySQL database password")
+	flag.StringVar(&dbHost, "dbHost", "localhost", "MySQL database host")
+	flag.StringVar(&dbPort, "dbPort", "3306", "MySQL database port")
+	flag.StringVar(&dbTLS, "dbTLS", "", "TLS mode for MySQL connections: preferred, skip-verify or true (verify the server certificate)")
+	flag.BoolVar(&secondary, "selectSecondary", false, "Treat -dbHost as an endpoint of a Group Replication group and dump its ONLINE secondary with the fewest queued transactions")
+	flag.BoolVar(&allowPrimary, "allowPrimary", false, "With -selectSecondary, dump the primary when the group has no online secondary instead of failing")
+	flag.StringVar(&shards, "shards", "", "Comma-separated host[:port] endpoints of the shards of one dataset, backed up in parallel under shard-N/ with a combined manifest (default: back up -dbHost)")
+	flag.StringVar(&mysqldumpPath, "mysqldumpPath", "mysqldump", "Path of the mysqldump binary, looked up in PATH unless it contains a slash")
+	flag.BoolVar(&pureGo, "pureGo", false, "Dump tables, their definitions, triggers and routines over the driver connection instead of with mysqldump, so that backups need no external binaries")
+	flag.BoolVar(&consistent, "consistentSnapshot", false, "Dump every table as of one point in time, taken at the start of the run under a brief FLUSH TABLES WITH READ LOCK or Percona backup locks (requires -pureGo)")
+	flag.StringVar(&charset, "defaultCharacterSet", backup.DefaultCharset, "Character set of MySQL connections and dumps, passed to mysqldump --default-character-set")
+	flag.StringVar(&bucketName, "bucketName", "", "GCS bucket name")
+	flag.StringVar(&dest, "dest", "", "Set to - to write the objects of the run as a tar stream to stdout instead of uploading them to bucketName")
+	gcs := gcsFlags(flag.CommandLine)
+	flag.StringVar(&replicaBucket, "replicaBucket", "", "Second GCS bucket, e.g. in another region, every object is also written to")
+	flag.StringVar(&fallbackBucket, "fallbackBucket", "", "GCS bucket to write to once writes to bucketName fail repeatedly")
+	flag.UintVar(&fallbackAfter, "fallbackAfter", 3, "Number of consecutive failed writes after which the fallback bucket is used")
+	flag.UintVar(&workers, "workers", backup.DefaultWorkers, "Number of tables dumped at the same time across all databases")
+	flag.UintVar(&dbLimit, "dbLimit", 0, "Deprecated: use -workers, which defaults to dbLimit*tableLimit when either is set")
+	flag.UintVar(&tableLimit, "tableLimit", 0, "Deprecated: use -workers, which defaults to dbLimit*tableLimit when either is set")
+	flag.StringVar(&skipDBs, "skipDBs", strings.Join(backup.DefaultSkipDBs, ","), "Comma-separated database names or patterns (tmp_*, /^shard_[0-9]+$/) to skip")
+	flag.Var(&ignoreTables, "ignoreTable", "Table to skip as database.table, a glob (shop.tmp_*) or a /regexp/; repeatable")
+	flag.Var(&ignoreTables, "ignore-table", "Alias of -ignoreTable, as in mysqldump")
+	flag.BoolVar(&systemSchemas, "includeSystemSchemas", false, "Back up the mysql schema, without its log, statistics and replication tables")
+	flag.BoolVar(&probeTables, "probeTables", true, "Read one row of each table before dumping it and skip unreadable tables")
+	flag.StringVar(&nonTransactional, "nonTransactional", backup.NonTransactionalLock, "Handling of MyISAM and other non-transactional tables: lock, warn (dump without locking) or skip")
+	flag.StringVar(&routines, "routines", backup.RoutinesDatabase, "Where stored procedures, functions and events are dumped: database (once per database), table (with every table) or none")
+	flag.BoolVar(&triggers, "triggers", true, "Dump the triggers of each table with the table")
+	flag.BoolVar(&extendedInsert, "extendedInsert", false, "Dump multiple rows per INSERT statement, which restores much faster")
+	flag.BoolVar(&hexBlob, "hexBlob", true, "Dump binary columns as hexadecimal literals")
+	flag.BoolVar(&splitPartitions, "splitPartitions", false, "Dump each partition of RANGE and LIST partitioned tables as its own object, in parallel")
+	flag.BoolVar(&dedup, "dedup", false, "Store table dumps as content-defined chunks under <host>/chunks/ plus a <table>.dedup.json index, uploading only chunks not stored yet")
+	flag.BoolVar(&archivePerDB, "archivePerDB", false, "Bundle the table dumps of each database into a single <database>.tar.gz object with a manifest.json entry, instead of one object per table")
+	flag.BoolVar(&archivePerDB, "archive-per-db", false, "Alias of -archivePerDB")
+	flag.StringVar(&archiveFormat, "archiveFormat", backup.ArchiveTarGz, "Format of the archives of -archivePerDB: tar.gz or tar.zst")
+	flag.Int64Var(&chunkRows, "chunkRows", 0, "Dump tables with an integer primary key and more rows than this in chunks of this many rows, resumable after an interruption (0 disables)")
+	flag.StringVar(&environment, "env", "", "Environment label, e.g. prod, stored in the backup-env metadata of every object")
+	flag.StringVar(&cluster, "cluster", "", "Cluster label stored in the backup-cluster metadata of every object")
+	flag.StringVar(&triggeredBy, "triggeredBy", "", "Who or what requested the run, e.g. the API client or pipeline invoking it, recorded in the manifest and the backup-trigger-caller metadata")
+	flag.StringVar(&schedule, "schedule", "", "Cron schedule starting the run, e.g. \"0 2 * * *\", recorded in the manifest and the backup-trigger-schedule metadata")
+	flag.BoolVar(&writeIndex, "writeIndex", false, "Write an index of the run's objects to _index/<run ID>.json")
+	flag.StringVar(&granularity, "granularity", backup.GranularityHour, "Generation runs are written to: hour (<host>/YYYY-MM-DD-HH), day (<host>/YYYY-MM-DD) or run (<host>/YYYY-MM-DD-HHMMSS)")
+	flag.BoolVar(&tableChanges, "tableChangeCheck", true, "Compare the enumerated tables with those of the previous run and report tables that appeared or disappeared")
+	flag.BoolVar(&autoIncrements, "autoIncrementReport", true, "Record the AUTO_INCREMENT counters and MariaDB sequences of each database in the manifest once its tables were dumped")
+	flag.BoolVar(&serverInfo, "serverInfo", true, "Upload the server's global variables, global status and replication status to server-info.json.gz in the run prefix")
+	flag.UintVar(&compositeMiB, "compositeThresholdMiB", 0, "Upload compressed dumps larger than this many MiB as parts of that size in parallel and compose them (0 disables)")
+	flag.UintVar(&uploadWorkers, "uploadWorkers", 0, "Spool compressed dumps to -spoolDir and upload this many at a time, so slow uploads do not hold dumps open (0 streams dumps to GCS)")
+	flag.StringVar(&spoolDir, "spoolDir", "", "Directory of the spool files of -uploadWorkers (default: the system temporary directory)")
+	flag.UintVar(&spoolMinFreeMiB, "spoolMinFreeMiB", 1024, "MiB of free space -spoolDir must keep, below which dumps are streamed to GCS instead of spooled")
+	flag.UintVar(&compositeParts, "compositeParallelism", 4, "Number of parts of a composite upload uploaded in parallel")
+	flag.UintVar(&maxObjectMiB, "maxObjectSizeMiB", 0, "Compressed size in MiB at which a dump continues in <object>.partNNN objects (default: the 5 TiB GCS object limit)")
+	flag.UintVar(&dictTableMiB, "zstdDictionaryTableSizeMiB", 0, "Compress tables of at most this many MiB with zstd and a dictionary trained on the first run, as <table>.sql.zst (default: 0, disabled)")
+	flag.UintVar(&maxRunMiB, "maxMiBPerRun", 0, "Compressed MiB after which the run starts no new tables, e.g. for a metered link to GCS (0 disables)")
+	flag.UintVar(&maxMemoryMiB, "maxMemoryMiB", 0, "MiB of upload buffers of all concurrent dumps, within which upload chunks are shrunk and dumps wait (0 disables)")
+	flag.Float64Var(&minSizeRatio, "minSizeRatio", 0, "Fraction of a table's compressed size in the previous run below which its dump is flagged as suspiciously small, e.g. 0.5 (0 disables)")
+	flag.BoolVar(&failSmallDumps, "failSmallDumps", false, "Fail tables whose dumps are flagged as suspiciously small")
+	flag.BoolVar(&checksumTables, "checksumTables", false, "Record the CHECKSUM TABLE of every table, taken before it is dumped, in the manifest and object metadata; reads every table twice")
+	flag.StringVar(&rowCountCheck, "rowCountCheck", backup.RowCountEstimate, "Compare the rows dumped of every table with its row count: estimate (flag dumps far off the information_schema estimate), exact (COUNT(*) before and after the dump, failing mismatches) or none")
+	flag.StringVar(&firestoreProject, "firestoreProject", "", "Project of a Firestore database to record runs and tables in (default: none)")
+	flag.StringVar(&firestoreDB, "firestoreDatabase", "(default)", "Firestore database to record runs and tables in")
+	flag.StringVar(&firestoreColl, "firestoreCollection", "backupRuns", "Firestore collection of run documents")
+	flag.StringVar(&statusConfigMap, "k8sStatusConfigMap", "", "When running in a Kubernetes pod, write the run status to this ConfigMap and emit Events on it when runs start, succeed and fail")
+	flag.StringVar(&signingKey, "signingKey", "", "PEM private key file to sign the manifest of every run with (default: none)")
+	flag.StringVar(&kmsSigningKey, "kmsSigningKey", "", "Cloud KMS asymmetric signing key version to sign the manifest of every run with, projects/.../cryptoKeyVersions/<version> (default: none)")
+	flag.BoolVar(&completionMarker, "completionMarker", false, "Write a _SUCCESS or _FAILED marker object to the run prefix once the run is complete")
+	flag.BoolVar(&htmlReport, "htmlReport", false, "Upload an HTML run report next to the manifest")
+	flag.DurationVar(&deadline, "deadline", 0, "Time after the start by which the run should be finished; low-priority tables are shed once it would not be (0 disables)")
+	flag.StringVar(&window, "window", "", "Daily maintenance window in local time, e.g. 22:00-06:00, outside of which no table is started (default: none)")
+	flag.BoolVar(&windowMustFinish, "windowMustFinish", false, "Skip tables not started when the maintenance window closes instead of pausing until it reopens")
+	flag.UintVar(&maxRunning, "maxThreadsRunning", 0, "Pause starting new tables while the server's Threads_running is above this (0 disables)")
+	flag.UintVar(&maxConnected, "maxThreadsConnected", 0, "Pause starting new tables while the server's Threads_connected is above this (0 disables)")
+	flag.DurationVar(&loadInterval, "loadCheckInterval", backup.DefaultLoadCheckInterval, "How often the server load is checked again while new tables are paused by -maxThreadsRunning or -maxThreadsConnected")
+	flag.DurationVar(&connectTimeout, "connectTimeout", backup.DefaultConnectTimeout, "Timeout for establishing MySQL connections, including those of mysqldump")
+	flag.DurationVar(&enumTimeout, "enumerationTimeout", backup.DefaultEnumerationTimeout, "Timeout for each query listing databases, tables and partitions")
+	flag.DurationVar(&dumpTimeout, "dumpTimeout", 0, "Log dumps running longer than this with the server threads running their queries (0 disables)")
+	flag.BoolVar(&killLongDumps, "killLongDumps", false, "KILL QUERY the server threads of dumps running longer than -dumpTimeout, failing them")
+	flag.BoolVar(&killOnCancel, "killOnCancel", false, "On SIGINT or SIGTERM, KILL QUERY the server threads of running dumps before exiting")
+	flag.DurationVar(&progressInterval, "progressInterval", 30*time.Second, "Interval between progress log lines (0 disables)")
+	flag.BoolVar(&costEstimate, "costEstimate", false, "Estimate the monthly GCS storage cost of the run and of all backups of this host")
+	flag.StringVar(&storageClass, "storageClass", "", "Storage class used for cost estimation (default: bucket default storage class)")
+	flag.Float64Var(&storagePrice, "storagePricePerGiB", 0, "Storage price in USD per GiB-month used for cost estimation (default: list price of the storage class)")
+	flag.BoolVar(&requireVersions, "requireVersioning", false, "Require object versioning on the destination buckets")
+	flag.StringVar(&minRetention, "minRetention", "", "Minimum retention policy period required of the destination buckets, e.g. 30d (default: none)")
+	flag.BoolVar(&requirePAP, "requirePublicAccessPrevention", false, "Require public access prevention to be enforced on the destination buckets")
+	flag.BoolVar(&checkPermissions, "checkPermissions", true, "Test the storage.objects permissions of the credentials on the destination buckets before the run")
+	flag.BoolVar(&checkPrivileges, "checkPrivileges", true, "Check that the MySQL user holds the privileges the run needs before dumping any table")
+	flag.StringVar(&bucketPolicy, "bucketPolicy", "warn", "What to do before a run when a destination bucket violates -requireVersioning, -minRetention or -requirePublicAccessPrevention: warn or abort")
+	flag.StringVar(&pprofAddr, "pprofAddr", "", "Address such as localhost:6060 to serve net/http/pprof on while the run lasts (default: none)")
+	flag.StringVar(&cpuProfile, "cpuProfile", "", "Write a CPU profile of the run to this file")
+	flag.StringVar(&memProfile, "memProfile", "", "Write a heap profile to this file once the run is done")
+	flag.BoolVar(&showVersion, "version", false, "Print version information and exit")
+	flag.StringVar(&configFile, "config", "", "Path to a JSON configuration file with per-table settings")
+	flag.StringVar(&hooks.PreRun, "preHook", "", "Shell command to run before the backup starts")
+	flag.StringVar(&hooks.PostRun, "postHook", "", "Shell command to run after the backup finishes")
+	flag.StringVar(&hooks.PreDatabase, "preDBHook", "", "Shell command to run before each database is backed up")
+	flag.StringVar(&hooks.PostDatabase, "postDBHook", "", "Shell command to run after each database is backed up")
+	flag.StringVar(&hooks.TablesChanged, "tablesChangedHook", "", "Shell command to run when tables appeared or disappeared since the previous run")
+	flag.StringVar(&messageTemplate, "messageTemplate", "", "Path to a Go text/template file rendering the BACKUP_MESSAGE of the post-run and tables-changed hooks from the run summary")
+
+	flag.Parse()
+
+	if showVersion {
+		fmt.Println(backup.VersionString())
+		return
+	}
+
+	if dbUser == "" || dbPass == "" || (bucketName == "" && dest == "") {
+		exitf(exitConfigError, "Missing required command line arguments. Please provide dbUser, dbPass, and bucketName.")
+	}
+	switch {
+	case dest != "" && dest != "-":
+		exitf(exitConfigError, "Invalid -dest %q: must be - (stdout)", dest)
+	case dest == "-" && (bucketName != "" || replicaBucket != "" || fallbackBucket != ""):
+		exitf(exitConfigError, "-dest=- cannot be combined with -bucketName, -replicaBucket or -fallbackBucket")
+	}
+
+	workers = legacyWorkers(workers, dbLimit, tableLimit)
+	if workers == 0 {
+		exitf(exitConfigError, "Invalid -workers 0: must be at least 1")
+	}
+
+	if !validTLSMode(dbTLS) {
+		exitf(exitConfigError, "Invalid -dbTLS %q: must be preferred, skip-verify or true", dbTLS)
+	}
+
+	switch nonTransactional {
+	case backup.NonTransactionalLock, backup.NonTransactionalWarn, backup.NonTransactionalSkip:
+	default:
+		exitf(exitConfigError, "Invalid -nonTransactional %q: must be lock, warn or skip", nonTransactional)
+	}
+
+	switch rowCountCheck {
+	case backup.RowCountEstimate, backup.RowCountExact, backup.RowCountNone:
+	default:
+		exitf(exitConfigError, "Invalid -rowCountCheck %q: must be estimate, exact or none", rowCountCheck)
+	}
+
+	switch routines {
+	case backup.RoutinesDatabase, backup.RoutinesTable, backup.RoutinesNone:
+	default:
+		exitf(exitConfigError, "Invalid -routines %q: must be database, table or none", routines)
+	}
+
+	shardHosts, err := parseEndpoints(shards, dbPort)
+	if err != nil {
+		exitf(exitConfigError, "Invalid -shards %q: %v", shards, err)
+	}
+
+	if allowPrimary && !secondary {
+		exitf(exitConfigError, "-allowPrimary requires -selectSecondary")
+	}
+	if consistent && !pureGo {
+		exitf(exitConfigError, "-consistentSnapshot requires -pureGo: separate mysqldump processes cannot share a snapshot")
+	}
+
+	if minSizeRatio < 0 || minSizeRatio >= 1 {
+		exitf(exitConfigError, "Invalid -minSizeRatio %g: must be at least 0 and below 1", minSizeRatio)
+	}
+
+	if err := backup.ValidateArchiveFormat(archiveFormat); err != nil {
+		exitf(exitConfigError, "Invalid -archiveFormat %q: must be tar.gz or tar.zst", archiveFormat)
+	}
+	if !archivePerDB {
+		archiveFormat = ""
+	}
+
+	switch granularity {
+	case backup.GranularityHour, backup.GranularityDay, backup.GranularityRun:
+	default:
+		exitf(exitConfigError, "Invalid -granularity %q: must be hour, day or run", granularity)
+	}
+
+	if bucketPolicy != "warn" && bucketPolicy != "abort" {
+		exitf(exitConfigError, "Invalid -bucketPolicy %q: must be warn or abort", bucketPolicy)
+	}
+	policy := backup.BucketPolicy{
+		RequireVersioning:             requireVersions,
+		RequirePublicAccessPrevention: requirePAP,
+		Abort:                         bucketPolicy == "abort",
+	}
+	if minRetention != "" {
+		retention, err := parseAge(minRetention)
+		if err != nil {
+			exitf(exitConfigError, "Invalid -minRetention %q: %v", minRetention, err)
+		}
+		policy.MinRetention = retention
+	}
+
+	var maintenanceWindow *backup.Window
+	if window != "" {
+		parsed, err := backup.ParseWindow(window)
+		if err != nil {
+			exitf(exitConfigError, "Invalid -window: %v", err)
+		}
+		maintenanceWindow = &parsed
+	}
+
+	skipPatterns := strings.Split(skipDBs, ",")
+	if err := backup.ValidatePatterns(skipPatterns); err != nil {
+		exitf(exitConfigError, "Invalid -skipDBs: %v", err)
+	}
+	if err := backup.ValidateTablePatterns(ignoreTables); err != nil {
+		exitf(exitConfigError, "Invalid -ignoreTable: %v", err)
+	}
+
+	fileConfig := &backup.FileConfig{}
+	if configFile != "" {
+		loaded, err := backup.LoadFileConfig(configFile)
+		if err != nil {
+			exitf(exitConfigError, "Failed to load config: %v", err)
+		}
+		fileConfig = loaded
+	}
+
+	if messageTemplate != "" {
+		text, err := os.ReadFile(messageTemplate)
+		if err != nil {
+			exitf(exitConfigError, "Failed to read message template: %v", err)
+		}
+		if hooks.MessageTemplate, err = backup.ParseMessageTemplate(string(text)); err != nil {
+			exitf(exitConfigError, "%v", err)
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		exitf(exitFailure, "Failed to get hostname: %v", err)
+	}
+
+	started := time.Now()
+	profiles, err := startProfiling(pprofAddr, cpuProfile, memProfile)
+	if err != nil {
+		exitf(exitConfigError, "Failed to start profiling: %v", err)
+	}
+
+	runID := backup.NewRunID(started)
+	log.SetPrefix(runID + " ")
+	log.Printf("Starting backup run %s\n", runID)
+
+	ctx := context.Background()
+	if killOnCancel {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		// A second signal terminates the process right away.
+		go func() {
+			<-ctx.Done()
+			stop()
+		}()
+	}
+
+	var store backup.ObjectStore
+	var stream *backup.TarStore
+	if dest == "-" {
+		stream = backup.NewTarStore(os.Stdout)
+		stream.Dir = spoolDir
+		store = stream
+	} else {
+		client, err := newStorageClient(ctx, int(workers), gcs)
+		if err != nil {
+			exitf(exitConfigError, "Failed to create GCS client: %v", err)
+		}
+		defer client.Close()
+
+		store = backup.NewGCSStore(client.Bucket(bucketName))
+		if replicaBucket != "" {
+			store = backup.NewMirrorStore(store, backup.NewGCSStore(client.Bucket(replicaBucket)))
+		}
+		if fallbackBucket != "" {
+			store = backup.NewFailoverStore(store, backup.NewGCSStore(client.Bucket(fallbackBucket)), int(fallbackAfter))
+		}
+	}
+
+	var inventory backup.Inventory
+	if firestoreProject != "" {
+		service, err := firestore.NewService(ctx, gcs.apiOptions()...)
+		if err != nil {
+			exitf(exitConfigError, "Failed to create Firestore client: %v", err)
+		}
+		inventory = backup.NewFirestoreInventory(service, firestoreProject, firestoreDB, firestoreColl)
+	}
+
+	var reporter backup.StatusReporter
+	if statusConfigMap != "" {
+		kubernetes, err := backup.NewInClusterReporter(statusConfigMap)
+		if err != nil {
+			exitf(exitConfigError, "Failed to set up Kubernetes status reporting: %v", err)
+		}
+		reporter = kubernetes
+	}
+
+	signer, err := newManifestSigner(ctx, gcs, signingKey, kmsSigningKey)
+	if err != nil {
+		exitf(exitConfigError, "%v", err)
+	}
+
+	trigger := backup.DetectTrigger()
+	trigger.Caller = triggeredBy
+	trigger.Schedule = schedule
+	if trigger.ConfigHash, err = configHash(flag.CommandLine, configFile); err != nil {
+		exitf(exitConfigError, "Failed to hash the configuration: %v", err)
+	}
+
+	var runDeadline time.Time
+	if deadline > 0 {
+		runDeadline = started.Add(deadline)
+	}
+
+	connection := backup.Connection{
+		User:     dbUser,
+		Password: dbPass,
+		Host:     dbHost,
+		Port:     dbPort,
+		TLS:      dbTLS,
+		Charset:  charset,
+
+		SessionVariables: fileConfig.SessionVariables,
+		ConnectTimeout:   connectTimeout,
+	}
+	var shardConns []backup.Connection
+	for _, hostPort := range shardHosts {
+		shard := connection
+		shard.Host, shard.Port = hostPort[0], hostPort[1]
+		shardConns = append(shardConns, shard)
+	}
+
+	_, err = backup.Run(ctx, backup.Config{
+		RunID:            runID,
+		Environment:      environment,
+		Cluster:          cluster,
+		Trigger:          trigger,
+		Index:            writeIndex,
+		Granularity:      granularity,
+		SkipServerInfo:   !serverInfo,
+		CompletionMarker: completionMarker,
+
+		Connection:           connection,
+		Shards:               shardConns,
+		SkipTableChangeCheck: !tableChanges,
+		SkipAutoIncrements:   !autoIncrements,
+		SelectSecondary:      secondary,
+		AllowPrimary:         allowPrimary,
+		MysqldumpPath:        mysqldumpPath,
+		PureGo:               pureGo,
+		ConsistentSnapshot:   consistent,
+		Store:                store,
+		Hostname:             hostname,
+		Workers:              int(workers),
+		SkipDBs:              skipPatterns,
+		IgnoreTables:         ignoreTables,
+		IncludeSystemSchemas: systemSchemas,
+		ProbeTables:          probeTables,
+		SplitPartitions:      splitPartitions,
+		Deduplicate:          dedup,
+		ArchiveFormat:        archiveFormat,
+		ChunkRows:            chunkRows,
+		NonTransactional:     nonTransactional,
+		Routines:             routines,
+		SkipTriggers:         !triggers,
+		ExtendedInsert:       extendedInsert,
+		NoHexBlob:            !hexBlob,
+		CompositeThreshold:   int(compositeMiB) << 20,
+		CompositeParallelism: int(compositeParts),
+		MaxObjectSize:        int64(maxObjectMiB) << 20,
+		DictionaryTableSize:  int64(dictTableMiB) << 20,
+		MaxBytesPerRun:       int64(maxRunMiB) << 20,
+		MaxMemory:            int64(maxMemoryMiB) << 20,
+		UploadWorkers:        int(uploadWorkers),
+		SpoolDir:             spoolDir,
+		SpoolMinFree:         int64(spoolMinFreeMiB) << 20,
+		MinSizeRatio:         minSizeRatio,
+		FailSmallDumps:       failSmallDumps,
+		ChecksumTables:       checksumTables,
+		RowCountCheck:        rowCountCheck,
+		HTMLReport:           htmlReport,
+		ProgressInterval:     progressInterval,
+		Deadline:             runDeadline,
+		DumpTimeout:          dumpTimeout,
+		EnumerationTimeout:   enumTimeout,
+		KillLongDumps:        killLongDumps,
+		KillOnCancel:         killOnCancel,
+		Window:               maintenanceWindow,
+		WindowMustFinish:     windowMustFinish,
+		MaxThreadsRunning:    int(maxRunning),
+		MaxThreadsConnected:  int(maxConnected),
+		LoadCheckInterval:    loadInterval,
+		CostEstimate:         costEstimate,
+		StorageClass:         storageClass,
+		StoragePricePerGiB:   storagePrice,
+		BucketPolicy:         policy,
+		SkipPermissionCheck:  !checkPermissions,
+		SkipPrivilegeCheck:   !checkPrivileges,
+		Hooks:                hooks,
+		Inventory:            inventory,
+		StatusReporter:       reporter,
+		Signer:               signer,
+		Tables:               fileConfig.Tables,
+	})
+
+	profiles.stop()
+	if stream != nil {
+		if closeErr := stream.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	switch {
+	case errors.Is(err, backup.ErrPreflight), errors.Is(err, backup.ErrPrivileges):
+		exitf(exitConfigError, "Database backup failed: %v", err)
+	case errors.Is(err, backup.ErrEnumeration):
+		exitf(exitEnumerationFailure, "Database backup failed: %v", err)
+	case errors.Is(err, backup.ErrPartialFailure):
+		exitf(exitPartialFailure, "Database backup failed: %v", err)
+	case err != nil:
+		exitf(exitFailure, "Database backup failed: %v", err)
+	}
+
+	log.Println("Database backup completed")
+}
+
+// parseArgs parses flags that may appear before, between or after the
+// positional arguments, which the flag package alone does not allow.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// legacyWorkers derives the number of workers from the deprecated -dbLimit
+// and -tableLimit flags when -workers was not set, as their product bounded
+// the number of parallel dumps before.
+func legacyWorkers(workers uint, dbLimit uint, tableLimit uint) uint {
+	workersSet := false
+	flag.Visit(func(f *flag.Flag) {
+		workersSet = workersSet || f.Name == "workers"
+	})
+	if workersSet || (dbLimit == 0 && tableLimit == 0) {
+		return workers
+	}
+
+	log.Println("-dbLimit and -tableLimit are deprecated, use -workers")
+	if dbLimit == 0 {
+		dbLimit = 2
+	}
+	if tableLimit == 0 {
+		tableLimit = 2
+	}
+	return dbLimit * tableLimit
+}
+
+// configHash returns the hex SHA-256 of the flags set on fs, except the
+// password and the settings only describing the run, and of the content of
+// configFile, if any.
+func configHash(fs *flag.FlagSet, configFile string) (string, error) {
+	var settings []string
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "dbPass", "triggeredBy", "schedule":
+			return
+		}
+		settings = append(settings, f.Name+"="+f.Value.String())
+	})
+	sort.Strings(settings)
+
+	hash := sha256.New()
+	for _, setting := range settings {
+		fmt.Fprintln(hash, setting)
+	}
+	if configFile != "" {
+		data, err := os.ReadFile(configFile)
+		if err != nil {
+			return "", err
+		}
+		hash.Write(data)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// listFlag collects the values of a repeated flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parseEndpoints splits comma-separated host[:port] endpoints into host and
+// port pairs, with defaultPort for those without one.
+func parseEndpoints(value string, defaultPort string) ([][2]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var endpoints [][2]string
+	for _, endpoint := range strings.Split(value, ",") {
+		host, port, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			host, port = endpoint, defaultPort
+		}
+		if host == "" || port == "" || strings.Contains(host, ":") && net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("invalid endpoint %q", endpoint)
+		}
+		endpoints = append(endpoints, [2]string{host, port})
+	}
+	return endpoints, nil
+}
+
+func validTLSMode(mode string) bool {
+	switch mode {
+	case "", backup.TLSPreferred, backup.TLSSkipVerify, backup.TLSVerify:
+		return true
+	}
+	return false
+}
+
+// newManifestSigner returns the signer of -signingKey or -kmsSigningKey, or
+// nil if neither is set.
+func newManifestSigner(ctx context.Context, gcs *gcsOptions, signingKey string, kmsSigningKey string) (backup.ManifestSigner, error) {
+	switch {
+	case signingKey != "" && kmsSigningKey != "":
+		return nil, errors.New("-signingKey and -kmsSigningKey are mutually exclusive")
+	case signingKey != "":
+		signer, err := backup.LoadSigningKey(signingKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -signingKey: %w", err)
+		}
+		return signer, nil
+	case kmsSigningKey != "":
+		service, err := cloudkms.NewService(ctx, gcs.apiOptions()...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Cloud KMS client: %w", err)
+		}
+		return &backup.KMSSigner{Service: service, KeyVersion: kmsSigningKey}, nil
+	}
+	return nil, nil
+}
+
+func exitf(code int, format string, v ...any) {
+	log.Printf(format, v...)
+	os.Exit(code)
+}
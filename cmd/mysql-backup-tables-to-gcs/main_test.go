@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/eugenepaniot/mysql-tables-to-gcs/pkg/backup"
+)
+
+func TestParseArgsAllowsInterspersedFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	ttl := fs.Duration("ttl", time.Hour, "")
+	bucket := fs.String("bucketName", "", "")
+
+	positional, err := parseArgs(fs, []string{"-bucketName=b", "host/a.sql.gz", "--ttl=2h", "host/b/"})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+
+	if want := []string{"host/a.sql.gz", "host/b/"}; !reflect.DeepEqual(positional, want) {
+		t.Errorf("positional = %v, want %v", positional, want)
+	}
+	if *ttl != 2*time.Hour || *bucket != "b" {
+		t.Errorf("flags = %s, %s", *ttl, *bucket)
+	}
+}
+
+func TestResolveObjects(t *testing.T) {
+	ctx := context.Background()
+	store := backup.NewMemoryStore()
+	for _, name := range []string{"host/run/shop/orders.sql.gz", "host/run/shop/users.sql.gz"} {
+		w := store.NewWriter(ctx, name, "", nil)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"host/run/shop/orders.sql.gz", []string{"host/run/shop/orders.sql.gz"}},
+		{"host/run/shop", []string{"host/run/shop/orders.sql.gz", "host/run/shop/users.sql.gz"}},
+		{"host/run/", []string{"host/run/shop/orders.sql.gz", "host/run/shop/users.sql.gz"}},
+	}
+	for _, tt := range tests {
+		got, err := resolveObjects(ctx, store, tt.name)
+		if err != nil {
+			t.Errorf("resolveObjects(%s) failed: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("resolveObjects(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := resolveObjects(ctx, store, "host/missing"); err == nil {
+		t.Errorf("resolveObjects accepted a missing object")
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"7d", 7 * 24 * time.Hour},
+		{"0d", 0},
+		{"12h", 12 * time.Hour},
+		{"90m", 90 * time.Minute},
+	}
+	for _, tt := range tests {
+		got, err := parseAge(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseAge(%s) = %s, %v, want %s", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "d", "-1d", "xd", "-2h", "week"} {
+		if _, err := parseAge(in); err == nil {
+			t.Errorf("parseAge(%q) succeeded, want error", in)
+		}
+	}
+}
+
+func TestParseEndpoints(t *testing.T) {
+	endpoints, err := parseEndpoints("db0,db1:3307,[::1]:3308", "3306")
+	if err != nil {
+		t.Fatalf("parseEndpoints failed: %v", err)
+	}
+	want := [][2]string{{"db0", "3306"}, {"db1", "3307"}, {"::1", "3308"}}
+	if !reflect.DeepEqual(endpoints, want) {
+		t.Errorf("endpoints = %v, want %v", endpoints, want)
+	}
+
+	for _, value := range []string{"db0,", ":3306", "db0:3306:1"} {
+		if _, err := parseEndpoints(value, "3306"); err == nil {
+			t.Errorf("parseEndpoints(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestListFlag(t *testing.T) {
+	var tables listFlag
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&tables, "ignoreTable", "")
+	fs.Var(&tables, "ignore-table", "")
+	if err := fs.Parse([]string{"-ignoreTable", "shop.tmp_*", "--ignore-table=audit.log"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := (listFlag{"shop.tmp_*", "audit.log"}); !reflect.DeepEqual(tables, want) {
+		t.Errorf("tables = %v, want %v", tables, want)
+	}
+}
+
+func TestConfigHashIgnoresPasswordAndOrder(t *testing.T) {
+	hash := func(args ...string) string {
+		t.Helper()
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.String("dbPass", "", "")
+		fs.String("dbUser", "", "")
+		fs.Bool("dedup", false, "")
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		sum, err := configHash(fs, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sum
+	}
+
+	base := hash("-dbUser=backup", "-dbPass=one", "-dedup")
+	if got := hash("-dedup", "-dbPass=two", "-dbUser=backup"); got != base {
+		t.Errorf("configHash() = %s after changing the password and order, want %s", got, base)
+	}
+	if got := hash("-dbUser=backup", "-dbPass=one"); got == base {
+		t.Errorf("configHash() = %s without -dedup, want a different hash", got)
+	}
+}
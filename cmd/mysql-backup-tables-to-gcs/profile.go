@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	runtimepprof "runtime/pprof"
+)
+
+// profiling holds the profiles requested on the command line.
+type profiling struct {
+	cpu      *os.File
+	memPath  string
+	listener net.Listener
+}
+
+// startProfiling serves net/http/pprof on addr and starts a CPU profile
+// written to cpuPath, each if not empty. stop writes a heap profile to
+// memPath once the run is done.
+func startProfiling(addr string, cpuPath string, memPath string) (*profiling, error) {
+	p := &profiling{memPath: memPath}
+
+	if addr != "" {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		p.listener = listener
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+		log.Printf("Serving pprof on http://%s/debug/pprof/\n", listener.Addr())
+		go http.Serve(listener, mux)
+	}
+
+	if cpuPath != "" {
+		file, err := os.Create(cpuPath)
+		if err != nil {
+			p.stop()
+			return nil, fmt.Errorf("failed to create CPU profile: %w", err)
+		}
+		if err := runtimepprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			p.stop()
+			return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		p.cpu = file
+	}
+
+	return p, nil
+}
+
+// stop writes the requested profiles and stops serving pprof.
+func (p *profiling) stop() {
+	if p.cpu != nil {
+		runtimepprof.StopCPUProfile()
+		if err := p.cpu.Close(); err != nil {
+			log.Printf("Failed to write CPU profile: %v\n", err)
+		}
+		p.cpu = nil
+	}
+
+	if p.memPath != "" {
+		if err := writeHeapProfile(p.memPath); err != nil {
+			log.Printf("Failed to write memory profile: %v\n", err)
+		}
+		p.memPath = ""
+	}
+
+	if p.listener != nil {
+		p.listener.Close()
+		p.listener = nil
+	}
+}
+
+func writeHeapProfile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	runtime.GC()
+	if err := runtimepprof.WriteHeapProfile(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
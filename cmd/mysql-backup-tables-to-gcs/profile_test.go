@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfiling(t *testing.T) {
+	dir := t.TempDir()
+	cpu, mem := filepath.Join(dir, "cpu.pprof"), filepath.Join(dir, "mem.pprof")
+
+	p, err := startProfiling("127.0.0.1:0", cpu, mem)
+	if err != nil {
+		t.Fatalf("startProfiling failed: %v", err)
+	}
+
+	resp, err := http.Get("http://" + p.listener.Addr().String() + "/debug/pprof/heap")
+	if err != nil {
+		t.Fatalf("GET heap profile failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET heap profile status = %d", resp.StatusCode)
+	}
+
+	p.stop()
+
+	for _, name := range []string{cpu, mem} {
+		if info, err := os.Stat(name); err != nil || info.Size() == 0 {
+			t.Errorf("profile %s was not written: %v", name, err)
+		}
+	}
+}
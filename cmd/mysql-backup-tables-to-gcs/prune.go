@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/eugenepaniot/mysql-tables-to-gcs/pkg/backup"
+)
+
+func pruneCommand(args []string) int {
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s prune [options]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	var (
+		bucketName string
+		host       string
+		olderThan  string
+		keep       uint
+		protect    string
+		dryRun     bool
+	)
+	fs.StringVar(&bucketName, "bucketName", "", "GCS bucket name")
+	gcs := gcsFlags(fs)
+	fs.StringVar(&host, "host", "", "Host name whose backups are pruned (default: this host)")
+	fs.StringVar(&olderThan, "olderThan", "", "Delete generations older than this age, e.g. 30d or 720h")
+	fs.UintVar(&keep, "keep", 1, "Number of latest generations kept regardless of their age")
+	fs.StringVar(&protect, "protect", "", "Comma-separated generations never deleted: @monthly, @weekly, @daily (first generation of each period) or generation patterns such as 2024-*-01-00")
+	fs.BoolVar(&dryRun, "dryRun", false, "Only print the generations that would be deleted")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return exitConfigError
+	}
+
+	if bucketName == "" || olderThan == "" || len(positional) != 0 {
+		fs.Usage()
+		return exitConfigError
+	}
+
+	age, err := parseAge(olderThan)
+	if err != nil {
+		log.Printf("Invalid -olderThan %q: %v\n", olderThan, err)
+		return exitConfigError
+	}
+
+	var protectRules []string
+	if protect != "" {
+		protectRules = strings.Split(protect, ",")
+	}
+	if err := backup.ValidateProtect(protectRules); err != nil {
+		log.Printf("Invalid -protect: %v\n", err)
+		return exitConfigError
+	}
+
+	if host == "" {
+		if host, err = os.Hostname(); err != nil {
+			log.Printf("Failed to get hostname: %v\n", err)
+			return exitFailure
+		}
+	}
+
+	ctx := context.Background()
+	client, err := newStorageClient(ctx, 1, gcs)
+	if err != nil {
+		log.Printf("Failed to create GCS client: %v\n", err)
+		return exitConfigError
+	}
+	defer client.Close()
+
+	pruned, err := backup.Prune(ctx, backup.PruneConfig{
+		Store:   backup.NewGCSStore(client.Bucket(bucketName)),
+		Host:    host,
+		Before:  time.Now().Add(-age),
+		Keep:    int(keep),
+		Protect: protectRules,
+		DryRun:  dryRun,
+	})
+
+	for _, generation := range pruned {
+		action := "deleted"
+		switch {
+		case generation.Protected != "":
+			action = "protected by " + generation.Protected
+		case dryRun:
+			action = "would delete"
+		}
+		fmt.Printf("%s/%s\t%d objects\t%s\t%s\n", host, generation.Generation, generation.Objects, backup.FormatBytes(generation.Bytes), action)
+	}
+
+	if err != nil {
+		log.Printf("Prune failed: %v\n", err)
+		return exitFailure
+	}
+
+	return exitSuccess
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/eugenepaniot/mysql-tables-to-gcs/pkg/backup"
+)
+
+func rekeyCommand(args []string) int {
+	fs := flag.NewFlagSet("rekey", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s rekey [options] <host>/<generation>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	var (
+		bucketName    string
+		kmsKey        string
+		force         bool
+		workers       uint
+		signingKey    string
+		kmsSigningKey string
+	)
+	fs.StringVar(&bucketName, "bucketName", "", "GCS bucket name")
+	gcs := gcsFlags(fs)
+	fs.StringVar(&kmsKey, "kmsKey", "", "Cloud KMS key to re-encrypt the objects with, projects/.../cryptoKeys/<key>")
+	fs.BoolVar(&force, "force", false, "Also rewrite objects already encrypted with -kmsKey, moving them to its primary version")
+	fs.UintVar(&workers, "workers", 4, "Number of objects rewritten at the same time")
+	fs.StringVar(&signingKey, "signingKey", "", "PEM private key file to re-sign signed manifests with")
+	fs.StringVar(&kmsSigningKey, "kmsSigningKey", "", "Cloud KMS asymmetric signing key version to re-sign signed manifests with")
+
+	paths, err := parseArgs(fs, args)
+	if err != nil {
+		return exitConfigError
+	}
+
+	if bucketName == "" || kmsKey == "" || len(paths) == 0 {
+		fs.Usage()
+		return exitConfigError
+	}
+	if strings.Contains(kmsKey, "/cryptoKeyVersions/") {
+		log.Printf("Invalid -kmsKey %s: objects are encrypted with the primary version of a key, not a given version\n", kmsKey)
+		return exitConfigError
+	}
+
+	ctx := context.Background()
+	signer, err := newManifestSigner(ctx, gcs, signingKey, kmsSigningKey)
+	if err != nil {
+		log.Printf("%v\n", err)
+		return exitConfigError
+	}
+
+	client, err := newStorageClient(ctx, int(workers), gcs)
+	if err != nil {
+		log.Printf("Failed to create GCS client: %v\n", err)
+		return exitConfigError
+	}
+	defer client.Close()
+
+	store := backup.NewGCSStore(client.Bucket(bucketName))
+	cfg := backup.RekeyConfig{KMSKey: kmsKey, Force: force, Workers: int(workers), Signer: signer}
+
+	code := exitSuccess
+	for _, path := range paths {
+		path = strings.Trim(path, "/")
+		rewritten, err := backup.Rekey(ctx, store, path, cfg)
+		if err != nil {
+			log.Printf("Failed to rekey %s after rewriting %d object(s): %v\n", path, rewritten, err)
+			code = exitFailure
+			continue
+		}
+		fmt.Printf("%s: %d object(s) rewritten\n", path, rewritten)
+	}
+	return code
+}
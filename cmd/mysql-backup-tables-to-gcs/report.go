@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/eugenepaniot/mysql-tables-to-gcs/pkg/backup"
+)
+
+func reportCommand(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s report [options]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	var (
+		bucketName string
+		host       string
+		last       uint
+		dropPct    float64
+		format     string
+	)
+	fs.StringVar(&bucketName, "bucketName", "", "GCS bucket name")
+	gcs := gcsFlags(fs)
+	fs.StringVar(&host, "host", "", "Host name the backups were taken on (default: this host)")
+	fs.UintVar(&last, "last", 30, "Number of latest generations to aggregate")
+	fs.Float64Var(&dropPct, "dropPercent", 100*backup.DefaultDropThreshold, "Shrinkage in percent of a database's dumped size from one run to the next reported as an anomaly")
+	fs.StringVar(&format, "format", "table", "Output format: table or json")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return exitConfigError
+	}
+
+	if bucketName == "" || last == 0 || len(positional) != 0 {
+		fs.Usage()
+		return exitConfigError
+	}
+
+	if format != "table" && format != "json" {
+		log.Printf("Invalid -format %q: must be table or json\n", format)
+		return exitConfigError
+	}
+
+	if dropPct <= 0 || dropPct > 100 {
+		log.Printf("Invalid -dropPercent %g: must be above 0 and at most 100\n", dropPct)
+		return exitConfigError
+	}
+
+	if host == "" {
+		if host, err = os.Hostname(); err != nil {
+			log.Printf("Failed to get hostname: %v\n", err)
+			return exitFailure
+		}
+	}
+
+	ctx := context.Background()
+	client, err := newStorageClient(ctx, 1, gcs)
+	if err != nil {
+		log.Printf("Failed to create GCS client: %v\n", err)
+		return exitConfigError
+	}
+	defer client.Close()
+
+	trends, err := backup.SizeTrend(ctx, backup.TrendConfig{
+		Store:         backup.NewGCSStore(client.Bucket(bucketName)),
+		Host:          host,
+		Last:          int(last),
+		DropThreshold: dropPct / 100,
+	})
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+
+	if err := printTrends(os.Stdout, format, trends); err != nil {
+		log.Printf("Failed to print report: %v\n", err)
+		return exitFailure
+	}
+
+	return exitSuccess
+}
+
+func printTrends(w io.Writer, format string, trends []backup.DatabaseTrend) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if trends == nil {
+			trends = []backup.DatabaseTrend{}
+		}
+		return encoder.Encode(trends)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATABASE\tRUNS\tFIRST\tLAST\tSIZE\tGROWTH\tPER DAY\tANOMALIES")
+	for _, t := range trends {
+		first, last := t.Points[0], t.Points[len(t.Points)-1]
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s (%+.1f%%)\t%s\t%d\n",
+			t.Database, len(t.Points), first.Generation, last.Generation, backup.FormatBytes(last.UncompressedBytes),
+			formatSignedBytes(t.GrowthBytes), 100*t.GrowthRatio, formatSignedBytes(int64(t.GrowthBytesPerDay)), len(t.Anomalies))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, t := range trends {
+		for _, anomaly := range t.Anomalies {
+			if _, err := fmt.Fprintf(w, "Anomaly in %s at %s: %s\n", t.Database, anomaly.Generation, anomaly.Reason); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func formatSignedBytes(n int64) string {
+	if n < 0 {
+		return "-" + backup.FormatBytes(-n)
+	}
+	return "+" + backup.FormatBytes(n)
+}
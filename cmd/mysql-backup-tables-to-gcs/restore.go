@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/eugenepaniot/mysql-tables-to-gcs/pkg/backup"
+)
+
+// mappingFlag collects repeated old=new flag values.
+type mappingFlag map[string]string
+
+func (m mappingFlag) String() string {
+	pairs := make([]string, 0, len(m))
+	for from, to := range m {
+		pairs = append(pairs, from+"="+to)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m mappingFlag) Set(value string) error {
+	from, to, ok := strings.Cut(value, "=")
+	if !ok || from == "" || to == "" {
+		return fmt.Errorf("expected old=new, got %q", value)
+	}
+	m[from] = to
+	return nil
+}
+
+func restoreCommand(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s restore [options]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	var (
+		dbUser     string
+		dbPass     string
+		dbHost     string
+		dbPort     string
+		dbTLS      string
+		bucketName string
+		host       string
+		generation string
+		mapDB      = mappingFlag{}
+		mapTable   = mappingFlag{}
+		workers    uint
+		fkChecks   bool
+		dryRun     bool
+		tables     string
+		verify     bool
+		routines   bool
+		mysqlPath  string
+		from       string
+		database   string
+	)
+	fs.StringVar(&dbUser, "dbUser", "", "MySQL database username")
+	fs.StringVar(&dbPass, "dbPass", "", "MySQL database password")
+	fs.StringVar(&dbHost, "dbHost", "localhost", "MySQL database host")
+	fs.StringVar(&dbPort, "dbPort", "3306", "MySQL database port")
+	fs.StringVar(&dbTLS, "dbTLS", "", "TLS mode for MySQL connections: preferred, skip-verify or true (verify the server certificate)")
+	fs.StringVar(&bucketName, "bucketName", "", "GCS bucket name")
+	gcs := gcsFlags(fs)
+	fs.StringVar(&host, "host", "", "Host name the backup was taken on (default: this host)")
+	fs.StringVar(&generation, "generation", "", "Backup generation (YYYY-MM-DD-HH) to restore (default: latest)")
+	fs.StringVar(&tables, "tables", "", "Comma-separated table or database.table patterns to restore (default: all)")
+	fs.Var(mapDB, "mapDB", "Restore database old into new, as old=new (repeatable)")
+	fs.Var(mapTable, "mapTable", "Restore table db.old as new, as db.old=new (repeatable)")
+	fs.UintVar(&workers, "restoreConcurrency", 2, "Number of tables restored in parallel")
+	fs.BoolVar(&fkChecks, "foreignKeyChecks", false, "Keep foreign key checks enabled while restoring")
+	fs.BoolVar(&verify, "verify", false, "Compare the row counts of restored tables with the backup")
+	fs.BoolVar(&routines, "routines", true, "Restore the stored procedures, functions and events dumped once per database")
+	fs.BoolVar(&dryRun, "dryRun", false, "Validate the dumps and the target server version without restoring")
+	fs.StringVar(&from, "from", "", "Set to - to apply a single dump, gzip-compressed or not, read from stdin instead of a generation in bucketName")
+	fs.StringVar(&database, "database", "", "Database the dump read with -from=- is applied to")
+	fs.StringVar(&mysqlPath, "mysqlPath", "mysql", "Path of the mysql client binary, looked up in PATH unless it contains a slash")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return exitConfigError
+	}
+
+	if dbUser == "" || dbPass == "" || (bucketName == "" && from == "") || len(positional) != 0 {
+		fs.Usage()
+		return exitConfigError
+	}
+
+	if !validTLSMode(dbTLS) {
+		log.Printf("Invalid -dbTLS %q: must be preferred, skip-verify or true\n", dbTLS)
+		return exitConfigError
+	}
+
+	connection := backup.Connection{
+		User:     dbUser,
+		Password: dbPass,
+		Host:     dbHost,
+		Port:     dbPort,
+		TLS:      dbTLS,
+	}
+
+	switch {
+	case from != "" && from != "-":
+		log.Printf("Invalid -from %q: must be - (stdin)\n", from)
+		return exitConfigError
+	case from == "-" && database == "":
+		log.Println("-from=- needs -database")
+		return exitConfigError
+	case from == "-" && (bucketName != "" || generation != "" || tables != "" || len(mapDB) > 0 || len(mapTable) > 0 || dryRun || verify):
+		log.Println("-from=- cannot be combined with -bucketName, -generation, -tables, -mapDB, -mapTable, -dryRun or -verify")
+		return exitConfigError
+	case from == "-":
+		err := backup.RestoreStream(context.Background(), backup.RestoreConfig{
+			Connection:              connection,
+			DisableForeignKeyChecks: !fkChecks,
+			MysqlPath:               mysqlPath,
+		}, database, os.Stdin)
+		if err != nil {
+			log.Printf("Restore failed: %v\n", err)
+			if errors.Is(err, backup.ErrPreflight) {
+				return exitConfigError
+			}
+			return exitFailure
+		}
+		log.Println("Restore completed")
+		return exitSuccess
+	}
+
+	var patterns []string
+	if tables != "" {
+		patterns = strings.Split(tables, ",")
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			log.Printf("Invalid -tables pattern %q\n", pattern)
+			return exitConfigError
+		}
+	}
+
+	for from := range mapTable {
+		if !strings.Contains(from, ".") {
+			log.Printf("Invalid -mapTable %s: the source table must be given as database.table\n", from)
+			return exitConfigError
+		}
+	}
+
+	if host == "" {
+		if host, err = os.Hostname(); err != nil {
+			log.Printf("Failed to get hostname: %v\n", err)
+			return exitFailure
+		}
+	}
+
+	ctx := context.Background()
+	client, err := newStorageClient(ctx, 1, gcs)
+	if err != nil {
+		log.Printf("Failed to create GCS client: %v\n", err)
+		return exitConfigError
+	}
+	defer client.Close()
+
+	results, err := backup.Restore(ctx, backup.RestoreConfig{
+		Connection:  connection,
+		Store:       backup.NewGCSStore(client.Bucket(bucketName)),
+		Host:        host,
+		Generation:  generation,
+		Tables:      patterns,
+		DatabaseMap: mapDB,
+		TableMap:    mapTable,
+
+		Concurrency:             int(workers),
+		DisableForeignKeyChecks: !fkChecks,
+		DryRun:                  dryRun,
+		Verify:                  verify,
+		SkipRoutines:            !routines,
+		MysqlPath:               mysqlPath,
+	})
+	if dryRun {
+		for _, result := range results {
+			if result.Err == nil {
+				fmt.Printf("%s\t%s.%s\n", result.Object, result.TargetDatabase, result.TargetTable)
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("Restore failed: %v\n", err)
+		if errors.Is(err, backup.ErrPreflight) {
+			return exitConfigError
+		}
+		return exitFailure
+	}
+	if dryRun {
+		log.Println("Dry run completed, nothing was restored")
+		return exitSuccess
+	}
+
+	log.Println("Restore completed")
+
+	return exitSuccess
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/eugenepaniot/mysql-tables-to-gcs/pkg/backup"
+)
+
+func signCommand(args []string) int {
+	fs := flag.NewFlagSet("sign", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s sign [options] <object-or-prefix>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	var (
+		bucketName string
+		ttl        time.Duration
+	)
+	fs.StringVar(&bucketName, "bucketName", "", "GCS bucket name")
+	gcs := gcsFlags(fs)
+	fs.DurationVar(&ttl, "ttl", time.Hour, "Validity of the signed URLs (at most 7 days)")
+
+	names, err := parseArgs(fs, args)
+	if err != nil {
+		return exitConfigError
+	}
+
+	if bucketName == "" || len(names) == 0 {
+		fs.Usage()
+		return exitConfigError
+	}
+
+	if ttl <= 0 || ttl > 7*24*time.Hour {
+		log.Printf("Invalid -ttl %s: V4 signed URLs are valid for at most 7 days\n", ttl)
+		return exitConfigError
+	}
+
+	ctx := context.Background()
+	client, err := newStorageClient(ctx, 1, gcs)
+	if err != nil {
+		log.Printf("Failed to create GCS client: %v\n", err)
+		return exitConfigError
+	}
+	defer client.Close()
+
+	store := backup.NewGCSStore(client.Bucket(bucketName))
+
+	for _, name := range names {
+		objects, err := resolveObjects(ctx, store, name)
+		if err != nil {
+			log.Printf("Failed to resolve %s: %v\n", name, err)
+			return exitFailure
+		}
+
+		for _, object := range objects {
+			url, err := store.SignedURL(object, ttl)
+			if err != nil {
+				log.Printf("Failed to sign %s: %v\n", object, err)
+				return exitFailure
+			}
+			fmt.Printf("%s\t%s\n", object, url)
+		}
+	}
+
+	return exitSuccess
+}
+
+// resolveObjects returns name itself if it is an object, or the objects
+// below it when it is a prefix.
+func resolveObjects(ctx context.Context, store backup.ObjectStore, name string) ([]string, error) {
+	if !strings.HasSuffix(name, "/") {
+		_, err := store.Attrs(ctx, name)
+		if err == nil {
+			return []string{name}, nil
+		}
+		if !errors.Is(err, backup.ErrObjectNotExist) {
+			return nil, err
+		}
+		name += "/"
+	}
+
+	objects, err := store.List(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("no object or prefix named %s", name)
+	}
+
+	names := make([]string, 0, len(objects))
+	for _, object := range objects {
+		names = append(names, object.Name)
+	}
+	return names, nil
+}
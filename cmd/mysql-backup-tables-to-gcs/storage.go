@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+
+	"github.com/eugenepaniot/mysql-tables-to-gcs/pkg/backup"
+)
+
+// gcsOptions are the GCS client flags shared by all commands. The user agent
+// and telemetry settings apply to the clients of all Google APIs.
+type gcsOptions struct {
+	endpoint        string
+	caBundle        string
+	userAgentSuffix string
+	telemetry       bool
+	// transport is "http", "grpc" or, to leave the choice to
+	// STORAGE_USE_GRPC, empty.
+	transport string
+}
+
+func gcsFlags(fs *flag.FlagSet) *gcsOptions {
+	gcs := &gcsOptions{}
+	fs.StringVar(&gcs.endpoint, "gcsEndpoint", "", "GCS JSON API endpoint URL, e.g. a Private Service Connect endpoint or a storage emulator (default: STORAGE_EMULATOR_HOST or the public endpoint)")
+	fs.StringVar(&gcs.caBundle, "gcsCABundle", "", "PEM file with CA certificates trusted for GCS connections in addition to the system ones, e.g. of a TLS-inspecting proxy")
+	fs.StringVar(&gcs.userAgentSuffix, "userAgentSuffix", "", "Appended to the user agent of Google API requests, e.g. a team or job name to find them in audit and request logs")
+	fs.StringVar(&gcs.transport, "gcsTransport", "", "GCS API transport: http (JSON API) or grpc (default: http unless STORAGE_USE_GRPC is set)")
+	fs.BoolVar(&gcs.telemetry, "gcsTelemetry", false, "Enable the OpenCensus metrics and traces of the Google API clients, e.g. for troubleshooting GCS with an exporter")
+	return gcs
+}
+
+func (gcs *gcsOptions) userAgent() string {
+	if gcs.userAgentSuffix == "" {
+		return backup.UserAgent()
+	}
+	return backup.UserAgent() + " " + gcs.userAgentSuffix
+}
+
+// apiOptions are the options of every Google API client.
+func (gcs *gcsOptions) apiOptions() []option.ClientOption {
+	options := []option.ClientOption{option.WithUserAgent(gcs.userAgent())}
+	if !gcs.telemetry {
+		options = append(options, option.WithTelemetryDisabled())
+	}
+	return options
+}
+
+// newStorageClient returns a GCS client. The storage package already honors
+// STORAGE_EMULATOR_HOST and HTTP_PROXY/HTTPS_PROXY/NO_PROXY; gcs.endpoint, if
+// set, overrides the former. Plain HTTP endpoints are taken to be emulators
+// and are used without credentials.
+func newStorageClient(ctx context.Context, connectionPool int, gcs *gcsOptions) (*storage.Client, error) {
+	// The storage package only offers its gRPC client through
+	// STORAGE_USE_GRPC, read when a client is created.
+	switch gcs.transport {
+	case "":
+	case "http":
+		os.Unsetenv("STORAGE_USE_GRPC")
+	case "grpc":
+		if gcs.endpoint != "" || gcs.caBundle != "" {
+			return nil, errors.New("-gcsEndpoint and -gcsCABundle are not supported with the grpc transport")
+		}
+		os.Setenv("STORAGE_USE_GRPC", "true")
+	default:
+		return nil, fmt.Errorf("invalid GCS transport %q: must be http or grpc", gcs.transport)
+	}
+
+	options := append([]option.ClientOption{
+		option.WithScopes("https://www.googleapis.com/auth/devstorage.read_write"),
+		option.WithGRPCConnectionPool(connectionPool),
+	}, gcs.apiOptions()...)
+
+	if gcs.endpoint != "" {
+		endpointURL, err := url.Parse(gcs.endpoint)
+		if err != nil || (endpointURL.Scheme != "http" && endpointURL.Scheme != "https") || endpointURL.Host == "" {
+			return nil, fmt.Errorf("invalid GCS endpoint %q: must be an http or https URL", gcs.endpoint)
+		}
+		options = append(options, option.WithEndpoint(gcs.endpoint))
+		if endpointURL.Scheme == "http" {
+			options = append(options, option.WithoutAuthentication())
+		}
+	}
+
+	if gcs.caBundle != "" {
+		base, err := caBundleTransport(gcs.caBundle)
+		if err != nil {
+			return nil, err
+		}
+		// A custom base transport needs the authenticating transport built
+		// around it here, as option.WithHTTPClient disables the client's own.
+		transport, err := htransport.NewTransport(ctx, base, options...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCS transport: %w", err)
+		}
+		options = append(options, option.WithHTTPClient(&http.Client{Transport: transport}))
+	}
+
+	return storage.NewClient(ctx, options...)
+}
+
+// caBundleTransport returns a transport like http.DefaultTransport that also
+// trusts the CA certificates in the PEM file at path.
+func caBundleTransport(path string) (*http.Transport, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return transport, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewStorageClientEndpoint(t *testing.T) {
+	client, err := newStorageClient(context.Background(), 1, &gcsOptions{endpoint: "http://localhost:4443/storage/v1/"})
+	if err != nil {
+		t.Fatalf("newStorageClient failed: %v", err)
+	}
+	client.Close()
+
+	for _, endpoint := range []string{"localhost:4443", "ftp://localhost/", "https://"} {
+		if _, err := newStorageClient(context.Background(), 1, &gcsOptions{endpoint: endpoint}); err == nil {
+			t.Errorf("newStorageClient(%q) succeeded, want error", endpoint)
+		}
+	}
+}
+
+func TestNewStorageClientTransport(t *testing.T) {
+	t.Setenv("STORAGE_USE_GRPC", "")
+	for _, gcs := range []*gcsOptions{
+		{transport: "quic"},
+		{transport: "grpc", endpoint: "http://localhost:4443/storage/v1/"},
+	} {
+		if _, err := newStorageClient(context.Background(), 1, gcs); err == nil {
+			t.Errorf("newStorageClient(%+v) succeeded, want error", gcs)
+		}
+	}
+}
+
+func TestCABundleTransport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(bundle, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	transport, err := caBundleTransport(bundle)
+	if err != nil {
+		t.Fatalf("caBundleTransport failed: %v", err)
+	}
+	response, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("request through CA bundle transport failed: %v", err)
+	}
+	response.Body.Close()
+
+	if _, err := http.Get(server.URL); err == nil {
+		t.Error("request without CA bundle succeeded, want certificate error")
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	os.WriteFile(empty, []byte("not a certificate"), 0o600)
+	if _, err := caBundleTransport(empty); err == nil {
+		t.Error("caBundleTransport accepted a bundle without certificates")
+	}
+}
+
+func TestAPIOptions(t *testing.T) {
+	if userAgent := (&gcsOptions{userAgentSuffix: "team-dba"}).userAgent(); !strings.HasPrefix(userAgent, "mysql-backup-tables-to-gcs/") || !strings.HasSuffix(userAgent, " team-dba") {
+		t.Errorf("user agent = %q, want the tool version followed by the suffix", userAgent)
+	}
+	if options := (&gcsOptions{}).apiOptions(); len(options) != 2 {
+		t.Errorf("default options = %v, want user agent and disabled telemetry", options)
+	}
+	if options := (&gcsOptions{telemetry: true, userAgentSuffix: "team-dba"}).apiOptions(); len(options) != 1 {
+		t.Errorf("options with telemetry = %v, want only the user agent", options)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"google.golang.org/api/cloudkms/v1"
+
+	"github.com/eugenepaniot/mysql-tables-to-gcs/pkg/backup"
+)
+
+func verifyManifestCommand(args []string) int {
+	fs := flag.NewFlagSet("verify-manifest", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s verify-manifest [options] <host>/<generation>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	var (
+		bucketName string
+		publicKey  string
+		kmsKey     string
+	)
+	fs.StringVar(&bucketName, "bucketName", "", "GCS bucket name")
+	gcs := gcsFlags(fs)
+	fs.StringVar(&publicKey, "publicKey", "", "PEM public key file to verify the manifest signatures with")
+	fs.StringVar(&kmsKey, "kmsKey", "", "Cloud KMS key version whose public key to verify the manifest signatures with")
+
+	paths, err := parseArgs(fs, args)
+	if err != nil {
+		return exitConfigError
+	}
+
+	if bucketName == "" || len(paths) == 0 || (publicKey == "") == (kmsKey == "") {
+		fs.Usage()
+		return exitConfigError
+	}
+
+	ctx := context.Background()
+
+	var key crypto.PublicKey
+	if publicKey != "" {
+		data, err := os.ReadFile(publicKey)
+		if err != nil {
+			log.Printf("Failed to read -publicKey: %v\n", err)
+			return exitConfigError
+		}
+		if key, err = backup.ParsePublicKey(data); err != nil {
+			log.Printf("Invalid -publicKey: %v\n", err)
+			return exitConfigError
+		}
+	} else {
+		service, err := cloudkms.NewService(ctx, gcs.apiOptions()...)
+		if err != nil {
+			log.Printf("Failed to create Cloud KMS client: %v\n", err)
+			return exitConfigError
+		}
+		if key, err = backup.KMSPublicKey(ctx, service, kmsKey); err != nil {
+			log.Printf("Failed to get -kmsKey: %v\n", err)
+			return exitFailure
+		}
+	}
+
+	client, err := newStorageClient(ctx, 1, gcs)
+	if err != nil {
+		log.Printf("Failed to create GCS client: %v\n", err)
+		return exitConfigError
+	}
+	defer client.Close()
+
+	store := backup.NewGCSStore(client.Bucket(bucketName))
+
+	code := exitSuccess
+	for _, path := range paths {
+		path = strings.Trim(path, "/")
+		if err := backup.VerifyManifest(ctx, store, path, key); err != nil {
+			log.Printf("Manifest of %s not verified: %v\n", path, err)
+			code = exitFailure
+			continue
+		}
+		fmt.Printf("%s: signature verified\n", path)
+	}
+	return code
+}
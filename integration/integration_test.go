@@ -0,0 +1,206 @@
+//go:build integration
+
+// Package integration runs a backup and a restore against a MySQL server and
+// fake-gcs-server started in Docker containers. It requires docker and the
+// mysql/mysqldump client binaries on PATH:
+//
+//	go test -tags integration ./integration/...
+package integration
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+
+	"github.com/eugenepaniot/mysql-tables-to-gcs/pkg/backup"
+)
+
+const (
+	mysqlPassword = "secret"
+	bucketName    = "backups"
+)
+
+func envOr(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func docker(t *testing.T, args ...string) string {
+	t.Helper()
+
+	output, err := exec.Command("docker", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("docker %s failed: %v\n%s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func startContainer(t *testing.T, port string, args ...string) string {
+	t.Helper()
+
+	id := docker(t, append([]string{"run", "-d", "--rm", "-p", "127.0.0.1::" + port}, args...)...)
+	t.Cleanup(func() {
+		exec.Command("docker", "rm", "-f", id).Run()
+	})
+
+	mapped := docker(t, "port", id, port)
+	mapped = strings.Split(mapped, "\n")[0]
+	return mapped[strings.LastIndex(mapped, ":")+1:]
+}
+
+func mysqlExec(conn backup.Connection, database string, stdin io.Reader, query string) (string, error) {
+	args := []string{
+		"--user=" + conn.User,
+		"--password=" + conn.Password,
+		"--host=" + conn.Host,
+		"--port=" + conn.Port,
+		"--skip-column-names",
+	}
+	if query != "" {
+		args = append(args, "-e", query)
+	}
+	if database != "" {
+		args = append(args, database)
+	}
+
+	cmd := exec.Command("mysql", args...)
+	cmd.Stdin = stdin
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, stderr.String())
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func waitForMySQL(t *testing.T, conn backup.Connection) {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * time.Minute)
+	for {
+		_, err := mysqlExec(conn, "", nil, "SELECT 1")
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("MySQL did not become ready: %v", err)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+func checksum(t *testing.T, conn backup.Connection, table string) string {
+	t.Helper()
+
+	output, err := mysqlExec(conn, "", nil, "CHECKSUM TABLE "+table)
+	if err != nil {
+		t.Fatalf("CHECKSUM TABLE %s failed: %v", table, err)
+	}
+
+	fields := strings.Fields(output)
+	return fields[len(fields)-1]
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	for _, binary := range []string{"docker", "mysql", "mysqldump"} {
+		if _, err := exec.LookPath(binary); err != nil {
+			t.Skipf("%s is not available: %v", binary, err)
+		}
+	}
+
+	ctx := context.Background()
+
+	mysqlPort := startContainer(t, "3306",
+		"-e", "MYSQL_ROOT_PASSWORD="+mysqlPassword,
+		envOr("INTEGRATION_MYSQL_IMAGE", "mysql:8.0"),
+	)
+	gcsPort := startContainer(t, "4443",
+		envOr("INTEGRATION_GCS_IMAGE", "fsouza/fake-gcs-server"),
+		"-scheme", "http",
+	)
+
+	conn := backup.Connection{User: "root", Password: mysqlPassword, Host: "127.0.0.1", Port: mysqlPort}
+	waitForMySQL(t, conn)
+
+	seed := `
+CREATE DATABASE shop;
+CREATE TABLE shop.customers (id INT PRIMARY KEY, name VARCHAR(100) CHARACTER SET utf8mb4);
+CREATE TABLE shop.orders (id INT PRIMARY KEY, customer_id INT, amount DECIMAL(10,2), payload BLOB);
+INSERT INTO shop.customers VALUES (1, 'Zoë'), (2, 'O''Brien'), (3, '日本');
+INSERT INTO shop.orders VALUES (1, 1, 10.50, X'00FF10'), (2, 2, 99.99, NULL), (3, 3, 0.01, X'DEADBEEF');
+`
+	if _, err := mysqlExec(conn, "", strings.NewReader(seed), ""); err != nil {
+		t.Fatalf("failed to seed data: %v", err)
+	}
+
+	client, err := storage.NewClient(ctx,
+		option.WithEndpoint(fmt.Sprintf("http://127.0.0.1:%s/storage/v1/", gcsPort)),
+		option.WithoutAuthentication(),
+	)
+	if err != nil {
+		t.Fatalf("failed to create GCS client: %v", err)
+	}
+	defer client.Close()
+
+	bucket := client.Bucket(bucketName)
+	if err := bucket.Create(ctx, "integration", nil); err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+	store := backup.NewGCSStore(bucket)
+
+	m, err := backup.Run(ctx, backup.Config{
+		Connection: conn,
+		Store:      store,
+		Hostname:   "integration",
+		Workers:    4,
+		SkipDBs:    []string{"information_schema", "performance_schema", "mysql", "sys"},
+		// fake-gcs-server does not implement testIamPermissions.
+		SkipPermissionCheck: true,
+	})
+	if err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+
+	if _, err := mysqlExec(conn, "", nil, "CREATE DATABASE restored"); err != nil {
+		t.Fatalf("failed to create restore database: %v", err)
+	}
+
+	for _, table := range m.Tables {
+		reader, err := store.NewReader(ctx, table.Object)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", table.Object, err)
+		}
+
+		dump, err := gzip.NewReader(reader)
+		if err != nil {
+			t.Fatalf("failed to decompress %s: %v", table.Object, err)
+		}
+
+		if _, err := mysqlExec(conn, "restored", dump, ""); err != nil {
+			t.Fatalf("failed to restore %s: %v", table.Object, err)
+		}
+		reader.Close()
+	}
+
+	for _, table := range []string{"customers", "orders"} {
+		want := checksum(t, conn, "shop."+table)
+		if got := checksum(t, conn, "restored."+table); got != want {
+			t.Errorf("checksum of restored %s = %s, want %s", table, got, want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Formats of the per-database archives of Config.ArchiveFormat.
+const (
+	ArchiveTarGz  = "tar.gz"
+	ArchiveTarZst = "tar.zst"
+)
+
+// archiveManifestEntry is the entry of an archive listing its tables,
+// written after them.
+const archiveManifestEntry = "manifest.json"
+
+// archiveEntrySuffix is the suffix of the uncompressed table dumps in an
+// archive.
+const archiveEntrySuffix = ".sql"
+
+// ValidateArchiveFormat checks an archive format, where empty disables
+// archives.
+func ValidateArchiveFormat(format string) error {
+	switch format {
+	case "", ArchiveTarGz, ArchiveTarZst:
+		return nil
+	}
+	return fmt.Errorf("invalid archive format %q, must be %s or %s", format, ArchiveTarGz, ArchiveTarZst)
+}
+
+// ArchiveManifest is the manifest.json entry of a database archive.
+type ArchiveManifest struct {
+	Database string        `json:"database"`
+	Tables   []TableResult `json:"tables"`
+}
+
+// databaseArchive bundles the table dumps of a database into a single
+// <database>.tar.gz or .tar.zst object: the uncompressed dump of each table
+// as <table>.sql, in the order they are done, followed by manifest.json.
+type databaseArchive struct {
+	object     string
+	store      *TarStore
+	compressor io.WriteCloser
+	writer     *hashingWriter
+	uploader   *Uploader
+	cancel     context.CancelFunc
+}
+
+// newDatabaseArchive starts writing the archive of the database at
+// backupPath in format.
+func newDatabaseArchive(ctx context.Context, uploader *Uploader, backupPath string, format string, spoolDir string) (*databaseArchive, error) {
+	object := backupPath + "." + format
+	ctx, cancel := context.WithCancel(ctx)
+	writer := newHashingWriter(uploader.Store.NewWriter(ctx, object, "application/x-tar", uploader.metadata()))
+
+	var compressor io.WriteCloser = gzip.NewWriter(writer)
+	if format == ArchiveTarZst {
+		encoder, err := zstd.NewWriter(writer)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to start archive %s: %w", object, err)
+		}
+		compressor = encoder
+	}
+
+	store := NewTarStore(compressor)
+	store.Dir = spoolDir
+	return &databaseArchive{object: object, store: store, compressor: compressor, writer: writer, uploader: uploader, cancel: cancel}, nil
+}
+
+// entryUploader returns an Uploader writing uncompressed dumps into the
+// archive, which is compressed as a whole.
+func (a *databaseArchive) entryUploader(labels map[string]string) *Uploader {
+	entries := *a.uploader.withMetadata(labels)
+	entries.Store = a.store
+	entries.CompositePartSize = 0
+	entries.MaxObjectSize = 0
+	entries.stage = nil
+	entries.dictionary = nil
+	entries.samples = nil
+	entries.plain = true
+	return &entries
+}
+
+// close writes the manifest of the archive listing tables and completes the
+// archive object, returning its size.
+func (a *databaseArchive) close(ctx context.Context, database string, tables []TableResult) (int64, error) {
+	defer a.cancel()
+
+	data, err := json.MarshalIndent(ArchiveManifest{Database: database, Tables: tables}, "", "  ")
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode archive manifest: %w", err)
+	}
+	entry := a.store.NewWriter(ctx, archiveManifestEntry, "application/json", nil)
+	if _, err := entry.Write(data); err != nil {
+		return 0, fmt.Errorf("failed to write %s: %w", archiveManifestEntry, err)
+	}
+	if err := entry.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write %s: %w", archiveManifestEntry, err)
+	}
+
+	if err := a.store.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close tar stream: %w", err)
+	}
+	if err := a.compressor.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close compressor: %w", err)
+	}
+	if err := a.writer.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close writer: %w", err)
+	}
+	attrs, err := a.uploader.verify(ctx, a.object, a.writer.sum())
+	if err != nil {
+		return 0, err
+	}
+	return attrs.Size, nil
+}
+
+// archiveTableEntry returns the entry of the dump of a table in an archive.
+func archiveTableEntry(table string) string {
+	return table + archiveEntrySuffix
+}
+
+// splitArchiveEntry splits the name of a table dump in an archive,
+// <archive>/<table>.sql, into the archive object and the entry.
+func splitArchiveEntry(name string) (string, string, bool) {
+	for _, format := range []string{ArchiveTarGz, ArchiveTarZst} {
+		if i := strings.Index(name, "."+format+"/"); i >= 0 {
+			end := i + len(format) + 1
+			return name[:end], name[end+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// parseArchiveObject parses the name of a database archive,
+// <host>/<generation>/<database>.tar.gz or .tar.zst.
+func parseArchiveObject(name string) (TableObject, bool) {
+	var base string
+	for _, format := range []string{ArchiveTarGz, ArchiveTarZst} {
+		if strings.HasSuffix(name, "."+format) {
+			base = strings.TrimSuffix(name, "."+format)
+		}
+	}
+	parts := strings.Split(base, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return TableObject{}, false
+	}
+	if _, ok := parseGeneration(parts[1]); !ok {
+		return TableObject{}, false
+	}
+	return TableObject{Host: parts[0], Generation: parts[1], Database: parts[2], archive: name}, true
+}
+
+// archiveReader reads an entry of an archive.
+type archiveReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *archiveReader) Close() error {
+	var err error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if closeErr := r.closers[i].Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// openArchiveEntry reads an archive up to an entry and returns its content.
+func openArchiveEntry(ctx context.Context, store ObjectStore, archive string, entry string) (io.ReadCloser, error) {
+	reader, err := store.NewReader(ctx, archive)
+	if err != nil {
+		return nil, err
+	}
+	result := &archiveReader{closers: []io.Closer{reader}}
+
+	var decompressed io.ReadCloser
+	if strings.HasSuffix(archive, "."+ArchiveTarZst) {
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			result.Close()
+			return nil, fmt.Errorf("failed to decompress %s: %w", archive, err)
+		}
+		decompressed = decoder.IOReadCloser()
+	} else {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			result.Close()
+			return nil, fmt.Errorf("failed to decompress %s: %w", archive, err)
+		}
+		decompressed = gzipReader
+	}
+	result.closers = append(result.closers, decompressed)
+
+	tarReader := tar.NewReader(decompressed)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			result.Close()
+			return nil, fmt.Errorf("%w: no entry %s in %s", ErrObjectNotExist, entry, archive)
+		}
+		if err != nil {
+			result.Close()
+			return nil, fmt.Errorf("failed to read %s: %w", archive, err)
+		}
+		if header.Name == entry {
+			result.Reader = tarReader
+			return result, nil
+		}
+	}
+}
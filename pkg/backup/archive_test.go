@@ -0,0 +1,79 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+)
+
+func TestRunArchivesEachDatabase(t *testing.T) {
+	for _, format := range []string{ArchiveTarGz, ArchiveTarZst} {
+		t.Run(format, func(t *testing.T) {
+			store := NewMemoryStore()
+			planner := &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"orders", "empty"}}}
+			dumper := &fakeDumper{dumps: map[string]string{"shop.orders": ordersDump}}
+			cfg := testConfig(store, planner, dumper)
+			cfg.ArchiveFormat = format
+
+			m, err := Run(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			archive := m.Path + "/shop." + format
+			for _, table := range m.Tables {
+				if table.Object != archive || table.ArchiveEntry != table.Table+".sql" {
+					t.Errorf("table %s object = %s, entry %s, want %s/%s.sql", table.Table, table.Object, table.ArchiveEntry, archive, table.Table)
+				}
+			}
+
+			for table, want := range map[string]string{"orders": ordersDump, "empty": ""} {
+				object, err := FindTableObject(context.Background(), store, "host", "", "shop", table)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var buf bytes.Buffer
+				if _, err := Download(context.Background(), store, object.Name(), &buf); err != nil {
+					t.Fatalf("Download(%s) error = %v", object.Name(), err)
+				}
+				if buf.String() != want {
+					t.Errorf("Download(%s) = %q, want %q", object.Name(), buf.String(), want)
+				}
+			}
+
+			backups, err := ListDatabaseBackups(context.Background(), store, "host/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(backups) != 1 || backups[0].Tables != 2 {
+				t.Errorf("ListDatabaseBackups() = %+v, want shop with 2 tables", backups)
+			}
+
+			reader, err := openArchiveEntry(context.Background(), store, archive, archiveManifestEntry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var manifest ArchiveManifest
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				t.Fatal(err)
+			}
+			if manifest.Database != "shop" || len(manifest.Tables) != 2 {
+				t.Errorf("archive manifest = %+v, want both tables of shop", manifest)
+			}
+		})
+	}
+}
+
+func TestValidateArchiveFormat(t *testing.T) {
+	for format, valid := range map[string]bool{"": true, ArchiveTarGz: true, ArchiveTarZst: true, "zip": false} {
+		if err := ValidateArchiveFormat(format); (err == nil) != valid {
+			t.Errorf("ValidateArchiveFormat(%q) error = %v, want valid %v", format, err, valid)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+// AutoIncrementReader reads the AUTO_INCREMENT counters and sequences of a
+// database. A Planner implementing it enables the AutoIncrementReport of
+// each database in the manifest, see Config.SkipAutoIncrements.
+type AutoIncrementReader interface {
+	AutoIncrements(database string) (AutoIncrementReport, error)
+}
+
+// AutoIncrementReport holds the AUTO_INCREMENT counters and the state of the
+// sequences of a database, read once its last table was dumped. As counters
+// only grow, they are at least those of the dumps, and raising the counters
+// of restored tables to them keeps values handed out before the backup from
+// being reused.
+type AutoIncrementReport struct {
+	Database string `json:"database"`
+	// Tables maps the tables with an AUTO_INCREMENT column to the next
+	// value it hands out.
+	Tables map[string]int64 `json:"tables,omitempty"`
+	// Sequences are the MariaDB sequences of the database.
+	Sequences []SequenceState `json:"sequences,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// SequenceState is the state of a MariaDB sequence.
+type SequenceState struct {
+	Name string `json:"name"`
+	// NextValue is the next_not_cached_value of the sequence, the first
+	// value no server instance may have handed out yet.
+	NextValue int64 `json:"nextValue"`
+	MinValue  int64 `json:"minValue"`
+	MaxValue  int64 `json:"maxValue"`
+	Start     int64 `json:"start"`
+	Increment int64 `json:"increment"`
+	Cycle     bool  `json:"cycle,omitempty"`
+}
+
+// only returns the report restricted to tables, leaving out the counters and
+// sequences of tables the run did not back up.
+func (r AutoIncrementReport) only(tables []string) AutoIncrementReport {
+	backedUp := map[string]bool{}
+	for _, table := range tables {
+		backedUp[table] = true
+	}
+	report := AutoIncrementReport{Database: r.Database, Error: r.Error}
+	for table, value := range r.Tables {
+		if backedUp[table] {
+			if report.Tables == nil {
+				report.Tables = map[string]int64{}
+			}
+			report.Tables[table] = value
+		}
+	}
+	for _, sequence := range r.Sequences {
+		if backedUp[sequence.Name] {
+			report.Sequences = append(report.Sequences, sequence)
+		}
+	}
+	return report
+}
+
+func (m *Manifest) addAutoIncrements(report AutoIncrementReport) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.AutoIncrements = append(m.AutoIncrements, report)
+	sort.Slice(m.AutoIncrements, func(i, j int) bool {
+		return m.AutoIncrements[i].Database < m.AutoIncrements[j].Database
+	})
+}
+
+// AutoIncrements reads the AUTO_INCREMENT counters of the tables of a
+// database and the state of its sequences.
+func (p *MySQLPlanner) AutoIncrements(database string) (AutoIncrementReport, error) {
+	report := AutoIncrementReport{Database: database}
+
+	db, err := p.get(p.Connection)
+	if err != nil {
+		return report, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return report, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+	defer conn.Close()
+
+	// MySQL 8.0 caches the counters of information_schema.tables for a day
+	// by default; the variable is unknown to older servers and MariaDB,
+	// which always read them from the storage engine.
+	_, _ = conn.ExecContext(ctx, "SET SESSION information_schema_stats_expiry = 0")
+
+	rows, err := conn.QueryContext(ctx, "SELECT table_name, table_type, auto_increment FROM information_schema.tables WHERE table_schema = ?", database)
+	if err != nil {
+		return report, fmt.Errorf("failed to query MySQL: %w", err)
+	}
+	defer rows.Close()
+
+	var sequences []string
+	for rows.Next() {
+		var name, tableType string
+		var autoIncrement sql.NullInt64
+		if err := rows.Scan(&name, &tableType, &autoIncrement); err != nil {
+			return report, fmt.Errorf("failed to read query result: %w", err)
+		}
+		switch {
+		case tableType == "SEQUENCE":
+			sequences = append(sequences, name)
+		case autoIncrement.Valid:
+			if report.Tables == nil {
+				report.Tables = map[string]int64{}
+			}
+			report.Tables[name] = autoIncrement.Int64
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return report, fmt.Errorf("failed to read query result: %w", err)
+	}
+	rows.Close()
+
+	sort.Strings(sequences)
+	for _, name := range sequences {
+		sequence := SequenceState{Name: name}
+		row := conn.QueryRowContext(ctx, "SELECT next_not_cached_value, minimum_value, maximum_value, start_value, increment, cycle_option FROM "+quoteTable(database, name))
+		if err := row.Scan(&sequence.NextValue, &sequence.MinValue, &sequence.MaxValue, &sequence.Start, &sequence.Increment, &sequence.Cycle); err != nil {
+			return report, fmt.Errorf("failed to read sequence %s.%s: %w", database, name, err)
+		}
+		report.Sequences = append(report.Sequences, sequence)
+	}
+	return report, nil
+}
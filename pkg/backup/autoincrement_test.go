@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// autoIncrementPlanner reports the AUTO_INCREMENT counters of reports, or
+// errs for databases without one.
+type autoIncrementPlanner struct {
+	*fakePlanner
+	reports map[string]AutoIncrementReport
+}
+
+func (p *autoIncrementPlanner) AutoIncrements(database string) (AutoIncrementReport, error) {
+	report, ok := p.reports[database]
+	if !ok {
+		return AutoIncrementReport{Database: database}, errFake
+	}
+	return report, nil
+}
+
+func TestRunRecordsAutoIncrements(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &autoIncrementPlanner{
+		fakePlanner: &fakePlanner{databases: []string{"shop", "crm"}, tables: map[string][]string{"shop": {"orders", "order_seq"}, "crm": {"leads"}}},
+		reports: map[string]AutoIncrementReport{
+			"shop": {
+				Database:  "shop",
+				Tables:    map[string]int64{"orders": 1042, "ignored": 7},
+				Sequences: []SequenceState{{Name: "order_seq", NextValue: 2001, MinValue: 1, MaxValue: 9223372036854775806, Start: 1, Increment: 1}},
+			},
+		},
+	}
+	dumper := &fakeDumper{dumps: map[string]string{"shop.orders": ordersDump}}
+	cfg := testConfig(store, planner, dumper)
+
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := []AutoIncrementReport{
+		{Database: "crm", Error: errFake.Error()},
+		{
+			Database:  "shop",
+			Tables:    map[string]int64{"orders": 1042},
+			Sequences: []SequenceState{{Name: "order_seq", NextValue: 2001, MinValue: 1, MaxValue: 9223372036854775806, Start: 1, Increment: 1}},
+		},
+	}
+	if !reflect.DeepEqual(m.AutoIncrements, want) {
+		t.Errorf("AutoIncrements = %+v, want %+v", m.AutoIncrements, want)
+	}
+	if got := readManifest(t, store, m.Path).AutoIncrements; !reflect.DeepEqual(got, want) {
+		t.Errorf("uploaded AutoIncrements = %+v, want %+v", got, want)
+	}
+
+	cfg.SkipAutoIncrements = true
+	m, err = Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if m.AutoIncrements != nil {
+		t.Errorf("AutoIncrements = %+v with SkipAutoIncrements, want none", m.AutoIncrements)
+	}
+}
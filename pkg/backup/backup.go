@@ -0,0 +1,1342 @@
+// Package backup dumps MySQL tables with mysqldump and streams them,
+// gzip-compressed, to Google Cloud Storage.
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+var (
+	// ErrEnumeration is returned by Run when databases or tables could not
+	// be listed.
+	ErrEnumeration = errors.New("enumeration failed")
+	// ErrPartialFailure is returned by Run when one or more tables failed
+	// to back up.
+	ErrPartialFailure = errors.New("partial failure")
+)
+
+// Config configures a backup run.
+type Config struct {
+	// RunID identifies the run in the manifest, object metadata and hook
+	// environment; Run generates one if empty.
+	RunID string
+	// Environment and Cluster label every object of the run, see
+	// runLabels.
+	Environment string
+	Cluster     string
+	// Trigger records who or what started the run in the manifest, the
+	// run index and the metadata of every object, see DetectTrigger.
+	Trigger Trigger
+	// Granularity is one of GranularityHour, the default, GranularityDay
+	// and GranularityRun, naming the generation the run is written to.
+	Granularity string
+	// Index writes a RunIndex of the run's objects next to the manifest.
+	Index bool
+	// CompletionMarker writes SuccessMarker or FailedMarker to the run
+	// prefix once everything else of the run was uploaded.
+	CompletionMarker bool
+	// SkipServerInfo leaves out the ServerInfo snapshot uploaded at the
+	// start of runs whose planner is a ServerInspector.
+	SkipServerInfo bool
+	// SkipTableChangeCheck leaves out the comparison of the enumerated
+	// tables with those of the previous run, see TableChanges, which are
+	// otherwise logged, recorded in the manifest and passed to the
+	// tables-changed hook.
+	SkipTableChangeCheck bool
+	// SkipAutoIncrements leaves out the AutoIncrementReport recorded in the
+	// manifest per database of runs whose planner is an AutoIncrementReader.
+	SkipAutoIncrements bool
+
+	Connection Connection
+	// SelectSecondary treats Connection as an endpoint of a Group
+	// Replication group, e.g. any member or a MySQL Router, and dumps the
+	// ONLINE secondary with the fewest queued transactions instead. Runs
+	// without one fail with ErrNoSecondary unless AllowPrimary is set.
+	SelectSecondary bool
+	AllowPrimary    bool
+	Store           ObjectStore
+	Hostname        string
+	// Workers bounds the number of tables, partitions and database routines
+	// dumped at the same time across all databases; it defaults to
+	// DefaultWorkers.
+	Workers int
+	// SkipDBs are database name patterns, see ValidatePatterns; nil skips
+	// DefaultSkipDBs.
+	SkipDBs []string
+	// IgnoreTables are "database.table" patterns of tables left out of the
+	// run, like the --ignore-table option of mysqldump; see
+	// ValidateTablePatterns.
+	IgnoreTables []string
+	// IncludeSystemSchemas backs up SystemSchemas, except for their volatile
+	// tables; they are skipped otherwise.
+	IncludeSystemSchemas bool
+
+	// SplitPartitions dumps RANGE and LIST partitioned tables as their
+	// definition plus one object per partition, using PartitionDumper, which
+	// defaults to a NativeDumper.
+	SplitPartitions bool
+	PartitionDumper Dumper
+	// ChunkRows, if positive, dumps base tables with an integer primary key
+	// and more rows than it, by their information_schema estimate, as their
+	// definition plus chunks of ChunkRows rows in key order, using
+	// PartitionDumper and a Planner implementing Chunker. Every chunk done
+	// is checkpointed, so a later run writing to the same generation
+	// resumes an interrupted table after its last chunk.
+	ChunkRows int64
+
+	// NonTransactional selects how tables of non-transactional engines such
+	// as MyISAM are handled; it defaults to NonTransactionalLock.
+	NonTransactional string
+
+	// Routines selects where stored procedures, functions and events are
+	// dumped; it defaults to RoutinesDatabase. SkipTriggers leaves out
+	// triggers, which are otherwise dumped with their table.
+	Routines     string
+	SkipTriggers bool
+
+	// ExtendedInsert dumps multiple rows per INSERT statement and NoHexBlob
+	// binary columns as strings; TableConfig overrides both per table.
+	ExtendedInsert bool
+	NoHexBlob      bool
+
+	// ProbeTables reads one row of every table before dumping it and skips
+	// tables that cannot be read instead of failing them.
+	ProbeTables bool
+
+	// CompositeThreshold enables parallel composite uploads of compressed
+	// dumps larger than it, see Uploader.CompositePartSize.
+	CompositeThreshold   int
+	CompositeParallelism int
+	// MaxObjectSize is the compressed size at which dumps roll over to
+	// continuation objects; it defaults to DefaultMaxObjectSize.
+	MaxObjectSize int64
+	// DictionaryTableSize, if positive, compresses the dumps of tables of
+	// at most this size, by information_schema, with zstd and a dictionary
+	// shared by all of them instead of gzip, which compresses thousands of
+	// tiny similar tables far better. A run finding no dictionary under
+	// <host>/DictionaryPrefix trains one on the start of their dumps for
+	// later runs to use.
+	DictionaryTableSize int64
+	// Deduplicate stores the dumps of tables dumped whole as content-defined
+	// chunks under <host>/DedupChunkPrefix, named by the SHA-256 of their
+	// content, plus a <table>.dedup.json index per generation listing them.
+	// Chunks already stored by earlier runs are not uploaded again, so
+	// repeated backups of slowly-changing data only store what changed.
+	Deduplicate bool
+	// ArchiveFormat, ArchiveTarGz or ArchiveTarZst, bundles the table dumps
+	// of each database into a single <database>.tar.gz or .tar.zst object
+	// holding their uncompressed dumps and a manifest.json listing them,
+	// instead of one object per table. Tables are then dumped whole.
+	ArchiveFormat string
+
+	HTMLReport       bool
+	ProgressInterval time.Duration
+
+	// DumpTimeout, if positive, logs dumps running longer than it with the
+	// server threads running their queries, and with KillLongDumps kills
+	// those queries with KILL QUERY, failing the dumps. KillOnCancel kills
+	// the queries of all running dumps when ctx is cancelled. All need a
+	// Planner implementing ThreadKiller, as MySQLPlanner does.
+	DumpTimeout   time.Duration
+	KillLongDumps bool
+	KillOnCancel  bool
+	// EnumerationTimeout is the MySQLPlanner.EnumerationTimeout of the
+	// default planner.
+	EnumerationTimeout time.Duration
+
+	// Deadline, if set, is the time the run should be finished by. Once the
+	// progress ETA is later, low-priority tables that have not started yet
+	// are recorded as skipped instead of being dumped.
+	Deadline time.Time
+
+	// Window, if set, restricts the run to a daily maintenance window: Run
+	// waits for it to open, and no table is started while it is closed.
+	// With WindowMustFinish, tables not started when the window closes are
+	// skipped instead, and the end of the window is the run's Deadline.
+	Window           *Window
+	WindowMustFinish bool
+	// MaxThreadsRunning and MaxThreadsConnected pause starting new tables
+	// while the Threads_running or Threads_connected status of the server,
+	// which counts the connections of the run itself, is above them, until
+	// a check every LoadCheckInterval finds it below. They need a Planner
+	// implementing LoadMonitor; 0 disables them.
+	MaxThreadsRunning   int
+	MaxThreadsConnected int
+	LoadCheckInterval   time.Duration
+
+	// MaxBytesPerRun, if positive, is the number of compressed bytes after
+	// which no new table is started; tables being dumped complete. Skipped
+	// tables are recorded as such.
+	MaxBytesPerRun int64
+
+	// MaxMemory, if positive, caps the memory buffered by concurrent
+	// uploads: upload chunks are made smaller and, if that is not enough,
+	// fewer dumps run at the same time.
+	MaxMemory int64
+
+	// UploadWorkers, if positive, compresses dumps into files in SpoolDir,
+	// or the default temporary directory, and uploads at most this many of
+	// them at a time, so that slow uploads do not keep mysqldump processes
+	// and server connections open. Otherwise dumps are streamed to GCS.
+	// Dumps started while less than SpoolMinFree bytes are free in the
+	// directory are streamed as well.
+	UploadWorkers int
+	SpoolDir      string
+	SpoolMinFree  int64
+
+	// MinSizeRatio, if positive, flags dumps whose compressed size is below
+	// this fraction of their size in the previous run as suspiciously
+	// small, like those below TableConfig.MinCompressedBytes.
+	// FailSmallDumps fails such tables instead of only flagging them.
+	MinSizeRatio   float64
+	FailSmallDumps bool
+
+	// ChecksumTables records the CHECKSUM TABLE of every base table, taken
+	// just before it is dumped, in the manifest and the metadata of its
+	// objects if the planner is a Checksummer. It reads every table once
+	// more; TableConfig.Checksum overrides it per table.
+	ChecksumTables bool
+
+	// RowCountCheck compares the rows dumped of every base table with its
+	// row count, see RowCountEstimate and RowCountExact. Mismatches are
+	// recorded in the manifest; with RowCountExact they fail the table.
+	RowCountCheck string
+
+	CostEstimate       bool
+	StorageClass       string
+	StoragePricePerGiB float64
+
+	// BucketPolicy is checked against the buckets of Store before the run.
+	BucketPolicy BucketPolicy
+	// SkipPermissionCheck skips testing the permissions of the credentials
+	// on the buckets of Store before the run, and SkipPrivilegeCheck the
+	// privileges of the MySQL account, see GrantLister.
+	SkipPermissionCheck bool
+	SkipPrivilegeCheck  bool
+
+	// Planner and Dumper default to a MySQLPlanner and to the mysqldump
+	// binary at MysqldumpPath using Connection. With PureGo the Dumper
+	// defaults to a NativeDumper writing definitions, so that a run needs no
+	// external binaries.
+	Planner       Planner
+	Dumper        Dumper
+	MysqldumpPath string
+	PureGo        bool
+
+	// Shards, if set, back up a sharded dataset: each connection is backed
+	// up as a run of its own, all at the same time and in the same
+	// generation, stored under the host name ShardName(i) instead of
+	// Hostname. Connection is unused; a combined manifest of the tables of
+	// all shards, listing them as Manifest.Shards, is uploaded to
+	// <Hostname>/<generation>.
+	Shards []Connection
+	// started, if set, is the start of the run, so that the shards of a
+	// sharded run share their generation.
+	started time.Time
+	// sharedStore is set for the shards of a sharded run, whose calls to
+	// the store they share are only counted in the combined manifest.
+	sharedStore bool
+
+	// ConsistentSnapshot dumps every table of the run as of one point in
+	// time, that of a snapshot taken at the start of the run under a brief
+	// FLUSH TABLES WITH READ LOCK, or the backup locks of Percona Server,
+	// see Snapshot. It needs the dumps to be made by NativeDumpers.
+	ConsistentSnapshot bool
+
+	Hooks Hooks
+
+	// Inventory, if set, records the run once its manifest is uploaded.
+	Inventory Inventory
+	// StatusReporter, if set, is told when the run starts and finishes.
+	StatusReporter StatusReporter
+	// Signer, if set, signs the manifest, see ManifestSigner.
+	Signer ManifestSigner
+
+	// Tables holds per-table settings keyed by "database.table" patterns.
+	Tables map[string]TableConfig
+}
+
+// Run backs up all databases and tables selected by cfg and uploads the
+// manifest. The returned manifest is non-nil whenever a run was attempted,
+// even if it failed.
+func Run(ctx context.Context, cfg Config) (m *Manifest, err error) {
+	if reporter := cfg.StatusReporter; reporter != nil {
+		defer func() {
+			if reportErr := reporter.RunFinished(ctx, m, err); reportErr != nil {
+				log.Printf("Failed to report the run status: %v\n", reportErr)
+			}
+		}()
+	}
+	if len(cfg.Shards) > 0 {
+		return runShards(ctx, cfg)
+	}
+
+	if cfg.SelectSecondary {
+		conn, err := selectGroupMember(ctx, cfg.Connection, cfg.AllowPrimary)
+		if err != nil {
+			return nil, fmt.Errorf("failed to select a replication group member: %w", err)
+		}
+		cfg.Connection = conn
+	}
+
+	planner := cfg.Planner
+	if planner == nil {
+		mysqlPlanner := &MySQLPlanner{Connection: cfg.Connection, EnumerationTimeout: cfg.EnumerationTimeout}
+		defer mysqlPlanner.Close()
+		planner = mysqlPlanner
+	}
+
+	dumper := cfg.Dumper
+	switch {
+	case dumper != nil:
+	case cfg.PureGo:
+		native := &NativeDumper{Connection: cfg.Connection, Definitions: true}
+		defer native.Close()
+		dumper = native
+	default:
+		dumper = &Mysqldump{Connection: cfg.Connection, Path: cfg.MysqldumpPath}
+	}
+
+	// The native dumper cannot apply mysqldump options, and a table limited
+	// with --where would be dumped in full.
+	if _, ok := dumper.(*NativeDumper); ok {
+		var patterns []string
+		for pattern, config := range cfg.Tables {
+			if len(config.MysqldumpArgs) > 0 {
+				patterns = append(patterns, fmt.Sprintf("%q", pattern))
+			}
+		}
+		if len(patterns) > 0 {
+			sort.Strings(patterns)
+			return nil, fmt.Errorf("the native dumper cannot apply the mysqldumpArgs of tables %s", strings.Join(patterns, ", "))
+		}
+	}
+
+	if err := ValidateArchiveFormat(cfg.ArchiveFormat); err != nil {
+		return nil, err
+	}
+
+	if cfg.ConsistentSnapshot {
+		if _, ok := dumper.(*NativeDumper); !ok {
+			return nil, errors.New("a consistent snapshot needs the native dumper")
+		}
+		if _, ok := cfg.PartitionDumper.(*NativeDumper); cfg.PartitionDumper != nil && !ok {
+			return nil, errors.New("a consistent snapshot needs the native partition dumper")
+		}
+	}
+
+	if err := runPreflight(ctx, cfg, planner, dumper); err != nil {
+		return nil, err
+	}
+
+	if cfg.Window != nil {
+		if err := waitForWindow(ctx, *cfg.Window); err != nil {
+			return nil, fmt.Errorf("failed to wait for the maintenance window: %w", err)
+		}
+		if cfg.WindowMustFinish {
+			closes := cfg.Window.Closes(time.Now())
+			if cfg.Deadline.IsZero() || closes.Before(cfg.Deadline) {
+				cfg.Deadline = closes
+			}
+		}
+	}
+
+	started := cfg.started
+	if started.IsZero() {
+		started = time.Now()
+	}
+	if cfg.RunID == "" {
+		cfg.RunID = NewRunID(started)
+	}
+	backupRoot := fmt.Sprintf("%s/%s", cfg.Hostname, generationName(started, cfg.Granularity))
+	runManifest := newManifest(cfg.Hostname, backupRoot, started)
+	runManifest.RunID = cfg.RunID
+	runManifest.Environment = cfg.Environment
+	runManifest.Cluster = cfg.Cluster
+	runManifest.Trigger = cfg.Trigger.recorded()
+	if cfg.SelectSecondary {
+		runManifest.Member = net.JoinHostPort(cfg.Connection.Host, cfg.Connection.Port)
+	}
+
+	reportStarted(ctx, cfg.StatusReporter, runManifest)
+	if err := runHook(ctx, "pre-run", cfg.Hooks.PreRun, runManifest.hookEnv("pre-run")); err != nil {
+		return nil, err
+	}
+
+	manifest, err := run(ctx, cfg, planner, dumper, runManifest)
+	if manifest == nil && err != nil && cfg.CompletionMarker {
+		uploader := &Uploader{Store: cfg.Store, Metadata: runLabels(cfg)}
+		if markerErr := writeCompletionMarker(ctx, uploader, cfg.RunID, backupRoot, false, err); markerErr != nil {
+			log.Printf("Failed to write completion marker: %v\n", markerErr)
+		}
+	}
+
+	env := runManifest.hookEnv("post-run")
+	env["BACKUP_STATUS"] = StatusSucceeded
+	env["BACKUP_FAILED_TABLES"] = fmt.Sprint(runManifest.FailedTables())
+	env["BACKUP_SMALL_TABLES"] = strings.Join(runManifest.smallTables(), ",")
+	env["BACKUP_ROW_COUNT_TABLES"] = strings.Join(runManifest.rowCountTables(), ",")
+	for key, value := range runManifest.TableChanges.env() {
+		env[key] = value
+	}
+	if err != nil {
+		env["BACKUP_STATUS"] = StatusFailed
+		env["BACKUP_ERROR"] = err.Error()
+	}
+	if cfg.Hooks.PostRun != "" {
+		env["BACKUP_MESSAGE"] = renderMessage(cfg.Hooks.MessageTemplate, runManifest.summary("post-run", err))
+	}
+	if hookErr := runHook(ctx, "post-run", cfg.Hooks.PostRun, env); hookErr != nil {
+		log.Println(hookErr)
+	}
+
+	return manifest, err
+}
+
+// Handling of tables with non-transactional engines, which mysqldump can
+// only dump consistently while holding a read lock on them.
+const (
+	// NonTransactionalLock dumps them with --lock-tables.
+	NonTransactionalLock = "lock"
+	// NonTransactionalWarn dumps them without locking, logging a warning.
+	NonTransactionalWarn = "warn"
+	// NonTransactionalSkip leaves them out of the run.
+	NonTransactionalSkip = "skip"
+)
+
+func run(ctx context.Context, cfg Config, planner Planner, dumper Dumper, runManifest *Manifest) (*Manifest, error) {
+	storageStats := func() *StorageStats { return nil }
+	if !cfg.sharedStore {
+		storageStats = trackStorageStats(cfg.Store)
+	}
+
+	allDatabases, err := planner.Databases()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to retrieve list of databases: %w", ErrEnumeration, err)
+	}
+
+	skipDBs := cfg.SkipDBs
+	if skipDBs == nil {
+		skipDBs = DefaultSkipDBs
+	}
+
+	var databases []string
+	for _, database := range allDatabases {
+		if matchAny(skipDBs, database) || (contains(SystemSchemas, database) && !cfg.IncludeSystemSchemas) {
+			continue
+		}
+		databases = append(databases, database)
+	}
+
+	if lister, ok := planner.(GrantLister); ok && !cfg.SkipPrivilegeCheck {
+		if err := checkPrivileges(lister, cfg, databases); err != nil {
+			return nil, err
+		}
+	}
+
+	backupRoot := runManifest.Path
+	runProgress := newProgress(runManifest.Started)
+	uploader := &Uploader{
+		Store:                cfg.Store,
+		Progress:             runProgress,
+		Metadata:             runLabels(cfg),
+		CompositePartSize:    cfg.CompositeThreshold,
+		CompositeParallelism: cfg.CompositeParallelism,
+		MaxObjectSize:        cfg.MaxObjectSize,
+	}
+	if uploader.MaxObjectSize <= 0 {
+		uploader.MaxObjectSize = DefaultMaxObjectSize
+	}
+	if cfg.UploadWorkers > 0 {
+		uploader.stage = newUploadStage(cfg.SpoolDir, cfg.UploadWorkers, cfg.SpoolMinFree)
+	}
+
+	if cfg.Deduplicate {
+		marker := runningMarker(cfg.Hostname, cfg.RunID)
+		if err := uploader.UploadObject(ctx, marker, "text/plain", []byte(backupRoot)); err != nil {
+			log.Printf("Failed to write %s, a concurrent gc may delete the chunks this run reuses: %v\n", marker, err)
+		} else {
+			defer func() {
+				if err := cfg.Store.Delete(context.Background(), marker); err != nil && !errors.Is(err, ErrObjectNotExist) {
+					log.Printf("Failed to delete %s: %v\n", marker, err)
+				}
+			}()
+		}
+	}
+
+	var previous *Manifest
+	if cfg.MinSizeRatio > 0 || !cfg.SkipTableChangeCheck {
+		if previous, err = previousRun(ctx, cfg.Store, cfg.Hostname, backupRoot); err != nil {
+			log.Printf("Failed to load the previous run, dump sizes and tables are not compared with it: %v\n", err)
+		}
+	}
+	sizeChecks := newSizeCheck(previous, cfg.MinSizeRatio)
+
+	if inspector, ok := planner.(ServerInspector); ok && !cfg.SkipServerInfo {
+		if name, err := uploadServerInfo(ctx, inspector, uploader, backupRoot); err != nil {
+			log.Printf("Failed to upload server info: %v\n", err)
+		} else {
+			runManifest.ServerInfo = name
+		}
+	}
+
+	sizes, err := planner.DatabaseSizes(databases)
+	if err != nil {
+		log.Printf("Failed to get database sizes, ETA will not be reported: %v\n", err)
+	}
+
+	// Start the largest databases first so they do not end up alone on the
+	// critical path of the run.
+	sort.SliceStable(databases, func(i, j int) bool {
+		return sizes[databases[i]].Data+sizes[databases[i]].Index > sizes[databases[j]].Data+sizes[databases[j]].Index
+	})
+
+	var estimatedBytes int64
+	for _, size := range sizes {
+		estimatedBytes += size.Data
+	}
+	runProgress.estimatedBytes.Store(estimatedBytes)
+
+	partitionDumper := cfg.PartitionDumper
+	if partitionDumper == nil && (cfg.SplitPartitions || cfg.ChunkRows > 0) {
+		native := &NativeDumper{Connection: cfg.Connection}
+		defer native.Close()
+		partitionDumper = native
+	}
+	_, nativePartitions := partitionDumper.(*NativeDumper)
+
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+
+	// Without the snapshot the run was asked for, every table fails.
+	var snapshotErr error
+	if cfg.ConsistentSnapshot {
+		native := dumper.(*NativeDumper)
+		if db, err := native.get(native.Connection); err != nil {
+			snapshotErr = fmt.Errorf("failed to connect to MySQL: %w", err)
+		} else if snapshot, err := openSnapshot(ctx, db, workers); err != nil {
+			snapshotErr = fmt.Errorf("failed to take a consistent snapshot: %w", err)
+		} else {
+			defer snapshot.close()
+			native.snapshot = snapshot
+			if partitions, ok := partitionDumper.(*NativeDumper); ok {
+				partitions.snapshot = snapshot
+			}
+			runManifest.Snapshot = &snapshot.info
+		}
+	}
+
+	stopProgress := runProgress.report(cfg.ProgressInterval)
+
+	if cfg.DumpTimeout > 0 || cfg.KillOnCancel {
+		if threads, ok := planner.(ThreadKiller); ok {
+			watch := newWatchdog(threads, cfg.DumpTimeout, cfg.KillLongDumps, cfg.KillOnCancel)
+			dumper = watch.wrap(dumper)
+			partitionDumper = watch.wrap(partitionDumper)
+			defer watch.run(ctx)()
+		} else {
+			log.Printf("The planner cannot find the server threads of dumps, dumps are not watched\n")
+		}
+	}
+
+	tableBackups := &tableBackup{
+		planner:         planner,
+		dumper:          dumper,
+		partitionDumper: partitionDumper,
+		uploader:        uploader,
+	}
+	if cfg.DictionaryTableSize > 0 {
+		dict, err := loadDictionary(ctx, cfg.Store, cfg.Hostname)
+		switch {
+		case err != nil:
+			log.Printf("Failed to load the zstd dictionary, small tables are compressed with gzip: %v\n", err)
+		case dict == nil:
+			log.Printf("No zstd dictionary yet, training one on the small tables of this run\n")
+			tableBackups.samples = &dictionarySamples{}
+		default:
+			tableBackups.dictionary = dict
+			runManifest.Dictionary = dict.object
+		}
+	}
+
+	pool := semaphore.NewWeighted(int64(workers))
+	tableBackups.pool = pool
+	if cfg.MaxMemory > 0 {
+		uploader.budgetMemory(cfg.MaxMemory, workers)
+	}
+
+	// The failures of databases are collected as they finish and only
+	// joined into the error of the run once every table is done.
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		failures []error
+	)
+	fail := func(err error) {
+		errMu.Lock()
+		defer errMu.Unlock()
+		failures = append(failures, err)
+	}
+
+	routines := cfg.Routines == "" || cfg.Routines == RoutinesDatabase
+
+	// launch runs job on a worker acquired from pool.
+	launch := func(db *databaseRun, job func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := job()
+			pool.Release(1)
+			db.done(err)
+		}()
+	}
+
+	// submit runs job once a worker is free, or cancelled instead if the
+	// run is cancelled first. Jobs start in the order they are submitted.
+	submit := func(db *databaseRun, job func() error, cancelled func()) {
+		if err := pool.Acquire(ctx, 1); err != nil {
+			cancelled()
+			return
+		}
+		launch(db, job)
+	}
+
+	throttle := newLoadThrottle(planner, cfg)
+	autoIncrements, _ := planner.(AutoIncrementReader)
+	if cfg.SkipAutoIncrements {
+		autoIncrements = nil
+	}
+
+	var queue []queuedTable
+	enumerated := map[string]bool{}
+	unknown := map[string]bool{}
+	ignored := map[string]bool{}
+	for _, database := range databases {
+		tableInfos, err := planner.Tables(database)
+		if err != nil {
+			err = fmt.Errorf("failed to retrieve list of tables for database %s: %w", database, err)
+			runManifest.addError(err)
+			fail(err)
+			unknown[database] = true
+			continue
+		}
+
+		db := &databaseRun{name: database, infos: map[string]TableInfo{}}
+		for _, info := range tableInfos {
+			if contains(volatileSystemTables[database], info.Name) {
+				continue
+			}
+			if matchAny(cfg.IgnoreTables, database+"."+info.Name) {
+				ignored[database+"."+info.Name] = true
+				continue
+			}
+			db.tables = append(db.tables, info.Name)
+			db.infos[info.Name] = info
+			enumerated[database+"."+info.Name] = true
+
+			if warning := charsetWarning(cfg.Connection.charset(), info.Charset()); warning != "" {
+				log.Printf("Table \"%s.%s\" uses collation %s: %s\n", database, info.Name, info.Collation, warning)
+			}
+		}
+		db.pending = len(db.tables)
+		if routines {
+			db.pending++
+		}
+		runProgress.tablesTotal.Add(int64(len(db.tables)))
+
+		if len(db.tables) == 0 {
+			queue = append(queue, queuedTable{db: db, priority: priorityRank(PriorityNormal)})
+		}
+		for _, table := range db.tables {
+			priority := tableConfig(cfg.Tables, database, table).Priority
+			queue = append(queue, queuedTable{db: db, table: table, priority: priorityRank(priority)})
+		}
+	}
+
+	if previous != nil && !cfg.SkipTableChangeCheck {
+		if changes := compareTables(previous, enumerated, unknown, ignored); changes != nil {
+			runManifest.TableChanges = changes
+			reportTableChanges(ctx, cfg.Hooks, runManifest, changes)
+		}
+	}
+
+	// High-priority tables of all databases go first; within a priority,
+	// databases keep their largest-first order.
+	sort.SliceStable(queue, func(i, j int) bool {
+		return queue[i].priority < queue[j].priority
+	})
+
+	// failTables records every table of a database as failed with err.
+	failTables := func(db *databaseRun, err error) {
+		now := time.Now()
+		for _, table := range db.tables {
+			runManifest.addTable(TableResult{
+				Database: db.name,
+				Table:    table,
+				Type:     db.infos[table].Type,
+				Engine:   db.infos[table].Engine,
+				Status:   StatusFailed,
+				Error:    err.Error(),
+				Started:  now,
+				Finished: now,
+			})
+		}
+		runProgress.tablesDone.Add(int64(len(db.tables)))
+	}
+
+	if snapshotErr != nil {
+		log.Println(snapshotErr)
+		for _, queued := range queue {
+			if !queued.db.failed {
+				queued.db.failed = true
+				failTables(queued.db, snapshotErr)
+			}
+		}
+		fail(snapshotErr)
+		queue = nil
+	}
+
+	// start runs the pre-database hook and queues the routines of a database
+	// before its first table, and reports whether its tables are to be
+	// backed up.
+	start := func(db *databaseRun) bool {
+		if db.started {
+			return !db.failed
+		}
+		db.started = true
+		database := db.name
+
+		log.Printf("Backing up database: %s\n", database)
+
+		db.finish = func(err error) {
+			if db.archive != nil {
+				size, archiveErr := db.archive.close(ctx, database, runManifest.databaseTables(database))
+				if archiveErr != nil {
+					archiveErr = fmt.Errorf("failed to write archive %s of database %s: %w", db.archive.object, database, archiveErr)
+					log.Println(archiveErr)
+					runManifest.failArchive(db.archive.object, archiveErr)
+					err = errors.Join(err, archiveErr)
+				} else {
+					log.Printf("Archived database %s to %s (%s)\n", database, db.archive.object, FormatBytes(size))
+				}
+			}
+
+			if autoIncrements != nil && len(db.tables) > 0 {
+				report, reportErr := autoIncrements.AutoIncrements(database)
+				if reportErr != nil {
+					log.Printf("Failed to read the AUTO_INCREMENT counters of database %s: %v\n", database, reportErr)
+					report.Error = reportErr.Error()
+				}
+				runManifest.addAutoIncrements(report.only(db.tables))
+			}
+
+			dbEnv := runManifest.hookEnv("post-database")
+			dbEnv["BACKUP_DATABASE"] = database
+			dbEnv["BACKUP_STATUS"] = StatusSucceeded
+			if err != nil {
+				dbEnv["BACKUP_STATUS"] = StatusFailed
+				dbEnv["BACKUP_ERROR"] = err.Error()
+			}
+			if hookErr := runHook(ctx, "post-database", cfg.Hooks.PostDatabase, dbEnv); hookErr != nil {
+				log.Printf("Database %s: %v\n", database, hookErr)
+			}
+
+			// Each failure was logged as it happened.
+			if err != nil {
+				failed := 0
+				for _, result := range runManifest.databaseTables(database) {
+					if result.Status == StatusFailed {
+						failed++
+					}
+				}
+				log.Printf("Backup for database %s completed with errors: %d of %d table(s) failed\n", database, failed, len(db.tables))
+				fail(fmt.Errorf("database %s: %w", database, err))
+				return
+			}
+
+			log.Printf("Backup for database %s completed.\n", database)
+		}
+
+		dbEnv := runManifest.hookEnv("pre-database")
+		dbEnv["BACKUP_DATABASE"] = database
+		if err := runHook(ctx, "pre-database", cfg.Hooks.PreDatabase, dbEnv); err != nil {
+			err = fmt.Errorf("database %s: %w", database, err)
+			failTables(db, err)
+			fail(err)
+			db.failed = true
+			return false
+		}
+
+		if cfg.ArchiveFormat != "" && len(db.tables) > 0 {
+			archive, err := newDatabaseArchive(ctx, uploader, fmt.Sprintf("%s/%s", backupRoot, database), cfg.ArchiveFormat, cfg.SpoolDir)
+			if err != nil {
+				log.Printf("Dumping the tables of database %s as separate objects: %v\n", database, err)
+			}
+			db.archive = archive
+		}
+
+		if db.pending == 0 {
+			db.finish(nil)
+			return true
+		}
+
+		if routines {
+			submit(db, func() error {
+				failedOver := storeFailedOver(cfg.Store)
+				result, err := backupRoutines(ctx, dumper, uploader, backupRoot, database)
+				if err != nil && !failedOver && storeFailedOver(cfg.Store) {
+					log.Printf("Retrying routines of database %s on the fallback bucket after: %v\n", database, err)
+					result, err = backupRoutines(ctx, dumper, uploader, backupRoot, database)
+				}
+				runManifest.addDatabase(result)
+				if err != nil {
+					log.Printf("Backup of the routines of database %s failed: %v\n", database, err)
+				}
+				return err
+			}, func() {
+				now := time.Now()
+				runManifest.addDatabase(DatabaseResult{Database: database, Status: StatusSkipped, Error: "run cancelled", Started: now, Finished: now})
+				db.done(nil)
+			})
+		}
+
+		return true
+	}
+
+	backupTable := func(db *databaseRun, table string) error {
+		database, infos := db.name, db.infos
+
+		backupPath := fmt.Sprintf("%s/%s", backupRoot, database)
+
+		log.Printf("Backing up table: \"%s.%s\"\n", database, table)
+
+		result := TableResult{
+			Database: database,
+			Table:    table,
+			Type:     infos[table].Type,
+			Engine:   infos[table].Engine,
+			Object:   fmt.Sprintf("%s/%s.sql.gz", backupPath, table),
+			Started:  time.Now(),
+
+			Collation:       infos[table].Collation,
+			ApproximateRows: infos[table].ApproximateRows,
+		}
+
+		if cfg.ProbeTables {
+			if err := planner.Probe(database, table); err != nil {
+				log.Printf("Skipping table \"%s.%s\", it cannot be read: %v\n", database, table, err)
+				runProgress.tablesDone.Add(1)
+				result.Object = ""
+				result.Finished = time.Now()
+				result.Status = StatusSkipped
+				result.Error = err.Error()
+				runManifest.addTable(result)
+				return nil
+			}
+		}
+
+		config := tableConfig(cfg.Tables, database, table)
+		opts := DumpOptions{
+			Routines:       cfg.Routines == RoutinesTable,
+			SkipTriggers:   cfg.SkipTriggers,
+			ExtendedInsert: cfg.ExtendedInsert,
+			NoHexBlob:      cfg.NoHexBlob,
+			MysqldumpArgs:  config.MysqldumpArgs,
+		}
+		if config.ExtendedInsert != nil {
+			opts.ExtendedInsert = *config.ExtendedInsert
+		}
+		if config.HexBlob != nil {
+			opts.NoHexBlob = !*config.HexBlob
+		}
+		if !infos[table].transactional() {
+			switch cfg.NonTransactional {
+			case NonTransactionalSkip:
+				log.Printf("Skipping table \"%s.%s\" with non-transactional engine %s\n", database, table, infos[table].Engine)
+				runProgress.tablesDone.Add(1)
+				result.Object = ""
+				result.Finished = time.Now()
+				result.Status = StatusSkipped
+				result.Error = fmt.Sprintf("non-transactional engine %s", infos[table].Engine)
+				runManifest.addTable(result)
+				return nil
+			case NonTransactionalWarn:
+				log.Printf("Table \"%s.%s\" uses non-transactional engine %s and is dumped without locking, the dump may be inconsistent\n", database, table, infos[table].Engine)
+			default:
+				opts.LockTables = true
+			}
+		}
+
+		result.Checksum = tableChecksum(planner, cfg.ChecksumTables, config, infos[table], database)
+		// Dumps of a subset of the rows cannot be checked against the table.
+		rowCountCheck := cfg.RowCountCheck
+		if config.filtersRows() {
+			rowCountCheck = RowCountNone
+		}
+		rowsBefore := countRows(planner, rowCountCheck, infos[table], database)
+
+		job := tableJob{
+			database: database,
+			table:    table,
+			info:     infos[table],
+			checksum: result.Checksum,
+			object:   result.Object,
+			config:   config,
+			opts:     opts,
+		}
+		if db.archive != nil {
+			result.Object = db.archive.object
+			result.ArchiveEntry = archiveTableEntry(table)
+			job.object = result.ArchiveEntry
+			job.archive = db.archive
+		}
+		// Partitions and chunks are dumped natively, which would ignore the
+		// mysqldump options of the table.
+		whole := nativePartitions && len(config.MysqldumpArgs) > 0
+		if whole && (cfg.SplitPartitions || cfg.ChunkRows > 0) {
+			log.Printf("Dumping table \"%s.%s\" whole with mysqldump, as its mysqldumpArgs do not apply to partitions and chunks\n", database, table)
+		}
+		if cfg.SplitPartitions && !whole && job.archive == nil && infos[table].Type == TableTypeBase {
+			partitions, err := planner.Partitions(database, table)
+			if err != nil {
+				log.Printf("Failed to list partitions of table \"%s.%s\", dumping it as a whole: %v\n", database, table, err)
+			}
+			job.partitions = partitions
+		}
+		if chunker, ok := planner.(Chunker); ok && cfg.ChunkRows > 0 && !whole && job.archive == nil && len(job.partitions) == 0 && infos[table].Type == TableTypeBase && infos[table].ApproximateRows > cfg.ChunkRows {
+			key, err := chunker.ChunkKey(ctx, database, table)
+			switch {
+			case err != nil:
+				log.Printf("Failed to find the primary key of table \"%s.%s\", dumping it as a whole: %v\n", database, table, err)
+			case key.Column == "":
+				log.Printf("Table \"%s.%s\" has no integer primary key to dump it in chunks by\n", database, table)
+			}
+			job.chunkKey = key
+			job.chunkRows = cfg.ChunkRows
+		}
+		if cfg.DictionaryTableSize > 0 && job.archive == nil && len(job.partitions) == 0 && job.chunkKey.Column == "" && infos[table].Size <= cfg.DictionaryTableSize {
+			job.small = true
+			if tableBackups.dictionary != nil {
+				result.Object = dictionaryObjectName(result.Object)
+				job.object = result.Object
+			}
+		}
+		if cfg.Deduplicate && job.archive == nil && len(job.partitions) == 0 && job.chunkKey.Column == "" && !job.small {
+			result.Object = dedupIndexName(result.Object)
+			job.object = result.Object
+		}
+
+		failedOver := storeFailedOver(cfg.Store)
+		stats, partitions, err := tableBackups.isolated(ctx, job)
+		// Entries written to an archive cannot be taken back to retry.
+		if err != nil && job.archive == nil && !failedOver && storeFailedOver(cfg.Store) {
+			log.Printf("Retrying table \"%s.%s\" on the fallback bucket after: %v\n", database, table, err)
+			stats, partitions, err = tableBackups.isolated(ctx, job)
+		}
+		runProgress.tablesDone.Add(1)
+
+		result.Finished = time.Now()
+		result.setStats(stats.UncompressedBytes, stats.CompressedBytes)
+		result.Rows = stats.Rows
+		result.ReusedBytes = stats.ReusedBytes
+		result.Buckets = stats.Buckets
+		result.Parts = stats.Parts
+		if err == nil {
+			result.CRC32C = formatCRC32C(stats.CRC32C)
+			result.MD5 = formatMD5(stats.MD5)
+			result.SHA256 = formatSHA256(stats.SHA256)
+			result.ObjectGeneration = stats.Generation
+			result.Etag = stats.Etag
+			result.PartGenerations = stats.PartGenerations
+		}
+		result.Partitions = partitions
+		if err == nil {
+			if warning := sizeChecks.warning(result, config); warning != "" {
+				log.Printf("Dump of table \"%s.%s\" is suspiciously small: %s\n", database, table, warning)
+				result.SizeWarning = warning
+				if cfg.FailSmallDumps {
+					err = fmt.Errorf("dump of table \"%s.%s\" is suspiciously small: %s", database, table, warning)
+				}
+			}
+		}
+		if err == nil {
+			rowsAfter := int64(-1)
+			if rowsBefore >= 0 {
+				rowsAfter = countRows(planner, rowCountCheck, infos[table], database)
+			}
+			if warning := rowCountWarning(rowCountCheck, result.Rows, infos[table], rowsBefore, rowsAfter); warning != "" {
+				log.Printf("Dump of table \"%s.%s\" does not match its row count: %s\n", database, table, warning)
+				result.RowCountWarning = warning
+				if rowsAfter >= 0 {
+					err = fmt.Errorf("%w: table \"%s.%s\": %s", ErrRowCountMismatch, database, table, warning)
+				}
+			}
+		}
+		result.Status = StatusSucceeded
+		if err != nil {
+			result.Status = StatusFailed
+			result.Error = err.Error()
+		}
+		runManifest.addTable(result)
+
+		if err != nil {
+			log.Printf("Backup for table \"%s.%s\" failed after %s: %v\n", database, table, result.Finished.Sub(result.Started).Round(time.Millisecond), err)
+			return err
+		}
+
+		log.Printf("Backup for table \"%s.%s\" completed in %s: %s dumped, %s compressed (ratio %.2f), %.1f MB/s.\n",
+			database, table, result.Finished.Sub(result.Started).Round(time.Millisecond),
+			FormatBytes(result.UncompressedBytes), FormatBytes(result.CompressedBytes),
+			result.CompressionRatio, result.ThroughputMBps)
+		if result.ReusedBytes > 0 {
+			log.Printf("Deduplicated table \"%s.%s\" reused %s of chunks already stored\n", database, table, FormatBytes(result.ReusedBytes))
+		}
+
+		return nil
+	}
+
+	skip := func(db *databaseRun, table string, reason string) {
+		now := time.Now()
+		runManifest.addTable(TableResult{
+			Database: db.name,
+			Table:    table,
+			Type:     db.infos[table].Type,
+			Engine:   db.infos[table].Engine,
+			Status:   StatusSkipped,
+			Error:    reason,
+			Started:  now,
+			Finished: now,
+		})
+		runProgress.tablesDone.Add(1)
+		db.done(nil)
+	}
+
+	cancelled := 0
+	for _, queued := range queue {
+		db, table := queued.db, queued.table
+
+		// Pause the queue while the maintenance window is closed.
+		if cfg.Window != nil && !cfg.WindowMustFinish && !cfg.Window.Contains(time.Now()) {
+			if err := waitForWindow(ctx, *cfg.Window); err != nil {
+				log.Printf("Stopped waiting for the maintenance window: %v\n", err)
+			}
+		}
+
+		if !start(db) || table == "" {
+			continue
+		}
+
+		// Whether the table is still to be dumped is decided once a worker
+		// is free to start it and the server is not overloaded.
+		if err := pool.Acquire(ctx, 1); err != nil {
+			skip(db, table, "run cancelled")
+			cancelled++
+			continue
+		}
+		if throttle != nil {
+			paused, err := throttle.wait(ctx)
+			if err != nil {
+				log.Printf("Stopped waiting for the server load to drop: %v\n", err)
+			}
+			runManifest.LoadPausedSeconds += paused.Seconds()
+		}
+		reason := ""
+		switch {
+		case cfg.Window != nil && cfg.WindowMustFinish && !cfg.Window.Contains(time.Now()):
+			log.Printf("Skipping table \"%s.%s\", the maintenance window %s has closed\n", db.name, table, cfg.Window)
+			reason = fmt.Sprintf("maintenance window %s closed", cfg.Window)
+		case cfg.MaxBytesPerRun > 0 && runProgress.bytesUploaded.Load() >= cfg.MaxBytesPerRun:
+			log.Printf("Skipping table \"%s.%s\", the run's upload budget of %s is used up\n", db.name, table, FormatBytes(cfg.MaxBytesPerRun))
+			reason = fmt.Sprintf("upload budget of %s used up", FormatBytes(cfg.MaxBytesPerRun))
+		case queued.priority == priorityRank(PriorityLow) && !cfg.Deadline.IsZero() && runProgress.pastDeadline(time.Now(), cfg.Deadline):
+			log.Printf("Shedding low-priority table \"%s.%s\", the run would not finish before its deadline %s\n", db.name, table, cfg.Deadline.Format(time.RFC3339))
+			reason = "shed: run deadline approaching"
+		}
+		if reason != "" {
+			pool.Release(1)
+			skip(db, table, reason)
+			continue
+		}
+
+		launch(db, func() error {
+			return backupTable(db, table)
+		})
+	}
+
+	wg.Wait()
+	if cancelled > 0 {
+		log.Printf("Skipped %d table(s) as the run was cancelled\n", cancelled)
+		fail(fmt.Errorf("run cancelled before %d table(s) started: %w", cancelled, ctx.Err()))
+	}
+
+	if tableBackups.samples != nil {
+		if name, err := trainDictionary(ctx, uploader, cfg.Hostname, runManifest.Started, tableBackups.samples); err != nil {
+			log.Printf("Failed to store a zstd dictionary, small tables of later runs are compressed with gzip: %v\n", err)
+		} else {
+			log.Printf("Stored zstd dictionary %s, later runs compress small tables with it\n", name)
+		}
+	}
+
+	stopProgress()
+	log.Printf("Progress: %s\n", runProgress)
+
+	runManifest.Finished = time.Now()
+	runManifest.Storage = storageStats()
+	runManifest.logSummary()
+
+	if cfg.CostEstimate {
+		estimate, err := estimateCost(ctx, cfg.Store, cfg.StorageClass, cfg.StoragePricePerGiB, cfg.Hostname, runManifest.CompressedBytes)
+		if err != nil {
+			log.Printf("Failed to estimate storage cost: %v\n", err)
+		} else {
+			estimate.log()
+			runManifest.CostEstimate = estimate
+		}
+	}
+
+	manifestErr := runManifest.upload(ctx, uploader, cfg.Signer)
+	if manifestErr != nil {
+		log.Printf("Failed to upload manifest: %v\n", manifestErr)
+	}
+
+	if cfg.Inventory != nil {
+		if err := cfg.Inventory.Record(ctx, runManifest); err != nil {
+			log.Printf("Failed to record run in inventory: %v\n", err)
+		}
+	}
+
+	if cfg.Index {
+		if err := runManifest.uploadIndex(ctx, uploader); err != nil {
+			log.Printf("Failed to upload run index: %v\n", err)
+		}
+	}
+
+	if cfg.HTMLReport {
+		if err := runManifest.uploadHTMLReport(ctx, uploader); err != nil {
+			log.Printf("Failed to upload HTML report: %v\n", err)
+		}
+	}
+
+	backupErr := errors.Join(failures...)
+	var runErr error
+	switch {
+	case len(runManifest.Errors) > 0:
+		runErr = fmt.Errorf("%w: %w", ErrEnumeration, backupErr)
+	case backupErr != nil:
+		runErr = fmt.Errorf("%w: %d table(s) failed: %w", ErrPartialFailure, runManifest.FailedTables(), backupErr)
+	}
+
+	if cfg.CompletionMarker {
+		markerErr := runErr
+		if markerErr == nil && manifestErr != nil {
+			markerErr = manifestErr
+		}
+		if err := writeCompletionMarker(ctx, uploader, cfg.RunID, backupRoot, manifestErr == nil, markerErr); err != nil {
+			log.Printf("Failed to write completion marker: %v\n", err)
+		}
+	}
+
+	if runErr != nil {
+		return runManifest, runErr
+	}
+
+	return runManifest, nil
+}
+
+func storeFailedOver(store ObjectStore) bool {
+	failover, ok := store.(*FailoverStore)
+	return ok && failover.FailedOver()
+}
+
+// tableBackup holds what backing up a single table needs from the run.
+type tableBackup struct {
+	planner         Planner
+	dumper          Dumper
+	partitionDumper Dumper
+	pool            *semaphore.Weighted
+	uploader        *Uploader
+	// dictionary compresses the dumps of small jobs, or samples collects
+	// them to train it on while there is none.
+	dictionary *dictionary
+	samples    *dictionarySamples
+}
+
+// DefaultWorkers is the number of dumps run at the same time unless
+// Config.Workers is set.
+const DefaultWorkers = 4
+
+// databaseRun tracks the jobs of a database still running and calls finish
+// with their errors once the last one is done.
+type databaseRun struct {
+	name   string
+	tables []string
+	infos  map[string]TableInfo
+
+	// started and failed are only used by the goroutine queueing jobs.
+	started bool
+	failed  bool
+
+	// archive, if set, is where the dumps of the tables go, see
+	// Config.ArchiveFormat.
+	archive *databaseArchive
+
+	mu      sync.Mutex
+	pending int
+	errs    []error
+	finish  func(error)
+}
+
+// queuedTable is a table waiting for a worker, or a database without tables
+// if table is empty.
+type queuedTable struct {
+	db       *databaseRun
+	table    string
+	priority int
+}
+
+func (d *databaseRun) done(err error) {
+	d.mu.Lock()
+	if err != nil {
+		d.errs = append(d.errs, err)
+	}
+	d.pending--
+	last := d.pending == 0
+	d.mu.Unlock()
+
+	if last {
+		d.finish(errors.Join(d.errs...))
+	}
+}
+
+// tableJob describes the backup of a single table. Tables with partitions
+// are dumped as their definition plus one object per partition, and tables
+// with a chunkKey as their definition plus one object per chunk.
+type tableJob struct {
+	database   string
+	table      string
+	info       TableInfo
+	checksum   string
+	object     string
+	config     TableConfig
+	opts       DumpOptions
+	partitions []string
+	chunkKey   IntegerKey
+	chunkRows  int64
+	// small is set for tables compressed with the dictionary, see
+	// Config.DictionaryTableSize.
+	small bool
+	// archive, if set, is the archive of the database that object is an
+	// entry of.
+	archive *databaseArchive
+}
+
+func (b *tableBackup) backup(ctx context.Context, job tableJob) (UploadStats, []PartitionResult, error) {
+	database, table := job.database, job.table
+
+	if err := b.planner.Exec(database, job.config.PreSQL); err != nil {
+		return UploadStats{}, nil, fmt.Errorf("pre-dump SQL for table \"%s.%s\" failed: %w", database, table, err)
+	}
+
+	opts := job.opts
+	opts.NoData = len(job.partitions) > 0 || job.chunkKey.Column != ""
+
+	labels := tableLabels(job.info)
+	if job.checksum != "" {
+		labels[checksumMetadataKey] = job.checksum
+	}
+	uploader := b.uploader.withMetadata(labels)
+	if job.archive != nil {
+		uploader = job.archive.entryUploader(labels)
+	}
+	if job.small {
+		uploader.dictionary = b.dictionary
+		uploader.samples = b.samples
+	}
+	stats, err := dumpObject(ctx, b.dumper, uploader, opts, database, table, job.object)
+	if err != nil {
+		return stats, nil, err
+	}
+
+	var partitions []PartitionResult
+	if len(job.partitions) > 0 {
+		partitions, err = backupPartitions(ctx, b.partitionDumper, uploader, b.pool, job.opts, database, table, job.object, job.partitions)
+		for _, partition := range partitions {
+			stats.UncompressedBytes += partition.UncompressedBytes
+			stats.CompressedBytes += partition.CompressedBytes
+			stats.Rows += partition.Rows
+		}
+		if err != nil {
+			return stats, partitions, fmt.Errorf("failed to back up partitions of table \"%s.%s\": %w", database, table, err)
+		}
+	}
+	if job.chunkKey.Column != "" {
+		partitions, err = backupChunks(ctx, b.planner.(Chunker), b.partitionDumper, uploader, job.opts, database, table, job.object, job.chunkKey, job.chunkRows)
+		for _, chunk := range partitions {
+			stats.UncompressedBytes += chunk.UncompressedBytes
+			stats.CompressedBytes += chunk.CompressedBytes
+			stats.Rows += chunk.Rows
+		}
+		if err != nil {
+			return stats, partitions, fmt.Errorf("failed to back up chunks of table \"%s.%s\": %w", database, table, err)
+		}
+	}
+
+	if err := b.planner.Exec(database, job.config.PostSQL); err != nil {
+		return stats, partitions, fmt.Errorf("post-dump SQL for table \"%s.%s\" failed: %w", database, table, err)
+	}
+
+	return stats, partitions, nil
+}
+
+// isolated runs backup, turning a panic into the error of the job so that it
+// only fails its own table.
+func (b *tableBackup) isolated(ctx context.Context, job tableJob) (stats UploadStats, partitions []PartitionResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Backup for table \"%s.%s\" panicked: %v\n%s", job.database, job.table, r, debug.Stack())
+			err = fmt.Errorf("backup of table \"%s.%s\" panicked: %v", job.database, job.table, r)
+		}
+	}()
+	return b.backup(ctx, job)
+}
+
+func dumpObject(ctx context.Context, dumper Dumper, uploader *Uploader, opts DumpOptions, database string, table string, object string) (UploadStats, error) {
+	release, err := uploader.reserveMemory(ctx)
+	if err != nil {
+		return UploadStats{}, err
+	}
+	defer release()
+
+	output, err := dumper.Dump(ctx, database, table, opts)
+	if err != nil {
+		return UploadStats{}, err
+	}
+
+	stats, err := uploader.withMetadata(objectLabels(database, table, opts.Partition)).Upload(ctx, object, output)
+	if err != nil {
+		output.Close()
+		return stats, fmt.Errorf("failed to upload backup for table \"%s.%s\": %w", database, table, err)
+	}
+
+	// A dump that fails once its output is complete, e.g. mysqldump
+	// exiting with an error, leaves a truncated object that looks whole.
+	if err := output.Close(); err != nil {
+		uploader.discard(append([]string{object}, stats.Parts...))
+		return stats, err
+	}
+
+	return stats, nil
+}
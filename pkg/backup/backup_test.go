@@ -0,0 +1,576 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testConfig(store ObjectStore, planner Planner, dumper Dumper) Config {
+	return Config{
+		Store:    store,
+		Hostname: "host",
+		Workers:  4,
+		SkipDBs:  []string{"information_schema"},
+		Routines: RoutinesNone,
+		Planner:  planner,
+		Dumper:   dumper,
+	}
+}
+
+func readGzipObject(t *testing.T, store *MemoryStore, name string) string {
+	t.Helper()
+
+	data, ok := store.Data(name)
+	if !ok {
+		t.Fatalf("object %s was not uploaded", name)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("object %s is not gzip-compressed: %v", name, err)
+	}
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to decompress %s: %v", name, err)
+	}
+
+	return string(content)
+}
+
+func readManifest(t *testing.T, store *MemoryStore, path string) *Manifest {
+	t.Helper()
+
+	data, ok := store.Data(path + "/manifest.json")
+	if !ok {
+		t.Fatalf("manifest was not uploaded under %s", path)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("failed to parse manifest: %v", err)
+	}
+
+	return &m
+}
+
+func TestRunUploadsTablesAndManifest(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &fakePlanner{
+		databases: []string{"information_schema", "shop"},
+		tables:    map[string][]string{"shop": {"orders", "users"}},
+	}
+	dumper := &fakeDumper{dumps: map[string]string{
+		"shop.orders": "INSERT INTO orders VALUES (1);\n",
+		"shop.users":  "INSERT INTO users VALUES (1);\n",
+	}}
+
+	m, err := Run(context.Background(), testConfig(store, planner, dumper))
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if got := readGzipObject(t, store, m.Path+"/shop/orders.sql.gz"); got != dumper.dumps["shop.orders"] {
+		t.Errorf("orders dump = %q, want %q", got, dumper.dumps["shop.orders"])
+	}
+	if got := readGzipObject(t, store, m.Path+"/shop/users.sql.gz"); got != dumper.dumps["shop.users"] {
+		t.Errorf("users dump = %q, want %q", got, dumper.dumps["shop.users"])
+	}
+
+	uploaded := readManifest(t, store, m.Path)
+	if len(uploaded.Tables) != 2 {
+		t.Fatalf("manifest has %d tables, want 2", len(uploaded.Tables))
+	}
+	for _, table := range uploaded.Tables {
+		if table.Status != StatusSucceeded {
+			t.Errorf("table %s status = %s, want %s", table.Table, table.Status, StatusSucceeded)
+		}
+		if table.UncompressedBytes != int64(len(dumper.dumps["shop."+table.Table])) {
+			t.Errorf("table %s uncompressed bytes = %d", table.Table, table.UncompressedBytes)
+		}
+		if table.Rows != 1 {
+			t.Errorf("table %s rows = %d, want 1", table.Table, table.Rows)
+		}
+	}
+}
+
+func TestRunSkipsDatabases(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &fakePlanner{
+		databases: []string{"information_schema", "shop"},
+		tables:    map[string][]string{"information_schema": {"tables"}, "shop": {"orders"}},
+	}
+	dumper := &fakeDumper{}
+
+	if _, err := Run(context.Background(), testConfig(store, planner, dumper)); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	for _, dumped := range dumper.dumped {
+		if strings.HasPrefix(dumped, "information_schema.") {
+			t.Errorf("skipped database was dumped: %s", dumped)
+		}
+	}
+}
+
+func TestRunIgnoresTables(t *testing.T) {
+	planner := &fakePlanner{
+		databases: []string{"shop", "audit"},
+		tables:    map[string][]string{"shop": {"orders", "tmp_orders", "tmp_carts"}, "audit": {"log"}},
+	}
+	dumper := &fakeDumper{}
+	cfg := testConfig(NewMemoryStore(), planner, dumper)
+	cfg.IgnoreTables = []string{"shop.tmp_*", "/^audit\\./"}
+
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(dumper.dumped) != 1 || dumper.dumped[0] != "shop.orders" {
+		t.Errorf("dumped %v, want only shop.orders", dumper.dumped)
+	}
+	if len(m.Tables) != 1 {
+		t.Errorf("manifest lists %d tables, want 1", len(m.Tables))
+	}
+}
+
+func TestRunIncludesSystemSchemas(t *testing.T) {
+	planner := &fakePlanner{
+		databases: []string{"mysql", "shop"},
+		tables: map[string][]string{
+			"mysql": {"general_log", "innodb_table_stats", "time_zone_name", "user"},
+			"shop":  {"orders"},
+		},
+	}
+
+	dumper := &fakeDumper{}
+	if _, err := Run(context.Background(), testConfig(NewMemoryStore(), planner, dumper)); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := strings.Join(dumper.dumped, ","); got != "shop.orders" {
+		t.Errorf("dumped %s without IncludeSystemSchemas, want shop.orders", got)
+	}
+
+	dumper = &fakeDumper{}
+	cfg := testConfig(NewMemoryStore(), planner, dumper)
+	cfg.IncludeSystemSchemas = true
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	dumped := append([]string(nil), dumper.dumped...)
+	sort.Strings(dumped)
+	if got := strings.Join(dumped, ","); got != "mysql.time_zone_name,mysql.user,shop.orders" {
+		t.Errorf("dumped %s, want the mysql tables except its log and statistics tables", got)
+	}
+}
+
+func TestRunReportsPartialFailure(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders", "users"}},
+	}
+	dumper := &fakeDumper{
+		dumps:  map[string]string{"shop.orders": "INSERT INTO `orders` VALUES (1);\n"},
+		failed: map[string]error{"shop.orders": errFake},
+	}
+
+	m, err := Run(context.Background(), testConfig(store, planner, dumper))
+	if !errors.Is(err, ErrPartialFailure) {
+		t.Fatalf("Run error = %v, want ErrPartialFailure", err)
+	}
+	if !errors.Is(err, errFake) {
+		t.Errorf("Run error = %v, want it to wrap the dump failure", err)
+	}
+	if got := m.FailedTables(); got != 1 {
+		t.Errorf("FailedTables() = %d, want 1", got)
+	}
+
+	uploaded := readManifest(t, store, m.Path)
+	for _, table := range uploaded.Tables {
+		want := StatusSucceeded
+		if table.Table == "orders" {
+			want = StatusFailed
+		}
+		if table.Status != want {
+			t.Errorf("table %s status = %s, want %s", table.Table, table.Status, want)
+		}
+	}
+	if _, ok := store.Data(m.Path + "/shop/orders.sql.gz"); ok {
+		t.Errorf("the dump of the failed table was left in the bucket")
+	}
+}
+
+// panickingDumper panics dumping table and dumps the others like Dumper.
+type panickingDumper struct {
+	Dumper
+	table string
+}
+
+func (d panickingDumper) Dump(ctx context.Context, database string, table string, opts DumpOptions) (io.ReadCloser, error) {
+	if database+"."+table == d.table {
+		panic("corrupt row")
+	}
+	return d.Dumper.Dump(ctx, database, table, opts)
+}
+
+func TestRunIsolatesFailuresPerTable(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &fakePlanner{
+		databases: []string{"shop", "crm"},
+		tables:    map[string][]string{"shop": {"orders", "users"}, "crm": {"leads", "notes"}},
+	}
+	dumper := &fakeDumper{
+		dumps:  map[string]string{"shop.users": "INSERT INTO `users` VALUES (1);\n", "crm.notes": "INSERT INTO `notes` VALUES (1);\n"},
+		failed: map[string]error{"shop.orders": errFake},
+	}
+
+	m, err := Run(context.Background(), testConfig(store, planner, panickingDumper{Dumper: dumper, table: "crm.leads"}))
+	if !errors.Is(err, ErrPartialFailure) || !errors.Is(err, errFake) {
+		t.Fatalf("Run error = %v, want ErrPartialFailure wrapping the dump failure", err)
+	}
+	if !strings.Contains(err.Error(), "database shop") || !strings.Contains(err.Error(), "database crm") {
+		t.Errorf("Run error = %v, want the failures of both databases", err)
+	}
+
+	want := map[string]string{"shop.orders": StatusFailed, "shop.users": StatusSucceeded, "crm.leads": StatusFailed, "crm.notes": StatusSucceeded}
+	for _, table := range readManifest(t, store, m.Path).Tables {
+		if got := table.Status; got != want[table.Database+"."+table.Table] {
+			t.Errorf("table %s.%s status = %s, want %s", table.Database, table.Table, got, want[table.Database+"."+table.Table])
+		}
+	}
+}
+
+func TestRunReportsEnumerationFailure(t *testing.T) {
+	store := NewMemoryStore()
+
+	planner := &fakePlanner{databasesErr: errFake}
+	if _, err := Run(context.Background(), testConfig(store, planner, &fakeDumper{})); !errors.Is(err, ErrEnumeration) {
+		t.Fatalf("Run error = %v, want ErrEnumeration", err)
+	}
+
+	planner = &fakePlanner{
+		databases: []string{"shop", "crm"},
+		tables:    map[string][]string{"crm": {"leads"}},
+		tablesErr: map[string]error{"shop": errFake},
+	}
+	m, err := Run(context.Background(), testConfig(store, planner, &fakeDumper{}))
+	if !errors.Is(err, ErrEnumeration) {
+		t.Fatalf("Run error = %v, want ErrEnumeration", err)
+	}
+	if len(m.Errors) != 1 {
+		t.Errorf("manifest errors = %v, want one", m.Errors)
+	}
+	if _, ok := store.Data(m.Path + "/crm/leads.sql.gz"); !ok {
+		t.Errorf("tables of other databases were not backed up")
+	}
+}
+
+func TestRunSkipsUnreadableTables(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders", "remote", "summary"}},
+		types:     map[string]string{"shop.summary": TableTypeView},
+		probeErr:  map[string]error{"shop.remote": errFake},
+	}
+	dumper := &fakeDumper{}
+
+	cfg := testConfig(store, planner, dumper)
+	cfg.ProbeTables = true
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if got := strings.Join(dumper.dumped, ","); got != "shop.orders,shop.summary" && got != "shop.summary,shop.orders" {
+		t.Errorf("dumped %s, want orders and summary", got)
+	}
+
+	for _, table := range readManifest(t, store, m.Path).Tables {
+		wantStatus, wantType := StatusSucceeded, TableTypeBase
+		switch table.Table {
+		case "remote":
+			wantStatus = StatusSkipped
+		case "summary":
+			wantType = TableTypeView
+		}
+		if table.Status != wantStatus || table.Type != wantType {
+			t.Errorf("table %s: status %s, type %s, want %s, %s", table.Table, table.Status, table.Type, wantStatus, wantType)
+		}
+	}
+}
+
+// cancellingDumper cancels the run as it starts the first dump.
+type cancellingDumper struct {
+	*fakeDumper
+	cancel context.CancelFunc
+}
+
+func (d *cancellingDumper) Dump(ctx context.Context, database string, table string, opts DumpOptions) (io.ReadCloser, error) {
+	d.cancel()
+	return d.fakeDumper.Dump(ctx, database, table, opts)
+}
+
+func TestRunSkipsTablesAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := NewMemoryStore()
+	planner := &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"orders", "customers", "items"}}}
+	dumper := &cancellingDumper{fakeDumper: &fakeDumper{}, cancel: cancel}
+	cfg := testConfig(store, planner, dumper)
+	cfg.Workers = 1
+
+	m, err := Run(ctx, cfg)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want the run cancelled", err)
+	}
+	if len(dumper.dumped) != 1 {
+		t.Errorf("dumped %v after the run was cancelled, want only the first table", dumper.dumped)
+	}
+	skipped := 0
+	for _, table := range m.Tables {
+		if table.Status == StatusSkipped && table.Error == "run cancelled" {
+			skipped++
+		}
+	}
+	if skipped != 2 {
+		t.Errorf("tables = %+v, want 2 skipped as the run was cancelled", m.Tables)
+	}
+}
+
+func TestRunHandlesNonTransactionalTables(t *testing.T) {
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders", "legacy"}},
+		engines:   map[string]string{"shop.orders": "InnoDB", "shop.legacy": "MyISAM"},
+	}
+
+	for _, mode := range []string{"", NonTransactionalWarn, NonTransactionalSkip} {
+		store := NewMemoryStore()
+		dumper := &fakeDumper{}
+		cfg := testConfig(store, planner, dumper)
+		cfg.NonTransactional = mode
+
+		m, err := Run(context.Background(), cfg)
+		if err != nil {
+			t.Fatalf("%q: Run failed: %v", mode, err)
+		}
+
+		legacy, dumped := dumper.opts["shop.legacy"]
+		switch mode {
+		case NonTransactionalSkip:
+			if dumped {
+				t.Errorf("%q: MyISAM table was dumped", mode)
+			}
+			if result, _ := readManifest(t, store, m.Path).Table("shop", "legacy"); result.Status != StatusSkipped || result.Engine != "MyISAM" {
+				t.Errorf("%q: legacy status %s, engine %s", mode, result.Status, result.Engine)
+			}
+		default:
+			if legacy.LockTables != (mode == "") {
+				t.Errorf("%q: legacy LockTables = %v", mode, legacy.LockTables)
+			}
+		}
+		if dumper.opts["shop.orders"].LockTables {
+			t.Errorf("%q: InnoDB table was locked", mode)
+		}
+	}
+}
+
+func TestRunSplitsPartitions(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &fakePlanner{
+		databases:  []string{"shop"},
+		tables:     map[string][]string{"shop": {"events"}},
+		partitions: map[string][]string{"shop.events": {"p2023", "p2024"}},
+	}
+	dumper := &fakeDumper{dumps: map[string]string{"shop.events": "CREATE TABLE `events` (`id` int);\n"}}
+	partitionDumper := &fakeDumper{dumps: map[string]string{
+		"shop.events#p2023": "INSERT INTO `events` VALUES (1);\n",
+		"shop.events#p2024": "INSERT INTO `events` VALUES (2);\nINSERT INTO `events` VALUES (3);\n",
+	}}
+
+	cfg := testConfig(store, planner, dumper)
+	cfg.SplitPartitions = true
+	cfg.PartitionDumper = partitionDumper
+	cfg.started = time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if !dumper.opts["shop.events"].NoData {
+		t.Errorf("table definition was dumped with data")
+	}
+	if got := readGzipObject(t, store, m.Path+"/shop/events/p2024.sql.gz"); got != partitionDumper.dumps["shop.events#p2024"] {
+		t.Errorf("p2024 dump = %q", got)
+	}
+
+	result, _ := readManifest(t, store, m.Path).Table("shop", "events")
+	if len(result.Partitions) != 2 || result.Partitions[1].Object != m.Path+"/shop/events/p2024.sql.gz" {
+		t.Fatalf("manifest partitions = %+v", result.Partitions)
+	}
+	if result.Rows != 3 {
+		t.Errorf("table rows = %d, want 3", result.Rows)
+	}
+
+	// A partition dropped before the next run to the same generation goes.
+	planner.partitions["shop.events"] = []string{"p2024"}
+	cfg.started = cfg.started.Add(30 * time.Minute)
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("second Run failed: %v", err)
+	}
+	if _, ok := store.Data(m.Path + "/shop/events/p2023.sql.gz"); ok {
+		t.Error("dropped partition p2023 was kept")
+	}
+}
+
+func TestRunStartsLargestDatabasesFirst(t *testing.T) {
+	planner := &fakePlanner{
+		databases: []string{"a", "b", "c", "d"},
+		tables:    map[string][]string{"a": {"t"}, "b": {"t"}, "c": {"t"}, "d": {"t"}},
+		sizes: map[string]DatabaseSize{
+			"a": {Data: 10},
+			"b": {Data: 100, Index: 900},
+			"c": {Data: 500},
+		},
+	}
+	dumper := &fakeDumper{}
+
+	cfg := testConfig(NewMemoryStore(), planner, dumper)
+	cfg.Workers = 1
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if got, want := strings.Join(dumper.dumped, ","), "b.t,c.t,a.t,d.t"; got != want {
+		t.Errorf("dump order = %s, want %s", got, want)
+	}
+}
+
+func TestRunRecordsRunID(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders"}},
+	}
+
+	cfg := testConfig(store, planner, &fakeDumper{})
+	cfg.RunID = "01HZZZZZZZZZZZZZZZZZZZZZZZ"
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if got := readManifest(t, store, m.Path).RunID; got != cfg.RunID {
+		t.Errorf("manifest run ID = %q, want %q", got, cfg.RunID)
+	}
+	attrs, err := store.Attrs(context.Background(), m.Path+"/shop/orders.sql.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := attrs.Metadata[runIDMetadataKey]; got != cfg.RunID {
+		t.Errorf("object run ID = %q, want %q", got, cfg.RunID)
+	}
+
+	m, err = Run(context.Background(), testConfig(store, planner, &fakeDumper{}))
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(m.RunID) != 26 {
+		t.Errorf("generated run ID %q is not a ULID", m.RunID)
+	}
+}
+
+// countingDumper records the largest number of dumps open at the same time.
+type countingDumper struct {
+	fakeDumper
+	mu      sync.Mutex
+	open    int
+	maxOpen int
+}
+
+func (d *countingDumper) Dump(ctx context.Context, database string, table string, opts DumpOptions) (io.ReadCloser, error) {
+	d.mu.Lock()
+	d.open++
+	if d.open > d.maxOpen {
+		d.maxOpen = d.open
+	}
+	d.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+	output, err := d.fakeDumper.Dump(ctx, database, table, opts)
+	return &countedDump{ReadCloser: output, dumper: d}, err
+}
+
+type countedDump struct {
+	io.ReadCloser
+	dumper *countingDumper
+}
+
+func (d *countedDump) Close() error {
+	d.dumper.mu.Lock()
+	d.dumper.open--
+	d.dumper.mu.Unlock()
+	return d.ReadCloser.Close()
+}
+
+func TestRunBoundsDumpsAcrossDatabases(t *testing.T) {
+	planner := &fakePlanner{
+		databases: []string{"a", "b", "c", "d"},
+		tables: map[string][]string{
+			"a": {"t1", "t2", "t3"},
+			"b": {"t1", "t2", "t3"},
+			"c": {"t1", "t2", "t3"},
+			"d": {"t1", "t2", "t3"},
+		},
+	}
+	dumper := &countingDumper{}
+
+	cfg := testConfig(NewMemoryStore(), planner, dumper)
+	cfg.Routines = RoutinesDatabase
+	cfg.Workers = 3
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if dumper.maxOpen > cfg.Workers {
+		t.Errorf("%d dumps ran at the same time, want at most %d", dumper.maxOpen, cfg.Workers)
+	}
+	if len(m.Tables) != 12 || len(m.Databases) != 4 {
+		t.Errorf("backed up %d tables and %d databases, want 12 and 4", len(m.Tables), len(m.Databases))
+	}
+}
+
+func TestRunStopsStartingTablesOnceBudgetIsUsedUp(t *testing.T) {
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders", "users"}},
+	}
+	dumper := &fakeDumper{dumps: map[string]string{"shop.orders": "INSERT INTO `orders` VALUES (1);\n"}}
+
+	cfg := testConfig(NewMemoryStore(), planner, dumper)
+	cfg.Workers = 1
+	cfg.MaxBytesPerRun = 1
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if got := strings.Join(dumper.dumped, ","); got != "shop.orders" {
+		t.Errorf("dumped %s, want only shop.orders", got)
+	}
+	if result, _ := m.Table("shop", "users"); result.Status != StatusSkipped {
+		t.Errorf("users status = %q, want %q", result.Status, StatusSkipped)
+	}
+}
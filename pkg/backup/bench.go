@@ -0,0 +1,108 @@
+package backup
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// benchBlockSize is the size of the random block benchmark objects repeat.
+const benchBlockSize = 1 << 20
+
+// BenchConfig configures BenchUpload.
+type BenchConfig struct {
+	// Prefix is where the benchmark objects are written. They are deleted
+	// once measured.
+	Prefix string
+	// Objects objects of Size bytes each are uploaded at the same time.
+	Objects int
+	Size    int64
+	// ChunkSize, if set, is the buffer size of GCS writers.
+	ChunkSize int
+}
+
+// BenchResult is the outcome of BenchUpload.
+type BenchResult struct {
+	Bytes   int64
+	Elapsed time.Duration
+	// ThroughputMBps is that of all uploads together, SlowestMBps that of
+	// the slowest one.
+	ThroughputMBps float64
+	SlowestMBps    float64
+}
+
+// BenchUpload measures the upload throughput to store with incompressible
+// synthetic data, uploading cfg.Objects objects in parallel as the workers of
+// a run do.
+func BenchUpload(ctx context.Context, store ObjectStore, cfg BenchConfig) (BenchResult, error) {
+	if cfg.Objects <= 0 || cfg.Size <= 0 {
+		return BenchResult{}, errors.New("benchmark needs at least one object of at least one byte")
+	}
+
+	block := make([]byte, benchBlockSize)
+	if _, err := rand.Read(block); err != nil {
+		return BenchResult{}, fmt.Errorf("failed to generate data: %w", err)
+	}
+	if cfg.ChunkSize > 0 {
+		ctx = withChunkSize(ctx, cfg.ChunkSize)
+	}
+
+	names := make([]string, cfg.Objects)
+	elapsed := make([]time.Duration, cfg.Objects)
+	defer func() {
+		for _, name := range names {
+			if name == "" {
+				continue
+			}
+			if err := store.Delete(context.Background(), name); err != nil && !errors.Is(err, ErrObjectNotExist) {
+				log.Printf("Failed to delete benchmark object %s: %v\n", name, err)
+			}
+		}
+	}()
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	started := time.Now()
+	for i := range names {
+		i := i
+		name := fmt.Sprintf("%s/%04d.bin", cfg.Prefix, i)
+		group.Go(func() error {
+			start := time.Now()
+			writer := store.NewWriter(groupCtx, name, "application/octet-stream", nil)
+			for remaining := cfg.Size; remaining > 0; {
+				n := int64(len(block))
+				if n > remaining {
+					n = remaining
+				}
+				if _, err := writer.Write(block[:n]); err != nil {
+					return fmt.Errorf("failed to write %s: %w", name, err)
+				}
+				remaining -= n
+			}
+			if err := writer.Close(); err != nil {
+				return fmt.Errorf("failed to close %s: %w", name, err)
+			}
+			names[i] = name
+			elapsed[i] = time.Since(start)
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return BenchResult{}, err
+	}
+
+	result := BenchResult{Bytes: cfg.Size * int64(cfg.Objects), Elapsed: time.Since(started)}
+	result.ThroughputMBps = throughputMBps(result.Bytes, result.Elapsed)
+	var slowest time.Duration
+	for _, d := range elapsed {
+		if d > slowest {
+			slowest = d
+		}
+	}
+	result.SlowestMBps = throughputMBps(cfg.Size, slowest)
+	return result, nil
+}
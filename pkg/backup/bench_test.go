@@ -0,0 +1,20 @@
+package backup
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBenchUpload(t *testing.T) {
+	store := NewMemoryStore()
+	result, err := BenchUpload(context.Background(), store, BenchConfig{Prefix: "_bench/test", Objects: 3, Size: benchBlockSize + 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Bytes != 3*(benchBlockSize+10) || result.ThroughputMBps <= 0 || result.SlowestMBps <= 0 {
+		t.Errorf("result = %+v", result)
+	}
+	if objects, _ := store.List(context.Background(), "_bench/"); len(objects) != 0 {
+		t.Errorf("benchmark left %d objects", len(objects))
+	}
+}
@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// ErrBucketPolicy is returned by Run when a destination bucket violates
+// Config.BucketPolicy and the policy aborts runs.
+var ErrBucketPolicy = errors.New("bucket violates the protection policy")
+
+// BucketPolicy are the protection settings required of the buckets backups
+// are written to, checked before a run starts.
+type BucketPolicy struct {
+	RequireVersioning bool
+	// MinRetention is the shortest retention policy period accepted.
+	MinRetention                  time.Duration
+	RequirePublicAccessPrevention bool
+	// Abort fails the run on violations instead of logging them.
+	Abort bool
+}
+
+func (p BucketPolicy) enabled() bool {
+	return p.RequireVersioning || p.MinRetention > 0 || p.RequirePublicAccessPrevention
+}
+
+// violations returns how a bucket falls short of the policy.
+func (p BucketPolicy) violations(attrs *storage.BucketAttrs) []string {
+	var violations []string
+	if p.RequireVersioning && !attrs.VersioningEnabled {
+		violations = append(violations, "object versioning is disabled")
+	}
+	if p.MinRetention > 0 {
+		switch {
+		case attrs.RetentionPolicy == nil:
+			violations = append(violations, "no retention policy is set")
+		case attrs.RetentionPolicy.RetentionPeriod < p.MinRetention:
+			violations = append(violations, fmt.Sprintf("retention period %s is shorter than %s", attrs.RetentionPolicy.RetentionPeriod, p.MinRetention))
+		}
+	}
+	if p.RequirePublicAccessPrevention && attrs.PublicAccessPrevention != storage.PublicAccessPreventionEnforced {
+		violations = append(violations, "public access prevention is not enforced")
+	}
+	return violations
+}
+
+// checkBucketPolicy checks every GCS bucket of store against the policy,
+// including replicas and the fallback bucket.
+func checkBucketPolicy(ctx context.Context, store ObjectStore, policy BucketPolicy) error {
+	if !policy.enabled() {
+		return nil
+	}
+
+	var violations []string
+	for _, bucket := range gcsBuckets(store) {
+		attrs, err := bucket.Attrs(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve bucket attributes: %w", err)
+		}
+		for _, violation := range policy.violations(attrs) {
+			violations = append(violations, fmt.Sprintf("gs://%s: %s", attrs.Name, violation))
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+
+	if policy.Abort {
+		return fmt.Errorf("%w: %s", ErrBucketPolicy, strings.Join(violations, "; "))
+	}
+	for _, violation := range violations {
+		log.Printf("Bucket protection policy violated, backups may be deleted or exposed: %s\n", violation)
+	}
+	return nil
+}
+
+func gcsBuckets(store ObjectStore) []*storage.BucketHandle {
+	switch store := store.(type) {
+	case *GCSStore:
+		return []*storage.BucketHandle{store.Bucket}
+	case *MirrorStore:
+		var buckets []*storage.BucketHandle
+		for _, s := range store.Stores {
+			buckets = append(buckets, gcsBuckets(s)...)
+		}
+		return buckets
+	case *FailoverStore:
+		return append(gcsBuckets(store.Primary), gcsBuckets(store.Fallback)...)
+	}
+	return nil
+}
+
+// bucketName returns the name of a bucket, which BucketHandle only exposes
+// through its objects.
+func bucketName(bucket *storage.BucketHandle) string {
+	return bucket.Object("").BucketName()
+}
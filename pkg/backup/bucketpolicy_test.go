@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+func TestBucketPolicyViolations(t *testing.T) {
+	policy := BucketPolicy{RequireVersioning: true, MinRetention: 30 * 24 * time.Hour, RequirePublicAccessPrevention: true}
+
+	safe := &storage.BucketAttrs{
+		VersioningEnabled:      true,
+		RetentionPolicy:        &storage.RetentionPolicy{RetentionPeriod: 90 * 24 * time.Hour},
+		PublicAccessPrevention: storage.PublicAccessPreventionEnforced,
+	}
+	if violations := policy.violations(safe); len(violations) != 0 {
+		t.Errorf("violations of a safe bucket = %v", violations)
+	}
+
+	unsafe := &storage.BucketAttrs{
+		RetentionPolicy:        &storage.RetentionPolicy{RetentionPeriod: 7 * 24 * time.Hour},
+		PublicAccessPrevention: storage.PublicAccessPreventionInherited,
+	}
+	if violations := policy.violations(unsafe); len(violations) != 3 {
+		t.Errorf("violations of an unsafe bucket = %v, want 3", violations)
+	}
+
+	if violations := (BucketPolicy{MinRetention: time.Hour}).violations(&storage.BucketAttrs{}); len(violations) != 1 {
+		t.Errorf("violations of a bucket without retention policy = %v, want 1", violations)
+	}
+}
+
+func TestRunAbortsOnUnsafeBucket(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name": "backups", "versioning": {"enabled": false}}`))
+	}))
+	defer server.Close()
+
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(server.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	planner := &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"orders"}}}
+	dumper := &fakeDumper{}
+	cfg := testConfig(NewMirrorStore(NewMemoryStore(), NewGCSStore(client.Bucket("backups"))), planner, dumper)
+	cfg.BucketPolicy = BucketPolicy{RequireVersioning: true, Abort: true}
+	cfg.SkipPermissionCheck = true
+
+	_, err = Run(context.Background(), cfg)
+	if !errors.Is(err, ErrBucketPolicy) || !strings.Contains(err.Error(), "gs://backups: object versioning is disabled") {
+		t.Fatalf("Run = %v, want ErrBucketPolicy for gs://backups", err)
+	}
+	if len(dumper.dumped) != 0 {
+		t.Errorf("dumped %v despite the policy violation", dumper.dumped)
+	}
+
+	cfg.BucketPolicy.RequireVersioning = false
+	if err := checkBucketPolicy(context.Background(), cfg.Store, cfg.BucketPolicy); err != nil {
+		t.Errorf("checkBucketPolicy without requirements = %v", err)
+	}
+}
@@ -0,0 +1,414 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GenerationLayout is the time layout of the generation component of
+// backup paths with GranularityHour.
+const GenerationLayout = "2006-01-02-15"
+
+// Granularities of generations, grouping the runs started within the same
+// hour or day into one generation, or giving every run its own.
+const (
+	GranularityHour = "hour"
+	GranularityDay  = "day"
+	GranularityRun  = "run"
+)
+
+var generationLayouts = map[string]string{
+	GranularityHour: GenerationLayout,
+	GranularityDay:  "2006-01-02",
+	GranularityRun:  "2006-01-02-150405",
+}
+
+// generationName returns the generation of a run started at started, in
+// GranularityHour unless granularity is set.
+func generationName(started time.Time, granularity string) string {
+	layout, ok := generationLayouts[granularity]
+	if !ok {
+		layout = GenerationLayout
+	}
+	return started.Format(layout)
+}
+
+// parseGeneration returns the start of a generation of any granularity, so
+// that generations written before a change of granularity are still
+// recognized.
+func parseGeneration(name string) (time.Time, bool) {
+	for _, layout := range generationLayouts {
+		if len(layout) != len(name) {
+			continue
+		}
+		if started, err := time.ParseInLocation(layout, name, time.Local); err == nil {
+			return started, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// generationLess orders generations by their start, and by name if they
+// start at the same time. Their names alone do not order them once the
+// granularity changed: 2026-10-14 sorts after 2026-10-14-15.
+func generationLess(a string, b string) bool {
+	startA, okA := parseGeneration(a)
+	startB, okB := parseGeneration(b)
+	if okA && okB && !startA.Equal(startB) {
+		return startA.Before(startB)
+	}
+	return a < b
+}
+
+const tableObjectSuffix = ".sql.gz"
+
+// TableObject is a table dump stored under
+// <host>/<generation>/<database>/<table>.sql.gz, .sql.zst for small tables
+// compressed with a dictionary, or .dedup.json for deduplicated dumps.
+type TableObject struct {
+	Host       string
+	Generation string
+	Database   string
+	Table      string
+	Attrs      ObjectAttrs
+
+	// suffix is the suffix of the object if it is not tableObjectSuffix.
+	suffix string
+	// archive and entry locate dumps in the archive of their database,
+	// see Config.ArchiveFormat, whose Attrs are those of the archive.
+	archive string
+	entry   string
+
+	// Partitions are the names of the per-partition objects of a table
+	// dumped per partition, stored under <database>/<table>/.
+	Partitions []string
+}
+
+// ParseTableObject parses an object name in the table dump layout.
+func ParseTableObject(name string) (TableObject, bool) {
+	suffix := ""
+	switch {
+	case strings.HasSuffix(name, tableObjectSuffix):
+		name = strings.TrimSuffix(name, tableObjectSuffix)
+	case strings.HasSuffix(name, zstdObjectSuffix):
+		name = strings.TrimSuffix(name, zstdObjectSuffix)
+		suffix = zstdObjectSuffix
+	case strings.HasSuffix(name, dedupIndexSuffix):
+		name = strings.TrimSuffix(name, dedupIndexSuffix)
+		suffix = dedupIndexSuffix
+	default:
+		return TableObject{}, false
+	}
+
+	parts := strings.Split(name, "/")
+	if len(parts) != 4 {
+		return TableObject{}, false
+	}
+	for _, part := range parts {
+		if part == "" {
+			return TableObject{}, false
+		}
+	}
+
+	return TableObject{Host: parts[0], Generation: parts[1], Database: parts[2], Table: parts[3], suffix: suffix}, true
+}
+
+// parsePartitionObject returns the name of the table object a partition
+// object <host>/<generation>/<database>/<table>/<partition>.sql.gz belongs
+// to.
+func parsePartitionObject(name string) (string, bool) {
+	if !strings.HasSuffix(name, tableObjectSuffix) {
+		return "", false
+	}
+
+	parts := strings.Split(strings.TrimSuffix(name, tableObjectSuffix), "/")
+	if len(parts) != 5 {
+		return "", false
+	}
+	for _, part := range parts {
+		if part == "" {
+			return "", false
+		}
+	}
+
+	return strings.Join(parts[:4], "/") + tableObjectSuffix, true
+}
+
+// Name returns the object name of the table dump.
+func (o TableObject) Name() string {
+	if o.archive != "" {
+		return o.archive + "/" + o.entry
+	}
+	suffix := o.suffix
+	if suffix == "" {
+		suffix = tableObjectSuffix
+	}
+	return fmt.Sprintf("%s/%s/%s/%s%s", o.Host, o.Generation, o.Database, o.Table, suffix)
+}
+
+// ListTableObjects returns the table dumps below prefix, ordered by
+// generation, database and table. Tables that the manifest of their
+// generation does not record as succeeded are left out, and the partitions
+// of those it records are the ones it lists, not whatever is stored under
+// <database>/<table>/.
+func ListTableObjects(ctx context.Context, store ObjectStore, prefix string) ([]TableObject, error) {
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects under %s: %w", prefix, err)
+	}
+
+	var tables []TableObject
+	partitions := map[string][]string{}
+	manifests := map[string]*Manifest{}
+	// succeeded holds the results of the succeeded tables per generation
+	// with a manifest.
+	succeeded := map[string]map[string]TableResult{}
+	recorded := map[string]bool{}
+	for _, attrs := range objects {
+		if table, ok := parsePartitionObject(attrs.Name); ok {
+			partitions[table] = append(partitions[table], attrs.Name)
+			continue
+		}
+		if archive, ok := parseArchiveObject(attrs.Name); ok {
+			archived, err := archivedTables(ctx, store, archive, manifests)
+			if err != nil {
+				return nil, err
+			}
+			for _, table := range archived {
+				table.Attrs = attrs
+				tables = append(tables, table)
+			}
+			continue
+		}
+
+		table, ok := ParseTableObject(attrs.Name)
+		if !ok {
+			continue
+		}
+		// A table that failed may have left some of its objects, e.g. a
+		// truncated dump or the partitions dumped before the failure.
+		path := table.Host + "/" + table.Generation
+		done, ok := succeeded[path]
+		if !ok {
+			m, err := generationManifest(ctx, store, table.Host, table.Generation, manifests)
+			if err != nil {
+				return nil, err
+			}
+			if m != nil {
+				done = map[string]TableResult{}
+				for _, result := range m.Tables {
+					if result.Status == StatusSucceeded {
+						done[result.Database+"."+result.Table] = result
+					}
+				}
+			}
+			succeeded[path] = done
+		}
+		table.Attrs = attrs
+		if done != nil {
+			result, ok := done[table.Database+"."+table.Table]
+			if !ok {
+				continue
+			}
+			// An earlier run to the same generation may have left
+			// partitions or chunks the table no longer has.
+			for _, partition := range result.Partitions {
+				table.Partitions = append(table.Partitions, partition.Object)
+			}
+			recorded[table.Name()] = true
+		}
+		tables = append(tables, table)
+	}
+
+	for i := range tables {
+		if recorded[tables[i].Name()] {
+			continue
+		}
+		tables[i].Partitions = partitions[tables[i].Name()]
+		sort.Strings(tables[i].Partitions)
+	}
+
+	sort.Slice(tables, func(i, j int) bool {
+		a, b := tables[i], tables[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.Generation != b.Generation {
+			return generationLess(a.Generation, b.Generation)
+		}
+		return a.Name() < b.Name()
+	})
+
+	return tables, nil
+}
+
+// generationManifest returns the manifest of a generation, or nil if it has
+// none or one of a newer format, loading manifests only once per generation.
+func generationManifest(ctx context.Context, store ObjectStore, host string, generation string, manifests map[string]*Manifest) (*Manifest, error) {
+	path := host + "/" + generation
+	m, ok := manifests[path]
+	if !ok {
+		var err error
+		if m, err = LoadManifest(ctx, store, path); err != nil && !errors.Is(err, ErrObjectNotExist) && !errors.Is(err, ErrUnsupportedFormat) {
+			return nil, err
+		}
+		manifests[path] = m
+	}
+	return m, nil
+}
+
+// archivedTables returns the tables that the manifest of its run records
+// as succeeded in an archive.
+func archivedTables(ctx context.Context, store ObjectStore, archive TableObject, manifests map[string]*Manifest) ([]TableObject, error) {
+	m, err := generationManifest(ctx, store, archive.Host, archive.Generation, manifests)
+	if err != nil {
+		return nil, err
+	}
+	// The archives of generations without a manifest, or with one of a
+	// newer format, list no tables.
+	if m == nil {
+		return nil, nil
+	}
+
+	var tables []TableObject
+	for _, result := range m.Tables {
+		if result.Object != archive.archive || result.ArchiveEntry == "" || result.Status != StatusSucceeded {
+			continue
+		}
+		table := archive
+		table.Table = result.Table
+		table.entry = result.ArchiveEntry
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// DatabaseBackup summarizes the dumps of a database in one generation.
+type DatabaseBackup struct {
+	Host       string `json:"host"`
+	Generation string `json:"generation"`
+	Database   string `json:"database"`
+	Tables     int    `json:"tables"`
+	// Bytes is the compressed size of the table and partition dumps,
+	// including continuation objects.
+	Bytes int64 `json:"bytes"`
+	// Created is the creation time of the newest dump.
+	Created time.Time `json:"created"`
+}
+
+// ListDatabaseBackups returns the database backups below prefix, ordered by
+// host, generation and database.
+func ListDatabaseBackups(ctx context.Context, store ObjectStore, prefix string) ([]DatabaseBackup, error) {
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects under %s: %w", prefix, err)
+	}
+
+	backups := map[string]*DatabaseBackup{}
+	manifests := map[string]*Manifest{}
+	for _, attrs := range objects {
+		name, isPart := partOf(attrs.Name)
+		if !isPart {
+			name = attrs.Name
+		}
+		if table, isPartition := parsePartitionObject(name); isPartition {
+			name, isPart = table, true
+		}
+		tables := 1
+		table, ok := ParseTableObject(name)
+		if archive, isArchive := parseArchiveObject(name); isArchive {
+			archived, err := archivedTables(ctx, store, archive, manifests)
+			if err != nil {
+				return nil, err
+			}
+			table, ok, tables = archive, true, len(archived)
+		}
+		if !ok {
+			continue
+		}
+
+		key := table.Host + "/" + table.Generation + "/" + table.Database
+		backup, ok := backups[key]
+		if !ok {
+			backup = &DatabaseBackup{Host: table.Host, Generation: table.Generation, Database: table.Database}
+			backups[key] = backup
+		}
+		if !isPart {
+			backup.Tables += tables
+		}
+		backup.Bytes += attrs.Size
+		if attrs.Created.After(backup.Created) {
+			backup.Created = attrs.Created
+		}
+	}
+
+	var result []DatabaseBackup
+	for _, backup := range backups {
+		result = append(result, *backup)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.Generation != b.Generation {
+			return generationLess(a.Generation, b.Generation)
+		}
+		return a.Database < b.Database
+	})
+
+	return result, nil
+}
+
+// FindTableObject returns the dump of a table in the given generation, or in
+// the latest generation containing the table when generation is empty.
+func FindTableObject(ctx context.Context, store ObjectStore, host string, generation string, database string, table string) (TableObject, error) {
+	prefix := host + "/"
+	if generation != "" {
+		prefix += generation + "/"
+	}
+
+	tables, err := ListTableObjects(ctx, store, prefix)
+	if err != nil {
+		return TableObject{}, err
+	}
+
+	for i := len(tables) - 1; i >= 0; i-- {
+		if tables[i].Database == database && tables[i].Table == table {
+			return tables[i], nil
+		}
+	}
+
+	return TableObject{}, fmt.Errorf("%w: no backup of table \"%s.%s\" under %s", ErrObjectNotExist, database, table, prefix)
+}
+
+// Download writes the decompressed content of a table dump, including its
+// continuation objects if it was rolled over, to w.
+func Download(ctx context.Context, store ObjectStore, name string, w io.Writer) (int64, error) {
+	reader, err := openDump(ctx, store, name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	dump, err := decompress(ctx, store, name, reader)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decompress %s: %w", name, err)
+	}
+
+	n, err := io.Copy(w, dump)
+	if err != nil {
+		return n, fmt.Errorf("failed to download %s: %w", name, err)
+	}
+
+	if err := dump.Close(); err != nil {
+		return n, fmt.Errorf("failed to decompress %s: %w", name, err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,158 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func putGzipObject(t *testing.T, store *MemoryStore, name string, content string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(content))
+	gz.Close()
+
+	w := store.NewWriter(context.Background(), name, "", nil)
+	w.Write(buf.Bytes())
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerationName(t *testing.T) {
+	started := time.Date(2024, 3, 1, 15, 4, 5, 0, time.Local)
+	for granularity, want := range map[string]string{
+		"":              "2024-03-01-15",
+		GranularityHour: "2024-03-01-15",
+		GranularityDay:  "2024-03-01",
+		GranularityRun:  "2024-03-01-150405",
+	} {
+		name := generationName(started, granularity)
+		if name != want {
+			t.Errorf("generationName(%q) = %s, want %s", granularity, name, want)
+		}
+		if _, ok := parseGeneration(name); !ok {
+			t.Errorf("parseGeneration(%s) failed", name)
+		}
+	}
+}
+
+func TestParseTableObject(t *testing.T) {
+	object, ok := ParseTableObject("db1/2024-01-02-03/shop/orders.sql.gz")
+	if !ok {
+		t.Fatalf("ParseTableObject rejected a table object")
+	}
+	if object.Host != "db1" || object.Generation != "2024-01-02-03" || object.Database != "shop" || object.Table != "orders" {
+		t.Errorf("ParseTableObject = %+v", object)
+	}
+	if object.Name() != "db1/2024-01-02-03/shop/orders.sql.gz" {
+		t.Errorf("Name() = %s", object.Name())
+	}
+
+	for _, name := range []string{"db1/2024-01-02-03/manifest.json", "db1/2024-01-02-03/orders.sql.gz", "db1//shop/orders.sql.gz"} {
+		if _, ok := ParseTableObject(name); ok {
+			t.Errorf("ParseTableObject accepted %s", name)
+		}
+	}
+}
+
+func TestFindTableObjectAndDownload(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	putGzipObject(t, store, "db1/2024-01-01-00/shop/orders.sql.gz", "old")
+	putGzipObject(t, store, "db1/2024-01-02-00/shop/orders.sql.gz", "new")
+	putGzipObject(t, store, "db1/2024-01-03-00/shop/users.sql.gz", "users")
+
+	latest, err := FindTableObject(ctx, store, "db1", "", "shop", "orders")
+	if err != nil {
+		t.Fatalf("FindTableObject failed: %v", err)
+	}
+	if latest.Generation != "2024-01-02-00" {
+		t.Errorf("latest generation = %s, want 2024-01-02-00", latest.Generation)
+	}
+
+	pinned, err := FindTableObject(ctx, store, "db1", "2024-01-01-00", "shop", "orders")
+	if err != nil {
+		t.Fatalf("FindTableObject failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := Download(ctx, store, pinned.Name(), &buf); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if buf.String() != "old" {
+		t.Errorf("downloaded %q, want %q", buf.String(), "old")
+	}
+
+	if _, err := FindTableObject(ctx, store, "db1", "", "shop", "missing"); !errors.Is(err, ErrObjectNotExist) {
+		t.Errorf("FindTableObject error = %v, want ErrObjectNotExist", err)
+	}
+}
+
+func TestLatestGenerationAcrossGranularities(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	putGzipObject(t, store, "db1/2024-01-02-15/shop/orders.sql.gz", "hour")
+	putGzipObject(t, store, "db1/2024-01-02-153000/shop/orders.sql.gz", "run")
+	putGzipObject(t, store, "db1/2024-01-02/shop/orders.sql.gz", "day")
+
+	latest, err := FindTableObject(ctx, store, "db1", "", "shop", "orders")
+	if err != nil {
+		t.Fatalf("FindTableObject failed: %v", err)
+	}
+	if latest.Generation != "2024-01-02-153000" {
+		t.Errorf("latest generation = %s, want 2024-01-02-153000", latest.Generation)
+	}
+
+	tables, err := generationTables(ctx, store, "db1", "")
+	if err != nil {
+		t.Fatalf("generationTables failed: %v", err)
+	}
+	if len(tables) != 1 || tables[0].Generation != "2024-01-02-153000" {
+		t.Errorf("generationTables = %+v, want the run generation", tables)
+	}
+}
+
+func TestListDatabaseBackups(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	putGzipObject(t, store, "db1/2024-01-01-00/shop/orders.sql.gz", "orders")
+	putGzipObject(t, store, "db1/2024-01-01-00/shop/users.sql.gz", "users")
+	putGzipObject(t, store, "db1/2024-01-01-00/shop/events.sql.gz", "events")
+	putGzipObject(t, store, "db1/2024-01-01-00/shop/events/p2024.sql.gz", "p2024")
+	putGzipObject(t, store, "db1/2024-01-01-00/crm/leads.sql.gz", "leads")
+	putGzipObject(t, store, "db1/2024-01-01-00/manifest.json", "{}")
+	putGzipObject(t, store, "db1/2024-01-02-00/shop/orders.sql.gz", "orders")
+
+	backups, err := ListDatabaseBackups(ctx, store, "db1/")
+	if err != nil {
+		t.Fatalf("ListDatabaseBackups failed: %v", err)
+	}
+
+	var got []string
+	for _, backup := range backups {
+		got = append(got, fmt.Sprintf("%s/%s:%d", backup.Generation, backup.Database, backup.Tables))
+	}
+	want := []string{"2024-01-01-00/crm:1", "2024-01-01-00/shop:3", "2024-01-02-00/shop:1"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("backups = %v, want %v", got, want)
+	}
+
+	var size int64
+	for _, name := range []string{"orders", "users", "events", "events/p2024"} {
+		data, _ := store.Data("db1/2024-01-01-00/shop/" + name + ".sql.gz")
+		size += int64(len(data))
+	}
+	if backups[1].Bytes != size {
+		t.Errorf("shop size = %d, want %d including partitions", backups[1].Bytes, size)
+	}
+	if backups[1].Created.IsZero() {
+		t.Error("created time not set")
+	}
+}
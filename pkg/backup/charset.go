@@ -0,0 +1,28 @@
+package backup
+
+import "fmt"
+
+// charsetWarning returns how dumping a table whose default character set is
+// tableCharset with dumpCharset may alter its data, or "" if it does not.
+func charsetWarning(dumpCharset string, tableCharset string) string {
+	dumpCharset, tableCharset = canonicalCharset(dumpCharset), canonicalCharset(tableCharset)
+
+	switch {
+	case tableCharset == "" || tableCharset == dumpCharset:
+		return ""
+	case tableCharset == "ascii" || tableCharset == "binary" || dumpCharset == "binary":
+		return ""
+	case dumpCharset == "utf8mb4" && tableCharset == "utf8mb3":
+		return ""
+	case dumpCharset == "utf8mb4":
+		return fmt.Sprintf("its legacy character set %s is converted to utf8mb4 in the dump, which alters binary or double-encoded UTF-8 data stored in its text columns; dump it with character set %s to keep such data byte for byte", tableCharset, tableCharset)
+	}
+	return fmt.Sprintf("characters of its character set %s that %s cannot represent are replaced by ? in the dump", tableCharset, dumpCharset)
+}
+
+func canonicalCharset(charset string) string {
+	if charset == "utf8" {
+		return "utf8mb3"
+	}
+	return charset
+}
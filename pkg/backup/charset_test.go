@@ -0,0 +1,49 @@
+package backup
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCharsetWarning(t *testing.T) {
+	tests := []struct {
+		dump, table string
+		warn        bool
+	}{
+		{"utf8mb4", "utf8mb4", false},
+		{"utf8mb4", "utf8", false},
+		{"utf8mb4", "ascii", false},
+		{"utf8mb4", "", false},
+		{"utf8mb4", "latin1", true},
+		{"latin1", "latin1", false},
+		{"latin1", "utf8mb4", true},
+		{"utf8", "utf8mb4", true},
+		{"binary", "latin1", false},
+	}
+	for _, tt := range tests {
+		if got := charsetWarning(tt.dump, tt.table); (got != "") != tt.warn {
+			t.Errorf("charsetWarning(%s, %s) = %q, want warning %v", tt.dump, tt.table, got, tt.warn)
+		}
+	}
+}
+
+func TestRunRecordsCollation(t *testing.T) {
+	planner := &fakePlanner{
+		databases:  []string{"shop"},
+		tables:     map[string][]string{"shop": {"orders"}},
+		collations: map[string]string{"shop.orders": "latin1_swedish_ci"},
+	}
+
+	m, err := Run(context.Background(), testConfig(NewMemoryStore(), planner, &fakeDumper{}))
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	result, _ := m.Table("shop", "orders")
+	if result.Collation != "latin1_swedish_ci" {
+		t.Errorf("collation = %q, want latin1_swedish_ci", result.Collation)
+	}
+	if charset := (TableInfo{Collation: result.Collation}).Charset(); charset != "latin1" {
+		t.Errorf("Charset() = %q, want latin1", charset)
+	}
+}
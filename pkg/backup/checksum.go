@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// Checksummer computes table checksums. A Planner implementing it enables
+// Config.ChecksumTables.
+type Checksummer interface {
+	// Checksum returns the checksum of a table, or false if the server
+	// cannot compute one, e.g. for a table that no longer exists.
+	Checksum(database string, table string) (string, bool, error)
+}
+
+// Checksum runs CHECKSUM TABLE, which reads every row of the table.
+func (p *MySQLPlanner) Checksum(database string, table string) (string, bool, error) {
+	db, err := p.get(p.Connection)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+
+	var name string
+	var checksum sql.NullInt64
+	err = db.QueryRowContext(context.Background(), "CHECKSUM TABLE "+quoteTable(database, table)).Scan(&name, &checksum)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to checksum table \"%s.%s\": %w", database, table, err)
+	}
+	if !checksum.Valid {
+		return "", false, nil
+	}
+	return strconv.FormatInt(checksum.Int64, 10), true, nil
+}
+
+// tableChecksum returns the checksum recorded for a table if checksums are
+// enabled for it, which they are by Config.ChecksumTables unless its
+// TableConfig overrides that. A failure to compute it is logged.
+func tableChecksum(planner Planner, enabled bool, config TableConfig, info TableInfo, database string) string {
+	if config.Checksum != nil {
+		enabled = *config.Checksum
+	}
+	checksummer, ok := planner.(Checksummer)
+	if !enabled || !ok || info.Type != TableTypeBase {
+		return ""
+	}
+
+	checksum, ok, err := checksummer.Checksum(database, info.Name)
+	if err != nil {
+		log.Printf("Failed to checksum table \"%s.%s\", it is backed up without a checksum: %v\n", database, info.Name, err)
+		return ""
+	}
+	if !ok {
+		return ""
+	}
+	return checksum
+}
@@ -0,0 +1,85 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type checksumPlanner struct {
+	*fakePlanner
+	checksums map[string]string
+	err       error
+}
+
+func (p *checksumPlanner) Checksum(database string, table string) (string, bool, error) {
+	if p.err != nil {
+		return "", false, p.err
+	}
+	checksum, ok := p.checksums[database+"."+table]
+	return checksum, ok, nil
+}
+
+func TestRunRecordsTableChecksums(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &checksumPlanner{
+		fakePlanner: &fakePlanner{
+			databases: []string{"shop"},
+			tables:    map[string][]string{"shop": {"orders", "users"}},
+		},
+		checksums: map[string]string{"shop.orders": "3102337249", "shop.users": "42"},
+	}
+
+	cfg := testConfig(store, planner, &fakeDumper{})
+	cfg.ChecksumTables = true
+	disabled := false
+	cfg.Tables = map[string]TableConfig{"shop.users": {Checksum: &disabled}}
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result, _ := m.Table("shop", "orders"); result.Checksum != "3102337249" {
+		t.Errorf("orders checksum = %q, want 3102337249", result.Checksum)
+	}
+	attrs, err := store.Attrs(context.Background(), m.Path+"/shop/orders.sql.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := attrs.Metadata[checksumMetadataKey]; got != "3102337249" {
+		t.Errorf("orders metadata %s = %q, want 3102337249", checksumMetadataKey, got)
+	}
+
+	if result, _ := m.Table("shop", "users"); result.Checksum != "" {
+		t.Errorf("users checksum = %q, want none", result.Checksum)
+	}
+	attrs, err = store.Attrs(context.Background(), m.Path+"/shop/users.sql.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := attrs.Metadata[checksumMetadataKey]; ok {
+		t.Errorf("users metadata has %s", checksumMetadataKey)
+	}
+}
+
+func TestRunBacksUpTablesWhoseChecksumFails(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &checksumPlanner{
+		fakePlanner: &fakePlanner{
+			databases: []string{"shop"},
+			tables:    map[string][]string{"shop": {"orders"}},
+		},
+		err: errors.New("lock wait timeout"),
+	}
+
+	cfg := testConfig(store, planner, &fakeDumper{})
+	cfg.ChecksumTables = true
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result, _ := m.Table("shop", "orders"); result.Status != StatusSucceeded || result.Checksum != "" {
+		t.Errorf("orders = %s with checksum %q, want succeeded without one", result.Status, result.Checksum)
+	}
+}
@@ -0,0 +1,254 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Chunker splits tables into ranges of their primary key. A Planner
+// implementing it enables Config.ChunkRows. Both methods stop once ctx is
+// done, so that a cancelled run does not wait for the server.
+type Chunker interface {
+	// ChunkKey returns the primary key of a table if it is a single
+	// integer column, and an IntegerKey without Column otherwise.
+	ChunkKey(ctx context.Context, database string, table string) (IntegerKey, error)
+	// ChunkEnd returns the key of the rows-th row after the key after, or
+	// from the start if after is nil, and false if there are fewer rows.
+	ChunkEnd(ctx context.Context, database string, table string, key IntegerKey, after *KeyValue, rows int64) (KeyValue, bool, error)
+}
+
+// IntegerKey is a primary key of a single integer column.
+type IntegerKey struct {
+	Column   string
+	Unsigned bool
+}
+
+// KeyValue is a value of an IntegerKey, Uint for an UNSIGNED column and Int
+// otherwise. Keys are scanned and bound as integers, as MySQL compares a
+// string with an integer column as DOUBLE, which rounds keys above 2^53.
+type KeyValue struct {
+	Unsigned bool
+	Int      int64
+	Uint     uint64
+}
+
+// arg returns the value as a query argument.
+func (v KeyValue) arg() any {
+	if v.Unsigned {
+		return v.Uint
+	}
+	return v.Int
+}
+
+func (v KeyValue) String() string {
+	if v.Unsigned {
+		return strconv.FormatUint(v.Uint, 10)
+	}
+	return strconv.FormatInt(v.Int, 10)
+}
+
+// parseKeyValue parses the decimal value s of key.
+func parseKeyValue(key IntegerKey, s string) (KeyValue, error) {
+	v := KeyValue{Unsigned: key.Unsigned}
+	var err error
+	if key.Unsigned {
+		v.Uint, err = strconv.ParseUint(s, 10, 64)
+	} else {
+		v.Int, err = strconv.ParseInt(s, 10, 64)
+	}
+	return v, err
+}
+
+// integerTypes are the column types ChunkKey accepts.
+var integerTypes = []string{"tinyint", "smallint", "mediumint", "int", "bigint"}
+
+// ChunkKey returns the primary key of a table from information_schema if it
+// is a single integer column, within the EnumerationTimeout.
+func (p *MySQLPlanner) ChunkKey(ctx context.Context, database string, table string) (IntegerKey, error) {
+	db, err := p.get(p.Connection)
+	if err != nil {
+		return IntegerKey{}, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.enumerationTimeout())
+	defer cancel()
+	rows, err := db.QueryContext(ctx,
+		"SELECT k.column_name, c.data_type, c.column_type FROM information_schema.key_column_usage k "+
+			"JOIN information_schema.columns c ON c.table_schema = k.table_schema AND c.table_name = k.table_name AND c.column_name = k.column_name "+
+			"WHERE k.table_schema = ? AND k.table_name = ? AND k.constraint_name = 'PRIMARY'", database, table)
+	if err != nil {
+		return IntegerKey{}, fmt.Errorf("failed to query the primary key of table \"%s.%s\": %w", database, table, p.queryError(ctx, err))
+	}
+	defer rows.Close()
+
+	var keys []IntegerKey
+	var types []string
+	for rows.Next() {
+		var column, dataType, columnType string
+		if err := rows.Scan(&column, &dataType, &columnType); err != nil {
+			return IntegerKey{}, fmt.Errorf("failed to read the primary key of table \"%s.%s\": %w", database, table, err)
+		}
+		keys = append(keys, IntegerKey{Column: column, Unsigned: strings.Contains(strings.ToLower(columnType), "unsigned")})
+		types = append(types, strings.ToLower(dataType))
+	}
+	if err := rows.Err(); err != nil {
+		return IntegerKey{}, fmt.Errorf("failed to read the primary key of table \"%s.%s\": %w", database, table, p.queryError(ctx, err))
+	}
+
+	if len(keys) != 1 || !contains(integerTypes, types[0]) {
+		return IntegerKey{}, nil
+	}
+	return keys[0], nil
+}
+
+// ChunkEnd reads the key rows-1 rows past the start of the chunk in the
+// primary key, which walks the index only, within the EnumerationTimeout.
+func (p *MySQLPlanner) ChunkEnd(ctx context.Context, database string, table string, key IntegerKey, after *KeyValue, rows int64) (KeyValue, bool, error) {
+	db, err := p.get(p.Connection)
+	if err != nil {
+		return KeyValue{}, false, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+
+	query := "SELECT " + quoteIdentifier(key.Column) + " FROM " + quoteTable(database, table)
+	var args []any
+	if after != nil {
+		query += " WHERE " + quoteIdentifier(key.Column) + " > ?"
+		args = append(args, after.arg())
+	}
+	query += fmt.Sprintf(" ORDER BY %s LIMIT 1 OFFSET %d", quoteIdentifier(key.Column), rows-1)
+
+	end := KeyValue{Unsigned: key.Unsigned}
+	var dest any = &end.Int
+	if key.Unsigned {
+		dest = &end.Uint
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.enumerationTimeout())
+	defer cancel()
+	err = db.QueryRowContext(ctx, query, args...).Scan(dest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return KeyValue{}, false, nil
+	}
+	if err != nil {
+		return KeyValue{}, false, fmt.Errorf("failed to find the end of a chunk of table \"%s.%s\": %w", database, table, p.queryError(ctx, err))
+	}
+	return end, true, nil
+}
+
+// chunkCheckpoint records the chunks of a table dumped so far, so that a
+// run writing to the same generation after an interrupted one resumes the
+// table after its last chunk.
+type chunkCheckpoint struct {
+	Key       string `json:"key"`
+	Unsigned  bool   `json:"unsigned,omitempty"`
+	ChunkRows int64  `json:"chunkRows"`
+	// Through is the key of the last row of the last chunk.
+	Through json.Number       `json:"through"`
+	Chunks  []PartitionResult `json:"chunks"`
+}
+
+// checkpointObject returns the object name of the checkpoint of the table
+// dumped to object, <database>/<table>/checkpoint.json.
+func checkpointObject(object string) string {
+	return object[:len(object)-len(tableObjectSuffix)] + "/checkpoint.json"
+}
+
+// loadCheckpoint returns the checkpoint of a chunked dump and the key of its
+// last row, or nil if there is none or it was written with another key or
+// chunk size.
+func loadCheckpoint(ctx context.Context, store ObjectStore, name string, key IntegerKey, chunkRows int64) (*chunkCheckpoint, *KeyValue) {
+	reader, err := store.NewReader(ctx, name)
+	if err != nil {
+		if !errors.Is(err, ErrObjectNotExist) {
+			log.Printf("Failed to read checkpoint %s, dumping the table from the start: %v\n", name, err)
+		}
+		return nil, nil
+	}
+	defer reader.Close()
+
+	var checkpoint chunkCheckpoint
+	if err := json.NewDecoder(reader).Decode(&checkpoint); err != nil {
+		log.Printf("Failed to parse checkpoint %s, dumping the table from the start: %v\n", name, err)
+		return nil, nil
+	}
+	if checkpoint.Key != key.Column || checkpoint.Unsigned != key.Unsigned || checkpoint.ChunkRows != chunkRows || checkpoint.Through == "" {
+		return nil, nil
+	}
+	through, err := parseKeyValue(key, checkpoint.Through.String())
+	if err != nil {
+		log.Printf("Failed to parse checkpoint %s, dumping the table from the start: %v\n", name, err)
+		return nil, nil
+	}
+	return &checkpoint, &through
+}
+
+// backupChunks dumps the rows of a table in chunks of chunkRows rows in the
+// order of its integer primary key key, each into its own object
+// <database>/<table>/chunk-<n>.sql.gz, and checkpoints every chunk done.
+// The checkpoint is removed once the last chunk is dumped.
+func backupChunks(ctx context.Context, chunker Chunker, dumper Dumper, uploader *Uploader, opts DumpOptions, database string, table string, object string, key IntegerKey, chunkRows int64) ([]PartitionResult, error) {
+	name := checkpointObject(object)
+	checkpoint, after := loadCheckpoint(ctx, uploader.Store, name, key, chunkRows)
+	if checkpoint != nil {
+		log.Printf("Resuming table \"%s.%s\" after chunk %d, %s %s\n", database, table, len(checkpoint.Chunks), key.Column, after)
+	} else {
+		checkpoint = &chunkCheckpoint{Key: key.Column, Unsigned: key.Unsigned, ChunkRows: chunkRows}
+	}
+
+	for {
+		through, more, err := chunker.ChunkEnd(ctx, database, table, key, after, chunkRows)
+		if err != nil {
+			return checkpoint.Chunks, err
+		}
+		chunk := fmt.Sprintf("chunk-%08d", len(checkpoint.Chunks)+1)
+		result := PartitionResult{Partition: chunk, Object: partitionObject(object, chunk)}
+
+		chunkOpts := opts
+		chunkOpts.Range = &KeyRange{Column: key.Column, After: after}
+		if more {
+			chunkOpts.Range.Through = &through
+		}
+		stats, err := dumpObject(ctx, dumper, uploader, chunkOpts, database, table, result.Object)
+		result.UncompressedBytes = stats.UncompressedBytes
+		result.CompressedBytes = stats.CompressedBytes
+		result.Rows = stats.Rows
+		result.Parts = stats.Parts
+		result.ObjectGeneration = stats.Generation
+		result.Etag = stats.Etag
+		result.PartGenerations = stats.PartGenerations
+		if err != nil {
+			return append(checkpoint.Chunks, result), fmt.Errorf("%s: %w", chunk, err)
+		}
+		result.CRC32C = formatCRC32C(stats.CRC32C)
+		result.MD5 = formatMD5(stats.MD5)
+		result.SHA256 = formatSHA256(stats.SHA256)
+		checkpoint.Chunks = append(checkpoint.Chunks, result)
+
+		if !more {
+			break
+		}
+		after = &through
+		checkpoint.Through = json.Number(through.String())
+		data, err := json.Marshal(checkpoint)
+		if err == nil {
+			err = uploader.UploadObject(ctx, name, "application/json", data)
+		}
+		if err != nil {
+			log.Printf("Failed to checkpoint table \"%s.%s\" after %s: %v\n", database, table, chunk, err)
+		}
+	}
+
+	if err := deleteStalePartitions(ctx, uploader.Store, object, checkpoint.Chunks); err != nil {
+		return checkpoint.Chunks, err
+	}
+	if err := uploader.Store.Delete(ctx, name); err != nil && !errors.Is(err, ErrObjectNotExist) {
+		log.Printf("Failed to delete checkpoint %s: %v\n", name, err)
+	}
+	log.Printf("Backup of table \"%s.%s\" completed in %d chunk(s).\n", database, table, len(checkpoint.Chunks))
+	return checkpoint.Chunks, nil
+}
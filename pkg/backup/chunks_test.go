@@ -0,0 +1,324 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// chunkingPlanner chunks every table by an id column with the given keys,
+// in order.
+type chunkingPlanner struct {
+	fakePlanner
+	keys     []uint64
+	unsigned bool
+}
+
+func (p *chunkingPlanner) ChunkKey(ctx context.Context, database string, table string) (IntegerKey, error) {
+	return IntegerKey{Column: "id", Unsigned: p.unsigned}, nil
+}
+
+func (p *chunkingPlanner) ChunkEnd(ctx context.Context, database string, table string, key IntegerKey, after *KeyValue, rows int64) (KeyValue, bool, error) {
+	var remaining []uint64
+	for _, k := range p.keys {
+		switch {
+		case after == nil, after.Unsigned && k > after.Uint, !after.Unsigned && k > uint64(after.Int):
+			remaining = append(remaining, k)
+		}
+	}
+	if int64(len(remaining)) < rows {
+		return KeyValue{}, false, nil
+	}
+	end := remaining[rows-1]
+	if key.Unsigned {
+		return KeyValue{Unsigned: true, Uint: end}, true, nil
+	}
+	return KeyValue{Int: int64(end)}, true, nil
+}
+
+// rangeDumper records the key ranges it dumps and fails the chunk starting
+// after failAfter.
+type rangeDumper struct {
+	mu        sync.Mutex
+	ranges    []string
+	failAfter string
+}
+
+func (d *rangeDumper) Dump(ctx context.Context, database string, table string, opts DumpOptions) (io.ReadCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r := formatRange(*opts.Range)
+	d.ranges = append(d.ranges, r)
+	var err error
+	if d.failAfter != "" && strings.HasPrefix(r, "id>"+d.failAfter+" ") {
+		err = errFake
+	}
+	return &fakeDump{Reader: strings.NewReader("INSERT INTO `events` VALUES (" + r + ");\n"), err: err}, nil
+}
+
+// formatRange formats a key range as Column>After Column<=Through, with
+// "-" for nil bounds.
+func formatRange(r KeyRange) string {
+	bound := func(v *KeyValue) string {
+		if v == nil {
+			return "-"
+		}
+		return v.String()
+	}
+	return fmt.Sprintf("%s>%s %s<=%s", r.Column, bound(r.After), r.Column, bound(r.Through))
+}
+
+func TestRunResumesChunkedTable(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &chunkingPlanner{
+		fakePlanner: fakePlanner{
+			databases: []string{"shop"},
+			tables:    map[string][]string{"shop": {"events"}},
+			rows:      map[string]int64{"shop.events": 10},
+		},
+		keys: []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+	}
+	chunks := &rangeDumper{failAfter: "6"}
+	dumper := &fakeDumper{}
+	cfg := testConfig(store, planner, dumper)
+	cfg.PartitionDumper = chunks
+	cfg.ChunkRows = 3
+	cfg.started = time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	if _, err := Run(context.Background(), cfg); err == nil {
+		t.Fatal("Run succeeded, want the failure of the third chunk")
+	}
+	if !dumper.opts["shop.events"].NoData {
+		t.Error("table definition was dumped with its rows")
+	}
+	if _, ok := store.Data("host/2024-01-01-10/shop/events/checkpoint.json"); !ok {
+		t.Fatal("no checkpoint was written")
+	}
+
+	chunks.ranges = nil
+	chunks.failAfter = ""
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	want := []string{"id>6 id<=9", "id>9 id<=-"}
+	if strings.Join(chunks.ranges, ",") != strings.Join(want, ",") {
+		t.Errorf("resumed run dumped %v, want %v", chunks.ranges, want)
+	}
+	result, _ := m.Table("shop", "events")
+	var objects []string
+	for _, chunk := range result.Partitions {
+		objects = append(objects, chunk.Object)
+	}
+	if got := strings.Join(objects, " "); got != "host/2024-01-01-10/shop/events/chunk-00000001.sql.gz host/2024-01-01-10/shop/events/chunk-00000002.sql.gz host/2024-01-01-10/shop/events/chunk-00000003.sql.gz host/2024-01-01-10/shop/events/chunk-00000004.sql.gz" {
+		t.Errorf("chunks = %s", got)
+	}
+	if _, ok := store.Data("host/2024-01-01-10/shop/events/checkpoint.json"); ok {
+		t.Error("checkpoint was not deleted after the last chunk")
+	}
+}
+
+func TestRunReplacesChunksOfEarlierRun(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	planner := &chunkingPlanner{
+		fakePlanner: fakePlanner{
+			databases: []string{"shop"},
+			tables:    map[string][]string{"shop": {"events"}},
+			rows:      map[string]int64{"shop.events": 10},
+		},
+		keys: []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+	}
+	cfg := testConfig(store, planner, &fakeDumper{})
+	cfg.PartitionDumper = &rangeDumper{}
+	cfg.ChunkRows = 3
+	cfg.started = time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	if _, err := Run(ctx, cfg); err != nil {
+		t.Fatalf("first Run failed: %v", err)
+	}
+
+	// The table shrank before the next run to the same generation.
+	planner.keys = planner.keys[:5]
+	planner.rows["shop.events"] = 5
+	cfg.started = cfg.started.Add(30 * time.Minute)
+	if _, err := Run(ctx, cfg); err != nil {
+		t.Fatalf("second Run failed: %v", err)
+	}
+	for _, chunk := range []string{"chunk-00000003", "chunk-00000004"} {
+		if _, ok := store.Data("host/2024-01-01-10/shop/events/" + chunk + ".sql.gz"); ok {
+			t.Errorf("%s of the first run was kept", chunk)
+		}
+	}
+
+	// Restores only apply the chunks the manifest records, even if a stale
+	// one could not be deleted.
+	putGzipObject(t, store, "host/2024-01-01-10/shop/events/chunk-00000003.sql.gz", "INSERT INTO `events` VALUES (7);\n")
+	object, err := FindTableObject(ctx, store, "host", "2024-01-01-10", "shop", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"host/2024-01-01-10/shop/events/chunk-00000001.sql.gz", "host/2024-01-01-10/shop/events/chunk-00000002.sql.gz"}
+	if !reflect.DeepEqual(object.Partitions, want) {
+		t.Errorf("Partitions = %v, want %v", object.Partitions, want)
+	}
+}
+
+func TestRunDumpsSmallTablesWhole(t *testing.T) {
+	planner := &chunkingPlanner{fakePlanner: fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"events"}},
+		rows:      map[string]int64{"shop.events": 2},
+	}}
+	chunks := &rangeDumper{}
+	cfg := testConfig(NewMemoryStore(), planner, &fakeDumper{})
+	cfg.PartitionDumper = chunks
+	cfg.ChunkRows = 3
+
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(chunks.ranges) != 0 {
+		t.Errorf("table of 2 rows was dumped in chunks %v", chunks.ranges)
+	}
+}
+
+func TestRunChunksLargeIntegerKeysExactly(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		unsigned bool
+		base     uint64
+	}{
+		{name: "BIGINT", base: 1 << 53},
+		{name: "BIGINT UNSIGNED", unsigned: true, base: 1<<64 - 8},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			planner := &chunkingPlanner{
+				fakePlanner: fakePlanner{
+					databases: []string{"shop"},
+					tables:    map[string][]string{"shop": {"events"}},
+					rows:      map[string]int64{"shop.events": 6},
+				},
+				unsigned: test.unsigned,
+			}
+			for i := uint64(0); i < 6; i++ {
+				planner.keys = append(planner.keys, test.base+i)
+			}
+			key := func(i uint64) string { return fmt.Sprint(test.base + i) }
+			chunks := &rangeDumper{failAfter: key(3)}
+			cfg := testConfig(store, planner, &fakeDumper{})
+			cfg.PartitionDumper = chunks
+			cfg.ChunkRows = 2
+			cfg.started = time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+			if _, err := Run(context.Background(), cfg); err == nil {
+				t.Fatal("Run succeeded, want the failure of the third chunk")
+			}
+			data, ok := store.Data("host/2024-01-01-10/shop/events/checkpoint.json")
+			if !ok {
+				t.Fatal("no checkpoint was written")
+			}
+			var checkpoint map[string]json.RawMessage
+			if err := json.Unmarshal(data, &checkpoint); err != nil {
+				t.Fatal(err)
+			}
+			if got := string(checkpoint["through"]); got != key(3) {
+				t.Errorf("checkpoint through = %s, want the number %s", got, key(3))
+			}
+
+			want := []string{
+				"id>- id<=" + key(1),
+				"id>" + key(1) + " id<=" + key(3),
+				"id>" + key(3) + " id<=" + key(5),
+			}
+			if strings.Join(chunks.ranges, ",") != strings.Join(want, ",") {
+				t.Errorf("run dumped %v, want %v", chunks.ranges, want)
+			}
+
+			chunks.ranges = nil
+			chunks.failAfter = ""
+			if _, err := Run(context.Background(), cfg); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			want = []string{"id>" + key(3) + " id<=" + key(5), "id>" + key(5) + " id<=-"}
+			if strings.Join(chunks.ranges, ",") != strings.Join(want, ",") {
+				t.Errorf("resumed run dumped %v, want %v", chunks.ranges, want)
+			}
+		})
+	}
+}
+
+// stuckChunker cancels the run and then waits for its context, as a
+// wedged server would.
+type stuckChunker struct {
+	chunkingPlanner
+	cancel context.CancelFunc
+}
+
+func (p *stuckChunker) ChunkEnd(ctx context.Context, database string, table string, key IntegerKey, after *KeyValue, rows int64) (KeyValue, bool, error) {
+	p.cancel()
+	<-ctx.Done()
+	return KeyValue{}, false, ctx.Err()
+}
+
+func TestRunStopsChunkingOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	planner := &stuckChunker{
+		chunkingPlanner: chunkingPlanner{fakePlanner: fakePlanner{
+			databases: []string{"shop"},
+			tables:    map[string][]string{"shop": {"events"}},
+			rows:      map[string]int64{"shop.events": 10},
+		}},
+		cancel: cancel,
+	}
+	cfg := testConfig(NewMemoryStore(), planner, &fakeDumper{})
+	cfg.PartitionDumper = &rangeDumper{}
+	cfg.ChunkRows = 3
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := Run(ctx, cfg)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run() error = %v, want the run cancelled", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run() kept waiting for the chunk end after the run was cancelled")
+	}
+}
+
+func TestParseKeyValue(t *testing.T) {
+	for _, test := range []struct {
+		key   IntegerKey
+		value string
+		valid bool
+	}{
+		{IntegerKey{Column: "id"}, "9007199254740993", true},
+		{IntegerKey{Column: "id"}, "-9223372036854775808", true},
+		{IntegerKey{Column: "id"}, "18446744073709551615", false},
+		{IntegerKey{Column: "id", Unsigned: true}, "18446744073709551615", true},
+		{IntegerKey{Column: "id", Unsigned: true}, "-1", false},
+		{IntegerKey{Column: "id"}, "1e3", false},
+	} {
+		v, err := parseKeyValue(test.key, test.value)
+		if (err == nil) != test.valid {
+			t.Errorf("parseKeyValue(%+v, %s) error = %v, want valid %v", test.key, test.value, err, test.valid)
+			continue
+		}
+		if err == nil && v.String() != test.value {
+			t.Errorf("parseKeyValue(%+v, %s) = %s", test.key, test.value, v)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package backup
+
+import (
+	"archive/tar"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ClonePrefix is the directory of a host holding its physical backups,
+// beside the generations of its logical runs.
+const ClonePrefix = "clone"
+
+// CloneConfig configures Clone.
+type CloneConfig struct {
+	Connection Connection
+	Store      ObjectStore
+	Hostname   string
+	// Dir is the absolute path of the directory the server clones its data
+	// into. It must not exist, and must be readable by this process once
+	// the server wrote it, so Clone runs on the host of the server or with
+	// Dir on a file system shared with it.
+	Dir string
+	// KeepDir keeps Dir once it was uploaded instead of removing it.
+	KeepDir bool
+}
+
+// CloneResult describes an uploaded physical backup.
+type CloneResult struct {
+	Object            string
+	Files             int
+	UncompressedBytes int64
+	CompressedBytes   int64
+	Elapsed           time.Duration
+}
+
+// Clone takes a physical backup of the whole instance with the CLONE
+// plugin of MySQL 8.0.17 and later: the server writes a consistent copy of
+// its data directory to cfg.Dir, which is uploaded as a gzip-compressed tar
+// archive to <host>/clone/<time>.tar.gz. The archive is a data directory
+// that a server of the same version starts from after it is extracted.
+func Clone(ctx context.Context, cfg CloneConfig) (*CloneResult, error) {
+	if !filepath.IsAbs(cfg.Dir) || strings.ContainsAny(cfg.Dir, `'\`) {
+		return nil, fmt.Errorf("invalid clone directory %q: must be an absolute path without quotes or backslashes", cfg.Dir)
+	}
+	if _, err := os.Stat(cfg.Dir); err == nil {
+		return nil, fmt.Errorf("clone directory %s already exists", cfg.Dir)
+	}
+
+	var l lazyDB
+	db, err := l.get(cfg.Connection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+	defer l.Close()
+
+	var status string
+	err = db.QueryRowContext(ctx, "SELECT PLUGIN_STATUS FROM information_schema.PLUGINS WHERE PLUGIN_NAME = 'clone'").Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("the clone plugin is not installed, install it with INSTALL PLUGIN clone SONAME 'mysql_clone.so'")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the clone plugin: %w", err)
+	}
+	if status != "ACTIVE" {
+		return nil, fmt.Errorf("the clone plugin is %s", status)
+	}
+	var version string
+	if err := db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err != nil {
+		return nil, fmt.Errorf("failed to query the server version: %w", err)
+	}
+
+	started := time.Now()
+	log.Printf("Cloning the data of MySQL %s into %s\n", version, cfg.Dir)
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("CLONE LOCAL DATA DIRECTORY = '%s'", cfg.Dir)); err != nil {
+		return nil, fmt.Errorf("failed to clone the data directory: %w", err)
+	}
+	if _, err := os.Stat(cfg.Dir); err != nil {
+		return nil, fmt.Errorf("the server cloned its data into %s, which cannot be read here; run clone on the host of the server: %w", cfg.Dir, err)
+	}
+	if !cfg.KeepDir {
+		defer func() {
+			if err := os.RemoveAll(cfg.Dir); err != nil {
+				log.Printf("Failed to remove the clone directory %s: %v\n", cfg.Dir, err)
+			}
+		}()
+	}
+	log.Printf("Cloned the data directory in %s\n", time.Since(started).Round(time.Second))
+
+	result := &CloneResult{Object: fmt.Sprintf("%s/%s/%s.tar.gz", cfg.Hostname, ClonePrefix, generationName(started, GranularityRun))}
+	uploader := &Uploader{
+		Store:         cfg.Store,
+		Progress:      newProgress(started),
+		Metadata:      map[string]string{hostMetadataKey: cfg.Hostname, serverVersionMetadataKey: version},
+		MaxObjectSize: DefaultMaxObjectSize,
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		files, err := writeTar(pw, cfg.Dir)
+		result.Files = files
+		pw.CloseWithError(err)
+	}()
+	stats, err := uploader.Upload(ctx, result.Object, pr)
+	pr.CloseWithError(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload the clone: %w", err)
+	}
+	if len(stats.Parts) > 0 {
+		log.Printf("The clone was split into %s and %d continuation objects, concatenate them before extracting\n", result.Object, len(stats.Parts))
+	}
+
+	result.UncompressedBytes = stats.UncompressedBytes
+	result.CompressedBytes = stats.CompressedBytes
+	result.Elapsed = time.Since(started)
+	return result, nil
+}
+
+// serverVersionMetadataKey labels physical backups with the server version
+// they can be started with.
+const serverVersionMetadataKey = "backup-server-version"
+
+// writeTar writes the files under dir to w as a tar archive with paths
+// relative to dir, returning the number of files written.
+func writeTar(w io.Writer, dir string) (int, error) {
+	tw := tar.NewWriter(w)
+	files := 0
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil || name == "." {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if _, err := io.Copy(tw, file); err != nil {
+			return fmt.Errorf("failed to archive %s: %w", path, err)
+		}
+		files++
+		return nil
+	})
+	if err != nil {
+		return files, fmt.Errorf("failed to archive %s: %w", dir, err)
+	}
+	return files, tw.Close()
+}
@@ -0,0 +1,79 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteTar(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "shop"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"ibdata1":         "system tablespace",
+		"shop/orders.ibd": "orders",
+		"mysql.ibd":       "",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	n, err := writeTar(&buf, dir)
+	if err != nil {
+		t.Fatalf("writeTar: %v", err)
+	}
+	if n != len(files) {
+		t.Errorf("writeTar wrote %d files, want %d", n, len(files))
+	}
+
+	got := map[string]string{}
+	reader := tar.NewReader(&buf)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read archive: %v", err)
+		}
+		if header.Typeflag == tar.TypeDir {
+			if header.Name != "shop/" {
+				t.Errorf("unexpected directory %q", header.Name)
+			}
+			continue
+		}
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[header.Name] = string(content)
+	}
+	for name, content := range files {
+		if got[name] != content {
+			t.Errorf("archive has %q for %s, want %q", got[name], name, content)
+		}
+	}
+	if len(got) != len(files) {
+		t.Errorf("archive has files %v, want %v", got, files)
+	}
+}
+
+func TestCloneRejectsDirectory(t *testing.T) {
+	existing := t.TempDir()
+	for _, dir := range []string{"", "relative/clone", "/tmp/it's", existing} {
+		_, err := Clone(context.Background(), CloneConfig{Store: NewMemoryStore(), Hostname: "host", Dir: dir})
+		if err == nil || !strings.Contains(err.Error(), "clone directory") {
+			t.Errorf("Clone with directory %q: got %v, want an invalid directory error", dir, err)
+		}
+	}
+}
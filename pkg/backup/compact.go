@@ -0,0 +1,226 @@
+package backup
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// CompactConfig configures Compact.
+type CompactConfig struct {
+	Workers int
+	// Signer signs the updated manifest. It is required for runs whose
+	// manifest is signed.
+	Signer ManifestSigner
+}
+
+// compactedDump is the standalone object a deduplicated dump was rewritten
+// to.
+type compactedDump struct {
+	attrs  *ObjectAttrs
+	hashes objectHashes
+}
+
+// Compact rewrites the deduplicated dumps of the run stored under path,
+// see Config.Deduplicate, into standalone .sql.gz objects without reading
+// MySQL, so that the run no longer depends on chunks shared with other
+// runs. Stores that are Composers concatenate the chunks server-side, the
+// others have them downloaded and written again. The manifest and the run
+// index record the new objects, and the indexes of the dumps are deleted
+// last, leaving the chunks nothing else lists to CollectGarbage. It returns
+// the number of dumps rewritten.
+func Compact(ctx context.Context, store ObjectStore, path string, cfg CompactConfig) (int, error) {
+	m, err := LoadManifest(ctx, store, path)
+	if err != nil {
+		return 0, err
+	}
+	_, err = store.Attrs(ctx, signatureName(path))
+	signed := err == nil
+	if err != nil && !errors.Is(err, ErrObjectNotExist) {
+		return 0, fmt.Errorf("failed to look up the manifest signature: %w", err)
+	}
+	if signed && cfg.Signer == nil {
+		return 0, fmt.Errorf("the manifest of %s is signed and needs a signer to be re-signed after compacting", path)
+	}
+
+	var (
+		mu        sync.Mutex
+		compacted = map[string]compactedDump{}
+	)
+	group, groupCtx := errgroup.WithContext(ctx)
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	group.SetLimit(workers)
+	for _, table := range m.Tables {
+		if table.Status != StatusSucceeded || !strings.HasSuffix(table.Object, dedupIndexSuffix) {
+			continue
+		}
+		index := table.Object
+
+		group.Go(func() error {
+			dump, err := compactDump(groupCtx, store, index)
+			if err != nil {
+				return fmt.Errorf("failed to compact %s: %w", index, err)
+			}
+			log.Printf("Compacted %s into %s (%s)\n", index, dump.attrs.Name, FormatBytes(dump.attrs.Size))
+
+			mu.Lock()
+			defer mu.Unlock()
+			compacted[index] = dump
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		// The manifest still records the indexes, and the objects written
+		// so far are left to CollectGarbage.
+		return 0, err
+	}
+	if len(compacted) == 0 {
+		return 0, nil
+	}
+
+	m.replaceDeduplicated(compacted)
+	if !signed {
+		cfg.Signer = nil
+	}
+	uploader := &Uploader{Store: store}
+	if m.RunID != "" {
+		_, err := store.Attrs(ctx, indexName(m.RunID))
+		switch {
+		case err == nil:
+			if err := m.uploadIndex(ctx, uploader); err != nil {
+				return 0, fmt.Errorf("failed to upload the updated run index: %w", err)
+			}
+		case !errors.Is(err, ErrObjectNotExist):
+			return 0, fmt.Errorf("failed to look up the run index: %w", err)
+		}
+	}
+	if err := m.upload(ctx, uploader, cfg.Signer); err != nil {
+		return 0, fmt.Errorf("failed to upload the updated manifest: %w", err)
+	}
+
+	for index := range compacted {
+		if err := store.Delete(ctx, index); err != nil && !errors.Is(err, ErrObjectNotExist) {
+			log.Printf("Failed to delete %s, gc deletes it later: %v\n", index, err)
+		}
+	}
+	return len(compacted), nil
+}
+
+// compactDump writes the chunks the index of a deduplicated dump lists to
+// the .sql.gz object the dump would have had otherwise.
+func compactDump(ctx context.Context, store ObjectStore, indexObject string) (compactedDump, error) {
+	name := strings.TrimSuffix(indexObject, dedupIndexSuffix) + tableObjectSuffix
+	attrs, err := store.Attrs(ctx, indexObject)
+	if err != nil {
+		return compactedDump{}, fmt.Errorf("failed to retrieve attributes for %s: %w", indexObject, err)
+	}
+	// The labels of the run carry over, the checksums of the index do not.
+	metadata := map[string]string{}
+	for key, value := range attrs.Metadata {
+		if key != md5MetadataKey && key != sha256MetadataKey {
+			metadata[key] = value
+		}
+	}
+
+	var hashes objectHashes
+	if composer, ok := store.(Composer); ok {
+		index, err := loadDedupIndex(ctx, store, indexObject)
+		if err != nil {
+			return compactedDump{}, err
+		}
+		if len(index.Chunks) == 0 {
+			return compactedDump{}, fmt.Errorf("index %s lists no chunks", indexObject)
+		}
+		host, _, _ := strings.Cut(indexObject, "/")
+		writer := newCompositeWriter(ctx, store, composer, name, "", metadata, 0, 1)
+		for _, chunk := range index.Chunks {
+			writer.parts = append(writer.parts, dedupChunkObject(host, chunk.SHA256))
+		}
+		err = writer.compose()
+		writer.cleanup()
+		if err != nil {
+			return compactedDump{}, err
+		}
+		// The composed object is read back once, as GCS computes no MD5
+		// and no SHA-256 for it.
+		if hashes, err = hashObject(ctx, store, name); err != nil {
+			return compactedDump{}, err
+		}
+	} else {
+		reader, err := openDeduplicated(ctx, store, indexObject)
+		if err != nil {
+			return compactedDump{}, err
+		}
+		defer reader.Close()
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		writer := newHashingWriter(store.NewWriter(ctx, name, "", metadata))
+		if _, err := io.Copy(writer, reader); err != nil {
+			return compactedDump{}, fmt.Errorf("failed to write object %s: %w", name, err)
+		}
+		if err := writer.Close(); err != nil {
+			return compactedDump{}, fmt.Errorf("failed to close writer for object %s: %w", name, err)
+		}
+		hashes = writer.sum()
+	}
+
+	uploader := &Uploader{Store: store}
+	verified, err := uploader.verify(ctx, name, hashes)
+	if err != nil {
+		return compactedDump{}, err
+	}
+	return compactedDump{attrs: verified, hashes: hashes}, nil
+}
+
+// hashObject computes the checksums of an object by reading it.
+func hashObject(ctx context.Context, store ObjectStore, name string) (objectHashes, error) {
+	reader, err := store.NewReader(ctx, name)
+	if err != nil {
+		return objectHashes{}, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	crc32c, md5Hash, sha256Hash := crc32.New(crc32cTable), md5.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(crc32c, md5Hash, sha256Hash), reader); err != nil {
+		return objectHashes{}, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return objectHashes{CRC32C: crc32c.Sum32(), MD5: md5Hash.Sum(nil), SHA256: sha256Hash.Sum(nil)}, nil
+}
+
+// replaceDeduplicated records the standalone objects of compacted dumps in
+// place of their indexes.
+func (m *Manifest) replaceDeduplicated(compacted map[string]compactedDump) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.Tables {
+		table := &m.Tables[i]
+		dump, ok := compacted[table.Object]
+		if !ok {
+			continue
+		}
+		m.CompressedBytes += dump.attrs.Size - table.CompressedBytes
+		table.Object = dump.attrs.Name
+		table.setStats(table.UncompressedBytes, dump.attrs.Size)
+		table.ReusedBytes = 0
+		table.CRC32C = formatCRC32C(dump.hashes.CRC32C)
+		table.MD5 = formatMD5(dump.hashes.MD5)
+		table.SHA256 = formatSHA256(dump.hashes.SHA256)
+		table.ObjectGeneration = dump.attrs.Generation
+		table.Etag = dump.attrs.Etag
+	}
+	m.CompressionRatio = compressionRatio(m.UncompressedBytes, m.CompressedBytes)
+}
@@ -0,0 +1,125 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+// plainStore hides the optional interfaces of the store, such as Composer.
+type plainStore struct {
+	ObjectStore
+}
+
+func TestCompact(t *testing.T) {
+	var dump strings.Builder
+	random := rand.New(rand.NewSource(1))
+	for i := 0; dump.Len() < 3<<20; i++ {
+		fmt.Fprintf(&dump, "INSERT INTO `events` VALUES (%d,'%x');\n", i, random.Int63())
+	}
+	ctx := context.Background()
+	store := NewMemoryStore()
+	planner := &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"events", "empty"}}}
+	dumper := &fakeDumper{dumps: map[string]string{"shop.events": dump.String()}}
+	cfg := testConfig(store, planner, dumper)
+	cfg.Deduplicate = true
+	cfg.Index = true
+	cfg.started = time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	first, err := Run(ctx, cfg)
+	if err != nil {
+		t.Fatalf("first Run() error = %v", err)
+	}
+	cfg.started = cfg.started.Add(24 * time.Hour)
+	second, err := Run(ctx, cfg)
+	if err != nil {
+		t.Fatalf("second Run() error = %v", err)
+	}
+
+	check := func(run *Manifest) {
+		t.Helper()
+		m := readManifest(t, store, run.Path)
+		if m.CompressedBytes != run.CompressedBytes {
+			t.Errorf("CompressedBytes = %d after compacting, want %d", m.CompressedBytes, run.CompressedBytes)
+		}
+		for _, table := range m.Tables {
+			if !strings.HasSuffix(table.Object, tableObjectSuffix) || table.ReusedBytes != 0 {
+				t.Errorf("table %s is stored as %s reusing %d bytes, want a standalone %s", table.Table, table.Object, table.ReusedBytes, tableObjectSuffix)
+			}
+			data, ok := store.Data(table.Object)
+			if !ok {
+				t.Fatalf("%s is missing", table.Object)
+			}
+			if sum := sha256.Sum256(data); table.SHA256 != formatSHA256(sum[:]) {
+				t.Errorf("SHA256 of %s = %s, want %x", table.Object, table.SHA256, sum)
+			}
+			if _, ok := store.Data(dedupIndexName(table.Object)); ok {
+				t.Errorf("index of %s kept after compacting", table.Object)
+			}
+		}
+		index, err := LoadRunIndex(ctx, store, m.RunID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, object := range index.Objects {
+			if !strings.HasSuffix(object.Object, tableObjectSuffix) {
+				t.Errorf("run index lists %s after compacting", object.Object)
+			}
+		}
+	}
+	chunks := func() []GarbageObject {
+		t.Helper()
+		garbage, err := CollectGarbage(ctx, GCConfig{Store: store, Host: "host", Before: time.Now().Add(time.Hour), DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return garbage
+	}
+
+	if compacted, err := Compact(ctx, store, first.Path, CompactConfig{Workers: 2}); err != nil || compacted != 2 {
+		t.Fatalf("Compact() = %d, %v, want 2 dumps compacted", compacted, err)
+	}
+	check(first)
+	if garbage := chunks(); len(garbage) != 0 {
+		t.Errorf("CollectGarbage() = %+v while the second run lists the chunks, want nothing", garbage)
+	}
+
+	// Stores that cannot compose get the chunks copied.
+	if compacted, err := Compact(ctx, plainStore{store}, second.Path, CompactConfig{}); err != nil || compacted != 2 {
+		t.Fatalf("Compact() without composing = %d, %v, want 2 dumps compacted", compacted, err)
+	}
+	check(second)
+	garbage := chunks()
+	if len(garbage) == 0 {
+		t.Error("CollectGarbage() kept the chunks of the compacted dumps")
+	}
+	for _, object := range garbage {
+		if object.Reason != GarbageChunk {
+			t.Errorf("CollectGarbage() = %+v, want only chunks", object)
+		}
+	}
+
+	for _, generation := range []string{"2024-01-01-10", "2024-01-02-10"} {
+		for table, want := range map[string]string{"events": dump.String(), "empty": ""} {
+			object, err := FindTableObject(ctx, store, "host", generation, "shop", table)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if _, err := Download(ctx, store, object.Name(), &buf); err != nil {
+				t.Fatalf("Download(%s) error = %v", object.Name(), err)
+			}
+			if buf.String() != want {
+				t.Errorf("Download(%s) = %d bytes, want %d", object.Name(), buf.Len(), len(want))
+			}
+		}
+	}
+
+	if compacted, err := Compact(ctx, store, first.Path, CompactConfig{}); err != nil || compacted != 0 {
+		t.Errorf("second Compact() = %d, %v, want nothing compacted", compacted, err)
+	}
+}
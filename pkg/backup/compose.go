@@ -0,0 +1,262 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxComposeSources is the number of objects GCS composes in one request.
+const maxComposeSources = 32
+
+// Composer is implemented by ObjectStores that can concatenate objects
+// server-side, which parallel composite uploads require.
+type Composer interface {
+	// Compose writes the concatenation of at most maxComposeSources
+	// sources to name.
+	Compose(ctx context.Context, name string, sources []string, contentType string, metadata map[string]string) error
+}
+
+func (s *GCSStore) Compose(ctx context.Context, name string, sources []string, contentType string, metadata map[string]string) error {
+	objects := make([]*storage.ObjectHandle, len(sources))
+	for i, source := range sources {
+		objects[i] = s.Bucket.Object(source)
+	}
+
+	composer := s.Bucket.Object(name).ComposerFrom(objects...)
+	composer.ContentType = contentType
+	composer.Metadata = metadata
+	_, err := composer.Run(ctx)
+	return err
+}
+
+func (s *MemoryStore) Compose(ctx context.Context, name string, sources []string, contentType string, metadata map[string]string) error {
+	writer := s.NewWriter(ctx, name, contentType, metadata)
+	for _, source := range sources {
+		data, ok := s.Data(source)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrObjectNotExist, source)
+		}
+		writer.Write(data)
+	}
+	return writer.Close()
+}
+
+// Compose composes the object in every store, failing unless all of them
+// are Composers.
+func (s *MirrorStore) Compose(ctx context.Context, name string, sources []string, contentType string, metadata map[string]string) error {
+	for i, store := range s.Stores {
+		composer, ok := store.(Composer)
+		if !ok {
+			return fmt.Errorf("mirror %d does not support composing objects", i)
+		}
+		if err := composer.Compose(ctx, name, sources, contentType, metadata); err != nil {
+			return fmt.Errorf("mirror %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Compose composes the object in the store currently written to.
+func (s *FailoverStore) Compose(ctx context.Context, name string, sources []string, contentType string, metadata map[string]string) error {
+	store := s.Primary
+	if s.FailedOver() {
+		store = s.Fallback
+	}
+	composer, ok := store.(Composer)
+	if !ok {
+		return errors.New("store does not support composing objects")
+	}
+	return composer.Compose(ctx, name, sources, contentType, metadata)
+}
+
+// compositeWriter uploads a stream as parts of partSize bytes, up to
+// parallelism of them at a time, and composes them into the final object on
+// Close. Streams no larger than a single part are written as a plain
+// object.
+type compositeWriter struct {
+	ctx         context.Context
+	store       ObjectStore
+	composer    Composer
+	name        string
+	contentType string
+	metadata    map[string]string
+	partSize    int
+
+	buf      []byte
+	parts    []string
+	group    *errgroup.Group
+	groupCtx context.Context
+
+	mu        sync.Mutex
+	temporary []string
+	buckets   []string
+}
+
+func newCompositeWriter(ctx context.Context, store ObjectStore, composer Composer, name string, contentType string, metadata map[string]string, partSize int, parallelism int) *compositeWriter {
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(parallelism)
+
+	return &compositeWriter{
+		ctx:         ctx,
+		store:       store,
+		composer:    composer,
+		name:        name,
+		contentType: contentType,
+		metadata:    metadata,
+		partSize:    partSize,
+		group:       group,
+		groupCtx:    groupCtx,
+	}
+}
+
+func (w *compositeWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, w.partSize)
+		}
+
+		free := w.partSize - len(w.buf)
+		if free > len(p) {
+			free = len(p)
+		}
+		w.buf = append(w.buf, p[:free]...)
+		p = p[free:]
+
+		if len(w.buf) == w.partSize {
+			if err := w.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush starts the upload of the buffered part, blocking while parallelism
+// parts are in flight.
+func (w *compositeWriter) flush() error {
+	if w.groupCtx.Err() != nil {
+		if err := w.group.Wait(); err != nil {
+			return err
+		}
+		return w.groupCtx.Err()
+	}
+
+	data := w.buf
+	w.buf = nil
+	part := fmt.Sprintf("%s.composite-0-%04d", w.name, len(w.parts))
+	w.parts = append(w.parts, part)
+	w.addTemporary(part)
+
+	w.group.Go(func() error {
+		writer := w.store.NewWriter(w.groupCtx, part, "", nil)
+		if _, err := writer.Write(data); err != nil {
+			return fmt.Errorf("failed to write part %s: %w", part, err)
+		}
+		if err := writer.Close(); err != nil {
+			return fmt.Errorf("failed to close part %s: %w", part, err)
+		}
+		w.addBuckets(writerBuckets(writer))
+		return nil
+	})
+	return nil
+}
+
+func (w *compositeWriter) Close() error {
+	if len(w.parts) == 0 {
+		writer := w.store.NewWriter(w.ctx, w.name, w.contentType, w.metadata)
+		if _, err := writer.Write(w.buf); err != nil {
+			return err
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+		w.addBuckets(writerBuckets(writer))
+		return nil
+	}
+
+	defer w.cleanup()
+
+	if len(w.buf) > 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	if err := w.group.Wait(); err != nil {
+		return err
+	}
+
+	return w.compose()
+}
+
+// compose composes the parts into the final object, through intermediate
+// objects when there are more than maxComposeSources of them.
+func (w *compositeWriter) compose() error {
+	sources := w.parts
+	for round := 1; len(sources) > maxComposeSources; round++ {
+		var next []string
+		for i := 0; i < len(sources); i += maxComposeSources {
+			end := i + maxComposeSources
+			if end > len(sources) {
+				end = len(sources)
+			}
+			name := fmt.Sprintf("%s.composite-%d-%04d", w.name, round, len(next))
+			w.addTemporary(name)
+			if err := w.composer.Compose(w.ctx, name, sources[i:end], "", nil); err != nil {
+				return fmt.Errorf("failed to compose %s: %w", name, err)
+			}
+			next = append(next, name)
+		}
+		sources = next
+	}
+
+	if err := w.composer.Compose(w.ctx, w.name, sources, w.contentType, w.metadata); err != nil {
+		return fmt.Errorf("failed to compose %s: %w", w.name, err)
+	}
+	return nil
+}
+
+// cleanup waits for parts in flight and deletes the parts and intermediate
+// objects. It runs on a fresh context as the upload's may be cancelled.
+func (w *compositeWriter) cleanup() {
+	w.group.Wait()
+
+	w.mu.Lock()
+	temporary := w.temporary
+	w.temporary = nil
+	w.mu.Unlock()
+
+	for _, name := range temporary {
+		if err := w.store.Delete(context.Background(), name); err != nil && !errors.Is(err, ErrObjectNotExist) {
+			log.Printf("Failed to delete temporary object %s: %v\n", name, err)
+		}
+	}
+}
+
+func (w *compositeWriter) addTemporary(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.temporary = append(w.temporary, name)
+}
+
+func (w *compositeWriter) addBuckets(buckets []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, bucket := range buckets {
+		if !contains(w.buckets, bucket) {
+			w.buckets = append(w.buckets, bucket)
+		}
+	}
+}
+
+func (w *compositeWriter) Buckets() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buckets
+}
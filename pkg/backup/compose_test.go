@@ -0,0 +1,80 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadComposesLargeDumps(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	var content strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&content, "INSERT INTO t VALUES (%d, '%x');\n", i, random.Int63())
+	}
+
+	for _, partSize := range []int{1 << 20, 4096, 256} {
+		t.Run(fmt.Sprint(partSize), func(t *testing.T) {
+			store := NewMemoryStore()
+			uploader := &Uploader{
+				Store:                store,
+				Progress:             newProgress(time.Now()),
+				Metadata:             map[string]string{"key": "value"},
+				CompositePartSize:    partSize,
+				CompositeParallelism: 4,
+			}
+
+			stats, err := uploader.Upload(context.Background(), "db/t.sql.gz", strings.NewReader(content.String()))
+			if err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+
+			if got := readGzipObject(t, store, "db/t.sql.gz"); got != content.String() {
+				t.Error("composed content does not round-trip")
+			}
+			if stats.Rows != 2000 {
+				t.Errorf("Rows = %d, want 2000", stats.Rows)
+			}
+			attrs, _ := store.Attrs(context.Background(), "db/t.sql.gz")
+			if attrs.Metadata["key"] != "value" {
+				t.Errorf("metadata = %v", attrs.Metadata)
+			}
+
+			objects, _ := store.List(context.Background(), "")
+			if len(objects) != 1 {
+				t.Errorf("%d objects left, want only the composed one", len(objects))
+			}
+		})
+	}
+}
+
+func TestUploadDeletesPartsOnFailure(t *testing.T) {
+	store := NewMemoryStore()
+	uploader := &Uploader{Store: store, Progress: newProgress(time.Now()), CompositePartSize: 16, CompositeParallelism: 2}
+
+	reader := &failAfterReader{data: strings.Repeat("INSERT INTO t VALUES (1);\n", 1000)}
+	if _, err := uploader.Upload(context.Background(), "db/t.sql.gz", reader); err == nil {
+		t.Fatal("Upload succeeded, want error")
+	}
+
+	if objects, _ := store.List(context.Background(), ""); len(objects) != 0 {
+		t.Errorf("%d objects left after a failed upload", len(objects))
+	}
+}
+
+// failAfterReader returns data and then fails.
+type failAfterReader struct {
+	data string
+}
+
+func (r *failAfterReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, errFake
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
@@ -0,0 +1,171 @@
+package backup
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// TLS modes of a Connection.
+const (
+	// TLSPreferred uses TLS if the server supports it.
+	TLSPreferred = "preferred"
+	// TLSSkipVerify requires TLS without verifying the server certificate.
+	TLSSkipVerify = "skip-verify"
+	// TLSVerify requires TLS with a verified server certificate.
+	TLSVerify = "true"
+)
+
+// Connection holds the MySQL connection settings shared by the planner and
+// the dumper. A Host starting with a slash is the path of a Unix socket.
+type Connection struct {
+	User     string
+	Password string
+	Host     string
+	Port     string
+	// TLS is one of the TLS* modes; empty disables TLS for driver
+	// connections and leaves the client default for mysql and mysqldump.
+	TLS string
+	// Charset is the character set of connections and dumps; it defaults to
+	// DefaultCharset.
+	Charset string
+	// SessionVariables are set on every driver connection, i.e. those of
+	// the planner and of NativeDumper, but not by mysqldump. Values other
+	// than numbers and DEFAULT are quoted unless they are quoted already.
+	SessionVariables map[string]string
+	// ConnectTimeout bounds establishing connections, by the driver as well
+	// as by mysql and mysqldump; it defaults to DefaultConnectTimeout.
+	ConnectTimeout time.Duration
+}
+
+// DefaultConnectTimeout is the ConnectTimeout of connections unless it is
+// set.
+const DefaultConnectTimeout = 10 * time.Second
+
+func (c Connection) connectTimeout() time.Duration {
+	if c.ConnectTimeout <= 0 {
+		return DefaultConnectTimeout
+	}
+	return c.ConnectTimeout
+}
+
+// DefaultCharset is the character set used unless Connection.Charset is set.
+const DefaultCharset = "utf8mb4"
+
+func (c Connection) charset() string {
+	if c.Charset == "" {
+		return DefaultCharset
+	}
+	return c.Charset
+}
+
+func (c Connection) socket() bool {
+	return strings.HasPrefix(c.Host, "/")
+}
+
+func (c Connection) args() []string {
+	args := []string{
+		"--user=" + c.User,
+		"--password=" + c.Password,
+	}
+
+	if c.socket() {
+		args = append(args, "--socket="+c.Host)
+	} else {
+		args = append(args, "--host="+c.Host, "--port="+c.Port)
+	}
+	// mysql and mysqldump take whole seconds.
+	seconds := (c.connectTimeout() + time.Second - 1) / time.Second
+	args = append(args, "--connect-timeout="+strconv.FormatInt(int64(seconds), 10))
+
+	switch c.TLS {
+	case TLSPreferred:
+		args = append(args, "--ssl-mode=PREFERRED")
+	case TLSSkipVerify:
+		args = append(args, "--ssl-mode=REQUIRED")
+	case TLSVerify:
+		args = append(args, "--ssl-mode=VERIFY_IDENTITY")
+	}
+
+	return args
+}
+
+func (c Connection) dsn() string {
+	cfg := mysql.NewConfig()
+	cfg.User = c.User
+	cfg.Passwd = c.Password
+	if c.socket() {
+		cfg.Net = "unix"
+		cfg.Addr = c.Host
+	} else {
+		cfg.Net = "tcp"
+		cfg.Addr = net.JoinHostPort(c.Host, c.Port)
+	}
+	cfg.Timeout = c.connectTimeout()
+	cfg.Params = map[string]string{"charset": c.charset()}
+	for name, value := range c.SessionVariables {
+		cfg.Params[name] = sessionValue(value)
+	}
+	if c.TLS != "" {
+		cfg.Params["tls"] = c.TLS
+	}
+	return cfg.FormatDSN()
+}
+
+// sessionValue returns value as a SQL literal for SET.
+func sessionValue(value string) string {
+	if _, err := strconv.ParseFloat(value, 64); err == nil || strings.EqualFold(value, "DEFAULT") || strings.HasPrefix(value, "'") {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// sessionVariableName matches the lower-case snake_case names of server
+// variables. The driver's own parameters, such as allowAllFiles or
+// multiStatements, are camelCase, so they never match.
+var sessionVariableName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// ValidateSessionVariables checks that session variable names are plain
+// lower-case identifiers that the driver does not take as its own
+// parameters.
+func ValidateSessionVariables(variables map[string]string) error {
+	for name := range variables {
+		if !sessionVariableName.MatchString(name) {
+			return fmt.Errorf("invalid session variable name %q, server variables are lower-case snake_case", name)
+		}
+		switch name {
+		case "charset", "collation", "compress", "loc", "strict", "timeout", "tls":
+			return fmt.Errorf("session variable %q clashes with a driver parameter", name)
+		}
+	}
+	return nil
+}
+
+// lazyDB opens a connection pool to the server on first use.
+type lazyDB struct {
+	once sync.Once
+	db   *sql.DB
+	err  error
+}
+
+func (l *lazyDB) get(c Connection) (*sql.DB, error) {
+	l.once.Do(func() {
+		l.db, l.err = sql.Open("mysql", c.dsn())
+	})
+	return l.db, l.err
+}
+
+// Close closes the connections opened so far.
+func (l *lazyDB) Close() error {
+	if l.db == nil {
+		return nil
+	}
+	return l.db.Close()
+}
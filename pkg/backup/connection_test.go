@@ -0,0 +1,70 @@
+package backup
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestConnectionArgs(t *testing.T) {
+	tcp := Connection{User: "u", Password: "p", Host: "db", Port: "3307", TLS: TLSVerify}
+	if got, want := strings.Join(tcp.args(), " "), "--user=u --password=p --host=db --port=3307 --connect-timeout=10 --ssl-mode=VERIFY_IDENTITY"; got != want {
+		t.Errorf("args = %s, want %s", got, want)
+	}
+
+	socket := Connection{User: "u", Password: "p", Host: "/run/mysqld/mysqld.sock", Port: "3306", ConnectTimeout: 1500 * time.Millisecond}
+	if got, want := strings.Join(socket.args(), " "), "--user=u --password=p --socket=/run/mysqld/mysqld.sock --connect-timeout=2"; got != want {
+		t.Errorf("args = %s, want %s", got, want)
+	}
+}
+
+func TestConnectionDSN(t *testing.T) {
+	cfg, err := mysql.ParseDSN(Connection{User: "u", Password: "p@ss", Host: "db", Port: "3307", TLS: TLSSkipVerify}.dsn())
+	if err != nil {
+		t.Fatalf("ParseDSN failed: %v", err)
+	}
+	if cfg.Net != "tcp" || cfg.Addr != "db:3307" || cfg.Passwd != "p@ss" || cfg.TLSConfig != TLSSkipVerify || cfg.Timeout != DefaultConnectTimeout {
+		t.Errorf("dsn parsed as %s %s %s %s %s", cfg.Net, cfg.Addr, cfg.Passwd, cfg.TLSConfig, cfg.Timeout)
+	}
+
+	cfg, err = mysql.ParseDSN(Connection{User: "u", Host: "/run/mysqld/mysqld.sock"}.dsn())
+	if err != nil {
+		t.Fatalf("ParseDSN failed: %v", err)
+	}
+	if cfg.Net != "unix" || cfg.Addr != "/run/mysqld/mysqld.sock" {
+		t.Errorf("dsn parsed as %s %s", cfg.Net, cfg.Addr)
+	}
+
+	cfg, err = mysql.ParseDSN(Connection{User: "u", Host: "db", Port: "3306", SessionVariables: map[string]string{
+		"max_execution_time":    "0",
+		"transaction_isolation": "READ-COMMITTED",
+		"sql_mode":              "'ANSI'",
+	}}.dsn())
+	if err != nil {
+		t.Fatalf("ParseDSN failed: %v", err)
+	}
+	for name, want := range map[string]string{"max_execution_time": "0", "transaction_isolation": "'READ-COMMITTED'", "sql_mode": "'ANSI'"} {
+		if got := cfg.Params[name]; got != want {
+			t.Errorf("session variable %s = %q, want %q", name, got, want)
+		}
+	}
+
+	for charset, want := range map[string]string{"": "charset=utf8mb4", "latin1": "charset=latin1"} {
+		if dsn := (Connection{User: "u", Host: "db", Port: "3306", Charset: charset}).dsn(); !strings.Contains(dsn, want) {
+			t.Errorf("dsn with charset %q = %s, want %s", charset, dsn, want)
+		}
+	}
+}
+
+func TestValidateSessionVariables(t *testing.T) {
+	if err := ValidateSessionVariables(map[string]string{"net_read_timeout": "600", "max_execution_time": "0"}); err != nil {
+		t.Errorf("ValidateSessionVariables failed: %v", err)
+	}
+	for _, name := range []string{"timeout", "charset", "a=b", "@@x", "", "allowAllFiles", "multiStatements", "interpolateParams", "readTimeout", "writeTimeout", "maxAllowedPacket", "parseTime", "clientFoundRows", "allowCleartextPasswords", "ALLOWALLFILES"} {
+		if err := ValidateSessionVariables(map[string]string{name: "1"}); err == nil {
+			t.Errorf("ValidateSessionVariables accepted %q", name)
+		}
+	}
+}
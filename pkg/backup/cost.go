@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// List prices in USD per GiB-month for a single region; override with
+// -storagePricePerGiB for multi-region buckets or negotiated pricing.
+var storagePricesPerGiB = map[string]float64{
+	"STANDARD": 0.020,
+	"NEARLINE": 0.010,
+	"COLDLINE": 0.004,
+	"ARCHIVE":  0.0012,
+}
+
+// CostEstimate is the estimated monthly storage cost of a run and of all
+// backups under its host prefix.
+type CostEstimate struct {
+	StorageClass     string  `json:"storageClass"`
+	PricePerGiBMonth float64 `json:"pricePerGiBMonth"`
+	RunBytes         int64   `json:"runBytes"`
+	RunMonthlyUSD    float64 `json:"runMonthlyUSD"`
+	Prefix           string  `json:"prefix"`
+	PrefixBytes      int64   `json:"prefixBytes"`
+	PrefixMonthlyUSD float64 `json:"prefixMonthlyUSD"`
+}
+
+func estimateCost(ctx context.Context, store ObjectStore, storageClass string, pricePerGiB float64, prefix string, runBytes int64) (*CostEstimate, error) {
+	if gcs, ok := store.(*GCSStore); ok && storageClass == "" {
+		attrs, err := gcs.Bucket.Attrs(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve bucket attributes: %w", err)
+		}
+		storageClass = attrs.StorageClass
+	}
+	storageClass = strings.ToUpper(storageClass)
+	if storageClass == "" || storageClass == "MULTI_REGIONAL" || storageClass == "REGIONAL" {
+		storageClass = "STANDARD"
+	}
+
+	if pricePerGiB <= 0 {
+		price, ok := storagePricesPerGiB[storageClass]
+		if !ok {
+			return nil, fmt.Errorf("no price known for storage class %s, set -storagePricePerGiB", storageClass)
+		}
+		pricePerGiB = price
+	}
+
+	objects, err := store.List(ctx, prefix+"/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects under %s: %w", prefix, err)
+	}
+
+	var prefixBytes int64
+	for _, object := range objects {
+		prefixBytes += object.Size
+	}
+
+	return &CostEstimate{
+		StorageClass:     storageClass,
+		PricePerGiBMonth: pricePerGiB,
+		RunBytes:         runBytes,
+		RunMonthlyUSD:    monthlyCost(runBytes, pricePerGiB),
+		Prefix:           prefix,
+		PrefixBytes:      prefixBytes,
+		PrefixMonthlyUSD: monthlyCost(prefixBytes, pricePerGiB),
+	}, nil
+}
+
+func monthlyCost(bytes int64, pricePerGiB float64) float64 {
+	return float64(bytes) / (1 << 30) * pricePerGiB
+}
+
+func (c *CostEstimate) log() {
+	log.Printf("Estimated storage cost (%s, $%.4f/GiB-month): this run %s ≈ $%.2f/month, %s/ total %s ≈ $%.2f/month\n",
+		c.StorageClass, c.PricePerGiBMonth, FormatBytes(c.RunBytes), c.RunMonthlyUSD,
+		c.Prefix, FormatBytes(c.PrefixBytes), c.PrefixMonthlyUSD)
+}
@@ -0,0 +1,248 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+// DedupChunkPrefix is the directory of a host holding the content-defined
+// chunks of deduplicated dumps, see Config.Deduplicate.
+const DedupChunkPrefix = "chunks"
+
+// dedupIndexSuffix is the suffix of the index listing the chunks of a
+// deduplicated dump, which takes the place of its .sql.gz object.
+const dedupIndexSuffix = ".dedup.json"
+
+// Chunk boundaries are where the gear hash of the bytes read so far has its
+// top dedupChunkBits bits clear, which happens on average every 1 MiB, but
+// chunks are at least minDedupChunk and at most maxDedupChunk bytes.
+const (
+	dedupChunkBits = 20
+	minDedupChunk  = 256 << 10
+	maxDedupChunk  = 4 << 20
+)
+
+// gearTable maps bytes to the random values of the gear hash, derived from
+// SHA-256 so that chunk boundaries never change between releases.
+var gearTable = func() (table [256]uint64) {
+	for i := range table {
+		sum := sha256.Sum256([]byte{byte(i)})
+		table[i] = binary.LittleEndian.Uint64(sum[:8])
+	}
+	return table
+}()
+
+// dedupIndex lists the chunks of a deduplicated dump in order. Each chunk is
+// gzip-compressed on its own, so that their concatenation is the gzip
+// stream of the whole dump.
+type dedupIndex struct {
+	Chunks []dedupChunk `json:"chunks"`
+}
+
+type dedupChunk struct {
+	// SHA256 is the hex SHA-256 of the uncompressed content of the chunk,
+	// Size its length and CompressedBytes the size of its object.
+	SHA256          string `json:"sha256"`
+	Size            int64  `json:"size"`
+	CompressedBytes int64  `json:"compressedBytes"`
+}
+
+func dedupChunkObject(host string, sum string) string {
+	return fmt.Sprintf("%s/%s/%s.gz", host, DedupChunkPrefix, sum)
+}
+
+// dedupIndexName returns the name of the index of a deduplicated dump in
+// place of its .sql.gz object.
+func dedupIndexName(object string) string {
+	return strings.TrimSuffix(object, tableObjectSuffix) + dedupIndexSuffix
+}
+
+// chunkSplitter cuts a stream into content-defined chunks, so that an
+// insertion or deletion only changes the chunks around it.
+type chunkSplitter struct {
+	reader *bufio.Reader
+	buf    []byte
+}
+
+func newChunkSplitter(r io.Reader) *chunkSplitter {
+	return &chunkSplitter{reader: bufio.NewReaderSize(r, chunkSize)}
+}
+
+// next returns the next chunk, valid until the following call, or io.EOF
+// at the end of the stream.
+func (s *chunkSplitter) next() ([]byte, error) {
+	s.buf = s.buf[:0]
+	var hash uint64
+	for len(s.buf) < maxDedupChunk {
+		b, err := s.reader.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.buf = append(s.buf, b)
+		hash = hash<<1 + gearTable[b]
+		if len(s.buf) >= minDedupChunk && hash>>(64-dedupChunkBits) == 0 {
+			break
+		}
+	}
+	if len(s.buf) == 0 {
+		return nil, io.EOF
+	}
+	return s.buf, nil
+}
+
+// uploadDeduplicated writes the chunks of reader that the store does not
+// have yet under the DedupChunkPrefix of the host of name, followed by the
+// index of the dump as name. CompressedBytes counts all the chunks of the
+// dump and ReusedBytes those that were already stored.
+func (u *Uploader) uploadDeduplicated(ctx context.Context, name string, reader io.Reader) (UploadStats, error) {
+	var uncompressed atomic.Int64
+	var rows rowCounter
+	var stats UploadStats
+	host, _, _ := strings.Cut(name, "/")
+
+	source := io.TeeReader(&countingReader{reader: reader, counts: []*atomic.Int64{&uncompressed, &u.Progress.bytesRead}}, &rows)
+	splitter := newChunkSplitter(source)
+	var index dedupIndex
+	for {
+		// An empty dump is stored as a single empty chunk, which still
+		// decompresses as a valid gzip stream.
+		chunk, err := splitter.next()
+		last := err == io.EOF
+		if last && len(index.Chunks) > 0 {
+			break
+		}
+		if err != nil && !last {
+			return stats, fmt.Errorf("failed to upload %s: %w", name, err)
+		}
+
+		sum := sha256.Sum256(chunk)
+		entry := dedupChunk{SHA256: hex.EncodeToString(sum[:]), Size: int64(len(chunk))}
+		reused, compressed, err := u.uploadChunk(ctx, dedupChunkObject(host, entry.SHA256), chunk)
+		if err != nil {
+			return stats, err
+		}
+		entry.CompressedBytes = compressed
+		stats.CompressedBytes += compressed
+		if reused {
+			stats.ReusedBytes += compressed
+		}
+		index.Chunks = append(index.Chunks, entry)
+		if last {
+			break
+		}
+	}
+	stats.UncompressedBytes = uncompressed.Load()
+	stats.Rows = rows.rows
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return stats, fmt.Errorf("failed to encode index of %s: %w", name, err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	writer := newHashingWriter(u.Store.NewWriter(ctx, name, "application/json", u.metadata()))
+	if _, err := writer.Write(data); err != nil {
+		return stats, fmt.Errorf("failed to write object %s: %w", name, err)
+	}
+	if err := writer.Close(); err != nil {
+		return stats, fmt.Errorf("failed to close writer for object %s: %w", name, err)
+	}
+
+	hashes := writer.sum()
+	attrs, err := u.verify(ctx, name, hashes)
+	if err != nil {
+		return stats, err
+	}
+	stats.CRC32C = hashes.CRC32C
+	stats.MD5 = hashes.MD5
+	stats.SHA256 = hashes.SHA256
+	stats.Generation = attrs.Generation
+	stats.Etag = attrs.Etag
+	stats.Buckets = writer.Buckets()
+	return stats, nil
+}
+
+// uploadChunk gzip-compresses a chunk into object unless it already exists,
+// returning whether it did and the size of the object.
+func (u *Uploader) uploadChunk(ctx context.Context, object string, chunk []byte) (bool, int64, error) {
+	attrs, err := u.Store.Attrs(ctx, object)
+	if err == nil {
+		return true, attrs.Size, nil
+	}
+	if !errors.Is(err, ErrObjectNotExist) {
+		return false, 0, fmt.Errorf("failed to retrieve attributes for %s: %w", object, err)
+	}
+
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	if _, err := gzipWriter.Write(chunk); err != nil {
+		return false, 0, fmt.Errorf("failed to compress %s: %w", object, err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return false, 0, fmt.Errorf("failed to close gzipWriter: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	writer := newHashingWriter(u.Store.NewWriter(ctx, object, "", objectMetadata()))
+	counted := &countingWriter{writer: writer, counts: []*atomic.Int64{&u.Progress.bytesUploaded}}
+	if _, err := counted.Write(compressed.Bytes()); err != nil {
+		return false, 0, fmt.Errorf("failed to write object %s: %w", object, err)
+	}
+	if err := writer.Close(); err != nil {
+		return false, 0, fmt.Errorf("failed to close writer for object %s: %w", object, err)
+	}
+	if _, err := u.verify(ctx, object, writer.sum()); err != nil {
+		return false, 0, err
+	}
+	return false, int64(compressed.Len()), nil
+}
+
+// loadDedupIndex reads the index of a deduplicated dump.
+func loadDedupIndex(ctx context.Context, store ObjectStore, name string) (*dedupIndex, error) {
+	data, err := readObject(ctx, store, name)
+	if err != nil {
+		return nil, err
+	}
+	var index dedupIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return &index, nil
+}
+
+// openDeduplicated opens the chunks of a deduplicated dump in order.
+func openDeduplicated(ctx context.Context, store ObjectStore, name string) (io.ReadCloser, error) {
+	index, err := loadDedupIndex(ctx, store, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(index.Chunks) == 0 {
+		return nil, fmt.Errorf("index %s lists no chunks", name)
+	}
+
+	host, _, _ := strings.Cut(name, "/")
+	var chunks []string
+	for _, chunk := range index.Chunks {
+		chunks = append(chunks, dedupChunkObject(host, chunk.SHA256))
+	}
+	reader, err := store.NewReader(ctx, chunks[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", chunks[0], err)
+	}
+	return &partsReader{ctx: ctx, store: store, current: reader, parts: chunks[1:]}, nil
+}
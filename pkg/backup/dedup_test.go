@@ -0,0 +1,208 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func splitChunks(t *testing.T, data []byte) [][32]byte {
+	t.Helper()
+
+	var sums [][32]byte
+	splitter := newChunkSplitter(bytes.NewReader(data))
+	for {
+		chunk, err := splitter.next()
+		if err == io.EOF {
+			return sums
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chunk) > maxDedupChunk {
+			t.Fatalf("chunk of %d bytes, want at most %d", len(chunk), maxDedupChunk)
+		}
+		sums = append(sums, sha256.Sum256(chunk))
+	}
+}
+
+func TestChunkSplitterIsContentDefined(t *testing.T) {
+	data := make([]byte, 16<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	edited := append(append(append([]byte(nil), data[:1000]...), "INSERT INTO `new` VALUES (1);\n"...), data[1000:]...)
+
+	before, after := splitChunks(t, data), splitChunks(t, edited)
+	if len(before) < 4 {
+		t.Fatalf("got %d chunks of 16 MiB, want several", len(before))
+	}
+	shared := map[[32]byte]bool{}
+	for _, sum := range before {
+		shared[sum] = true
+	}
+	changed := 0
+	for _, sum := range after {
+		if !shared[sum] {
+			changed++
+		}
+	}
+	// The chunk of the insertion changes, and the next one if the first
+	// was cut at maxDedupChunk.
+	if changed < 1 || changed > 2 {
+		t.Errorf("%d of %d chunks changed after an insertion, want 1 or 2", changed, len(after))
+	}
+}
+
+func TestRunDeduplicatesUnchangedChunks(t *testing.T) {
+	var dump strings.Builder
+	random := rand.New(rand.NewSource(1))
+	for i := 0; dump.Len() < 3<<20; i++ {
+		fmt.Fprintf(&dump, "INSERT INTO `events` VALUES (%d,'%x');\n", i, random.Int63())
+	}
+	store := NewMemoryStore()
+	planner := &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"events", "empty"}}}
+	dumper := &fakeDumper{dumps: map[string]string{"shop.events": dump.String()}}
+	cfg := testConfig(store, planner, dumper)
+	cfg.Deduplicate = true
+	cfg.started = time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	first, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("first Run() error = %v", err)
+	}
+	cfg.started = cfg.started.Add(24 * time.Hour)
+	second, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("second Run() error = %v", err)
+	}
+
+	for _, table := range second.Tables {
+		if !strings.HasSuffix(table.Object, dedupIndexSuffix) {
+			t.Errorf("object = %s, want a %s index", table.Object, dedupIndexSuffix)
+		}
+		if table.ReusedBytes != table.CompressedBytes {
+			t.Errorf("table %s reused %d of %d bytes, want all of them", table.Table, table.ReusedBytes, table.CompressedBytes)
+		}
+	}
+	if first.CompressedBytes != second.CompressedBytes {
+		t.Errorf("CompressedBytes = %d, then %d, want the same", first.CompressedBytes, second.CompressedBytes)
+	}
+
+	for table, want := range map[string]string{"events": dump.String(), "empty": ""} {
+		object, err := FindTableObject(context.Background(), store, "host", "", "shop", table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if _, err := Download(context.Background(), store, object.Name(), &buf); err != nil {
+			t.Fatalf("Download(%s) error = %v", object.Name(), err)
+		}
+		if buf.String() != want {
+			t.Errorf("Download(%s) = %d bytes, want %d", object.Name(), buf.Len(), len(want))
+		}
+	}
+}
+
+func TestCollectGarbageDeletesUnreferencedChunks(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"orders"}}}
+	dumper := &fakeDumper{dumps: map[string]string{"shop.orders": ordersDump}}
+	cfg := testConfig(store, planner, dumper)
+	cfg.Deduplicate = true
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	stray := dedupChunkObject("host", strings.Repeat("0", 64))
+	putGzipObject(t, store, stray, "data")
+
+	garbage, err := CollectGarbage(context.Background(), GCConfig{Store: store, Host: "host", Before: time.Now().Add(time.Hour), DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(garbage) != 1 || garbage[0].Name != stray || garbage[0].Reason != GarbageChunk {
+		t.Errorf("CollectGarbage() = %+v, want only %s", garbage, stray)
+	}
+}
+
+// markingStore starts a deduplicated run, writing its marker, on the first
+// deletion.
+type markingStore struct {
+	*MemoryStore
+	t      *testing.T
+	marker string
+}
+
+func (s *markingStore) Delete(ctx context.Context, name string) error {
+	if s.marker != "" {
+		putGzipObject(s.t, s.MemoryStore, s.marker, "")
+		s.marker = ""
+	}
+	return s.MemoryStore.Delete(ctx, name)
+}
+
+func TestCollectGarbageKeepsChunksWhileDeduplicating(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"orders"}}}
+	dumper := &fakeDumper{dumps: map[string]string{"shop.orders": ordersDump}}
+	cfg := testConfig(store, planner, dumper)
+	cfg.Deduplicate = true
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if markers, _ := store.List(context.Background(), "host/"+RunningPrefix+"/"); len(markers) != 0 {
+		t.Fatalf("markers after the run = %+v, want none", markers)
+	}
+
+	stray := dedupChunkObject("host", strings.Repeat("0", 64))
+	putGzipObject(t, store, stray, "data")
+	orphan := "host/2024-01-01/shop/orders.sql.gz"
+	putGzipObject(t, store, orphan, "data")
+	time.Sleep(time.Millisecond)
+	cutoff := time.Now()
+	time.Sleep(time.Millisecond)
+
+	// A run started after the listing keeps the chunks.
+	marking := &markingStore{MemoryStore: store, t: t, marker: runningMarker("host", "started")}
+	garbage, err := CollectGarbage(context.Background(), GCConfig{Store: marking, Host: "host", Before: cutoff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(garbage) != 1 || garbage[0].Name != orphan {
+		t.Errorf("CollectGarbage() = %+v, want only %s", garbage, orphan)
+	}
+	if _, ok := store.Data(stray); !ok {
+		t.Errorf("%s deleted while a deduplicated run is in progress", stray)
+	}
+
+	// So does one in progress beforehand.
+	garbage, err = CollectGarbage(context.Background(), GCConfig{Store: store, Host: "host", Before: cutoff, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(garbage) != 0 {
+		t.Errorf("CollectGarbage() = %+v while a deduplicated run is in progress, want nothing", garbage)
+	}
+
+	// The marker of an interrupted run goes once it is older than the cutoff.
+	garbage, err = CollectGarbage(context.Background(), GCConfig{Store: store, Host: "host", Before: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []GarbageObject{
+		{Name: runningMarker("host", "started"), Reason: GarbageRunMarker},
+		{Name: stray, Reason: GarbageChunk},
+	}
+	if len(garbage) != len(want) {
+		t.Fatalf("CollectGarbage() = %+v, want %+v", garbage, want)
+	}
+	for i := range want {
+		if garbage[i].Name != want[i].Name || garbage[i].Reason != want[i].Reason {
+			t.Errorf("CollectGarbage()[%d] = %+v, want %+v", i, garbage[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DictionaryPrefix is the directory of a host holding the zstd dictionaries
+// its small tables are compressed with, see Config.DictionaryTableSize.
+const DictionaryPrefix = "dictionaries"
+
+// zstdObjectSuffix is the suffix of dumps compressed with a dictionary
+// instead of gzip.
+const zstdObjectSuffix = ".sql.zst"
+
+const dictionarySuffix = ".zdict"
+
+// Limits of dictionary training: the first dictionarySampleSize bytes of at
+// most maxDictionarySamples dumps are sampled, fewer than
+// minDictionarySamples are not worth a dictionary, and dictionaries hold at
+// most dictionarySize bytes of history.
+const (
+	dictionarySampleSize = 32 * 1024
+	maxDictionarySamples = 1000
+	minDictionarySamples = 8
+	dictionarySize       = 112 * 1024
+)
+
+// dictionary is a zstd dictionary stored as object.
+type dictionary struct {
+	object string
+	data   []byte
+}
+
+func dictionaryObject(host string, id uint32) string {
+	return fmt.Sprintf("%s/%s/%d%s", host, DictionaryPrefix, id, dictionarySuffix)
+}
+
+// dictionaryID derives the ID of a dictionary trained by a run from its
+// start, within the range zstd leaves to private dictionaries.
+func dictionaryID(started time.Time) uint32 {
+	const first, last = 1 << 15, 1<<31 - 1
+	return first + uint32(started.Unix()%(last-first))
+}
+
+// loadDictionary returns the latest dictionary of host, or nil if it has
+// none yet.
+func loadDictionary(ctx context.Context, store ObjectStore, host string) (*dictionary, error) {
+	prefix := fmt.Sprintf("%s/%s/", host, DictionaryPrefix)
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects under %s: %w", prefix, err)
+	}
+
+	var latest *ObjectAttrs
+	for i := range objects {
+		if !strings.HasSuffix(objects[i].Name, dictionarySuffix) {
+			continue
+		}
+		if latest == nil || objects[i].Created.After(latest.Created) {
+			latest = &objects[i]
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+
+	data, err := readObject(ctx, store, latest.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &dictionary{object: latest.Name, data: data}, nil
+}
+
+// dictionarySamples collects the start of the dumps of small tables during
+// a run without a dictionary, to train one on once the run is done.
+type dictionarySamples struct {
+	mu      sync.Mutex
+	samples [][]byte
+}
+
+func (s *dictionarySamples) add(sample []byte) {
+	if len(sample) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.samples) < maxDictionarySamples {
+		s.samples = append(s.samples, sample)
+	}
+}
+
+// train builds a dictionary with the given ID from the samples.
+func (s *dictionarySamples) train(id uint32) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.samples) < minDictionarySamples {
+		return nil, fmt.Errorf("only %d small tables were dumped, at least %d are needed", len(s.samples), minDictionarySamples)
+	}
+
+	return dict.BuildZstdDict(s.samples, dict.Options{
+		MaxDictSize: dictionarySize,
+		HashBytes:   6,
+		ZstdDictID:  id,
+		ZstdLevel:   zstd.SpeedDefault,
+	})
+}
+
+// trainDictionary trains a dictionary for host on samples and stores it.
+func trainDictionary(ctx context.Context, uploader *Uploader, host string, started time.Time, samples *dictionarySamples) (string, error) {
+	id := dictionaryID(started)
+	data, err := samples.train(id)
+	if err != nil {
+		return "", fmt.Errorf("failed to train a zstd dictionary: %w", err)
+	}
+
+	name := dictionaryObject(host, id)
+	if err := uploader.UploadObject(ctx, name, "application/octet-stream", data); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// sampleWriter keeps the first max bytes written to it.
+type sampleWriter struct {
+	data []byte
+	max  int
+}
+
+func (w *sampleWriter) Write(p []byte) (int, error) {
+	if n := w.max - len(w.data); n > 0 {
+		if len(p) < n {
+			n = len(p)
+		}
+		w.data = append(w.data, p[:n]...)
+	}
+	return len(p), nil
+}
+
+// newCompressor returns the writer compressing a dump into w, with zstd and
+// d if it is set and gzip otherwise.
+func newCompressor(w io.Writer, d *dictionary) (io.WriteCloser, error) {
+	if d == nil {
+		return gzip.NewWriter(w), nil
+	}
+	encoder, err := zstd.NewWriter(w, zstd.WithEncoderDict(d.data), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load zstd dictionary %s: %w", d.object, err)
+	}
+	return encoder, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// decompress returns the content of the dump object name read from reader.
+// Dumps of small tables compressed with zstd read the dictionary their
+// frame refers to from the DictionaryPrefix of their host.
+func decompress(ctx context.Context, store ObjectStore, name string, reader io.Reader) (io.ReadCloser, error) {
+	if strings.HasSuffix(name, archiveEntrySuffix) {
+		return io.NopCloser(reader), nil
+	}
+	if !strings.HasSuffix(name, zstdObjectSuffix) {
+		return gzip.NewReader(reader)
+	}
+
+	buffered := bufio.NewReaderSize(reader, chunkSize)
+	var options []zstd.DOption
+	peek, _ := buffered.Peek(zstd.HeaderMaxSize)
+	var header zstd.Header
+	if err := header.Decode(peek); err == nil && header.DictionaryID != 0 {
+		host, _, _ := strings.Cut(name, "/")
+		data, err := readObject(ctx, store, dictionaryObject(host, header.DictionaryID))
+		if err != nil {
+			if errors.Is(err, ErrObjectNotExist) {
+				return nil, fmt.Errorf("zstd dictionary %d of %s is missing: %w", header.DictionaryID, name, err)
+			}
+			return nil, err
+		}
+		options = append(options, zstd.WithDecoderDicts(data))
+	}
+
+	decoder, err := zstd.NewReader(buffered, append(options, zstd.WithDecoderConcurrency(1))...)
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
+
+// dictionaryObjectName returns the name of the dump of a small table
+// compressed with a dictionary rather than gzip.
+func dictionaryObjectName(object string) string {
+	return strings.TrimSuffix(object, tableObjectSuffix) + zstdObjectSuffix
+}
@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunTrainsAndUsesDictionary(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{}}
+	dumper := &fakeDumper{dumps: map[string]string{}}
+	for i := 0; i < 20; i++ {
+		table := fmt.Sprintf("tenant_%02d", i)
+		planner.tables["shop"] = append(planner.tables["shop"], table)
+		dumper.dumps["shop."+table] = fmt.Sprintf("-- MySQL dump 10.13\n"+
+			"CREATE TABLE `%s` (\n  `id` int NOT NULL AUTO_INCREMENT,\n  `name` varchar(255) NOT NULL,\n  `created_at` datetime NOT NULL,\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;\n"+
+			"INSERT INTO `%s` VALUES (%d,'tenant %d','2024-01-01 00:00:00');\n", table, table, i, i)
+	}
+	cfg := testConfig(store, planner, dumper)
+	cfg.DictionaryTableSize = 1 << 20
+	cfg.started = time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("first Run() error = %v", err)
+	}
+	if m.Dictionary != "" {
+		t.Errorf("first run Dictionary = %q, want none", m.Dictionary)
+	}
+	if !strings.HasSuffix(m.Tables[0].Object, tableObjectSuffix) {
+		t.Errorf("first run object = %s, want a gzip dump", m.Tables[0].Object)
+	}
+	dict, err := loadDictionary(context.Background(), store, "host")
+	if err != nil || dict == nil {
+		t.Fatalf("loadDictionary() = %v, %v, want the trained dictionary", dict, err)
+	}
+
+	cfg.started = cfg.started.Add(24 * time.Hour)
+	m, err = Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("second Run() error = %v", err)
+	}
+	if m.Dictionary != dict.object {
+		t.Errorf("second run Dictionary = %q, want %q", m.Dictionary, dict.object)
+	}
+
+	object, err := FindTableObject(context.Background(), store, "host", "", "shop", "tenant_07")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(object.Name(), zstdObjectSuffix) {
+		t.Fatalf("second run object = %s, want a zstd dump", object.Name())
+	}
+	var buf bytes.Buffer
+	if _, err := Download(context.Background(), store, object.Name(), &buf); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if buf.String() != dumper.dumps["shop.tenant_07"] {
+		t.Errorf("Download() = %q, want %q", buf.String(), dumper.dumps["shop.tenant_07"])
+	}
+}
+
+func TestRunSkipsDictionaryWithFewSmallTables(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"orders"}}}
+	dumper := &fakeDumper{dumps: map[string]string{"shop.orders": ordersDump}}
+	cfg := testConfig(store, planner, dumper)
+	cfg.DictionaryTableSize = 1 << 20
+
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	dict, err := loadDictionary(context.Background(), store, "host")
+	if err != nil || dict != nil {
+		t.Errorf("loadDictionary() = %v, %v, want none from a single table", dict, err)
+	}
+}
@@ -0,0 +1,140 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mysqldump names the source server in its header within the first lines.
+const (
+	serverVersionComment = "-- Server version"
+	dumpHeaderLines      = 20
+)
+
+func validateRestore(ctx context.Context, cfg *RestoreConfig, applier Applier, tables []TableObject) ([]RestoreResult, error) {
+	log.Printf("Validating %d table(s) from %s/%s\n", len(tables), cfg.Host, tables[0].Generation)
+
+	targetVersion, err := applier.ServerVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get target server version: %w", err)
+	}
+	log.Printf("Target server version: %s\n", targetVersion)
+
+	results := make([]RestoreResult, len(tables))
+	var errs []error
+
+	for i, table := range tables {
+		result := cfg.target(table)
+		started := time.Now()
+
+		sourceVersion, err := validateTable(ctx, cfg.Store, result.Object)
+		if err == nil && sourceVersion != "" && !serverVersionCompatible(sourceVersion, targetVersion) {
+			err = fmt.Errorf("%s was dumped from server %s, which is newer than the target server %s", result.Object, sourceVersion, targetVersion)
+		}
+		for _, partition := range result.Partitions {
+			if err != nil {
+				break
+			}
+			_, err = validateTable(ctx, cfg.Store, partition)
+		}
+
+		result.Err = err
+		result.Duration = time.Since(started)
+		if result.Err != nil {
+			log.Printf("Validation of table \"%s.%s\" failed: %v\n", result.Database, result.Table, result.Err)
+			errs = append(errs, result.Err)
+		} else {
+			log.Printf("Would restore %s into \"%s.%s\"\n", result.Object, result.TargetDatabase, result.TargetTable)
+		}
+
+		results[i] = result
+	}
+
+	return results, errors.Join(errs...)
+}
+
+// validateTable reads a dump completely, which verifies its checksum,
+// and returns the source server version from the mysqldump header if any.
+func validateTable(ctx context.Context, store ObjectStore, name string) (string, error) {
+	reader, err := openDump(ctx, store, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	decompressed, err := decompress(ctx, store, name, reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress %s: %w", name, err)
+	}
+	defer decompressed.Close()
+
+	dump := bufio.NewReaderSize(decompressed, chunkSize)
+
+	version := ""
+	for i := 0; i < dumpHeaderLines && version == ""; i++ {
+		line, err := dump.ReadString('\n')
+		if strings.HasPrefix(line, serverVersionComment) {
+			version = strings.TrimSpace(strings.TrimPrefix(line, serverVersionComment))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to decompress %s: %w", name, err)
+		}
+	}
+
+	if _, err := io.Copy(io.Discard, dump); err != nil {
+		return "", fmt.Errorf("failed to decompress %s: %w", name, err)
+	}
+
+	return version, nil
+}
+
+// serverVersionCompatible reports whether a dump taken on source can be
+// applied to target, i.e. target is the same or a later major.minor release.
+// Versions that cannot be parsed are assumed compatible.
+func serverVersionCompatible(source string, target string) bool {
+	sourceMajor, sourceMinor, ok := parseServerVersion(source)
+	if !ok {
+		return true
+	}
+	targetMajor, targetMinor, ok := parseServerVersion(target)
+	if !ok {
+		return true
+	}
+
+	if targetMajor != sourceMajor {
+		return targetMajor > sourceMajor
+	}
+	return targetMinor >= sourceMinor
+}
+
+func parseServerVersion(version string) (int, int, bool) {
+	if i := strings.IndexAny(version, "-_ "); i >= 0 {
+		version = version[:i]
+	}
+
+	parts := strings.Split(version, ".")
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return major, minor, true
+}
@@ -0,0 +1,194 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// Dumper produces the SQL dump of a single table. Closing the returned
+// reader releases the dump and reports whether it completed successfully.
+type Dumper interface {
+	Dump(ctx context.Context, database string, table string, opts DumpOptions) (io.ReadCloser, error)
+}
+
+// DumpOptions are the per-table settings of a dump.
+type DumpOptions struct {
+	// LockTables holds a read lock on the table while it is dumped, which a
+	// consistent dump of a non-transactional engine such as MyISAM needs.
+	LockTables bool
+	// NoData dumps only the table definition and its triggers.
+	NoData bool
+	// Partition restricts the dump to the rows of one partition. Only
+	// NativeDumper supports it.
+	Partition string
+	// Range restricts the dump to a range of the primary key. Only
+	// NativeDumper supports it.
+	Range *KeyRange
+	// Routines adds the stored procedures and functions of the database.
+	// Dumping an empty table name with Routines set dumps only them and the
+	// events of the database.
+	Routines bool
+	// SkipTriggers leaves out the triggers of the table.
+	SkipTriggers bool
+	// ExtendedInsert writes multiple rows per INSERT statement, which
+	// restores much faster than one statement per row. NoHexBlob writes
+	// binary columns as strings instead of hexadecimal literals.
+	ExtendedInsert bool
+	NoHexBlob      bool
+	// MysqldumpArgs are passed to mysqldump after the options above, which
+	// they override. Other Dumpers ignore them.
+	MysqldumpArgs []string
+}
+
+// KeyRange is the range of an integer key Column of the rows after After
+// through Through; a nil After starts at the first row and a nil Through
+// ends at the last.
+type KeyRange struct {
+	Column  string
+	After   *KeyValue
+	Through *KeyValue
+}
+
+// Mysqldump dumps tables with the mysqldump binary.
+type Mysqldump struct {
+	Connection Connection
+	// Path is the mysqldump binary, looked up in PATH if empty.
+	Path string
+}
+
+func (d *Mysqldump) binary() string {
+	if d.Path == "" {
+		return "mysqldump"
+	}
+	return d.Path
+}
+
+// Dump starts mysqldump for the table and returns its output. Closing the
+// returned reader waits for mysqldump to exit and reports its failure, or
+// that its output did not end with the "-- Dump completed" trailer.
+func (d *Mysqldump) Dump(ctx context.Context, database string, table string, opts DumpOptions) (io.ReadCloser, error) {
+	if opts.Partition != "" {
+		return nil, fmt.Errorf("mysqldump cannot dump partition %s of table \"%s.%s\"", opts.Partition, database, table)
+	}
+	if opts.Range != nil {
+		return nil, fmt.Errorf("mysqldump cannot dump a key range of table \"%s.%s\"", database, table)
+	}
+
+	lockTables := "--skip-lock-tables"
+	if opts.LockTables {
+		lockTables = "--lock-tables"
+	}
+
+	if table == "" {
+		if !opts.Routines {
+			return nil, fmt.Errorf("nothing to dump for database %s", database)
+		}
+		args := append(d.Connection.args(),
+			"--no-create-info",
+			"--no-data",
+			"--skip-triggers",
+			"--routines",
+			"--events",
+			"--dump-date",
+			"--default-character-set="+d.Connection.charset(),
+			"--",
+			database,
+		)
+		return startMysqldump(ctx, d.binary(), args)
+	}
+
+	triggers := "--triggers"
+	if opts.SkipTriggers {
+		triggers = "--skip-triggers"
+	}
+
+	extendedInsert := "--skip-extended-insert"
+	if opts.ExtendedInsert {
+		extendedInsert = "--extended-insert"
+	}
+
+	args := append(d.Connection.args(),
+		triggers,
+		"--dump-date",
+		"--quick",
+		"--create-options",
+		extendedInsert,
+		"--default-character-set="+d.Connection.charset(),
+		lockTables,
+	)
+	if !opts.NoHexBlob {
+		args = append(args, "--hex-blob")
+	}
+	if opts.Routines {
+		args = append(args, "--routines")
+	}
+	if opts.NoData {
+		args = append(args, "--no-data")
+	}
+	args = append(args, opts.MysqldumpArgs...)
+	// Names after -- are never taken for options, such as those of a
+	// database called --help.
+	args = append(args, "--", database, table)
+
+	return startMysqldump(ctx, d.binary(), args)
+}
+
+func startMysqldump(ctx context.Context, binary string, args []string) (io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, binary, args...)
+
+	output, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe for mysqldump command: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start mysqldump command: %w", err)
+	}
+
+	return &dumpReader{ReadCloser: output, cmd: cmd}, nil
+}
+
+// dumpTrailer starts the last line mysqldump writes, which is missing from
+// the output of a mysqldump that was killed or otherwise stopped early.
+const dumpTrailer = "-- Dump completed"
+
+type dumpReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+	// tail holds the last bytes read, enough for the trailer.
+	tail []byte
+}
+
+func (r *dumpReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.tail = append(r.tail, p[:n]...)
+	if keep := 2*len(dumpTrailer) + 64; len(r.tail) > keep {
+		r.tail = append(r.tail[:0], r.tail[len(r.tail)-keep:]...)
+	}
+	return n, err
+}
+
+func (r *dumpReader) Close() error {
+	// Closing the pipe first makes mysqldump exit instead of blocking on a
+	// full pipe when the upload stopped reading early.
+	r.ReadCloser.Close()
+
+	if err := r.cmd.Wait(); err != nil {
+		return fmt.Errorf("failed to wait for mysqldump command: %w", err)
+	}
+	if !completeDump(r.tail) {
+		return fmt.Errorf("mysqldump output ended without the %q trailer, the dump is incomplete", dumpTrailer)
+	}
+	return nil
+}
+
+// completeDump reports whether the last line of a dump, ignoring trailing
+// blank lines, is the trailer.
+func completeDump(tail []byte) bool {
+	tail = bytes.TrimRight(tail, "\r\n")
+	line := tail[bytes.LastIndexByte(tail, '\n')+1:]
+	return bytes.HasPrefix(line, []byte(dumpTrailer))
+}
@@ -0,0 +1,46 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestDumpReaderRequiresTrailer(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		ok     bool
+	}{
+		{"complete", "INSERT INTO t VALUES (1);\n" + strings.Repeat("-- padding\n", 100) + "-- Dump completed on 2024-03-01 10:00:00\n", true},
+		{"trailing blank line", "INSERT INTO t VALUES (1);\n-- Dump completed\n\n", true},
+		{"truncated", "INSERT INTO t VALUES (1);\nINSERT INTO t VALUES", false},
+		{"trailer not last", "-- Dump completed on 2024-03-01 10:00:00\nINSERT INTO t VALUES (1);\n", false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.CommandContext(context.Background(), "cat")
+			cmd.Stdin = strings.NewReader(tt.output)
+			output, err := cmd.StdoutPipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cmd.Start(); err != nil {
+				t.Fatal(err)
+			}
+
+			reader := &dumpReader{ReadCloser: output, cmd: cmd}
+			data, err := io.ReadAll(reader)
+			if err != nil || string(data) != tt.output {
+				t.Fatalf("read %q, %v, want the output", data, err)
+			}
+			if err := reader.Close(); (err == nil) != tt.ok {
+				t.Errorf("Close() = %v, want success %v", err, tt.ok)
+			}
+		})
+	}
+}
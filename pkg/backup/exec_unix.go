@@ -0,0 +1,25 @@
+//go:build !windows
+
+package backup
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// shellCommand runs command with sh.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	killProcessTree(cmd)
+	return cmd
+}
+
+// killProcessTree starts cmd in its own process group and kills the whole
+// group when its context is done, so that no child of a shell outlives it.
+func killProcessTree(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,65 @@
+//go:build !windows
+
+package backup
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCanceledHookKillsChildren(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	if err := runHook(ctx, "pre-run", "sleep 30 & echo $! > "+pidFile+"; wait", nil); err == nil {
+		t.Fatal("canceled hook succeeded")
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); syscall.Kill(pid, 0) == nil; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatal("child of the canceled hook is still running")
+		}
+	}
+}
+
+func TestMysqldumpAppendsTableArgs(t *testing.T) {
+	binary := filepath.Join(t.TempDir(), "mysqldump")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\nfor arg; do echo \"$arg\"; done\necho '-- Dump completed'\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Mysqldump{Connection: Connection{User: "u", Password: "p", Host: "db", Port: "3306"}, Path: binary}
+	reader, err := d.Dump(context.Background(), "orders", "events", DumpOptions{MysqldumpArgs: []string{"--where=id>1000000", "--skip-triggers"}})
+	if err != nil {
+		t.Fatalf("Dump failed: %v", err)
+	}
+	output, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	args := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if got := strings.Join(args[len(args)-6:], " "); got != "--where=id>1000000 --skip-triggers -- orders events -- Dump completed" {
+		t.Errorf("mysqldump was run with %v, want the table arguments last before the table", args)
+	}
+}
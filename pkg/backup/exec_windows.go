@@ -0,0 +1,31 @@
+package backup
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// shellCommand runs command with cmd.exe. The command line is passed as is,
+// as cmd.exe does not parse the quoting exec applies to arguments.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "cmd.exe")
+	killProcessTree(cmd)
+	cmd.SysProcAttr.CmdLine = `cmd.exe /S /C "` + command + `"`
+	return cmd
+}
+
+// killProcessTree starts cmd in its own process group and kills it and its
+// children with taskkill when its context is done, as Windows has no
+// signal to a process group.
+func killProcessTree(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+	cmd.Cancel = func() error {
+		pid := strconv.Itoa(cmd.Process.Pid)
+		if err := exec.Command("taskkill", "/T", "/F", "/PID", pid).Run(); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+}
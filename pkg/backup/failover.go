@@ -0,0 +1,156 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sort"
+	"sync/atomic"
+)
+
+// FailoverStore writes to Primary until MaxFailures consecutive writes to it
+// have failed, and to Fallback for the rest of its lifetime from then on.
+// Reads try the store currently written to first and fall back to Primary.
+type FailoverStore struct {
+	Primary     ObjectStore
+	Fallback    ObjectStore
+	MaxFailures int
+
+	failures   atomic.Int64
+	failedOver atomic.Bool
+}
+
+// NewFailoverStore returns a FailoverStore switching from primary to
+// fallback after maxFailures consecutive write failures.
+func NewFailoverStore(primary ObjectStore, fallback ObjectStore, maxFailures int) *FailoverStore {
+	if maxFailures < 1 {
+		maxFailures = 1
+	}
+	return &FailoverStore{Primary: primary, Fallback: fallback, MaxFailures: maxFailures}
+}
+
+// FailedOver reports whether writes have switched to the fallback store.
+func (s *FailoverStore) FailedOver() bool {
+	return s.failedOver.Load()
+}
+
+func (s *FailoverStore) writeFailed(err error) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+
+	if s.failures.Add(1) >= int64(s.MaxFailures) && s.failedOver.CompareAndSwap(false, true) {
+		log.Printf("Writes to the primary bucket failed %d times in a row, failing over to the fallback bucket: %v\n", s.MaxFailures, err)
+	}
+}
+
+func (s *FailoverStore) NewWriter(ctx context.Context, name string, contentType string, metadata map[string]string) ObjectWriter {
+	if s.FailedOver() {
+		return s.Fallback.NewWriter(ctx, name, contentType, metadata)
+	}
+	return &failoverWriter{store: s, writer: s.Primary.NewWriter(ctx, name, contentType, metadata)}
+}
+
+func (s *FailoverStore) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	if s.FailedOver() {
+		reader, err := s.Fallback.NewReader(ctx, name)
+		if !errors.Is(err, ErrObjectNotExist) {
+			return reader, err
+		}
+	}
+	return s.Primary.NewReader(ctx, name)
+}
+
+func (s *FailoverStore) Attrs(ctx context.Context, name string) (*ObjectAttrs, error) {
+	if s.FailedOver() {
+		attrs, err := s.Fallback.Attrs(ctx, name)
+		if !errors.Is(err, ErrObjectNotExist) {
+			return attrs, err
+		}
+	}
+	return s.Primary.Attrs(ctx, name)
+}
+
+// List returns the objects of Primary, merged with those of Fallback once
+// failed over; objects present in both are taken from Fallback.
+func (s *FailoverStore) List(ctx context.Context, prefix string) ([]ObjectAttrs, error) {
+	objects, err := s.Primary.List(ctx, prefix)
+	if !s.FailedOver() {
+		return objects, err
+	}
+	if err != nil {
+		log.Printf("Failed to list the primary bucket, listing the fallback bucket only: %v\n", err)
+		objects = nil
+	}
+
+	fallback, err := s.Fallback.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := map[string]ObjectAttrs{}
+	for _, attrs := range objects {
+		merged[attrs.Name] = attrs
+	}
+	for _, attrs := range fallback {
+		merged[attrs.Name] = attrs
+	}
+
+	objects = objects[:0]
+	for _, attrs := range merged {
+		objects = append(objects, attrs)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Name < objects[j].Name
+	})
+
+	return objects, nil
+}
+
+func (s *FailoverStore) Delete(ctx context.Context, name string) error {
+	err := s.Primary.Delete(ctx, name)
+	if !s.FailedOver() {
+		return err
+	}
+
+	fallbackErr := s.Fallback.Delete(ctx, name)
+	if errors.Is(err, ErrObjectNotExist) {
+		return fallbackErr
+	}
+	if errors.Is(fallbackErr, ErrObjectNotExist) {
+		return err
+	}
+	return errors.Join(err, fallbackErr)
+}
+
+type failoverWriter struct {
+	store  *FailoverStore
+	writer ObjectWriter
+	failed bool
+}
+
+func (w *failoverWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	if err != nil && !w.failed {
+		w.failed = true
+		w.store.writeFailed(err)
+	}
+	return n, err
+}
+
+func (w *failoverWriter) Close() error {
+	err := w.writer.Close()
+	if err != nil && !w.failed {
+		w.failed = true
+		w.store.writeFailed(err)
+	}
+	if !w.failed {
+		w.store.failures.Store(0)
+	}
+	return err
+}
+
+func (w *failoverWriter) Buckets() []string {
+	return writerBuckets(w.writer)
+}
@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// brokenStore is a MemoryStore whose writes always fail.
+type brokenStore struct {
+	*MemoryStore
+}
+
+func (s brokenStore) NewWriter(ctx context.Context, name string, contentType string, metadata map[string]string) ObjectWriter {
+	return brokenWriter{}
+}
+
+type brokenWriter struct{}
+
+func (brokenWriter) Write(p []byte) (int, error) {
+	return 0, errFake
+}
+
+func (brokenWriter) Close() error {
+	return errFake
+}
+
+func TestFailoverStoreSwitchesAfterRepeatedFailures(t *testing.T) {
+	ctx := context.Background()
+	primary := brokenStore{NewMemoryStore()}
+	fallback := NewMemoryStore()
+	store := NewFailoverStore(primary, fallback, 2)
+	uploader := &Uploader{Store: store}
+
+	for i := 0; i < 2; i++ {
+		if err := uploader.UploadObject(ctx, "a", "", []byte("a")); err == nil {
+			t.Fatalf("write %d to the broken primary succeeded", i)
+		}
+	}
+	if !store.FailedOver() {
+		t.Fatalf("store did not fail over after 2 failures")
+	}
+
+	if err := uploader.UploadObject(ctx, "a", "", []byte("a")); err != nil {
+		t.Fatalf("write after failover failed: %v", err)
+	}
+	if _, ok := fallback.Data("a"); !ok {
+		t.Errorf("object was not written to the fallback store")
+	}
+	if _, err := store.Attrs(ctx, "a"); err != nil {
+		t.Errorf("Attrs after failover: %v", err)
+	}
+}
+
+func TestRunRetriesTableOnFallback(t *testing.T) {
+	fallback := NewMemoryStore()
+	store := NewFailoverStore(brokenStore{NewMemoryStore()}, fallback, 1)
+
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders"}},
+	}
+	dumper := &fakeDumper{dumps: map[string]string{"shop.orders": "INSERT INTO orders VALUES (1);\n"}}
+
+	cfg := testConfig(NewMemoryStore(), planner, dumper)
+	cfg.Store = store
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if got := strings.Join(dumper.dumped, ","); got != "shop.orders,shop.orders" {
+		t.Errorf("dumped %s, want the table dumped twice", got)
+	}
+	if got := readGzipObject(t, fallback, m.Path+"/shop/orders.sql.gz"); got != dumper.dumps["shop.orders"] {
+		t.Errorf("fallback dump = %q", got)
+	}
+	if _, ok := fallback.Data(m.Path + "/manifest.json"); !ok {
+		t.Errorf("manifest was not written to the fallback store")
+	}
+}
@@ -0,0 +1,136 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+type fakePlanner struct {
+	mu           sync.Mutex
+	databases    []string
+	tables       map[string][]string
+	databasesErr error
+	tablesErr    map[string]error
+	engines      map[string]string
+	types        map[string]string
+	collations   map[string]string
+	rows         map[string]int64
+	probeErr     map[string]error
+	partitions   map[string][]string
+	sizes        map[string]DatabaseSize
+	execErr      error
+	executed     []string
+}
+
+func (p *fakePlanner) Databases() ([]string, error) {
+	return p.databases, p.databasesErr
+}
+
+func (p *fakePlanner) Tables(database string) ([]TableInfo, error) {
+	if err := p.tablesErr[database]; err != nil {
+		return nil, err
+	}
+
+	var tables []TableInfo
+	for _, table := range p.tables[database] {
+		tableType := p.types[database+"."+table]
+		if tableType == "" {
+			tableType = TableTypeBase
+		}
+		tables = append(tables, TableInfo{Name: table, Type: tableType, Engine: p.engines[database+"."+table], Collation: p.collations[database+"."+table], ApproximateRows: p.rows[database+"."+table]})
+	}
+	return tables, nil
+}
+
+func (p *fakePlanner) Partitions(database string, table string) ([]string, error) {
+	return p.partitions[database+"."+table], nil
+}
+
+func (p *fakePlanner) Probe(database string, table string) error {
+	return p.probeErr[database+"."+table]
+}
+
+func (p *fakePlanner) DatabaseSizes(databases []string) (map[string]DatabaseSize, error) {
+	return p.sizes, nil
+}
+
+func (p *fakePlanner) Exec(database string, statements []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, statement := range statements {
+		p.executed = append(p.executed, database+": "+statement)
+	}
+	return p.execErr
+}
+
+type fakeDumper struct {
+	mu     sync.Mutex
+	dumps  map[string]string
+	failed map[string]error
+	dumped []string
+	opts   map[string]DumpOptions
+}
+
+func (d *fakeDumper) Dump(ctx context.Context, database string, table string, opts DumpOptions) (io.ReadCloser, error) {
+	key := database + "." + table
+	if opts.Partition != "" {
+		key += "#" + opts.Partition
+	}
+
+	d.mu.Lock()
+	d.dumped = append(d.dumped, key)
+	if d.opts == nil {
+		d.opts = map[string]DumpOptions{}
+	}
+	d.opts[key] = opts
+	d.mu.Unlock()
+
+	return &fakeDump{Reader: strings.NewReader(d.dumps[key]), err: d.failed[key]}, nil
+}
+
+type fakeDump struct {
+	io.Reader
+	err error
+}
+
+func (d *fakeDump) Close() error {
+	return d.err
+}
+
+var errFake = errors.New("fake failure")
+
+type fakeApplier struct {
+	mu      sync.Mutex
+	applied map[string]string
+	failed  map[string]error
+	version string
+	rows    map[string]int64
+}
+
+func (a *fakeApplier) Apply(ctx context.Context, database string, dump io.Reader) error {
+	data, err := io.ReadAll(dump)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.applied == nil {
+		a.applied = map[string]string{}
+	}
+	a.applied[database] += string(data)
+	return a.failed[database]
+}
+
+func (a *fakeApplier) ServerVersion(ctx context.Context) (string, error) {
+	return a.version, nil
+}
+
+func (a *fakeApplier) CountRows(ctx context.Context, database string, table string) (int64, error) {
+	return a.rows[database+"."+table], nil
+}
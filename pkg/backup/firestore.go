@@ -0,0 +1,130 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/api/firestore/v1"
+)
+
+// maxFirestoreWrites is the number of writes Firestore accepts per commit.
+const maxFirestoreWrites = 500
+
+// FirestoreInventory records every run as a document <Collection>/<run ID>
+// and each of its tables as a document in the run's "tables" subcollection,
+// keyed by database.table.
+type FirestoreInventory struct {
+	Service *firestore.Service
+	// Database is projects/<project>/databases/<database>.
+	Database   string
+	Collection string
+}
+
+// NewFirestoreInventory returns a FirestoreInventory writing to collection in
+// the given project's database, "(default)" if empty.
+func NewFirestoreInventory(service *firestore.Service, project string, database string, collection string) *FirestoreInventory {
+	if database == "" {
+		database = "(default)"
+	}
+	return &FirestoreInventory{
+		Service:    service,
+		Database:   fmt.Sprintf("projects/%s/databases/%s", project, database),
+		Collection: collection,
+	}
+}
+
+func (i *FirestoreInventory) Record(ctx context.Context, m *Manifest) error {
+	m.mu.Lock()
+	tables := make([]TableResult, len(m.Tables))
+	copy(tables, m.Tables)
+	m.mu.Unlock()
+
+	run := fmt.Sprintf("%s/documents/%s/%s", i.Database, i.Collection, m.RunID)
+	failed := 0
+	for _, table := range tables {
+		if table.Status == StatusFailed {
+			failed++
+		}
+	}
+
+	writes := []*firestore.Write{{Update: &firestore.Document{
+		Name: run,
+		Fields: map[string]firestore.Value{
+			"runID":             firestoreString(m.RunID),
+			"environment":       firestoreString(m.Environment),
+			"cluster":           firestoreString(m.Cluster),
+			"hostname":          firestoreString(m.Hostname),
+			"path":              firestoreString(m.Path),
+			"manifest":          firestoreString(manifestName(m.Path)),
+			"status":            firestoreString(m.Status()),
+			"started":           firestoreTimestamp(m.Started),
+			"finished":          firestoreTimestamp(m.Finished),
+			"tables":            firestoreInteger(int64(len(tables))),
+			"failedTables":      firestoreInteger(int64(failed)),
+			"uncompressedBytes": firestoreInteger(m.UncompressedBytes),
+			"compressedBytes":   firestoreInteger(m.CompressedBytes),
+			"version":           firestoreString(m.Version),
+		},
+	}}}
+
+	for _, table := range tables {
+		uris := []*firestore.Value{}
+		if table.Object != "" {
+			for _, name := range append([]string{table.Object}, table.Parts...) {
+				for _, bucket := range table.Buckets {
+					uri := firestoreString(fmt.Sprintf("gs://%s/%s", bucket, name))
+					uris = append(uris, &uri)
+				}
+			}
+		}
+
+		writes = append(writes, &firestore.Write{Update: &firestore.Document{
+			Name: fmt.Sprintf("%s/tables/%s.%s", run, table.Database, table.Table),
+			Fields: map[string]firestore.Value{
+				"runID":             firestoreString(m.RunID),
+				"database":          firestoreString(table.Database),
+				"table":             firestoreString(table.Table),
+				"status":            firestoreString(table.Status),
+				"error":             firestoreString(table.Error),
+				"object":            firestoreString(table.Object),
+				"uris":              {ArrayValue: &firestore.ArrayValue{Values: uris}},
+				"crc32c":            firestoreString(table.CRC32C),
+				"sha256":            firestoreString(table.SHA256),
+				"objectGeneration":  firestoreInteger(table.ObjectGeneration),
+				"checksum":          firestoreString(table.Checksum),
+				"rows":              firestoreInteger(table.Rows),
+				"approximateRows":   firestoreInteger(table.ApproximateRows),
+				"uncompressedBytes": firestoreInteger(table.UncompressedBytes),
+				"compressedBytes":   firestoreInteger(table.CompressedBytes),
+				"started":           firestoreTimestamp(table.Started),
+				"finished":          firestoreTimestamp(table.Finished),
+			},
+		}})
+	}
+
+	for len(writes) > 0 {
+		n := len(writes)
+		if n > maxFirestoreWrites {
+			n = maxFirestoreWrites
+		}
+		if _, err := i.Service.Projects.Databases.Documents.Commit(i.Database, &firestore.CommitRequest{Writes: writes[:n]}).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("failed to write run %s to Firestore: %w", m.RunID, err)
+		}
+		writes = writes[n:]
+	}
+
+	return nil
+}
+
+func firestoreString(s string) firestore.Value {
+	return firestore.Value{StringValue: s, ForceSendFields: []string{"StringValue"}}
+}
+
+func firestoreInteger(n int64) firestore.Value {
+	return firestore.Value{IntegerValue: n, ForceSendFields: []string{"IntegerValue"}}
+}
+
+func firestoreTimestamp(t time.Time) firestore.Value {
+	return firestore.Value{TimestampValue: t.UTC().Format(time.RFC3339Nano)}
+}
@@ -0,0 +1,77 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/firestore/v1"
+	"google.golang.org/api/option"
+)
+
+func TestFirestoreInventoryRecord(t *testing.T) {
+	var requests []firestore.CommitRequest
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request firestore.CommitRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("invalid commit request: %v", err)
+		}
+		requests = append(requests, request)
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	service, err := firestore.NewService(context.Background(), option.WithEndpoint(server.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	inventory := NewFirestoreInventory(service, "proj", "", "backupRuns")
+
+	m := newManifest("host", "host/2024-01-01-00", time.Now())
+	m.RunID = "01HRUN"
+	m.addTable(TableResult{Database: "shop", Table: "orders", Status: StatusSucceeded, Object: "host/2024-01-01-00/shop/orders.sql.gz", Buckets: []string{"b1", "b2"}, CRC32C: "AAAAAA==", Rows: 3})
+	for i := 0; i < maxFirestoreWrites; i++ {
+		m.addTable(TableResult{Database: "shop", Table: fmt.Sprintf("t%d", i), Status: StatusFailed})
+	}
+
+	if err := inventory.Record(context.Background(), m); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	if len(requests) != 2 || len(requests[0].Writes)+len(requests[1].Writes) != maxFirestoreWrites+2 {
+		t.Fatalf("got %d commits, want the %d writes split into 2", len(requests), maxFirestoreWrites+2)
+	}
+	if !strings.HasSuffix(paths[0], "/projects/proj/databases/(default)/documents:commit") {
+		t.Errorf("commit path = %s", paths[0])
+	}
+
+	run := requests[0].Writes[0].Update
+	if run.Name != "projects/proj/databases/(default)/documents/backupRuns/01HRUN" {
+		t.Errorf("run document = %s", run.Name)
+	}
+	if run.Fields["status"].StringValue != StatusFailed || run.Fields["tables"].IntegerValue != maxFirestoreWrites+1 {
+		t.Errorf("run fields = %+v", run.Fields)
+	}
+
+	table := requests[0].Writes[1].Update
+	if table.Name != run.Name+"/tables/shop.orders" {
+		t.Errorf("table document = %s", table.Name)
+	}
+	var uris []string
+	for _, uri := range table.Fields["uris"].ArrayValue.Values {
+		uris = append(uris, uri.StringValue)
+	}
+	if strings.Join(uris, ",") != "gs://b1/host/2024-01-01-00/shop/orders.sql.gz,gs://b2/host/2024-01-01-00/shop/orders.sql.gz" {
+		t.Errorf("uris = %v", uris)
+	}
+	if table.Fields["crc32c"].StringValue != "AAAAAA==" || table.Fields["rows"].IntegerValue != 3 {
+		t.Errorf("table fields = %+v", table.Fields)
+	}
+}
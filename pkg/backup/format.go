@@ -0,0 +1,37 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+)
+
+// FormatVersion is the version of the object layout and manifest schema
+// that this release writes, recorded as the formatVersion of every
+// manifest. A release reads the generations of every version up to its
+// own, upgrading older manifests as they are loaded, so that changing the
+// layout only needs a new version and an upgrade step.
+//
+// Version 1 is the layout written before versions were recorded:
+// <host>/<generation>/<database>/<table>.sql.gz and the other objects of a
+// run next to manifest.json. Manifests without a formatVersion are read as
+// version 1, as are generations of releases that wrote no manifest at all,
+// whose dumps are found by their names.
+const FormatVersion = 1
+
+// ErrUnsupportedFormat is returned for manifests written by a newer release
+// in a format version this one does not read.
+var ErrUnsupportedFormat = errors.New("unsupported backup format")
+
+// upgrade checks the format version of the manifest read from name and
+// converts it to FormatVersion.
+func (m *Manifest) upgrade(name string) error {
+	if m.FormatVersion > FormatVersion {
+		return fmt.Errorf("%w: %s has format version %d, this release reads up to version %d", ErrUnsupportedFormat, name, m.FormatVersion, FormatVersion)
+	}
+	if m.FormatVersion == 0 {
+		// Written before format versions were recorded, in the layout
+		// of version 1.
+		m.FormatVersion = 1
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunRecordsFormatVersion(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"orders"}}}
+	dumper := &fakeDumper{dumps: map[string]string{"shop.orders": ordersDump}}
+	m, err := Run(context.Background(), testConfig(store, planner, dumper))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := readManifest(t, store, m.Path).FormatVersion; got != FormatVersion {
+		t.Errorf("formatVersion = %d, want %d", got, FormatVersion)
+	}
+}
+
+func TestLoadManifestUpgradesUnversionedManifest(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	uploader := &Uploader{Store: store}
+	data := []byte(`{"hostname":"host","path":"host/2024-03-01-00","tables":[{"database":"shop","table":"orders","object":"host/2024-03-01-00/shop/orders.sql.gz","status":"succeeded","rows":3}]}`)
+	if err := uploader.UploadObject(ctx, "host/2024-03-01-00/manifest.json", "application/json", data); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := LoadManifest(ctx, store, "host/2024-03-01-00")
+	if err != nil {
+		t.Fatalf("LoadManifest() error = %v", err)
+	}
+	if m.FormatVersion != 1 {
+		t.Errorf("FormatVersion = %d, want 1", m.FormatVersion)
+	}
+	if result, ok := m.Table("shop", "orders"); !ok || result.Rows != 3 {
+		t.Errorf("Table(shop, orders) = %+v, %v, want 3 rows", result, ok)
+	}
+}
+
+func TestNewerFormatIsRejectedAndKept(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	uploader := &Uploader{Store: store}
+	data := []byte(`{"formatVersion":99,"path":"host/2024-03-01-00"}`)
+	if err := uploader.UploadObject(ctx, "host/2024-03-01-00/manifest.json", "application/json", data); err != nil {
+		t.Fatal(err)
+	}
+	putGzipObject(t, store, "host/2024-03-01-00/shop/orders.sql.gz", "data")
+
+	if _, err := LoadManifest(ctx, store, "host/2024-03-01-00"); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("LoadManifest() error = %v, want ErrUnsupportedFormat", err)
+	}
+	garbage, err := CollectGarbage(ctx, GCConfig{Store: store, Host: "host", Before: time.Now().Add(time.Hour), DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(garbage) != 0 {
+		t.Errorf("CollectGarbage() = %+v, want the generation left alone", garbage)
+	}
+}
@@ -0,0 +1,140 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FreshnessConfig configures a check that every database was backed up
+// recently.
+type FreshnessConfig struct {
+	Store ObjectStore
+	// Prefix selects the hosts checked: "<host>/" or "" for all of them.
+	Prefix string
+	// Database, if set, is the only database checked.
+	Database string
+	// MaxAge is the age beyond which the latest successful backup of a
+	// database is stale.
+	MaxAge time.Duration
+	// AlertHook is a shell command run when a backup is stale, with the
+	// stale databases in BACKUP_STALE_DATABASES as <host>/<database>.
+	AlertHook string
+}
+
+// DatabaseFreshness is the latest successful backup of a database.
+type DatabaseFreshness struct {
+	Host     string `json:"host"`
+	Database string `json:"database"`
+	// Generation is the latest generation in which no table of the database
+	// failed, empty if there is none.
+	Generation string `json:"generation,omitempty"`
+	// LastBackup is when the run of Generation finished, or the creation
+	// time of its newest dump for generations without a manifest.
+	LastBackup time.Time `json:"lastBackup"`
+	Stale      bool      `json:"stale"`
+}
+
+// CheckFreshness finds the latest successful backup of every database below
+// cfg.Prefix and marks those older than cfg.MaxAge as stale, running
+// cfg.AlertHook if there are any. The results are ordered by host and
+// database.
+func CheckFreshness(ctx context.Context, cfg FreshnessConfig, now time.Time) ([]DatabaseFreshness, error) {
+	backups, err := ListDatabaseBackups(ctx, cfg.Store, cfg.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := map[string]*Manifest{}
+	manifest := func(path string) (*Manifest, error) {
+		if m, ok := manifests[path]; ok {
+			return m, nil
+		}
+		m, err := LoadManifest(ctx, cfg.Store, path)
+		if errors.Is(err, ErrObjectNotExist) {
+			m, err = nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifests[path] = m
+		return m, nil
+	}
+
+	latest := map[string]*DatabaseFreshness{}
+	var keys []string
+	// Backups are ordered by generation, so the newest of each database is
+	// looked at first.
+	for i := len(backups) - 1; i >= 0; i-- {
+		b := backups[i]
+		if cfg.Database != "" && b.Database != cfg.Database {
+			continue
+		}
+
+		key := b.Host + "/" + b.Database
+		freshness, ok := latest[key]
+		if !ok {
+			freshness = &DatabaseFreshness{Host: b.Host, Database: b.Database}
+			latest[key] = freshness
+			keys = append(keys, key)
+		}
+		if freshness.Generation != "" {
+			continue
+		}
+
+		m, err := manifest(b.Host + "/" + b.Generation)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case m == nil:
+			freshness.LastBackup = b.Created
+		case databaseSucceeded(m, b.Database):
+			freshness.LastBackup = m.Finished
+		default:
+			continue
+		}
+		freshness.Generation = b.Generation
+	}
+
+	sort.Strings(keys)
+	results := make([]DatabaseFreshness, 0, len(keys))
+	var stale []string
+	for _, key := range keys {
+		freshness := latest[key]
+		freshness.Stale = now.Sub(freshness.LastBackup) > cfg.MaxAge
+		if freshness.Stale {
+			stale = append(stale, key)
+		}
+		results = append(results, *freshness)
+	}
+
+	if len(stale) > 0 {
+		log.Printf("%d database(s) were not backed up within %s: %s\n", len(stale), cfg.MaxAge, strings.Join(stale, ", "))
+		env := map[string]string{
+			"BACKUP_STAGE":           "freshness",
+			"BACKUP_MAX_AGE":         cfg.MaxAge.String(),
+			"BACKUP_STALE_DATABASES": strings.Join(stale, ","),
+		}
+		if err := runHook(ctx, "alert", cfg.AlertHook, env); err != nil {
+			return results, fmt.Errorf("failed to alert about stale backups: %w", err)
+		}
+	}
+
+	return results, nil
+}
+
+// databaseSucceeded reports whether no table of database failed in the run
+// of m.
+func databaseSucceeded(m *Manifest, database string) bool {
+	for _, table := range m.Tables {
+		if table.Database == database && table.Status == StatusFailed {
+			return false
+		}
+	}
+	return true
+}
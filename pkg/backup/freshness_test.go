@@ -0,0 +1,70 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckFreshness(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	uploader := &Uploader{Store: store}
+	now := time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)
+
+	putManifest := func(path string, finished time.Time, tables ...TableResult) {
+		data, err := json.Marshal(&Manifest{Path: path, Finished: finished, Tables: tables})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := uploader.UploadObject(ctx, manifestName(path), "application/json", data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// shop was last backed up successfully a day ago, billing failed since
+	// three days ago.
+	putGzipObject(t, store, "db1/2024-02-28-10/billing/invoices.sql.gz", "invoices")
+	putGzipObject(t, store, "db1/2024-02-28-10/shop/orders.sql.gz", "orders")
+	putManifest("db1/2024-02-28-10", now.Add(-74*time.Hour),
+		TableResult{Database: "billing", Table: "invoices", Status: StatusSucceeded},
+		TableResult{Database: "shop", Table: "orders", Status: StatusSucceeded})
+	putGzipObject(t, store, "db1/2024-03-01-10/billing/invoices.sql.gz", "invoices")
+	putGzipObject(t, store, "db1/2024-03-01-10/shop/orders.sql.gz", "orders")
+	putManifest("db1/2024-03-01-10", now.Add(-25*time.Hour),
+		TableResult{Database: "billing", Table: "invoices", Status: StatusFailed},
+		TableResult{Database: "shop", Table: "orders", Status: StatusSucceeded})
+
+	hookOutput := filepath.Join(t.TempDir(), "stale")
+	results, err := CheckFreshness(ctx, FreshnessConfig{
+		Store:     store,
+		Prefix:    "db1/",
+		MaxAge:    26 * time.Hour,
+		AlertHook: "echo $BACKUP_STALE_DATABASES > " + hookOutput,
+	}, now)
+	if err != nil {
+		t.Fatalf("CheckFreshness failed: %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2: %+v", len(results), results)
+	}
+	if billing := results[0]; billing.Database != "billing" || billing.Generation != "2024-02-28-10" || !billing.Stale {
+		t.Errorf("billing = %+v, want stale since 2024-02-28-10", billing)
+	}
+	if shop := results[1]; shop.Database != "shop" || shop.Generation != "2024-03-01-10" || shop.Stale {
+		t.Errorf("shop = %+v, want fresh from 2024-03-01-10", shop)
+	}
+
+	data, err := os.ReadFile(hookOutput)
+	if err != nil {
+		t.Fatalf("alert hook did not run: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "db1/billing" {
+		t.Errorf("alert hook got BACKUP_STALE_DATABASES=%q, want db1/billing", got)
+	}
+}
@@ -0,0 +1,294 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reasons for which CollectGarbage deletes an object.
+const (
+	GarbageTemporary    = "temporary upload part"
+	GarbageUnreferenced = "not in the manifest"
+	GarbageNoManifest   = "generation has no manifest"
+	GarbageStaleIndex   = "run index of a deleted run"
+	GarbageChunk        = "chunk of no deduplicated dump"
+	GarbageRunMarker    = "marker of an interrupted run"
+)
+
+// compositePartPattern matches the parts and intermediate objects of
+// parallel composite uploads, see compositeWriter.
+var compositePartPattern = regexp.MustCompile(`\.composite-\d+-\d{4}$`)
+
+// GCConfig configures the garbage collection of the objects of a host.
+type GCConfig struct {
+	Store ObjectStore
+	Host  string
+	// Before is the cutoff: only objects created before it are deleted, so
+	// that runs in progress are not disturbed.
+	Before time.Time
+	// DryRun only reports the objects that would be deleted.
+	DryRun bool
+}
+
+// GarbageObject is an object left behind by a failed or interrupted run.
+type GarbageObject struct {
+	Name   string
+	Size   int64
+	Reason string
+}
+
+// CollectGarbage deletes what failed and interrupted runs of cfg.Host left
+// behind: parts of composite uploads, dumps not recorded as succeeded in the
+// manifest of their generation, generations that never got a manifest and
+// run indexes whose run is gone, and the chunks of deduplicated dumps that
+// no remaining index lists. Objects directly under a generation, such
+// as markers, reports and the manifest, are only deleted with their whole
+// generation. Chunks are kept while a deduplicated run is in progress, see
+// RunningPrefix.
+func CollectGarbage(ctx context.Context, cfg GCConfig) ([]GarbageObject, error) {
+	prefix := cfg.Host + "/"
+	objects, err := cfg.Store.List(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects under %s: %w", prefix, err)
+	}
+
+	byName := map[string][]ObjectAttrs{}
+	for _, attrs := range objects {
+		name, _, ok := strings.Cut(strings.TrimPrefix(attrs.Name, prefix), "/")
+		if !ok {
+			continue
+		}
+		if _, ok := parseGeneration(name); !ok {
+			continue
+		}
+		byName[name] = append(byName[name], attrs)
+	}
+
+	var garbage []GarbageObject
+	collect := func(attrs ObjectAttrs, reason string) {
+		if attrs.Created.Before(cfg.Before) {
+			garbage = append(garbage, GarbageObject{Name: attrs.Name, Size: attrs.Size, Reason: reason})
+		}
+	}
+
+	for name, objects := range byName {
+		path := prefix + name
+		m, err := LoadManifest(ctx, cfg.Store, path)
+		if errors.Is(err, ErrObjectNotExist) {
+			for _, attrs := range objects {
+				collect(attrs, GarbageNoManifest)
+			}
+			continue
+		}
+		if errors.Is(err, ErrUnsupportedFormat) {
+			// What a newer release references is unknown, so its
+			// objects are all kept.
+			log.Printf("Skipping generation %s: %v\n", path, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		referenced := m.referencedObjects()
+		for _, attrs := range objects {
+			switch {
+			case compositePartPattern.MatchString(attrs.Name):
+				collect(attrs, GarbageTemporary)
+			case !strings.Contains(strings.TrimPrefix(attrs.Name, path+"/"), "/"):
+			case !referenced[attrs.Name]:
+				collect(attrs, GarbageUnreferenced)
+			}
+		}
+	}
+
+	running, interrupted, err := runningMarkers(ctx, cfg.Store, cfg.Host, cfg.Before)
+	if err != nil {
+		return nil, err
+	}
+	for _, attrs := range interrupted {
+		collect(attrs, GarbageRunMarker)
+	}
+	if len(running) > 0 {
+		log.Printf("Keeping unreferenced chunks while deduplicated runs are in progress: %s\n", strings.Join(running, ", "))
+	} else {
+		deleted := map[string]bool{}
+		for _, object := range garbage {
+			deleted[object.Name] = true
+		}
+		chunks, err := unreferencedChunks(ctx, cfg.Store, prefix, objects, deleted)
+		if err != nil {
+			return nil, err
+		}
+		for _, attrs := range chunks {
+			collect(attrs, GarbageChunk)
+		}
+	}
+
+	stale, err := staleIndexes(ctx, cfg.Store, prefix, cfg.Before)
+	if err != nil {
+		return nil, err
+	}
+	for _, attrs := range stale {
+		collect(attrs, GarbageStaleIndex)
+	}
+
+	sort.Slice(garbage, func(i, j int) bool {
+		return garbage[i].Name < garbage[j].Name
+	})
+	if cfg.DryRun {
+		return garbage, nil
+	}
+
+	var errs []error
+	var deleted, chunks []GarbageObject
+	remove := func(object GarbageObject) {
+		log.Printf("Deleting %s (%s, %s)\n", object.Name, object.Reason, FormatBytes(object.Size))
+		if err := cfg.Store.Delete(ctx, object.Name); err != nil && !errors.Is(err, ErrObjectNotExist) {
+			errs = append(errs, fmt.Errorf("failed to delete %s: %w", object.Name, err))
+		}
+		deleted = append(deleted, object)
+	}
+	for _, object := range garbage {
+		if object.Reason == GarbageChunk {
+			chunks = append(chunks, object)
+			continue
+		}
+		remove(object)
+	}
+
+	// Chunks go last, and only if no deduplicated run started since the
+	// listing, as it may be about to reuse them.
+	if len(chunks) > 0 {
+		running, _, err := runningMarkers(ctx, cfg.Store, cfg.Host, cfg.Before)
+		switch {
+		case err != nil:
+			errs = append(errs, err)
+		case len(running) > 0:
+			log.Printf("Keeping unreferenced chunks, deduplicated runs started meanwhile: %s\n", strings.Join(running, ", "))
+		default:
+			for _, object := range chunks {
+				remove(object)
+			}
+		}
+	}
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].Name < deleted[j].Name
+	})
+	return deleted, errors.Join(errs...)
+}
+
+// runningMarkers returns the IDs of the deduplicated runs of host in
+// progress, see RunningPrefix, and the markers created before cutoff, which
+// interrupted runs left behind.
+func runningMarkers(ctx context.Context, store ObjectStore, host string, cutoff time.Time) ([]string, []ObjectAttrs, error) {
+	prefix := host + "/" + RunningPrefix + "/"
+	markers, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list the markers of runs in progress: %w", err)
+	}
+
+	var running []string
+	var interrupted []ObjectAttrs
+	for _, attrs := range markers {
+		if attrs.Created.Before(cutoff) {
+			interrupted = append(interrupted, attrs)
+		} else {
+			running = append(running, strings.TrimPrefix(attrs.Name, prefix))
+		}
+	}
+	return running, interrupted, nil
+}
+
+// referencedObjects returns the objects of the succeeded tables and
+// databases of the run.
+func (m *Manifest) referencedObjects() map[string]bool {
+	referenced := map[string]bool{}
+	for _, table := range m.Tables {
+		if table.Status != StatusSucceeded {
+			continue
+		}
+		referenced[table.Object] = true
+		for _, part := range table.Parts {
+			referenced[part] = true
+		}
+		for _, partition := range table.Partitions {
+			referenced[partition.Object] = true
+			for _, part := range partition.Parts {
+				referenced[part] = true
+			}
+		}
+	}
+	for _, database := range m.Databases {
+		if database.Status == StatusSucceeded {
+			referenced[database.Object] = true
+		}
+	}
+	return referenced
+}
+
+// unreferencedChunks returns the chunks under prefix that no index of a
+// deduplicated dump lists, leaving out the indexes about to be deleted.
+func unreferencedChunks(ctx context.Context, store ObjectStore, prefix string, objects []ObjectAttrs, deleted map[string]bool) ([]ObjectAttrs, error) {
+	referenced := map[string]bool{}
+	host := strings.TrimSuffix(prefix, "/")
+	for _, attrs := range objects {
+		if !strings.HasSuffix(attrs.Name, dedupIndexSuffix) || deleted[attrs.Name] {
+			continue
+		}
+		index, err := loadDedupIndex(ctx, store, attrs.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, chunk := range index.Chunks {
+			referenced[dedupChunkObject(host, chunk.SHA256)] = true
+		}
+	}
+
+	var chunks []ObjectAttrs
+	for _, attrs := range objects {
+		if strings.HasPrefix(attrs.Name, prefix+DedupChunkPrefix+"/") && !referenced[attrs.Name] {
+			chunks = append(chunks, attrs)
+		}
+	}
+	return chunks, nil
+}
+
+// staleIndexes returns the run indexes created before cutoff of runs under
+// prefix whose manifest no longer exists, e.g. because the generation was
+// pruned.
+func staleIndexes(ctx context.Context, store ObjectStore, prefix string, cutoff time.Time) ([]ObjectAttrs, error) {
+	indexes, err := store.List(ctx, IndexPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list run indexes: %w", err)
+	}
+
+	var stale []ObjectAttrs
+	for _, attrs := range indexes {
+		runID, ok := strings.CutSuffix(strings.TrimPrefix(attrs.Name, IndexPrefix), ".json")
+		if !ok || !attrs.Created.Before(cutoff) {
+			continue
+		}
+		index, err := LoadRunIndex(ctx, store, runID)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(index.Path, prefix) {
+			continue
+		}
+		_, err = store.Attrs(ctx, manifestName(index.Path))
+		switch {
+		case errors.Is(err, ErrObjectNotExist):
+			stale = append(stale, attrs)
+		case err != nil:
+			return nil, fmt.Errorf("failed to look up the manifest of %s: %w", index.Path, err)
+		}
+	}
+	return stale, nil
+}
@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	uploader := &Uploader{Store: store}
+	putJSON := func(name string, v any) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := uploader.UploadObject(ctx, name, "application/json", data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	putJSON("db1/2024-03-01-00/manifest.json", &Manifest{Path: "db1/2024-03-01-00", Tables: []TableResult{
+		{Object: "db1/2024-03-01-00/shop/orders.sql.gz", Status: StatusSucceeded, Parts: []string{"db1/2024-03-01-00/shop/orders.sql.gz.part001"}},
+		{Object: "db1/2024-03-01-00/shop/carts.sql.gz", Status: StatusFailed},
+	}})
+	for _, name := range []string{
+		"db1/2024-03-01-00/shop/orders.sql.gz",
+		"db1/2024-03-01-00/shop/orders.sql.gz.part001",
+		"db1/2024-03-01-00/shop/carts.sql.gz",
+		"db1/2024-03-01-00/shop/orders.sql.gz.composite-0-0003",
+		"db1/2024-03-01-00/_SUCCESS",
+		"db1/2024-02-01-00/shop/orders.sql.gz",
+		"db1/notes/readme.txt",
+		"db2/2024-02-01-00/shop/orders.sql.gz",
+	} {
+		putGzipObject(t, store, name, "data")
+	}
+	putJSON(indexName("kept"), &RunIndex{Path: "db1/2024-03-01-00"})
+	putJSON(indexName("pruned"), &RunIndex{Path: "db1/2024-01-01-00"})
+	putJSON(indexName("other"), &RunIndex{Path: "db2/2024-01-01-00"})
+
+	cfg := GCConfig{Store: store, Host: "db1", Before: time.Now().Add(time.Hour), DryRun: true}
+	garbage, err := CollectGarbage(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reasons := map[string]string{}
+	for _, object := range garbage {
+		reasons[object.Name] = object.Reason
+	}
+	want := map[string]string{
+		"db1/2024-02-01-00/shop/orders.sql.gz":                  GarbageNoManifest,
+		"db1/2024-03-01-00/shop/carts.sql.gz":                   GarbageUnreferenced,
+		"db1/2024-03-01-00/shop/orders.sql.gz.composite-0-0003": GarbageTemporary,
+		indexName("pruned"):                                     GarbageStaleIndex,
+	}
+	if !reflect.DeepEqual(reasons, want) {
+		t.Errorf("garbage = %v, want %v", reasons, want)
+	}
+
+	cfg.Before = time.Now().Add(-time.Hour)
+	if garbage, err := CollectGarbage(ctx, cfg); err != nil || len(garbage) != 0 {
+		t.Errorf("garbage of recent objects = %v, %v, want none", garbage, err)
+	}
+
+	cfg.Before, cfg.DryRun = time.Now().Add(time.Hour), false
+	if _, err := CollectGarbage(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	for name := range want {
+		if _, ok := store.Data(name); ok {
+			t.Errorf("%s was not deleted", name)
+		}
+	}
+	if _, ok := store.Data("db1/2024-03-01-00/shop/orders.sql.gz.part001"); !ok {
+		t.Error("referenced part was deleted")
+	}
+}
@@ -0,0 +1,126 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+)
+
+// ErrNoSecondary is returned by Run with Config.SelectSecondary when the
+// replication group has no ONLINE secondary to dump and Config.AllowPrimary
+// is not set.
+var ErrNoSecondary = errors.New("no online secondary member in the replication group")
+
+// groupMember is a member of a Group Replication group, as listed in
+// performance_schema.replication_group_members.
+type groupMember struct {
+	Host  string
+	Port  int
+	State string
+	Role  string
+	// Queued is the number of transactions received from the group that the
+	// member has yet to apply.
+	Queued int64
+}
+
+func (m groupMember) address() string {
+	return net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+}
+
+// groupMembers lists the members of the group the server behind conn
+// belongs to, which may be any member or a router in front of the group.
+func groupMembers(ctx context.Context, conn Connection) ([]groupMember, error) {
+	var l lazyDB
+	db, err := l.get(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+	defer l.Close()
+
+	rows, err := db.QueryContext(ctx, `SELECT m.MEMBER_HOST, m.MEMBER_PORT, m.MEMBER_STATE, m.MEMBER_ROLE, COALESCE(s.COUNT_TRANSACTIONS_REMOTE_IN_APPLIER_QUEUE, 0)
+		FROM performance_schema.replication_group_members m
+		LEFT JOIN performance_schema.replication_group_member_stats s USING (MEMBER_ID)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the replication group members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []groupMember
+	for rows.Next() {
+		var member groupMember
+		var port sql.NullInt64
+		if err := rows.Scan(&member.Host, &port, &member.State, &member.Role, &member.Queued); err != nil {
+			return nil, fmt.Errorf("failed to query the replication group members: %w", err)
+		}
+		member.Port = int(port.Int64)
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query the replication group members: %w", err)
+	}
+	return members, nil
+}
+
+// chooseGroupMember returns the ONLINE secondary with the fewest queued
+// transactions, the one closest to the primary, or the primary if there is
+// no such secondary and allowPrimary is set.
+func chooseGroupMember(members []groupMember, allowPrimary bool) (groupMember, error) {
+	var secondaries []groupMember
+	var primary *groupMember
+	for i, member := range members {
+		if member.State != "ONLINE" || member.Port == 0 {
+			continue
+		}
+		switch member.Role {
+		case "SECONDARY":
+			secondaries = append(secondaries, member)
+		case "PRIMARY":
+			primary = &members[i]
+		}
+	}
+
+	if len(secondaries) > 0 {
+		sort.Slice(secondaries, func(i, j int) bool {
+			if secondaries[i].Queued != secondaries[j].Queued {
+				return secondaries[i].Queued < secondaries[j].Queued
+			}
+			return secondaries[i].address() < secondaries[j].address()
+		})
+		return secondaries[0], nil
+	}
+	if allowPrimary && primary != nil {
+		return *primary, nil
+	}
+	return groupMember{}, fmt.Errorf("%w among %d member(s)", ErrNoSecondary, len(members))
+}
+
+// selectGroupMember points conn at the group member to dump, see
+// Config.SelectSecondary.
+func selectGroupMember(ctx context.Context, conn Connection, allowPrimary bool) (Connection, error) {
+	members, err := groupMembers(ctx, conn)
+	if err != nil {
+		return conn, err
+	}
+	if len(members) == 0 {
+		return conn, errors.New("the server is not a member of a replication group")
+	}
+
+	member, err := chooseGroupMember(members, allowPrimary)
+	if err != nil {
+		return conn, err
+	}
+	if member.Role == "PRIMARY" {
+		log.Printf("No online secondary in the replication group, dumping the primary %s\n", member.address())
+	} else {
+		log.Printf("Dumping the secondary %s of the replication group, %d transactions queued\n", member.address(), member.Queued)
+	}
+
+	conn.Host = member.Host
+	conn.Port = strconv.Itoa(member.Port)
+	return conn, nil
+}
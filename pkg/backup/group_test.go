@@ -0,0 +1,38 @@
+package backup
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestChooseGroupMember(t *testing.T) {
+	primary := groupMember{Host: "db1", Port: 3306, State: "ONLINE", Role: "PRIMARY"}
+	lagging := groupMember{Host: "db2", Port: 3306, State: "ONLINE", Role: "SECONDARY", Queued: 40}
+	current := groupMember{Host: "db3", Port: 3306, State: "ONLINE", Role: "SECONDARY", Queued: 2}
+	recovering := groupMember{Host: "db4", Port: 3306, State: "RECOVERING", Role: "SECONDARY"}
+
+	tests := []struct {
+		name         string
+		members      []groupMember
+		allowPrimary bool
+		want         string
+		wantErr      error
+	}{
+		{"fewest queued secondary", []groupMember{primary, lagging, current, recovering}, false, "db3:3306", nil},
+		{"only online secondaries", []groupMember{primary, recovering}, false, "", ErrNoSecondary},
+		{"primary allowed", []groupMember{primary, recovering}, true, "db1:3306", nil},
+		{"secondary over allowed primary", []groupMember{primary, lagging}, true, "db2:3306", nil},
+		{"no members", nil, true, "", ErrNoSecondary},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			member, err := chooseGroupMember(tt.members, tt.allowPrimary)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("chooseGroupMember: got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && member.address() != tt.want {
+				t.Errorf("chooseGroupMember: got %s, want %s", member.address(), tt.want)
+			}
+		})
+	}
+}
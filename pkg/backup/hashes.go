@@ -0,0 +1,160 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"cloud.google.com/go/storage"
+)
+
+// ErrChecksumMismatch is returned by uploads whose object, as stored, does
+// not match the checksums computed while it was written.
+var ErrChecksumMismatch = errors.New("uploaded object does not match its checksum")
+
+// Object metadata keys holding the checksums of an object that GCS does not
+// report itself: SHA-256 is not computed by GCS and MD5 not for composite
+// objects.
+const (
+	md5MetadataKey    = "backup-md5"
+	sha256MetadataKey = "backup-sha256"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// objectHashes are the checksums of the content of an object.
+type objectHashes struct {
+	CRC32C uint32
+	MD5    []byte
+	SHA256 []byte
+}
+
+// metadata returns the checksums recorded in the metadata of the object.
+func (h objectHashes) metadata() map[string]string {
+	return map[string]string{
+		md5MetadataKey:    formatMD5(h.MD5),
+		sha256MetadataKey: formatSHA256(h.SHA256),
+	}
+}
+
+// verify compares the checksums with those the store reports for the
+// object. Stores that report no MD5, as GCS for composite objects, are
+// only checked by CRC32C.
+func (h objectHashes) verify(attrs *ObjectAttrs) error {
+	if attrs.CRC32C != h.CRC32C {
+		return fmt.Errorf("%w: %s has CRC32C %s, %s was written", ErrChecksumMismatch, attrs.Name, formatCRC32C(attrs.CRC32C), formatCRC32C(h.CRC32C))
+	}
+	if len(attrs.MD5) > 0 && !bytes.Equal(attrs.MD5, h.MD5) {
+		return fmt.Errorf("%w: %s has MD5 %s, %s was written", ErrChecksumMismatch, attrs.Name, formatMD5(attrs.MD5), formatMD5(h.MD5))
+	}
+	return nil
+}
+
+// hashingWriter computes the checksums of an object in the same pass as it
+// is uploaded, so that uploads are verified without downloading them.
+type hashingWriter struct {
+	ObjectWriter
+	crc32c hash.Hash32
+	md5    hash.Hash
+	sha256 hash.Hash
+	hashes io.Writer
+}
+
+func newHashingWriter(writer ObjectWriter) *hashingWriter {
+	w := &hashingWriter{ObjectWriter: writer, crc32c: crc32.New(crc32cTable), md5: md5.New(), sha256: sha256.New()}
+	w.hashes = io.MultiWriter(w.crc32c, w.md5, w.sha256)
+	return w
+}
+
+func (w *hashingWriter) Write(p []byte) (int, error) {
+	n, err := w.ObjectWriter.Write(p)
+	w.hashes.Write(p[:n])
+	return n, err
+}
+
+func (w *hashingWriter) Buckets() []string {
+	return writerBuckets(w.ObjectWriter)
+}
+
+func (w *hashingWriter) sum() objectHashes {
+	return objectHashes{CRC32C: w.crc32c.Sum32(), MD5: w.md5.Sum(nil), SHA256: w.sha256.Sum(nil)}
+}
+
+// MetadataUpdater is implemented by ObjectStores that can add metadata to
+// existing objects, which is how the checksums of uploads are recorded as
+// they are only known once the object is written.
+type MetadataUpdater interface {
+	// UpdateMetadata sets metadata keys of an object, keeping the others,
+	// and returns its attributes after the update, whose etag may differ.
+	UpdateMetadata(ctx context.Context, name string, metadata map[string]string) (*ObjectAttrs, error)
+}
+
+func (s *GCSStore) UpdateMetadata(ctx context.Context, name string, metadata map[string]string) (*ObjectAttrs, error) {
+	attrs, err := s.Bucket.Object(name).Update(ctx, storage.ObjectAttrsToUpdate{Metadata: metadata})
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotExist, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gcsObjectAttrs(attrs), nil
+}
+
+func (s *MemoryStore) UpdateMetadata(ctx context.Context, name string, metadata map[string]string) (*ObjectAttrs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	object, ok := s.objects[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotExist, name)
+	}
+	merged := map[string]string{}
+	for key, value := range object.attrs.Metadata {
+		merged[key] = value
+	}
+	for key, value := range metadata {
+		merged[key] = value
+	}
+	object.attrs.Metadata = merged
+	attrs := object.attrs
+	return &attrs, nil
+}
+
+// UpdateMetadata updates the object in every store, failing unless all of
+// them are MetadataUpdaters. It returns the attributes in the first store.
+func (s *MirrorStore) UpdateMetadata(ctx context.Context, name string, metadata map[string]string) (*ObjectAttrs, error) {
+	var first *ObjectAttrs
+	for i, store := range s.Stores {
+		updater, ok := store.(MetadataUpdater)
+		if !ok {
+			return nil, fmt.Errorf("mirror %d does not support updating metadata", i)
+		}
+		attrs, err := updater.UpdateMetadata(ctx, name, metadata)
+		if err != nil {
+			return nil, fmt.Errorf("mirror %d: %w", i, err)
+		}
+		if first == nil {
+			first = attrs
+		}
+	}
+	return first, nil
+}
+
+// UpdateMetadata updates the object in the store currently written to.
+func (s *FailoverStore) UpdateMetadata(ctx context.Context, name string, metadata map[string]string) (*ObjectAttrs, error) {
+	store := s.Primary
+	if s.FailedOver() {
+		store = s.Fallback
+	}
+	updater, ok := store.(MetadataUpdater)
+	if !ok {
+		return nil, errors.New("store does not support updating metadata")
+	}
+	return updater.UpdateMetadata(ctx, name, metadata)
+}
@@ -0,0 +1,84 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadRecordsChecksums(t *testing.T) {
+	store := NewMemoryStore()
+	uploader := &Uploader{Store: store, Progress: newProgress(time.Now()), MaxObjectSize: 4096}
+	content := randomDump(2000)
+
+	stats, err := uploader.Upload(context.Background(), "db/t.sql.gz", strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if len(stats.Parts) == 0 {
+		t.Fatal("the dump was not rolled over")
+	}
+
+	data, _ := store.Data("db/t.sql.gz")
+	if sum := sha256.Sum256(data); hex.EncodeToString(stats.SHA256) != hex.EncodeToString(sum[:]) {
+		t.Errorf("SHA256 = %x, want %x", stats.SHA256, sum)
+	}
+	for _, name := range append([]string{"db/t.sql.gz"}, stats.Parts...) {
+		data, _ := store.Data(name)
+		sum := sha256.Sum256(data)
+		attrs, err := store.Attrs(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := attrs.Metadata[sha256MetadataKey]; got != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s has SHA-256 %q in its metadata, want %x", name, got, sum)
+		}
+		if attrs.Metadata[md5MetadataKey] != formatMD5(attrs.MD5) {
+			t.Errorf("%s has MD5 %q in its metadata, want %s", name, attrs.Metadata[md5MetadataKey], formatMD5(attrs.MD5))
+		}
+		if attrs.Metadata[runIDMetadataKey] != uploader.metadata()[runIDMetadataKey] {
+			t.Errorf("%s lost its metadata: %v", name, attrs.Metadata)
+		}
+	}
+}
+
+// corruptingStore flips a byte of every object written to it.
+type corruptingStore struct {
+	*MemoryStore
+}
+
+func (s *corruptingStore) NewWriter(ctx context.Context, name string, contentType string, metadata map[string]string) ObjectWriter {
+	return &corruptingWriter{ObjectWriter: s.MemoryStore.NewWriter(ctx, name, contentType, metadata)}
+}
+
+type corruptingWriter struct {
+	ObjectWriter
+	corrupted bool
+}
+
+func (w *corruptingWriter) Write(p []byte) (int, error) {
+	if w.corrupted || len(p) == 0 {
+		return w.ObjectWriter.Write(p)
+	}
+	w.corrupted = true
+	corrupted := append([]byte(nil), p...)
+	corrupted[0] ^= 0xff
+	return w.ObjectWriter.Write(corrupted)
+}
+
+func TestUploadFailsOnChecksumMismatch(t *testing.T) {
+	store := &corruptingStore{MemoryStore: NewMemoryStore()}
+	uploader := &Uploader{Store: store, Progress: newProgress(time.Now())}
+
+	_, err := uploader.Upload(context.Background(), "db/t.sql.gz", strings.NewReader("INSERT INTO t VALUES (1);\n"))
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Upload = %v, want ErrChecksumMismatch", err)
+	}
+	if _, ok := store.Data("db/t.sql.gz"); ok {
+		t.Error("the corrupted object was kept")
+	}
+}
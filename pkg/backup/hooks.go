@@ -0,0 +1,65 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/template"
+	"time"
+)
+
+// Hooks are shell commands run around a backup run and around each database,
+// with sh or, on Windows, cmd.exe. They receive the run context in BACKUP_*
+// environment variables.
+// TablesChanged runs when the tables differ from those of the previous run.
+// MessageTemplate, if set, renders the BACKUP_MESSAGE of the post-run and
+// tables-changed hooks instead of DefaultMessageTemplate.
+type Hooks struct {
+	PreRun        string
+	PostRun       string
+	PreDatabase   string
+	PostDatabase  string
+	TablesChanged string
+
+	MessageTemplate *template.Template
+}
+
+func runHook(ctx context.Context, name string, command string, env map[string]string) error {
+	if command == "" {
+		return nil
+	}
+
+	log.Printf("Running %s hook: %s\n", name, command)
+
+	cmd := shellCommand(ctx, command)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, env[key]))
+	}
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook failed: %w", name, err)
+	}
+
+	return nil
+}
+
+func (m *Manifest) hookEnv(stage string) map[string]string {
+	return map[string]string{
+		"BACKUP_STAGE":    stage,
+		"BACKUP_RUN_ID":   m.RunID,
+		"BACKUP_HOSTNAME": m.Hostname,
+		"BACKUP_PATH":     m.Path,
+		"BACKUP_STARTED":  m.Started.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,71 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunHooksReceiveContext(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "hooks.log")
+
+	cfg := testConfig(NewMemoryStore(), &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders"}},
+	}, &fakeDumper{failed: map[string]error{"shop.orders": errFake}})
+	cfg.Hooks = Hooks{
+		PreRun:       `echo "$BACKUP_STAGE $BACKUP_PATH" >> ` + out,
+		PreDatabase:  `echo "$BACKUP_STAGE $BACKUP_DATABASE" >> ` + out,
+		PostDatabase: `echo "$BACKUP_STAGE $BACKUP_DATABASE $BACKUP_STATUS" >> ` + out,
+		PostRun:      `echo "$BACKUP_STAGE $BACKUP_STATUS $BACKUP_FAILED_TABLES" >> ` + out,
+	}
+
+	m, err := Run(context.Background(), cfg)
+	if err == nil {
+		t.Fatalf("Run succeeded, want partial failure")
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("hooks did not run: %v", err)
+	}
+
+	want := strings.Join([]string{
+		"pre-run " + m.Path,
+		"pre-database shop",
+		"post-database shop failed",
+		"post-run failed 1",
+	}, "\n") + "\n"
+	if string(data) != want {
+		t.Errorf("hook output = %q, want %q", data, want)
+	}
+}
+
+func TestRunFailingPreHooks(t *testing.T) {
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders"}},
+	}
+
+	cfg := testConfig(NewMemoryStore(), planner, &fakeDumper{})
+	cfg.Hooks.PreRun = "exit 1"
+	if m, err := Run(context.Background(), cfg); err == nil || m != nil {
+		t.Errorf("Run = %v, %v, want failure before backing up", m, err)
+	}
+
+	dumper := &fakeDumper{}
+	cfg = testConfig(NewMemoryStore(), planner, dumper)
+	cfg.Hooks.PreDatabase = "exit 1"
+	m, err := Run(context.Background(), cfg)
+	if err == nil {
+		t.Fatalf("Run succeeded, want failure")
+	}
+	if len(dumper.dumped) != 0 {
+		t.Errorf("tables were dumped after a failing pre-database hook: %v", dumper.dumped)
+	}
+	if got := m.FailedTables(); got != 1 {
+		t.Errorf("FailedTables() = %d, want 1", got)
+	}
+}
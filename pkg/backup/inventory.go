@@ -0,0 +1,41 @@
+package backup
+
+import (
+	"context"
+	"log"
+)
+
+// Inventory records the outcome of runs outside of the bucket, e.g. in a
+// database that dashboards can query without listing objects.
+type Inventory interface {
+	Record(ctx context.Context, m *Manifest) error
+}
+
+// StatusReporter publishes the status of runs while they happen, e.g. to
+// the orchestrator running them. Its errors are logged and do not fail runs.
+type StatusReporter interface {
+	// RunStarted is called before the pre-run hook.
+	RunStarted(ctx context.Context, m *Manifest) error
+	// RunFinished is called with what Run returns, the manifest being nil
+	// for runs that failed before it was set up, e.g. in preflight.
+	RunFinished(ctx context.Context, m *Manifest, err error) error
+}
+
+// reportStarted reports the start of a run, if there is a reporter.
+func reportStarted(ctx context.Context, reporter StatusReporter, m *Manifest) {
+	if reporter == nil {
+		return
+	}
+	if err := reporter.RunStarted(ctx, m); err != nil {
+		log.Printf("Failed to report the run status: %v\n", err)
+	}
+}
+
+// Status reports StatusSucceeded for runs in which every table was backed
+// up or skipped and StatusFailed otherwise.
+func (m *Manifest) Status() string {
+	if m.FailedTables() > 0 || len(m.Errors) > 0 {
+		return StatusFailed
+	}
+	return StatusSucceeded
+}
@@ -0,0 +1,250 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// serviceAccountDir holds the credentials Kubernetes mounts into pods.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// statusRunning is the status of a run in progress in the ConfigMap of a
+// KubernetesReporter.
+const statusRunning = "running"
+
+// eventComponent is the source of the Events of a KubernetesReporter.
+const eventComponent = "mysql-backup-tables-to-gcs"
+
+// maxStatusError bounds the error messages written to ConfigMaps and Events.
+const maxStatusError = 1024
+
+// KubernetesReporter writes the status of runs to the data of a ConfigMap
+// and emits an Event on the ConfigMap when runs start, succeed and fail, so
+// that kubectl get configmap and kubectl get events show the health of
+// backups. It talks to the API server directly, needing get, create and
+// patch on configmaps and create on events in Namespace.
+type KubernetesReporter struct {
+	// APIServer is the base URL of the API server.
+	APIServer string
+	// TokenFile holds the bearer token, read for every request as the
+	// projected tokens of service accounts are rotated.
+	TokenFile string
+	Namespace string
+	ConfigMap string
+	Client    *http.Client
+
+	uid string
+}
+
+// NewInClusterReporter returns a KubernetesReporter writing to the ConfigMap
+// configMap in the namespace of the pod it runs in, authenticated as the
+// service account of the pod.
+func NewInClusterReporter(configMap string) (*KubernetesReporter, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+
+	namespace, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the namespace of the pod: %w", err)
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CA certificate of the cluster: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return nil, errors.New("failed to parse the CA certificate of the cluster")
+	}
+
+	return &KubernetesReporter{
+		APIServer: "https://" + net.JoinHostPort(host, port),
+		TokenFile: filepath.Join(serviceAccountDir, "token"),
+		Namespace: strings.TrimSpace(string(namespace)),
+		ConfigMap: configMap,
+		Client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+		},
+	}, nil
+}
+
+func (r *KubernetesReporter) RunStarted(ctx context.Context, m *Manifest) error {
+	err := r.updateConfigMap(ctx, map[string]string{
+		"status":   statusRunning,
+		"runID":    m.RunID,
+		"path":     m.Path,
+		"started":  m.Started.UTC().Format(time.RFC3339),
+		"finished": "",
+		"error":    "",
+	})
+	if err != nil {
+		return err
+	}
+	return r.event(ctx, "Normal", "BackupStarted", fmt.Sprintf("Backup run %s started, writing to %s", m.RunID, m.Path))
+}
+
+func (r *KubernetesReporter) RunFinished(ctx context.Context, m *Manifest, runErr error) error {
+	finished := time.Now().UTC().Format(time.RFC3339)
+	data := map[string]string{"status": StatusSucceeded, "finished": finished, "error": ""}
+	summary := ""
+	if m != nil {
+		data["runID"] = m.RunID
+		data["path"] = m.Path
+		data["started"] = m.Started.UTC().Format(time.RFC3339)
+		data["tables"] = fmt.Sprint(len(m.Tables))
+		data["failedTables"] = fmt.Sprint(m.FailedTables())
+		data["compressedBytes"] = fmt.Sprint(m.CompressedBytes)
+		summary = fmt.Sprintf(" %s: %d tables, %s compressed", m.RunID, len(m.Tables), FormatBytes(m.CompressedBytes))
+	}
+
+	eventType, reason, message := "Normal", "BackupSucceeded", "Backup run"+summary+" succeeded"
+	if runErr != nil {
+		data["status"] = StatusFailed
+		data["error"] = truncate(runErr.Error(), maxStatusError)
+		eventType, reason, message = "Warning", "BackupFailed", fmt.Sprintf("Backup run%s failed: %s", summary, data["error"])
+	} else {
+		data["lastSuccess"] = finished
+	}
+
+	if err := r.updateConfigMap(ctx, data); err != nil {
+		return err
+	}
+	return r.event(ctx, eventType, reason, message)
+}
+
+// updateConfigMap merges data into the data of the ConfigMap, creating it
+// if it does not exist.
+func (r *KubernetesReporter) updateConfigMap(ctx context.Context, data map[string]string) error {
+	path := fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", url.PathEscape(r.Namespace), url.PathEscape(r.ConfigMap))
+	var configMap struct {
+		Metadata struct {
+			UID string `json:"uid"`
+		} `json:"metadata"`
+	}
+
+	err := r.do(ctx, http.MethodPatch, path, "application/merge-patch+json", map[string]any{"data": data}, &configMap)
+	var status *kubernetesError
+	if errors.As(err, &status) && status.code == http.StatusNotFound {
+		created := map[string]any{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]any{
+				"name":   r.ConfigMap,
+				"labels": map[string]string{"app.kubernetes.io/managed-by": eventComponent},
+			},
+			"data": data,
+		}
+		err = r.do(ctx, http.MethodPost, fmt.Sprintf("/api/v1/namespaces/%s/configmaps", url.PathEscape(r.Namespace)), "application/json", created, &configMap)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update ConfigMap %s/%s: %w", r.Namespace, r.ConfigMap, err)
+	}
+	r.uid = configMap.Metadata.UID
+	return nil
+}
+
+// event emits an Event about the ConfigMap.
+func (r *KubernetesReporter) event(ctx context.Context, eventType string, reason string, message string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	hostname, _ := os.Hostname()
+	event := map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Event",
+		"metadata":   map[string]any{"generateName": r.ConfigMap + "-"},
+		"involvedObject": map[string]string{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"name":       r.ConfigMap,
+			"namespace":  r.Namespace,
+			"uid":        r.uid,
+		},
+		"type":           eventType,
+		"reason":         reason,
+		"message":        message,
+		"source":         map[string]string{"component": eventComponent, "host": hostname},
+		"firstTimestamp": now,
+		"lastTimestamp":  now,
+		"count":          1,
+	}
+	if err := r.do(ctx, http.MethodPost, fmt.Sprintf("/api/v1/namespaces/%s/events", url.PathEscape(r.Namespace)), "application/json", event, nil); err != nil {
+		return fmt.Errorf("failed to emit %s event: %w", reason, err)
+	}
+	return nil
+}
+
+// kubernetesError is a response of the API server other than a success.
+type kubernetesError struct {
+	code    int
+	message string
+}
+
+func (e *kubernetesError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.code, http.StatusText(e.code), e.message)
+}
+
+func (r *KubernetesReporter) do(ctx context.Context, method string, path string, contentType string, body any, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(r.APIServer, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	if r.TokenFile != "" {
+		token, err := os.ReadFile(r.TokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read the service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var status struct {
+			Message string `json:"message"`
+		}
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(message, &status) == nil && status.Message != "" {
+			message = []byte(status.Message)
+		}
+		return &kubernetesError{code: resp.StatusCode, message: strings.TrimSpace(string(message))}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
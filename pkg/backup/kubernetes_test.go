@@ -0,0 +1,112 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeAPIServer serves the ConfigMap and Event endpoints a
+// KubernetesReporter uses.
+type fakeAPIServer struct {
+	mu        sync.Mutex
+	configMap map[string]string
+	events    []map[string]any
+	tokens    []string
+}
+
+func (s *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = append(s.tokens, r.Header.Get("Authorization"))
+
+	var body map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, _ := body["data"].(map[string]any)
+
+	switch {
+	case r.Method == http.MethodPatch && r.URL.Path == "/api/v1/namespaces/backups/configmaps/mysql-backup":
+		if s.configMap == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "configmaps \"mysql-backup\" not found"})
+			return
+		}
+		if r.Header.Get("Content-Type") != "application/merge-patch+json" {
+			http.Error(w, "unsupported patch", http.StatusUnsupportedMediaType)
+			return
+		}
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/namespaces/backups/configmaps":
+		s.configMap = map[string]string{}
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/namespaces/backups/events":
+		s.events = append(s.events, body)
+		w.WriteHeader(http.StatusCreated)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	for key, value := range data {
+		s.configMap[key] = value.(string)
+	}
+	json.NewEncoder(w).Encode(map[string]any{"metadata": map[string]string{"uid": "cm-uid"}, "data": s.configMap})
+}
+
+func TestKubernetesReporter(t *testing.T) {
+	api := &fakeAPIServer{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	reporter := &KubernetesReporter{APIServer: server.URL, TokenFile: tokenFile, Namespace: "backups", ConfigMap: "mysql-backup"}
+
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders"}},
+	}
+	cfg := testConfig(NewMemoryStore(), planner, &fakeDumper{})
+	cfg.StatusReporter = reporter
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if api.configMap["status"] != StatusSucceeded || api.configMap["path"] != m.Path || api.configMap["lastSuccess"] == "" || api.configMap["tables"] != "1" {
+		t.Errorf("ConfigMap data = %v, want the succeeded run", api.configMap)
+	}
+	if len(api.events) != 2 || api.events[0]["reason"] != "BackupStarted" || api.events[1]["reason"] != "BackupSucceeded" {
+		t.Fatalf("events = %v, want BackupStarted and BackupSucceeded", api.events)
+	}
+	involved := api.events[1]["involvedObject"].(map[string]any)
+	if involved["kind"] != "ConfigMap" || involved["name"] != "mysql-backup" || involved["uid"] != "cm-uid" {
+		t.Errorf("event involves %v, want the ConfigMap", involved)
+	}
+	for _, token := range api.tokens {
+		if token != "Bearer secret" {
+			t.Errorf("request authorized with %q, want the token of the file", token)
+		}
+	}
+
+	cfg.Dumper = &fakeDumper{failed: map[string]error{"shop.orders": errFake}}
+	if _, err := Run(context.Background(), cfg); err == nil {
+		t.Fatal("Run succeeded, want the dump failure")
+	}
+	if api.configMap["status"] != StatusFailed || !strings.Contains(api.configMap["error"], errFake.Error()) || api.configMap["failedTables"] != "1" {
+		t.Errorf("ConfigMap data = %v, want the failed run", api.configMap)
+	}
+	if last := api.events[len(api.events)-1]; last["reason"] != "BackupFailed" || last["type"] != "Warning" {
+		t.Errorf("last event = %v, want a BackupFailed warning", last)
+	}
+}
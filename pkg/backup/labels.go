@@ -0,0 +1,159 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Object metadata keys labelling the objects of a run, so that backups can
+// be filtered by inventory tools without parsing object names.
+const (
+	runIDMetadataKey       = "backup-run-id"
+	environmentMetadataKey = "backup-env"
+	clusterMetadataKey     = "backup-cluster"
+	hostMetadataKey        = "backup-host"
+	databaseMetadataKey    = "backup-database"
+	tableMetadataKey       = "backup-table"
+	partitionMetadataKey   = "backup-partition"
+	engineMetadataKey      = "backup-engine"
+	rowsMetadataKey        = "backup-approximate-rows"
+	checksumMetadataKey    = "backup-checksum"
+
+	triggerUserMetadataKey           = "backup-trigger-user"
+	triggerServiceAccountMetadataKey = "backup-trigger-service-account"
+	triggerCallerMetadataKey         = "backup-trigger-caller"
+	triggerScheduleMetadataKey       = "backup-trigger-schedule"
+	configHashMetadataKey            = "backup-config-hash"
+)
+
+// IndexPrefix is the prefix run indexes are written to, one per run named
+// after its run ID, so the runs of every host sort by time.
+const IndexPrefix = "_index/"
+
+// runLabels returns the labels shared by every object of a run.
+func runLabels(cfg Config) map[string]string {
+	labels := map[string]string{
+		runIDMetadataKey: cfg.RunID,
+		hostMetadataKey:  cfg.Hostname,
+	}
+	if cfg.Environment != "" {
+		labels[environmentMetadataKey] = cfg.Environment
+	}
+	if cfg.Cluster != "" {
+		labels[clusterMetadataKey] = cfg.Cluster
+	}
+	for key, value := range cfg.Trigger.labels() {
+		labels[key] = value
+	}
+	return labels
+}
+
+// objectLabels returns the labels of a table or partition dump.
+func objectLabels(database string, table string, partition string) map[string]string {
+	labels := map[string]string{
+		databaseMetadataKey: database,
+		tableMetadataKey:    table,
+	}
+	if partition != "" {
+		labels[partitionMetadataKey] = partition
+	}
+	return labels
+}
+
+// tableLabels returns the labels of the objects of a table describing its
+// engine and size when it was dumped.
+func tableLabels(info TableInfo) map[string]string {
+	labels := map[string]string{}
+	if info.Engine != "" {
+		labels[engineMetadataKey] = info.Engine
+		labels[rowsMetadataKey] = strconv.FormatInt(info.ApproximateRows, 10)
+	}
+	return labels
+}
+
+// RunIndex lists the objects written by a run together with the labels they
+// carry.
+type RunIndex struct {
+	RunID       string        `json:"runID"`
+	Environment string        `json:"environment,omitempty"`
+	Cluster     string        `json:"cluster,omitempty"`
+	Trigger     *Trigger      `json:"trigger,omitempty"`
+	Hostname    string        `json:"hostname"`
+	Path        string        `json:"path"`
+	Started     time.Time     `json:"started"`
+	Finished    time.Time     `json:"finished"`
+	Objects     []IndexObject `json:"objects"`
+}
+
+// IndexObject is a table or partition dump listed in a RunIndex.
+type IndexObject struct {
+	Object    string `json:"object"`
+	Database  string `json:"database"`
+	Table     string `json:"table"`
+	Partition string `json:"partition,omitempty"`
+	// Parts are the continuation objects of a rolled over dump.
+	Parts []string `json:"parts,omitempty"`
+}
+
+func indexName(runID string) string {
+	return IndexPrefix + runID + ".json"
+}
+
+// index returns the index of the objects of the succeeded tables.
+func (m *Manifest) index() *RunIndex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	index := &RunIndex{
+		RunID:       m.RunID,
+		Environment: m.Environment,
+		Cluster:     m.Cluster,
+		Trigger:     m.Trigger,
+		Hostname:    m.Hostname,
+		Path:        m.Path,
+		Started:     m.Started,
+		Finished:    m.Finished,
+		Objects:     []IndexObject{},
+	}
+	for _, table := range m.Tables {
+		if table.Status != StatusSucceeded {
+			continue
+		}
+		index.Objects = append(index.Objects, IndexObject{Object: table.Object, Database: table.Database, Table: table.Table, Parts: table.Parts})
+		for _, partition := range table.Partitions {
+			index.Objects = append(index.Objects, IndexObject{Object: partition.Object, Database: table.Database, Table: table.Table, Partition: partition.Partition, Parts: partition.Parts})
+		}
+	}
+	return index
+}
+
+func (m *Manifest) uploadIndex(ctx context.Context, uploader *Uploader) error {
+	index := m.index()
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal run index: %w", err)
+	}
+
+	return uploader.UploadObject(ctx, indexName(index.RunID), "application/json", data)
+}
+
+// LoadRunIndex reads the index of the run with the given ID.
+func LoadRunIndex(ctx context.Context, store ObjectStore, runID string) (*RunIndex, error) {
+	name := indexName(runID)
+
+	reader, err := store.NewReader(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	var index RunIndex
+	if err := json.NewDecoder(reader).Decode(&index); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+
+	return &index, nil
+}
@@ -0,0 +1,64 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunLabelsObjectsAndWritesIndex(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders", "payments"}},
+		engines:   map[string]string{"shop.orders": "InnoDB"},
+		rows:      map[string]int64{"shop.orders": 40000000},
+	}
+
+	cfg := testConfig(store, planner, &fakeDumper{failed: map[string]error{"shop.payments": errors.New("dump failed")}})
+	cfg.Environment = "prod"
+	cfg.Cluster = "eu-1"
+	cfg.Index = true
+	m, err := Run(context.Background(), cfg)
+	if err == nil {
+		t.Fatal("Run succeeded, want partial failure")
+	}
+
+	attrs, err := store.Attrs(context.Background(), m.Path+"/shop/orders.sql.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		runIDMetadataKey:       m.RunID,
+		environmentMetadataKey: "prod",
+		clusterMetadataKey:     "eu-1",
+		hostMetadataKey:        "host",
+		databaseMetadataKey:    "shop",
+		tableMetadataKey:       "orders",
+		engineMetadataKey:      "InnoDB",
+		rowsMetadataKey:        "40000000",
+	}
+	for key, value := range want {
+		if got := attrs.Metadata[key]; got != value {
+			t.Errorf("metadata %s = %q, want %q", key, got, value)
+		}
+	}
+
+	if result, _ := m.Table("shop", "orders"); result.Engine != "InnoDB" || result.ApproximateRows != 40000000 {
+		t.Errorf("manifest engine = %q and approximate rows = %d, want InnoDB and 40000000", result.Engine, result.ApproximateRows)
+	}
+	if result, _ := m.Table("shop", "orders"); result.ObjectGeneration != attrs.Generation || result.Etag != attrs.Etag {
+		t.Errorf("manifest generation %d and etag %q, want %d and %q", result.ObjectGeneration, result.Etag, attrs.Generation, attrs.Etag)
+	}
+
+	index, err := LoadRunIndex(context.Background(), store, m.RunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.Environment != "prod" || index.Cluster != "eu-1" || index.Path != m.Path {
+		t.Errorf("index = %+v", index)
+	}
+	if len(index.Objects) != 1 || index.Objects[0].Object != m.Path+"/shop/orders.sql.gz" || index.Objects[0].Table != "orders" {
+		t.Errorf("index objects = %+v, want only shop.orders", index.Objects)
+	}
+}
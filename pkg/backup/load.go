@@ -0,0 +1,137 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLoadCheckInterval is how often a run paused by Config.MaxThreadsRunning
+// or Config.MaxThreadsConnected checks the load of the server again unless
+// Config.LoadCheckInterval is set.
+const DefaultLoadCheckInterval = 10 * time.Second
+
+// LoadMonitor reads the load of the server. A Planner implementing it
+// enables Config.MaxThreadsRunning and Config.MaxThreadsConnected.
+type LoadMonitor interface {
+	ServerLoad() (ServerLoad, error)
+}
+
+// ServerLoad is a sample of the Threads_running and Threads_connected
+// status variables of the server.
+type ServerLoad struct {
+	ThreadsRunning   int64
+	ThreadsConnected int64
+}
+
+func (l ServerLoad) String() string {
+	return fmt.Sprintf("Threads_running %d, Threads_connected %d", l.ThreadsRunning, l.ThreadsConnected)
+}
+
+// ServerLoad reads Threads_running and Threads_connected.
+func (p *MySQLPlanner) ServerLoad() (ServerLoad, error) {
+	db, err := p.get(p.Connection)
+	if err != nil {
+		return ServerLoad{}, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+
+	values, err := queryNameValues(db, "SHOW GLOBAL STATUS WHERE Variable_name IN ('Threads_running', 'Threads_connected')")
+	if err != nil {
+		return ServerLoad{}, fmt.Errorf("failed to query MySQL: %w", err)
+	}
+
+	var load ServerLoad
+	for name, value := range values {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return ServerLoad{}, fmt.Errorf("invalid %s %q: %w", name, value, err)
+		}
+		switch strings.ToLower(name) {
+		case "threads_running":
+			load.ThreadsRunning = n
+		case "threads_connected":
+			load.ThreadsConnected = n
+		}
+	}
+	return load, nil
+}
+
+// loadThrottle holds back new tables while the server is overloaded.
+type loadThrottle struct {
+	monitor      LoadMonitor
+	maxRunning   int64
+	maxConnected int64
+	interval     time.Duration
+}
+
+// newLoadThrottle returns the loadThrottle of cfg, or nil if it sets no
+// thresholds or planner cannot read the load of the server.
+func newLoadThrottle(planner Planner, cfg Config) *loadThrottle {
+	if cfg.MaxThreadsRunning <= 0 && cfg.MaxThreadsConnected <= 0 {
+		return nil
+	}
+	monitor, ok := planner.(LoadMonitor)
+	if !ok {
+		log.Printf("The server load cannot be read, tables are started regardless of the thread limits\n")
+		return nil
+	}
+	interval := cfg.LoadCheckInterval
+	if interval <= 0 {
+		interval = DefaultLoadCheckInterval
+	}
+	return &loadThrottle{monitor: monitor, maxRunning: int64(cfg.MaxThreadsRunning), maxConnected: int64(cfg.MaxThreadsConnected), interval: interval}
+}
+
+// overloaded returns why load is above the thresholds, if it is.
+func (t *loadThrottle) overloaded(load ServerLoad) string {
+	var reasons []string
+	if t.maxRunning > 0 && load.ThreadsRunning > t.maxRunning {
+		reasons = append(reasons, fmt.Sprintf("Threads_running %d above %d", load.ThreadsRunning, t.maxRunning))
+	}
+	if t.maxConnected > 0 && load.ThreadsConnected > t.maxConnected {
+		reasons = append(reasons, fmt.Sprintf("Threads_connected %d above %d", load.ThreadsConnected, t.maxConnected))
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// wait blocks while the server is overloaded and returns how long it paused.
+// A load that cannot be read does not hold tables back.
+func (t *loadThrottle) wait(ctx context.Context) (time.Duration, error) {
+	var paused time.Time
+	pausedFor := func() time.Duration {
+		if paused.IsZero() {
+			return 0
+		}
+		return time.Since(paused)
+	}
+
+	for {
+		load, err := t.monitor.ServerLoad()
+		if err != nil {
+			log.Printf("Failed to read the server load, starting the next table: %v\n", err)
+			return pausedFor(), nil
+		}
+		reason := t.overloaded(load)
+		if reason == "" {
+			if !paused.IsZero() {
+				log.Printf("Resuming table dumps after %s, the server load dropped: %s\n", pausedFor().Round(time.Second), load)
+			}
+			return pausedFor(), nil
+		}
+		if paused.IsZero() {
+			log.Printf("Pausing new table dumps, the server is overloaded: %s\n", reason)
+			paused = time.Now()
+		}
+
+		timer := time.NewTimer(t.interval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return pausedFor(), ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// loadPlanner reports loads in turn, then the last one.
+type loadPlanner struct {
+	*fakePlanner
+
+	mu      sync.Mutex
+	loads   []ServerLoad
+	samples int
+}
+
+func (p *loadPlanner) ServerLoad() (ServerLoad, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	load := p.loads[len(p.loads)-1]
+	if p.samples < len(p.loads) {
+		load = p.loads[p.samples]
+	}
+	p.samples++
+	return load, nil
+}
+
+func TestRunPausesWhileServerIsOverloaded(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &loadPlanner{
+		fakePlanner: &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"orders"}}},
+		loads:       []ServerLoad{{ThreadsRunning: 90, ThreadsConnected: 10}, {ThreadsRunning: 5, ThreadsConnected: 500}, {ThreadsRunning: 5, ThreadsConnected: 10}},
+	}
+	dumper := &fakeDumper{dumps: map[string]string{"shop.orders": ordersDump}}
+	cfg := testConfig(store, planner, dumper)
+	cfg.MaxThreadsRunning = 64
+	cfg.MaxThreadsConnected = 200
+	cfg.LoadCheckInterval = 10 * time.Millisecond
+
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if planner.samples != 3 {
+		t.Errorf("load sampled %d times, want 3 until both thresholds are met", planner.samples)
+	}
+	if m.LoadPausedSeconds < 0.02 {
+		t.Errorf("LoadPausedSeconds = %g, want at least two check intervals", m.LoadPausedSeconds)
+	}
+	if len(dumper.dumped) != 1 {
+		t.Errorf("dumped %v, want the table once the load dropped", dumper.dumped)
+	}
+}
+
+func TestLoadThrottleStopsWithContext(t *testing.T) {
+	planner := &loadPlanner{fakePlanner: &fakePlanner{}, loads: []ServerLoad{{ThreadsRunning: 90}}}
+	throttle := newLoadThrottle(planner, Config{MaxThreadsRunning: 64, LoadCheckInterval: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := throttle.wait(ctx); err == nil {
+		t.Errorf("wait() = nil, want the context's error while the server stays overloaded")
+	}
+}
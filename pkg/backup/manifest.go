@@ -0,0 +1,390 @@
+package backup
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Table statuses recorded in the manifest.
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	// StatusSkipped marks tables that could not be read when probed and
+	// were left out of the run.
+	StatusSkipped = "skipped"
+)
+
+const slowestTables = 5
+
+// TableResult is the outcome of backing up a single table.
+type TableResult struct {
+	Database string    `json:"database"`
+	Table    string    `json:"table"`
+	Type     string    `json:"type,omitempty"`
+	Engine   string    `json:"engine,omitempty"`
+	Object   string    `json:"object,omitempty"`
+	Shard    string    `json:"shard,omitempty"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+
+	DurationSeconds   float64 `json:"durationSeconds"`
+	UncompressedBytes int64   `json:"uncompressedBytes"`
+	CompressedBytes   int64   `json:"compressedBytes"`
+	ThroughputMBps    float64 `json:"throughputMBps"`
+	CompressionRatio  float64 `json:"compressionRatio"`
+	Rows              int64   `json:"rows"`
+	// ArchiveEntry is the entry of the dump in Object if it is the archive
+	// of the database, see Config.ArchiveFormat.
+	ArchiveEntry string `json:"archiveEntry,omitempty"`
+	// ReusedBytes is the part of CompressedBytes of a deduplicated dump in
+	// chunks that earlier runs had already stored.
+	ReusedBytes int64 `json:"reusedBytes,omitempty"`
+	// ApproximateRows is the row count the server estimated when the run
+	// enumerated the table, to compare Rows with.
+	ApproximateRows int64 `json:"approximateRows,omitempty"`
+
+	// Collation is the table's default collation, whose character set is
+	// that of its text columns unless they declare their own.
+	Collation string `json:"collation,omitempty"`
+	// SizeWarning is set for dumps that were suspiciously small, see
+	// Config.MinSizeRatio.
+	SizeWarning string `json:"sizeWarning,omitempty"`
+	// RowCountWarning is set for dumps whose rows do not match the row
+	// count of the table, see Config.RowCountCheck.
+	RowCountWarning string `json:"rowCountWarning,omitempty"`
+
+	Buckets []string `json:"buckets,omitempty"`
+	// Parts are the continuation objects of a dump that was rolled over to
+	// stay below the maximum object size, in order.
+	Parts []string `json:"parts,omitempty"`
+	// CRC32C and MD5 are the base64-encoded checksums of Object as reported
+	// by GCS, e.g. by gsutil hash, and SHA256 is its hex-encoded SHA-256 as
+	// printed by sha256sum. They are computed while the object is uploaded
+	// and checked against those GCS reports for it.
+	CRC32C string `json:"crc32c,omitempty"`
+	MD5    string `json:"md5,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// ObjectGeneration and Etag identify the version of Object that was
+	// written, so that a restore from a versioned bucket can read exactly
+	// that version even if the object was overwritten since.
+	ObjectGeneration int64  `json:"objectGeneration,omitempty"`
+	Etag             string `json:"etag,omitempty"`
+	// PartGenerations are the generations of Parts, in order.
+	PartGenerations []int64 `json:"partGenerations,omitempty"`
+	// Checksum is the result of CHECKSUM TABLE just before the dump, see
+	// Config.ChecksumTables.
+	Checksum string `json:"checksum,omitempty"`
+
+	// Partitions are set for tables dumped per partition, in which case
+	// Object holds only the table definition.
+	Partitions []PartitionResult `json:"partitions,omitempty"`
+}
+
+// PartitionResult describes the dump of one partition of a table.
+type PartitionResult struct {
+	Partition         string `json:"partition"`
+	Object            string `json:"object"`
+	UncompressedBytes int64  `json:"uncompressedBytes"`
+	CompressedBytes   int64  `json:"compressedBytes"`
+	Rows              int64  `json:"rows"`
+
+	ObjectGeneration int64  `json:"objectGeneration,omitempty"`
+	Etag             string `json:"etag,omitempty"`
+	CRC32C           string `json:"crc32c,omitempty"`
+	MD5              string `json:"md5,omitempty"`
+	SHA256           string `json:"sha256,omitempty"`
+
+	Parts           []string `json:"parts,omitempty"`
+	PartGenerations []int64  `json:"partGenerations,omitempty"`
+}
+
+func (r *TableResult) setStats(uncompressed int64, compressed int64) {
+	elapsed := r.Finished.Sub(r.Started)
+
+	r.DurationSeconds = elapsed.Seconds()
+	r.UncompressedBytes = uncompressed
+	r.CompressedBytes = compressed
+	r.ThroughputMBps = throughputMBps(uncompressed, elapsed)
+	r.CompressionRatio = compressionRatio(uncompressed, compressed)
+}
+
+func formatCRC32C(crc uint32) string {
+	return base64.StdEncoding.EncodeToString(binary.BigEndian.AppendUint32(nil, crc))
+}
+
+func formatMD5(sum []byte) string {
+	return base64.StdEncoding.EncodeToString(sum)
+}
+
+func formatSHA256(sum []byte) string {
+	return hex.EncodeToString(sum)
+}
+
+func compressionRatio(uncompressed int64, compressed int64) float64 {
+	if compressed <= 0 {
+		return 0
+	}
+	return float64(uncompressed) / float64(compressed)
+}
+
+// Manifest describes a backup run and is uploaded as manifest.json to the
+// run prefix.
+type Manifest struct {
+	mu sync.Mutex
+
+	RunID       string `json:"runID"`
+	Environment string `json:"environment,omitempty"`
+	Cluster     string `json:"cluster,omitempty"`
+	// Trigger records who or what started the run, see Config.Trigger.
+	Trigger *Trigger `json:"trigger,omitempty"`
+
+	// FormatVersion is the layout of the objects of the run, see
+	// FormatVersion.
+	FormatVersion int `json:"formatVersion"`
+
+	Version  string        `json:"version"`
+	Commit   string        `json:"commit"`
+	Hostname string        `json:"hostname"`
+	Path     string        `json:"path"`
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Tables   []TableResult `json:"tables"`
+	// Databases are set for runs dumping routines once per database.
+	Databases []DatabaseResult `json:"databases,omitempty"`
+	Errors    []string         `json:"errors,omitempty"`
+
+	UncompressedBytes int64   `json:"uncompressedBytes"`
+	CompressedBytes   int64   `json:"compressedBytes"`
+	CompressionRatio  float64 `json:"compressionRatio"`
+
+	CostEstimate *CostEstimate `json:"costEstimate,omitempty"`
+	// ServerInfo is the object of the run's ServerInfo snapshot, if any.
+	ServerInfo string `json:"serverInfo,omitempty"`
+	// Dictionary is the zstd dictionary the .sql.zst dumps of small tables
+	// are compressed with, see Config.DictionaryTableSize.
+	Dictionary string `json:"dictionary,omitempty"`
+	// Snapshot is set for runs dumping every table as of one point in time,
+	// see Config.ConsistentSnapshot.
+	Snapshot *Snapshot `json:"snapshot,omitempty"`
+	// Member is the replication group member the run dumped, see
+	// Config.SelectSecondary.
+	Member string `json:"member,omitempty"`
+	// Shards are set in the combined manifest of a sharded run, see
+	// Config.Shards, whose Tables are those of all shards with their Shard
+	// set.
+	Shards []ShardResult `json:"shards,omitempty"`
+	// LoadPausedSeconds is how long the run held back tables while the
+	// server was overloaded, see Config.MaxThreadsRunning.
+	LoadPausedSeconds float64 `json:"loadPausedSeconds,omitempty"`
+	// Storage counts the GCS API errors and retries of the run, if it wrote
+	// to GCS.
+	Storage *StorageStats `json:"storage,omitempty"`
+	// TableChanges are the tables that appeared or disappeared since the
+	// previous run, if any did.
+	TableChanges *TableChanges `json:"tableChanges,omitempty"`
+	// AutoIncrements are the AUTO_INCREMENT counters and sequences of each
+	// database, see Config.SkipAutoIncrements.
+	AutoIncrements []AutoIncrementReport `json:"autoIncrements,omitempty"`
+}
+
+func newManifest(hostname string, path string, started time.Time) *Manifest {
+	v, c, _ := buildVersion()
+
+	return &Manifest{
+		FormatVersion: FormatVersion,
+		Version:       v,
+		Commit:        c,
+		Hostname:      hostname,
+		Path:          path,
+		Started:       started,
+	}
+}
+
+func (m *Manifest) addTable(result TableResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Tables = append(m.Tables, result)
+	m.UncompressedBytes += result.UncompressedBytes
+	m.CompressedBytes += result.CompressedBytes
+	m.CompressionRatio = compressionRatio(m.UncompressedBytes, m.CompressedBytes)
+}
+
+// databaseTables returns the results of the tables of database so far.
+func (m *Manifest) databaseTables(database string) []TableResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tables []TableResult
+	for _, t := range m.Tables {
+		if t.Database == database {
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
+
+// failArchive marks the succeeded tables in archive as failed with err.
+func (m *Manifest) failArchive(archive string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, t := range m.Tables {
+		if t.Object == archive && t.Status == StatusSucceeded {
+			m.Tables[i].Status = StatusFailed
+			m.Tables[i].Error = err.Error()
+		}
+	}
+}
+
+func (m *Manifest) addError(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Errors = append(m.Errors, err.Error())
+}
+
+// smallTables returns the "database.table" names of the dumps flagged as
+// suspiciously small.
+func (m *Manifest) smallTables() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tables []string
+	for _, t := range m.Tables {
+		if t.SizeWarning != "" {
+			tables = append(tables, t.Database+"."+t.Table)
+		}
+	}
+	return tables
+}
+
+// rowCountTables returns the "database.table" names of the dumps whose rows
+// do not match the row count of their table.
+func (m *Manifest) rowCountTables() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tables []string
+	for _, t := range m.Tables {
+		if t.RowCountWarning != "" {
+			tables = append(tables, t.Database+"."+t.Table)
+		}
+	}
+	return tables
+}
+
+// FailedTables returns the number of tables that failed to back up.
+func (m *Manifest) FailedTables() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	failed := 0
+	for _, t := range m.Tables {
+		if t.Status == StatusFailed {
+			failed++
+		}
+	}
+	return failed
+}
+
+func (m *Manifest) logSummary() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.Tables {
+		if t.Status == StatusSkipped {
+			log.Printf("Skipped table \"%s.%s\": %s\n", t.Database, t.Table, t.Error)
+		}
+	}
+
+	elapsed := m.Finished.Sub(m.Started)
+	log.Printf("Run summary: %d tables in %s, %s dumped, %s compressed (ratio %.2f), %.1f MB/s\n",
+		len(m.Tables), elapsed.Round(time.Second), FormatBytes(m.UncompressedBytes),
+		FormatBytes(m.CompressedBytes), m.CompressionRatio, throughputMBps(m.UncompressedBytes, elapsed))
+
+	slowest := make([]TableResult, len(m.Tables))
+	copy(slowest, m.Tables)
+	sort.Slice(slowest, func(i, j int) bool {
+		return slowest[i].DurationSeconds > slowest[j].DurationSeconds
+	})
+	if len(slowest) > slowestTables {
+		slowest = slowest[:slowestTables]
+	}
+
+	if m.Storage != nil {
+		m.Storage.log()
+	}
+
+	for _, t := range slowest {
+		log.Printf("Slow table \"%s.%s\": %.1fs, %s dumped, %.1f MB/s\n",
+			t.Database, t.Table, t.DurationSeconds, FormatBytes(t.UncompressedBytes), t.ThroughputMBps)
+	}
+}
+
+// upload uploads the manifest and, with a signer, its signature. A manifest
+// whose signature failed to upload counts as not uploaded, as consumers
+// verifying it would reject it.
+func (m *Manifest) upload(ctx context.Context, uploader *Uploader, signer ManifestSigner) error {
+	m.mu.Lock()
+	data, err := json.MarshalIndent(m, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	if err := uploader.UploadObject(ctx, manifestName(m.Path), "application/json", data); err != nil {
+		return err
+	}
+	if signer == nil {
+		return nil
+	}
+	return uploadSignature(ctx, uploader, signer, m.Path, data)
+}
+
+func manifestName(path string) string {
+	return path + "/manifest.json"
+}
+
+// LoadManifest reads the manifest of the run stored under path, i.e.
+// <host>/<generation>. Manifests of older format versions are upgraded, and
+// those of newer ones fail with ErrUnsupportedFormat.
+func LoadManifest(ctx context.Context, store ObjectStore, path string) (*Manifest, error) {
+	name := manifestName(path)
+
+	reader, err := store.NewReader(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	var m Manifest
+	if err := json.NewDecoder(reader).Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	if err := m.upgrade(name); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// Table returns the result recorded for a table, if any.
+func (m *Manifest) Table(database string, table string) (TableResult, bool) {
+	for _, result := range m.Tables {
+		if result.Database == database && result.Table == table {
+			return result, true
+		}
+	}
+	return TableResult{}, false
+}
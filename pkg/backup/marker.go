@@ -0,0 +1,60 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Completion markers written to the run prefix as the last object of a run.
+const (
+	SuccessMarker = "_SUCCESS"
+	FailedMarker  = "_FAILED"
+)
+
+// RunningPrefix holds a marker <host>/_running/<run ID> for each
+// deduplicated run of a host in progress. Such a run may reuse a chunk
+// before it writes the index listing it, so CollectGarbage keeps the chunks
+// no index lists while there is one.
+const RunningPrefix = "_running"
+
+func runningMarker(host string, runID string) string {
+	return host + "/" + RunningPrefix + "/" + runID
+}
+
+type completionMarker struct {
+	RunID    string    `json:"runID"`
+	Status   string    `json:"status"`
+	Manifest string    `json:"manifest,omitempty"`
+	Finished time.Time `json:"finished"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// writeCompletionMarker writes SuccessMarker or FailedMarker to path,
+// depending on runErr, and deletes the other one, which an earlier run into
+// the same prefix may have left.
+func writeCompletionMarker(ctx context.Context, uploader *Uploader, runID string, path string, manifest bool, runErr error) error {
+	marker := completionMarker{RunID: runID, Status: StatusSucceeded, Finished: time.Now()}
+	if manifest {
+		marker.Manifest = manifestName(path)
+	}
+	name, stale := SuccessMarker, FailedMarker
+	if runErr != nil {
+		marker.Status = StatusFailed
+		marker.Error = runErr.Error()
+		name, stale = FailedMarker, SuccessMarker
+	}
+
+	if err := uploader.Store.Delete(ctx, path+"/"+stale); err != nil && !errors.Is(err, ErrObjectNotExist) {
+		return fmt.Errorf("failed to delete stale %s marker: %w", stale, err)
+	}
+
+	data, err := json.MarshalIndent(marker, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s marker: %w", name, err)
+	}
+
+	return uploader.UploadObject(ctx, path+"/"+name, "application/json", data)
+}
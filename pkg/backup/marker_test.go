@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRunWritesCompletionMarkerLast(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders", "users"}},
+	}
+
+	cfg := testConfig(store, planner, &fakeDumper{})
+	cfg.CompletionMarker = true
+	cfg.HTMLReport = true
+	m, err := Run(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	marker, err := store.Attrs(ctx, m.Path+"/"+SuccessMarker)
+	if err != nil {
+		t.Fatalf("no %s marker: %v", SuccessMarker, err)
+	}
+	objects, _ := store.List(ctx, m.Path+"/")
+	for _, attrs := range objects {
+		if attrs.Generation > marker.Generation {
+			t.Errorf("%s was written after the marker", attrs.Name)
+		}
+	}
+
+	var content completionMarker
+	data, _ := store.Data(m.Path + "/" + SuccessMarker)
+	if err := json.Unmarshal(data, &content); err != nil {
+		t.Fatal(err)
+	}
+	if content.RunID != m.RunID || content.Status != StatusSucceeded || content.Manifest != m.Path+"/manifest.json" {
+		t.Errorf("marker = %+v", content)
+	}
+
+	cfg = testConfig(store, planner, &fakeDumper{failed: map[string]error{"shop.users": errFake}})
+	cfg.CompletionMarker = true
+	failed, err := Run(ctx, cfg)
+	if err == nil {
+		t.Fatal("Run succeeded, want partial failure")
+	}
+	if _, err := store.Attrs(ctx, failed.Path+"/"+FailedMarker); err != nil {
+		t.Errorf("no %s marker: %v", FailedMarker, err)
+	}
+	if _, ok := store.Data(failed.Path + "/" + SuccessMarker); ok && failed.Path == m.Path {
+		t.Errorf("stale %s marker was not deleted", SuccessMarker)
+	}
+}
+
+func TestRunWritesFailedMarkerOnEnumerationFailure(t *testing.T) {
+	store := NewMemoryStore()
+	cfg := testConfig(store, &fakePlanner{databasesErr: errFake}, &fakeDumper{})
+	cfg.CompletionMarker = true
+	if _, err := Run(context.Background(), cfg); err == nil {
+		t.Fatal("Run succeeded, want enumeration failure")
+	}
+
+	objects, _ := store.List(context.Background(), "host/")
+	if len(objects) != 1 || !strings.HasSuffix(objects[0].Name, "/"+FailedMarker) {
+		t.Errorf("objects = %v, want only a %s marker", objects, FailedMarker)
+	}
+}
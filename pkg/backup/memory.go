@@ -0,0 +1,89 @@
+package backup
+
+import (
+	"context"
+	"log"
+
+	"golang.org/x/sync/semaphore"
+)
+
+const (
+	// DefaultChunkSize is the buffer size of a GCS upload unless memory is
+	// budgeted, that of the client library.
+	DefaultChunkSize = 16 << 20
+	// minChunkSize is the smallest chunk size of a resumable upload, whose
+	// chunks are multiples of 256 KiB.
+	minChunkSize = 256 << 10
+	// compressorMemory approximates the memory of a gzip writer and the
+	// buffers around it.
+	compressorMemory = 1 << 20
+)
+
+type chunkSizeKey struct{}
+
+// withChunkSize makes the GCS writers created with ctx buffer size bytes.
+func withChunkSize(ctx context.Context, size int) context.Context {
+	return context.WithValue(ctx, chunkSizeKey{}, size)
+}
+
+func chunkSizeFrom(ctx context.Context) (int, bool) {
+	size, ok := ctx.Value(chunkSizeKey{}).(int)
+	return size, ok
+}
+
+// storeBuckets returns the number of buckets each object is written to.
+func storeBuckets(store ObjectStore) int {
+	if mirror, ok := store.(*MirrorStore); ok {
+		return len(mirror.Stores)
+	}
+	return 1
+}
+
+// streamMemory estimates the memory buffered by one upload of u whose GCS
+// writers buffer chunk bytes.
+func (u *Uploader) streamMemory(chunk int) int64 {
+	buffers := int64(chunk) * int64(storeBuckets(u.Store))
+	memory := compressorMemory + buffers
+	if u.CompositePartSize > 0 {
+		parallelism := int64(u.compositeParallelism())
+		memory += int64(u.CompositePartSize)*(parallelism+1) + buffers*parallelism
+	}
+	return memory
+}
+
+// budgetMemory caps the memory buffered by the uploads of u at maxMemory.
+// The chunk buffers of GCS writers are shrunk for workers uploads to fit,
+// down to the smallest chunk size, and dumps that would exceed maxMemory
+// nevertheless wait for running ones to finish.
+func (u *Uploader) budgetMemory(maxMemory int64, workers int) {
+	chunk := DefaultChunkSize
+	for chunk > minChunkSize && int64(workers)*u.streamMemory(chunk) > maxMemory {
+		chunk /= 2
+	}
+
+	u.ChunkSize = chunk
+	u.streamBytes = u.streamMemory(chunk)
+	if u.streamBytes > maxMemory {
+		u.streamBytes = maxMemory
+	}
+	u.Memory = semaphore.NewWeighted(maxMemory)
+
+	concurrent := maxMemory / u.streamBytes
+	if concurrent < int64(workers) {
+		log.Printf("Memory budget of %s allows %d of %d concurrent dumps with %s upload chunks\n", FormatBytes(maxMemory), concurrent, workers, FormatBytes(int64(chunk)))
+	} else {
+		log.Printf("Memory budget of %s: %s upload chunks\n", FormatBytes(maxMemory), FormatBytes(int64(chunk)))
+	}
+}
+
+// reserveMemory waits until the memory budget allows another upload and
+// returns the function releasing the reservation.
+func (u *Uploader) reserveMemory(ctx context.Context) (release func(), err error) {
+	if u.Memory == nil {
+		return func() {}, nil
+	}
+	if err := u.Memory.Acquire(ctx, u.streamBytes); err != nil {
+		return nil, err
+	}
+	return func() { u.Memory.Release(u.streamBytes) }, nil
+}
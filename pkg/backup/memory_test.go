@@ -0,0 +1,52 @@
+package backup
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBudgetMemoryShrinksChunks(t *testing.T) {
+	u := &Uploader{Store: &MirrorStore{Stores: []ObjectStore{NewMemoryStore(), NewMemoryStore()}}}
+
+	u.budgetMemory(64<<20, 4)
+	if u.ChunkSize != 4<<20 {
+		t.Errorf("chunk size = %d, want %d", u.ChunkSize, 4<<20)
+	}
+	if u.streamBytes != compressorMemory+2*(4<<20) {
+		t.Errorf("stream memory = %d, want %d", u.streamBytes, compressorMemory+2*(4<<20))
+	}
+
+	u.budgetMemory(1<<20, 4)
+	if u.ChunkSize != minChunkSize {
+		t.Errorf("chunk size = %d, want %d", u.ChunkSize, minChunkSize)
+	}
+	if u.streamBytes != 1<<20 {
+		t.Errorf("stream memory = %d, want it capped at the budget", u.streamBytes)
+	}
+}
+
+func TestRunWaitsForMemory(t *testing.T) {
+	planner := &fakePlanner{
+		databases: []string{"a", "b"},
+		tables: map[string][]string{
+			"a": {"t1", "t2", "t3"},
+			"b": {"t1", "t2", "t3"},
+		},
+	}
+	dumper := &countingDumper{}
+
+	cfg := testConfig(NewMemoryStore(), planner, dumper)
+	cfg.Workers = 4
+	cfg.MaxMemory = 2 << 20
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if dumper.maxOpen != 1 {
+		t.Errorf("%d dumps ran at the same time, want 1", dumper.maxOpen)
+	}
+	if len(m.Tables) != 6 {
+		t.Errorf("backed up %d tables, want 6", len(m.Tables))
+	}
+}
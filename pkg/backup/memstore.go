@@ -0,0 +1,144 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory ObjectStore for tests and dry runs.
+type MemoryStore struct {
+	mu         sync.Mutex
+	objects    map[string]*memoryObject
+	generation int64
+}
+
+type memoryObject struct {
+	attrs ObjectAttrs
+	data  []byte
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{objects: map[string]*memoryObject{}}
+}
+
+// Data returns the content of an object and whether it exists.
+func (s *MemoryStore) Data(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	object, ok := s.objects[name]
+	if !ok {
+		return nil, false
+	}
+	return object.data, true
+}
+
+func (s *MemoryStore) NewWriter(ctx context.Context, name string, contentType string, metadata map[string]string) ObjectWriter {
+	return &memoryWriter{ctx: ctx, store: s, name: name, contentType: contentType, metadata: metadata}
+}
+
+func (s *MemoryStore) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	data, ok := s.Data(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotExist, name)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *MemoryStore) Attrs(ctx context.Context, name string) (*ObjectAttrs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	object, ok := s.objects[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotExist, name)
+	}
+	attrs := object.attrs
+	return &attrs, nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, prefix string) ([]ObjectAttrs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var objects []ObjectAttrs
+	for name, object := range s.objects {
+		if strings.HasPrefix(name, prefix) {
+			objects = append(objects, object.attrs)
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Name < objects[j].Name
+	})
+
+	return objects, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.objects[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrObjectNotExist, name)
+	}
+	delete(s.objects, name)
+	return nil
+}
+
+type memoryWriter struct {
+	ctx         context.Context
+	store       *MemoryStore
+	name        string
+	contentType string
+	metadata    map[string]string
+	buf         bytes.Buffer
+}
+
+func (w *memoryWriter) Write(b []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.buf.Write(b)
+}
+
+func (w *memoryWriter) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+
+	w.store.generation++
+	w.store.objects[w.name] = &memoryObject{
+		attrs: ObjectAttrs{
+			Name:        w.name,
+			Size:        int64(w.buf.Len()),
+			ContentType: w.contentType,
+			Metadata:    w.metadata,
+			Generation:  w.store.generation,
+			Etag:        fmt.Sprintf("%x", w.store.generation),
+			Created:     time.Now(),
+			CRC32C:      crc32.Checksum(w.buf.Bytes(), crc32.MakeTable(crc32.Castagnoli)),
+			MD5:         md5sum(w.buf.Bytes()),
+		},
+		data: w.buf.Bytes(),
+	}
+
+	return nil
+}
+
+func md5sum(data []byte) []byte {
+	sum := md5.Sum(data)
+	return sum[:]
+}
@@ -0,0 +1,128 @@
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultMessageTemplate renders BACKUP_MESSAGE unless Hooks.MessageTemplate
+// is set.
+const DefaultMessageTemplate = `{{if eq .Stage "tables-changed" -}}
+Tables of {{.Hostname}} changed in run {{.RunID}}:{{if .TablesAdded}} {{join .TablesAdded ", "}} appeared{{end}}{{if .TablesRemoved}}{{if .TablesAdded}};{{end}} {{join .TablesRemoved ", "}} disappeared{{end}}
+{{- else -}}
+Backup run {{.RunID}} of {{.Hostname}} {{.Status}}: {{.Tables}} tables, {{.FailedTables}} failed, {{bytes .CompressedBytes}} compressed in {{.Duration}}{{if .Error}}: {{.Error}}{{end}}
+{{- end}}`
+
+// RunSummary is what message templates are executed with.
+type RunSummary struct {
+	Stage       string
+	RunID       string
+	Environment string
+	Cluster     string
+	Hostname    string
+	Path        string
+	// Status is StatusSucceeded or StatusFailed, and Error the error of a
+	// failed run.
+	Status   string
+	Error    string
+	Started  time.Time
+	Finished time.Time
+	Duration time.Duration
+
+	Tables            int
+	FailedTables      int
+	UncompressedBytes int64
+	CompressedBytes   int64
+	// SmallTables, RowCountTables, TablesAdded and TablesRemoved are
+	// "database.table" names, see BACKUP_SMALL_TABLES,
+	// BACKUP_ROW_COUNT_TABLES and TableChanges.
+	SmallTables    []string
+	RowCountTables []string
+	TablesAdded    []string
+	TablesRemoved  []string
+}
+
+// messageFuncs are the functions available to message templates besides
+// the builtins.
+var messageFuncs = template.FuncMap{
+	"bytes": FormatBytes,
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"time":  func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}
+
+// ParseMessageTemplate parses a text/template rendering the BACKUP_MESSAGE
+// of hooks from a RunSummary, e.g. the body of a Slack message or an email.
+// The template is executed once with an empty summary, so that references
+// to fields that do not exist fail here rather than in runs.
+func ParseMessageTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("message").Funcs(messageFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, RunSummary{}); err != nil {
+		return nil, fmt.Errorf("invalid message template: %w", err)
+	}
+	return tmpl, nil
+}
+
+var defaultMessageTemplate = template.Must(ParseMessageTemplate(DefaultMessageTemplate))
+
+// summary returns the RunSummary of the run at stage, which failed with
+// runErr if it is not nil.
+func (m *Manifest) summary(stage string, runErr error) RunSummary {
+	s := RunSummary{
+		Stage:          stage,
+		RunID:          m.RunID,
+		Environment:    m.Environment,
+		Cluster:        m.Cluster,
+		Hostname:       m.Hostname,
+		Path:           m.Path,
+		Status:         StatusSucceeded,
+		Started:        m.Started,
+		Finished:       m.Finished,
+		FailedTables:   m.FailedTables(),
+		SmallTables:    m.smallTables(),
+		RowCountTables: m.rowCountTables(),
+	}
+	if runErr != nil {
+		s.Status = StatusFailed
+		s.Error = runErr.Error()
+	}
+	finished := m.Finished
+	if finished.IsZero() {
+		finished = time.Now()
+	}
+	s.Duration = finished.Sub(m.Started).Round(time.Second)
+
+	m.mu.Lock()
+	s.Tables = len(m.Tables)
+	s.UncompressedBytes = m.UncompressedBytes
+	s.CompressedBytes = m.CompressedBytes
+	m.mu.Unlock()
+	if m.TableChanges != nil {
+		s.TablesAdded = m.TableChanges.Added
+		s.TablesRemoved = m.TableChanges.Removed
+	}
+	return s
+}
+
+// renderMessage executes tmpl, or DefaultMessageTemplate if it is nil, with
+// summary. A failure is logged and falls back to DefaultMessageTemplate.
+func renderMessage(tmpl *template.Template, summary RunSummary) string {
+	if tmpl == nil {
+		tmpl = defaultMessageTemplate
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, summary); err != nil {
+		log.Printf("Failed to render the message template, using the default: %v\n", err)
+		buf.Reset()
+		defaultMessageTemplate.Execute(&buf, summary)
+	}
+	return strings.TrimRight(buf.String(), "\n")
+}
@@ -0,0 +1,64 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMessageTemplate(t *testing.T) {
+	if _, err := ParseMessageTemplate("{{.RunID}} {{bytes .CompressedBytes}} {{join .SmallTables \",\"}}"); err != nil {
+		t.Errorf("ParseMessageTemplate failed: %v", err)
+	}
+	for _, text := range []string{"{{.RunID", "{{.NoSuchField}}", "{{nosuchfunc .RunID}}"} {
+		if _, err := ParseMessageTemplate(text); err == nil {
+			t.Errorf("ParseMessageTemplate(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+func TestRenderDefaultMessage(t *testing.T) {
+	started := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := &Manifest{RunID: "run", Hostname: "host", Started: started, Finished: started.Add(90 * time.Second), CompressedBytes: 2048,
+		Tables: []TableResult{{Status: StatusSucceeded}, {Status: StatusFailed}}}
+
+	got := renderMessage(nil, m.summary("post-run", errors.New("1 table(s) failed")))
+	if want := "Backup run run of host failed: 2 tables, 1 failed, 2.0 KiB compressed in 1m30s: 1 table(s) failed"; got != want {
+		t.Errorf("post-run message = %q, want %q", got, want)
+	}
+
+	m.TableChanges = &TableChanges{Added: []string{"shop.payments"}, Removed: []string{"shop.carts"}}
+	got = renderMessage(nil, m.summary("tables-changed", nil))
+	if want := "Tables of host changed in run run: shop.payments appeared; shop.carts disappeared"; got != want {
+		t.Errorf("tables-changed message = %q, want %q", got, want)
+	}
+}
+
+func TestPostRunHookReceivesMessage(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "message")
+	tmpl, err := ParseMessageTemplate("{{upper .Status}} {{.Hostname}}\n{{range .SmallTables}}- {{.}}\n{{end}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig(NewMemoryStore(), &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders"}},
+	}, &fakeDumper{})
+	cfg.Hooks = Hooks{PostRun: `printf '%s' "$BACKUP_MESSAGE" > ` + out, MessageTemplate: tmpl}
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("post-run hook did not run: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "SUCCEEDED host" {
+		t.Errorf("BACKUP_MESSAGE = %q, want %q", got, "SUCCEEDED host")
+	}
+}
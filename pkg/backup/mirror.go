@@ -0,0 +1,111 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MirrorStore writes every object to all of its stores, e.g. buckets in
+// different regions. A write succeeds only once all stores have accepted it.
+// Reads are served by the first store holding the object.
+type MirrorStore struct {
+	Stores []ObjectStore
+}
+
+// NewMirrorStore returns a MirrorStore writing to all stores.
+func NewMirrorStore(stores ...ObjectStore) *MirrorStore {
+	return &MirrorStore{Stores: stores}
+}
+
+func (s *MirrorStore) NewWriter(ctx context.Context, name string, contentType string, metadata map[string]string) ObjectWriter {
+	writers := make([]ObjectWriter, len(s.Stores))
+	for i, store := range s.Stores {
+		writers[i] = store.NewWriter(ctx, name, contentType, metadata)
+	}
+	return &mirrorWriter{writers: writers}
+}
+
+func (s *MirrorStore) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	var err error
+	for _, store := range s.Stores {
+		var reader io.ReadCloser
+		if reader, err = store.NewReader(ctx, name); !errors.Is(err, ErrObjectNotExist) {
+			return reader, err
+		}
+	}
+	return nil, err
+}
+
+// Attrs returns the attributes of the object in the first store and fails
+// if any store is missing it, so an upload is only confirmed once every
+// copy exists.
+func (s *MirrorStore) Attrs(ctx context.Context, name string) (*ObjectAttrs, error) {
+	var first *ObjectAttrs
+	for i, store := range s.Stores {
+		attrs, err := store.Attrs(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("mirror %d: %w", i, err)
+		}
+		if first == nil {
+			first = attrs
+		}
+	}
+	return first, nil
+}
+
+func (s *MirrorStore) List(ctx context.Context, prefix string) ([]ObjectAttrs, error) {
+	return s.Stores[0].List(ctx, prefix)
+}
+
+func (s *MirrorStore) Delete(ctx context.Context, name string) error {
+	var errs []error
+	missing := 0
+	for _, store := range s.Stores {
+		err := store.Delete(ctx, name)
+		if errors.Is(err, ErrObjectNotExist) {
+			missing++
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if missing == len(s.Stores) {
+		return fmt.Errorf("%w: %s", ErrObjectNotExist, name)
+	}
+	return errors.Join(errs...)
+}
+
+type mirrorWriter struct {
+	writers []ObjectWriter
+}
+
+func (w *mirrorWriter) Write(p []byte) (int, error) {
+	for i, writer := range w.writers {
+		if _, err := writer.Write(p); err != nil {
+			return 0, fmt.Errorf("mirror %d: %w", i, err)
+		}
+	}
+	return len(p), nil
+}
+
+func (w *mirrorWriter) Close() error {
+	var errs []error
+	for i, writer := range w.writers {
+		if err := writer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("mirror %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (w *mirrorWriter) Buckets() []string {
+	var buckets []string
+	for _, writer := range w.writers {
+		buckets = append(buckets, writerBuckets(writer)...)
+	}
+	return buckets
+}
@@ -0,0 +1,37 @@
+package backup
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMirrorStoreWritesAllStores(t *testing.T) {
+	ctx := context.Background()
+	first, second := NewMemoryStore(), NewMemoryStore()
+	store := NewMirrorStore(first, second)
+
+	if err := (&Uploader{Store: store}).UploadObject(ctx, "a", "text/plain", []byte("data")); err != nil {
+		t.Fatalf("UploadObject failed: %v", err)
+	}
+	for i, s := range []*MemoryStore{first, second} {
+		if data, ok := s.Data("a"); !ok || string(data) != "data" {
+			t.Errorf("store %d has %q, %v", i, data, ok)
+		}
+	}
+
+	if err := second.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Attrs(ctx, "a"); err == nil {
+		t.Errorf("Attrs succeeded with a missing mirror copy")
+	}
+}
+
+func TestMirrorStoreFailsWhenAMirrorFails(t *testing.T) {
+	first := NewMemoryStore()
+	store := NewMirrorStore(first, brokenStore{NewMemoryStore()})
+
+	if err := (&Uploader{Store: store}).UploadObject(context.Background(), "a", "", []byte("data")); err == nil {
+		t.Fatalf("UploadObject succeeded with a broken mirror")
+	}
+}
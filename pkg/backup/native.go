@@ -0,0 +1,664 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// NativeDumper dumps table data with SELECT queries over a MySQL connection
+// instead of mysqldump. Its output has the format of mysqldump
+// --no-create-info, with multiple rows per INSERT and binary columns as
+// hexadecimal literals as DumpOptions select, and values of TIMESTAMP columns
+// in UTC. It can dump a single partition of a table, which mysqldump cannot.
+type NativeDumper struct {
+	Connection Connection
+	// Definitions adds the table or view definition and the triggers of the
+	// table and, with DumpOptions.Routines, the routines and events of the
+	// database, so that the dumps need no mysqldump binary. Dumps of a
+	// partition never include them.
+	Definitions bool
+
+	// snapshot, if set, provides the connections of dumps, see
+	// Config.ConsistentSnapshot.
+	snapshot *snapshotPool
+
+	lazyDB
+}
+
+// Dump selects the rows of the table, or of opts.Partition, and returns them
+// as INSERT statements.
+func (d *NativeDumper) Dump(ctx context.Context, database string, table string, opts DumpOptions) (io.ReadCloser, error) {
+	definitions := d.Definitions && opts.Partition == ""
+	if table == "" && !(definitions && opts.Routines) {
+		return nil, fmt.Errorf("nothing to dump for database %s", database)
+	}
+
+	conn, err := d.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if d.snapshot != nil && opts.LockTables {
+		// LOCK TABLES would commit the transaction of the snapshot.
+		log.Printf("Table \"%s.%s\" uses a non-transactional engine and is dumped as of its dump rather than of the consistent snapshot\n", database, table)
+		opts.LockTables = false
+	}
+
+	n := &nativeTableDump{conn: conn, snapshot: d.snapshot, database: database, table: table, opts: opts, definitions: definitions, charset: d.Connection.charset()}
+	if err := n.start(ctx); err != nil {
+		n.finish()
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	dump := &nativeDump{PipeReader: pr, done: make(chan error, 1)}
+
+	go func() {
+		err := n.write(ctx, pw)
+		if finishErr := n.finish(); err == nil {
+			err = finishErr
+		}
+		pw.CloseWithError(err)
+		dump.done <- err
+	}()
+
+	return dump, nil
+}
+
+// conn returns the connection of a dump, which holds its session time zone
+// and table lock until the dump is done.
+func (d *NativeDumper) conn(ctx context.Context) (*sql.Conn, error) {
+	if d.snapshot != nil {
+		return d.snapshot.acquire(ctx)
+	}
+
+	db, err := d.get(d.Connection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+	return conn, nil
+}
+
+// nativeTableDump is the state of a dump by NativeDumper.
+type nativeTableDump struct {
+	conn        *sql.Conn
+	snapshot    *snapshotPool
+	database    string
+	table       string
+	opts        DumpOptions
+	definitions bool
+	charset     string
+
+	version string
+	// create is the definition of the table, or of the view if view is set.
+	create string
+	view   bool
+	locked bool
+	rows   *sql.Rows
+	// columns are the columns of rows, and types their database type
+	// names.
+	columns []string
+	types   []string
+}
+
+// start prepares the session and runs the queries whose failure fails Dump
+// itself rather than the read of the dump.
+func (n *nativeTableDump) start(ctx context.Context) error {
+	if _, err := n.conn.ExecContext(ctx, "SET time_zone = '+00:00'"); err != nil {
+		return fmt.Errorf("failed to set the session time zone: %w", err)
+	}
+
+	if n.definitions {
+		if err := n.conn.QueryRowContext(ctx, "SELECT VERSION()").Scan(&n.version); err != nil {
+			return fmt.Errorf("failed to query the server version: %w", err)
+		}
+		if n.table != "" {
+			if err := n.showCreateTable(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	if n.table == "" || n.view {
+		return nil
+	}
+
+	if n.opts.LockTables {
+		if _, err := n.conn.ExecContext(ctx, "LOCK TABLES "+quoteTable(n.database, n.table)+" READ"); err != nil {
+			return fmt.Errorf("failed to lock table \"%s.%s\": %w", n.database, n.table, err)
+		}
+		n.locked = true
+	}
+	if n.opts.NoData && n.definitions {
+		return nil
+	}
+
+	columns, err := n.dumpedColumns(ctx)
+	if err != nil {
+		return err
+	}
+	n.columns = columns
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = quoteIdentifier(column)
+	}
+	query := "SELECT " + strings.Join(quoted, ", ") + " FROM " + quoteTable(n.database, n.table)
+	if n.opts.Partition != "" {
+		query += " PARTITION (" + quoteIdentifier(n.opts.Partition) + ")"
+	}
+	var args []any
+	if r := n.opts.Range; r != nil {
+		var conditions []string
+		if r.After != nil {
+			conditions = append(conditions, quoteIdentifier(r.Column)+" > ?")
+			args = append(args, r.After.arg())
+		}
+		if r.Through != nil {
+			conditions = append(conditions, quoteIdentifier(r.Column)+" <= ?")
+			args = append(args, r.Through.arg())
+		}
+		if len(conditions) > 0 {
+			query += " WHERE " + strings.Join(conditions, " AND ")
+		}
+	}
+
+	rows, err := n.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to select from table \"%s.%s\": %w", n.database, n.table, err)
+	}
+	n.rows = rows
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return fmt.Errorf("failed to get columns of table \"%s.%s\": %w", n.database, n.table, err)
+	}
+	for _, column := range columnTypes {
+		n.types = append(n.types, column.DatabaseTypeName())
+	}
+	return nil
+}
+
+// dumpedColumns returns the columns of the table whose values are dumped,
+// in order.
+func (n *nativeTableDump) dumpedColumns(ctx context.Context) ([]string, error) {
+	rows, err := n.conn.QueryContext(ctx, "SELECT column_name, extra FROM information_schema.columns WHERE table_schema = ? AND table_name = ? ORDER BY ordinal_position", n.database, n.table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the columns of table \"%s.%s\": %w", n.database, n.table, err)
+	}
+	defer rows.Close()
+
+	var columns []tableColumn
+	for rows.Next() {
+		var column tableColumn
+		if err := rows.Scan(&column.name, &column.extra); err != nil {
+			return nil, fmt.Errorf("failed to read the columns of table \"%s.%s\": %w", n.database, n.table, err)
+		}
+		columns = append(columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the columns of table \"%s.%s\": %w", n.database, n.table, err)
+	}
+
+	dumped := storedColumns(columns)
+	if len(dumped) == 0 {
+		return nil, fmt.Errorf("table \"%s.%s\" has no columns to dump", n.database, n.table)
+	}
+	return dumped, nil
+}
+
+// tableColumn is a column of information_schema.columns.
+type tableColumn struct {
+	name  string
+	extra string
+}
+
+// storedColumns returns the names of the columns that an INSERT can set.
+// Generated columns, which reject values, are left out, while INVISIBLE
+// ones, which SELECT * leaves out, are kept.
+func storedColumns(columns []tableColumn) []string {
+	var names []string
+	for _, column := range columns {
+		// MySQL reports VIRTUAL GENERATED and STORED GENERATED, MariaDB
+		// also PERSISTENT GENERATED, while DEFAULT_GENERATED only marks an
+		// expression default.
+		extra := strings.ToUpper(column.extra)
+		if strings.Contains(extra, "VIRTUAL GENERATED") || strings.Contains(extra, "STORED GENERATED") || strings.Contains(extra, "PERSISTENT GENERATED") {
+			continue
+		}
+		names = append(names, column.name)
+	}
+	return names
+}
+
+// showCreateTable reads the definition of the table, which SHOW CREATE
+// TABLE returns with four columns instead of two for a view.
+func (n *nativeTableDump) showCreateTable(ctx context.Context) error {
+	rows, err := n.conn.QueryContext(ctx, "SHOW CREATE TABLE "+quoteTable(n.database, n.table))
+	if err != nil {
+		return fmt.Errorf("failed to show the definition of table \"%s.%s\": %w", n.database, n.table, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("failed to show the definition of table \"%s.%s\": %w", n.database, n.table, err)
+	}
+	values := make([]sql.NullString, len(columns))
+	scan := make([]any, len(columns))
+	for i := range values {
+		scan[i] = &values[i]
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to show the definition of table \"%s.%s\": %w", n.database, n.table, err)
+		}
+		return fmt.Errorf("table \"%s.%s\" has no definition", n.database, n.table)
+	}
+	if err := rows.Scan(scan...); err != nil || len(values) < 2 {
+		return fmt.Errorf("failed to show the definition of table \"%s.%s\": %w", n.database, n.table, err)
+	}
+
+	n.create = values[1].String
+	n.view = len(values) == 4
+	return rows.Close()
+}
+
+// finish closes the rows, lock and connection of the dump, or returns the
+// connection to its snapshot.
+func (n *nativeTableDump) finish() error {
+	var err error
+	if n.rows != nil {
+		err = n.rows.Close()
+	}
+	if n.locked {
+		if _, unlockErr := n.conn.ExecContext(context.Background(), "UNLOCK TABLES"); err == nil {
+			err = unlockErr
+		}
+		n.locked = false
+	}
+	if n.snapshot != nil {
+		n.snapshot.release(n.conn)
+		return err
+	}
+	if closeErr := n.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (n *nativeTableDump) write(ctx context.Context, w io.Writer) error {
+	writer := bufio.NewWriterSize(w, chunkSize)
+
+	if n.definitions {
+		fmt.Fprintf(writer, "-- Native dump of database %s\n--\n-- Server version\t%s\n\n", quoteIdentifier(n.database), n.version)
+	}
+	fmt.Fprintf(writer, "/*!40101 SET NAMES %s */;\n/*!40103 SET TIME_ZONE='+00:00' */;\n", n.charset)
+
+	switch {
+	case n.view:
+		fmt.Fprintf(writer, "\n--\n-- View structure for view %s\n--\n\nDROP VIEW IF EXISTS %s;\n%s;\n", quoteIdentifier(n.table), quoteIdentifier(n.table), n.create)
+	case n.create != "":
+		fmt.Fprintf(writer, "\n--\n-- Table structure for table %s\n--\n\nDROP TABLE IF EXISTS %s;\n%s;\n", quoteIdentifier(n.table), quoteIdentifier(n.table), n.create)
+	}
+
+	if n.rows != nil {
+		if err := writeInserts(writer, n.table, n.opts, n.columns, n.types, n.rows); err != nil {
+			return err
+		}
+		// The connection cannot run the queries below while rows are open.
+		if err := n.rows.Close(); err != nil {
+			return err
+		}
+	}
+
+	if n.definitions && n.create != "" && !n.view && !n.opts.SkipTriggers {
+		if err := n.writeTriggers(ctx, writer); err != nil {
+			return err
+		}
+	}
+	if n.definitions && n.opts.Routines {
+		if err := n.writeRoutines(ctx, writer); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(writer, "\n-- Dump completed on %s\n", time.Now().Format("2006-01-02 15:04:05"))
+
+	return writer.Flush()
+}
+
+func (n *nativeTableDump) writeTriggers(ctx context.Context, w *bufio.Writer) error {
+	names, err := n.queryNames(ctx, "SELECT TRIGGER_NAME FROM information_schema.TRIGGERS WHERE EVENT_OBJECT_SCHEMA = ? AND EVENT_OBJECT_TABLE = ? ORDER BY EVENT_MANIPULATION, ACTION_TIMING, ACTION_ORDER", n.database, n.table)
+	if err != nil {
+		return fmt.Errorf("failed to list triggers of table \"%s.%s\": %w", n.database, n.table, err)
+	}
+	for _, name := range names {
+		d, err := n.showCreate(ctx, "TRIGGER", name, "SQL Original Statement")
+		if err != nil {
+			return err
+		}
+		writeCompound(w, "", d)
+	}
+	return nil
+}
+
+// writeRoutines writes the stored procedures and functions of the database
+// and, for a dump of the database alone, its events.
+func (n *nativeTableDump) writeRoutines(ctx context.Context, w *bufio.Writer) error {
+	rows, err := n.conn.QueryContext(ctx, "SELECT ROUTINE_TYPE, ROUTINE_NAME FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = ? ORDER BY ROUTINE_TYPE, ROUTINE_NAME", n.database)
+	if err != nil {
+		return fmt.Errorf("failed to list routines of database %s: %w", n.database, err)
+	}
+	type routine struct{ kind, name string }
+	var routines []routine
+	for rows.Next() {
+		var r routine
+		if err := rows.Scan(&r.kind, &r.name); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to list routines of database %s: %w", n.database, err)
+		}
+		routines = append(routines, r)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("failed to list routines of database %s: %w", n.database, err)
+	}
+
+	if len(routines) > 0 {
+		fmt.Fprintf(w, "\n--\n-- Dumping routines for database '%s'\n--\n", n.database)
+	}
+	for _, r := range routines {
+		column := "Create Procedure"
+		if r.kind == "FUNCTION" {
+			column = "Create Function"
+		}
+		d, err := n.showCreate(ctx, r.kind, r.name, column)
+		if err != nil {
+			return err
+		}
+		writeCompound(w, fmt.Sprintf("/*!50003 DROP %s IF EXISTS %s */;\n", r.kind, quoteIdentifier(r.name)), d)
+	}
+
+	if n.table != "" {
+		return nil
+	}
+	events, err := n.queryNames(ctx, "SELECT EVENT_NAME FROM information_schema.EVENTS WHERE EVENT_SCHEMA = ? ORDER BY EVENT_NAME", n.database)
+	if err != nil {
+		return fmt.Errorf("failed to list events of database %s: %w", n.database, err)
+	}
+	if len(events) > 0 {
+		fmt.Fprintf(w, "\n--\n-- Dumping events for database '%s'\n--\n", n.database)
+	}
+	for _, name := range events {
+		d, err := n.showCreate(ctx, "EVENT", name, "Create Event")
+		if err != nil {
+			return err
+		}
+		writeCompound(w, fmt.Sprintf("/*!50106 DROP EVENT IF EXISTS %s */;\n", quoteIdentifier(name)), d)
+	}
+	return nil
+}
+
+func (n *nativeTableDump) queryNames(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := n.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// definition is a trigger, routine or event as SHOW CREATE returns it.
+type definition struct {
+	statement string
+	sqlMode   string
+	// timeZone is only set for events.
+	timeZone  string
+	charset   string
+	collation string
+}
+
+// showCreate runs SHOW CREATE of an object whose statement is in column.
+// The statement is NULL when the account may not read the definition.
+func (n *nativeTableDump) showCreate(ctx context.Context, kind string, name string, column string) (definition, error) {
+	rows, err := n.conn.QueryContext(ctx, fmt.Sprintf("SHOW CREATE %s %s", kind, quoteTable(n.database, name)))
+	if err != nil {
+		return definition{}, fmt.Errorf("failed to show the definition of %s %s.%s: %w", strings.ToLower(kind), n.database, name, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return definition{}, fmt.Errorf("failed to show the definition of %s %s.%s: %w", strings.ToLower(kind), n.database, name, err)
+	}
+	values := make([]sql.NullString, len(columns))
+	scan := make([]any, len(columns))
+	for i := range values {
+		scan[i] = &values[i]
+	}
+	if !rows.Next() {
+		return definition{}, fmt.Errorf("failed to show the definition of %s %s.%s: %w", strings.ToLower(kind), n.database, name, errors.Join(rows.Err(), sql.ErrNoRows))
+	}
+	if err := rows.Scan(scan...); err != nil {
+		return definition{}, fmt.Errorf("failed to show the definition of %s %s.%s: %w", strings.ToLower(kind), n.database, name, err)
+	}
+
+	var d definition
+	for i, c := range columns {
+		switch c {
+		case "sql_mode":
+			d.sqlMode = values[i].String
+		case "time_zone":
+			d.timeZone = values[i].String
+		case "character_set_client":
+			d.charset = values[i].String
+		case "collation_connection":
+			d.collation = values[i].String
+		case column:
+			if !values[i].Valid {
+				return definition{}, fmt.Errorf("no privilege to read the definition of %s %s.%s", strings.ToLower(kind), n.database, name)
+			}
+			d.statement = values[i].String
+		}
+	}
+	return d, nil
+}
+
+type nativeDump struct {
+	*io.PipeReader
+	done chan error
+}
+
+func (d *nativeDump) Close() error {
+	d.PipeReader.Close()
+	if err := <-d.done; err != nil {
+		return fmt.Errorf("failed to dump rows: %w", err)
+	}
+	return nil
+}
+
+// writeCompound writes the statement of a trigger, routine or event with
+// the sql_mode, character set and time zone it was created with, between
+// DELIMITER lines so that the mysql client passes a body with semicolons
+// whole. drop precedes the statement.
+func writeCompound(w *bufio.Writer, drop string, d definition) {
+	w.WriteString("\n")
+	w.WriteString(drop)
+	fmt.Fprintf(w, "/*!50003 SET @saved_cs_client = @@character_set_client */ ;\n")
+	fmt.Fprintf(w, "/*!50003 SET @saved_col_connection = @@collation_connection */ ;\n")
+	fmt.Fprintf(w, "/*!50003 SET @saved_sql_mode = @@sql_mode */ ;\n")
+	if d.charset != "" {
+		fmt.Fprintf(w, "/*!50003 SET character_set_client = %s */ ;\n", d.charset)
+	}
+	if d.collation != "" {
+		fmt.Fprintf(w, "/*!50003 SET collation_connection = %s */ ;\n", d.collation)
+	}
+	fmt.Fprintf(w, "/*!50003 SET sql_mode = '%s' */ ;\n", d.sqlMode)
+	if d.timeZone != "" {
+		fmt.Fprintf(w, "/*!50003 SET @saved_time_zone = @@time_zone */ ;\n/*!50003 SET time_zone = '%s' */ ;\n", d.timeZone)
+	}
+	fmt.Fprintf(w, "DELIMITER ;;\n%s ;;\nDELIMITER ;\n", d.statement)
+	if d.timeZone != "" {
+		fmt.Fprintf(w, "/*!50003 SET time_zone = @saved_time_zone */ ;\n")
+	}
+	fmt.Fprintf(w, "/*!50003 SET sql_mode = @saved_sql_mode */ ;\n")
+	fmt.Fprintf(w, "/*!50003 SET character_set_client = @saved_cs_client */ ;\n")
+	fmt.Fprintf(w, "/*!50003 SET collation_connection = @saved_col_connection */ ;\n")
+}
+
+// extendedInsertSize is about the length after which an INSERT of multiple
+// rows is ended, like the default net_buffer_length of mysqldump.
+const extendedInsertSize = 1 << 20
+
+// scannedRows are the rows of a query, as sql.Rows reads them.
+type scannedRows interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+}
+
+// writeInserts writes rows of the given columns as INSERT statements.
+func writeInserts(writer *bufio.Writer, table string, opts DumpOptions, columns []string, types []string, rows scannedRows) error {
+	header := "\n--\n-- Dumping data for table " + quoteIdentifier(table)
+	if opts.Partition != "" {
+		header += " partition " + quoteIdentifier(opts.Partition)
+	}
+	fmt.Fprintf(writer, "%s\n--\n\n", header)
+
+	kinds := make([]valueKind, len(types))
+	for i, databaseType := range types {
+		kinds[i] = columnKind(databaseType)
+		// BIT values stay hexadecimal, as no string literal holds them.
+		if kinds[i] == binaryValue && opts.NoHexBlob && databaseType != "BIT" {
+			kinds[i] = stringValue
+		}
+	}
+
+	values := make([]sql.RawBytes, len(types))
+	scan := make([]any, len(types))
+	for i := range values {
+		scan[i] = &values[i]
+	}
+
+	// The column list keeps the values of INVISIBLE columns, which an
+	// INSERT without one does not set.
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = quoteIdentifier(column)
+	}
+	insert := "INSERT INTO " + quoteIdentifier(table) + " (" + strings.Join(quoted, ",") + ") VALUES "
+	// statement is the approximate length of the INSERT being written, 0
+	// if none is.
+	statement := 0
+	for rows.Next() {
+		if err := rows.Scan(scan...); err != nil {
+			return err
+		}
+
+		if statement > 0 && (!opts.ExtendedInsert || statement >= extendedInsertSize) {
+			writer.WriteString(";\n")
+			statement = 0
+		}
+		if statement == 0 {
+			writer.WriteString(insert)
+			statement = len(insert)
+		} else {
+			writer.WriteByte(',')
+		}
+		writer.WriteByte('(')
+		for i, value := range values {
+			if i > 0 {
+				writer.WriteByte(',')
+			}
+			writeValue(writer, kinds[i], value)
+			statement += 2*len(value) + 3
+		}
+		if err := writer.WriteByte(')'); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if statement > 0 {
+		_, err := writer.WriteString(";\n")
+		return err
+	}
+	return nil
+}
+
+type valueKind int
+
+const (
+	stringValue valueKind = iota
+	numericValue
+	binaryValue
+)
+
+func columnKind(databaseType string) valueKind {
+	switch strings.TrimPrefix(databaseType, "UNSIGNED ") {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "DECIMAL", "FLOAT", "DOUBLE", "YEAR":
+		return numericValue
+	case "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "BIT", "GEOMETRY":
+		return binaryValue
+	}
+	return stringValue
+}
+
+func writeValue(w *bufio.Writer, kind valueKind, value sql.RawBytes) {
+	switch {
+	case value == nil:
+		w.WriteString("NULL")
+	case kind == numericValue:
+		w.Write(value)
+	case kind == binaryValue && len(value) > 0:
+		w.WriteString("0x")
+		w.WriteString(strings.ToUpper(hex.EncodeToString(value)))
+	default:
+		w.WriteByte('\'')
+		writeEscaped(w, value)
+		w.WriteByte('\'')
+	}
+}
+
+// writeEscaped escapes a string literal like mysql_real_escape_string.
+func writeEscaped(w *bufio.Writer, value []byte) {
+	for _, b := range value {
+		switch b {
+		case 0:
+			w.WriteString(`\0`)
+		case '\n':
+			w.WriteString(`\n`)
+		case '\r':
+			w.WriteString(`\r`)
+		case '\\':
+			w.WriteString(`\\`)
+		case '\'':
+			w.WriteString(`\'`)
+		case '"':
+			w.WriteString(`\"`)
+		case 0x1a:
+			w.WriteString(`\Z`)
+		default:
+			w.WriteByte(b)
+		}
+	}
+}
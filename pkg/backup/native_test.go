@@ -0,0 +1,153 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteValue(t *testing.T) {
+	tests := []struct {
+		databaseType string
+		value        sql.RawBytes
+		want         string
+	}{
+		{"INT", sql.RawBytes("42"), "42"},
+		{"UNSIGNED BIGINT", sql.RawBytes("18446744073709551615"), "18446744073709551615"},
+		{"DECIMAL", sql.RawBytes("-1.50"), "-1.50"},
+		{"VARCHAR", sql.RawBytes("it's a \"test\"\n\\"), `'it\'s a \"test\"\n\\'`},
+		{"TEXT", sql.RawBytes("a\x00b\x1a"), `'a\0b\Z'`},
+		{"BLOB", sql.RawBytes{0x00, 0xff}, "0x00FF"},
+		{"VARBINARY", sql.RawBytes{}, "''"},
+		{"DATETIME", sql.RawBytes("2024-01-02 03:04:05"), "'2024-01-02 03:04:05'"},
+		{"INT", nil, "NULL"},
+	}
+
+	for _, test := range tests {
+		var out strings.Builder
+		writer := bufio.NewWriter(&out)
+		writeValue(writer, columnKind(test.databaseType), test.value)
+		writer.Flush()
+
+		if out.String() != test.want {
+			t.Errorf("writeValue(%s, %q) = %s, want %s", test.databaseType, test.value, out.String(), test.want)
+		}
+	}
+}
+
+// fakeRows are rows of raw column values.
+type fakeRows struct {
+	rows    [][]sql.RawBytes
+	current int
+}
+
+func (r *fakeRows) Next() bool {
+	r.current++
+	return r.current <= len(r.rows)
+}
+
+func (r *fakeRows) Scan(dest ...any) error {
+	for i, value := range r.rows[r.current-1] {
+		*dest[i].(*sql.RawBytes) = value
+	}
+	return nil
+}
+
+func (r *fakeRows) Err() error {
+	return nil
+}
+
+func TestWriteInserts(t *testing.T) {
+	rows := [][]sql.RawBytes{{sql.RawBytes("1"), sql.RawBytes{0x00, 0xff}}, {sql.RawBytes("2"), nil}}
+	tests := []struct {
+		name string
+		opts DumpOptions
+		want string
+	}{
+		{"default", DumpOptions{}, "INSERT INTO `t` (`id`,`data`) VALUES (1,0x00FF);\nINSERT INTO `t` (`id`,`data`) VALUES (2,NULL);\n"},
+		{"extended", DumpOptions{ExtendedInsert: true}, "INSERT INTO `t` (`id`,`data`) VALUES (1,0x00FF),(2,NULL);\n"},
+		{"no hex blob", DumpOptions{NoHexBlob: true}, "INSERT INTO `t` (`id`,`data`) VALUES (1,'\\0\xff');\nINSERT INTO `t` (`id`,`data`) VALUES (2,NULL);\n"},
+	}
+
+	for _, test := range tests {
+		var out strings.Builder
+		writer := bufio.NewWriter(&out)
+		if err := writeInserts(writer, "t", test.opts, []string{"id", "data"}, []string{"INT", "BLOB"}, &fakeRows{rows: rows}); err != nil {
+			t.Fatalf("%s: writeInserts error = %v", test.name, err)
+		}
+		writer.Flush()
+
+		if got := strings.TrimPrefix(out.String(), "\n--\n-- Dumping data for table `t`\n--\n\n"); got != test.want {
+			t.Errorf("%s: writeInserts = %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	// Extended inserts are split once they get long.
+	long := make([][]sql.RawBytes, 3)
+	for i := range long {
+		long[i] = []sql.RawBytes{sql.RawBytes("1"), make(sql.RawBytes, extendedInsertSize/2)}
+	}
+	var out strings.Builder
+	writer := bufio.NewWriter(&out)
+	if err := writeInserts(writer, "t", DumpOptions{ExtendedInsert: true}, []string{"id", "data"}, []string{"INT", "BLOB"}, &fakeRows{rows: long}); err != nil {
+		t.Fatal(err)
+	}
+	writer.Flush()
+	if got := strings.Count(out.String(), "INSERT INTO"); got != 3 {
+		t.Errorf("writeInserts wrote %d INSERT statements for rows of half extendedInsertSize, want 3", got)
+	}
+}
+
+func TestStoredColumns(t *testing.T) {
+	columns := []tableColumn{
+		{name: "id", extra: "auto_increment"},
+		{name: "total", extra: "STORED GENERATED"},
+		{name: "label", extra: "VIRTUAL GENERATED"},
+		{name: "legacy", extra: "PERSISTENT GENERATED"},
+		{name: "secret", extra: "INVISIBLE"},
+		{name: "created", extra: "DEFAULT_GENERATED on update CURRENT_TIMESTAMP"},
+		{name: "note", extra: ""},
+	}
+	want := []string{"id", "secret", "created", "note"}
+	if got := storedColumns(columns); !reflect.DeepEqual(got, want) {
+		t.Errorf("storedColumns() = %v, want %v", got, want)
+	}
+}
+
+func TestWriteCompound(t *testing.T) {
+	var out strings.Builder
+	writer := bufio.NewWriter(&out)
+	writeCompound(writer, "/*!50106 DROP EVENT IF EXISTS `purge` */;\n", definition{
+		statement: "CREATE EVENT `purge` ON SCHEDULE EVERY 1 DAY DO BEGIN DELETE FROM carts; END",
+		sqlMode:   "STRICT_TRANS_TABLES",
+		timeZone:  "SYSTEM",
+		charset:   "utf8mb4",
+		collation: "utf8mb4_0900_ai_ci",
+	})
+	writer.Flush()
+
+	for _, want := range []string{
+		"\n/*!50106 DROP EVENT IF EXISTS `purge` */;\n",
+		"/*!50003 SET sql_mode = 'STRICT_TRANS_TABLES' */ ;\n",
+		"/*!50003 SET time_zone = 'SYSTEM' */ ;\n",
+		"DELIMITER ;;\nCREATE EVENT `purge` ON SCHEDULE EVERY 1 DAY DO BEGIN DELETE FROM carts; END ;;\nDELIMITER ;\n",
+		"/*!50003 SET collation_connection = @saved_col_connection */ ;\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("writeCompound output %q does not contain %q", out.String(), want)
+		}
+	}
+}
+
+func TestNativeDumperNeedsDefinitionsForRoutines(t *testing.T) {
+	dumper := &NativeDumper{}
+	if _, err := dumper.Dump(context.Background(), "shop", "", DumpOptions{Routines: true}); err == nil || !strings.Contains(err.Error(), "nothing to dump") {
+		t.Errorf("Dump of routines without definitions = %v, want nothing to dump", err)
+	}
+	if _, err := dumper.Dump(context.Background(), "shop", "", DumpOptions{}); err == nil {
+		t.Error("Dump of an empty table name succeeded")
+	}
+}
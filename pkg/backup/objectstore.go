@@ -0,0 +1,194 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// ErrObjectNotExist is returned by ObjectStore implementations when the
+// requested object does not exist.
+var ErrObjectNotExist = errors.New("object does not exist")
+
+// ObjectAttrs describes a stored object.
+type ObjectAttrs struct {
+	Name        string
+	Size        int64
+	ContentType string
+	Metadata    map[string]string
+	Generation  int64
+	// Etag is the HTTP entity tag of the object's content.
+	Etag    string
+	Created time.Time
+	// CRC32C is the Castagnoli CRC32 checksum of the object's content.
+	CRC32C uint32
+	// MD5 is the MD5 hash of the object's content, which GCS does not
+	// compute for composite objects.
+	MD5 []byte
+	// KMSKeyName is the version of the Cloud KMS key the object is
+	// encrypted with, if any.
+	KMSKeyName string
+}
+
+// ObjectWriter writes a single object. The object becomes visible only once
+// Close returns successfully; cancelling the context passed to NewWriter
+// discards it.
+type ObjectWriter interface {
+	io.Writer
+	Close() error
+}
+
+// bucketReporter is implemented by ObjectWriters that know the buckets they
+// write to.
+type bucketReporter interface {
+	Buckets() []string
+}
+
+func writerBuckets(writer ObjectWriter) []string {
+	if reporter, ok := writer.(bucketReporter); ok {
+		return reporter.Buckets()
+	}
+	return nil
+}
+
+// ObjectStore is the destination of backup objects.
+type ObjectStore interface {
+	NewWriter(ctx context.Context, name string, contentType string, metadata map[string]string) ObjectWriter
+	NewReader(ctx context.Context, name string) (io.ReadCloser, error)
+	Attrs(ctx context.Context, name string) (*ObjectAttrs, error)
+	List(ctx context.Context, prefix string) ([]ObjectAttrs, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// GCSStore stores objects in a Google Cloud Storage bucket.
+type GCSStore struct {
+	Bucket *storage.BucketHandle
+
+	counters *storageCounters
+}
+
+// NewGCSStore returns an ObjectStore backed by bucket, counting the errors
+// and retries of its API calls, see StorageStats.
+func NewGCSStore(bucket *storage.BucketHandle) *GCSStore {
+	counters := &storageCounters{}
+	return &GCSStore{Bucket: bucket.Retryer(storage.WithErrorFunc(counters.shouldRetry)), counters: counters}
+}
+
+// StorageStats returns the stats of the store so far.
+func (s *GCSStore) StorageStats() StorageStats {
+	return s.counters.stats()
+}
+
+func (s *GCSStore) NewWriter(ctx context.Context, name string, contentType string, metadata map[string]string) ObjectWriter {
+	object := s.Bucket.Object(name)
+	writer := object.NewWriter(ctx)
+	if size, ok := chunkSizeFrom(ctx); ok {
+		writer.ChunkSize = size
+	}
+	writer.ContentType = contentType
+	writer.Metadata = metadata
+	return &gcsWriter{Writer: writer, bucket: object.BucketName(), counters: s.counters}
+}
+
+type gcsWriter struct {
+	*storage.Writer
+	bucket   string
+	counters *storageCounters
+	waited   time.Duration
+}
+
+func (w *gcsWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := w.Writer.Write(p)
+	w.waited += time.Since(start)
+	return n, err
+}
+
+// Close completes the upload and counts it, as uploads are not retried
+// without preconditions and so do not reach storageCounters.shouldRetry.
+func (w *gcsWriter) Close() error {
+	start := time.Now()
+	err := w.Writer.Close()
+	w.waited += time.Since(start)
+	w.counters.uploaded(w.waited, err)
+	return err
+}
+
+func (w *gcsWriter) Buckets() []string {
+	return []string{w.bucket}
+}
+
+func (s *GCSStore) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	reader, err := s.Bucket.Object(name).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotExist, name)
+	}
+	return reader, err
+}
+
+func (s *GCSStore) Attrs(ctx context.Context, name string) (*ObjectAttrs, error) {
+	attrs, err := s.Bucket.Object(name).Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotExist, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gcsObjectAttrs(attrs), nil
+}
+
+func (s *GCSStore) List(ctx context.Context, prefix string) ([]ObjectAttrs, error) {
+	var objects []ObjectAttrs
+
+	it := s.Bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, *gcsObjectAttrs(attrs))
+	}
+
+	return objects, nil
+}
+
+func (s *GCSStore) Delete(ctx context.Context, name string) error {
+	err := s.Bucket.Object(name).Delete(ctx)
+	s.counters.failed(err)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("%w: %s", ErrObjectNotExist, name)
+	}
+	return err
+}
+
+func gcsObjectAttrs(attrs *storage.ObjectAttrs) *ObjectAttrs {
+	return &ObjectAttrs{
+		Name:        attrs.Name,
+		Size:        attrs.Size,
+		ContentType: attrs.ContentType,
+		Metadata:    attrs.Metadata,
+		Generation:  attrs.Generation,
+		Etag:        attrs.Etag,
+		Created:     attrs.Created,
+		CRC32C:      attrs.CRC32C,
+		MD5:         attrs.MD5,
+		KMSKeyName:  attrs.KMSKeyName,
+	}
+}
+
+// SignedURL returns a V4 signed GET URL for the object valid for ttl.
+func (s *GCSStore) SignedURL(name string, ttl time.Duration) (string, error) {
+	return s.Bucket.SignedURL(name, &storage.SignedURLOptions{
+		Scheme:  storage.SigningSchemeV4,
+		Method:  "GET",
+		Expires: time.Now().Add(ttl),
+	})
+}
@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// partitionObject returns the object name of a partition of the table dumped
+// to object, <database>/<table>/<partition>.sql.gz.
+func partitionObject(object string, partition string) string {
+	return object[:len(object)-len(tableObjectSuffix)] + "/" + partition + tableObjectSuffix
+}
+
+// backupPartitions dumps each partition of a table into its own object. The
+// caller's worker dumps them one at a time, and further partitions are dumped
+// in parallel on workers of pool that are idle.
+func backupPartitions(ctx context.Context, dumper Dumper, uploader *Uploader, pool *semaphore.Weighted, opts DumpOptions, database string, table string, object string, partitions []string) ([]PartitionResult, error) {
+	results := make([]PartitionResult, len(partitions))
+
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+
+	for i, partition := range partitions {
+		i, partition := i, partition
+
+		dump := func() {
+			results[i] = PartitionResult{Partition: partition, Object: partitionObject(object, partition)}
+
+			partitionOpts := opts
+			partitionOpts.Partition = partition
+
+			stats, err := dumpObject(ctx, dumper, uploader, partitionOpts, database, table, results[i].Object)
+			results[i].UncompressedBytes = stats.UncompressedBytes
+			results[i].CompressedBytes = stats.CompressedBytes
+			results[i].Rows = stats.Rows
+			results[i].Parts = stats.Parts
+			results[i].ObjectGeneration = stats.Generation
+			results[i].Etag = stats.Etag
+			results[i].PartGenerations = stats.PartGenerations
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("partition %s: %w", partition, err))
+				mu.Unlock()
+				return
+			}
+			results[i].CRC32C = formatCRC32C(stats.CRC32C)
+			results[i].MD5 = formatMD5(stats.MD5)
+			results[i].SHA256 = formatSHA256(stats.SHA256)
+
+			log.Printf("Backup for partition %s of table \"%s.%s\" completed: %s dumped.\n", partition, database, table, FormatBytes(stats.UncompressedBytes))
+		}
+
+		if !pool.TryAcquire(1) {
+			dump()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer pool.Release(1)
+			dump()
+		}()
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return results, errors.Join(errs...)
+	}
+	return results, deleteStalePartitions(ctx, uploader.Store, object, results)
+}
+
+// deleteStalePartitions deletes the partition and chunk objects of the table
+// dumped to object, and their continuation objects, beyond those in
+// results, left behind by an earlier dump of the table to the same
+// generation with other partitions or more chunks.
+func deleteStalePartitions(ctx context.Context, store ObjectStore, object string, results []PartitionResult) error {
+	current := map[string]bool{}
+	for _, result := range results {
+		current[result.Object] = true
+	}
+
+	prefix := object[:len(object)-len(tableObjectSuffix)] + "/"
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions of %s: %w", object, err)
+	}
+	for _, attrs := range objects {
+		name := attrs.Name
+		if base, ok := partOf(name); ok {
+			name = base
+		}
+		if table, ok := parsePartitionObject(name); !ok || table != object || current[name] {
+			continue
+		}
+		if err := store.Delete(ctx, attrs.Name); err != nil && !errors.Is(err, ErrObjectNotExist) {
+			return fmt.Errorf("failed to delete stale partition %s: %w", attrs.Name, err)
+		}
+	}
+	return nil
+}
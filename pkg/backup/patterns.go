@@ -0,0 +1,80 @@
+package backup
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// DefaultSkipDBs are the databases skipped unless Config.SkipDBs is set.
+var DefaultSkipDBs = []string{"information_schema", "performance_schema", "sys", "test"}
+
+// SystemSchemas are the server's own schemas with data worth recovering,
+// such as accounts, proxies and time zone tables, which are only backed up
+// with Config.IncludeSystemSchemas.
+var SystemSchemas = []string{"mysql"}
+
+// volatileSystemTables are the tables of system schemas with state the
+// server maintains itself, logs, statistics and replication positions,
+// which are never backed up.
+var volatileSystemTables = map[string][]string{
+	"mysql": {
+		"general_log", "slow_log",
+		"innodb_index_stats", "innodb_table_stats",
+		"gtid_executed", "slave_master_info", "slave_relay_log_info", "slave_worker_info",
+		"ndb_binlog_index", "backup_history", "backup_progress",
+	},
+}
+
+// A name pattern is a regular expression when enclosed in slashes, such as
+// /^shard_[0-9]+$/, and a shell-style glob as understood by path.Match, such
+// as tmp_*, otherwise.
+func isRegexpPattern(pattern string) bool {
+	return len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
+// ValidatePatterns checks that every pattern is a valid glob or regular
+// expression.
+func ValidatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if isRegexpPattern(pattern) {
+			if _, err := regexp.Compile(pattern[1 : len(pattern)-1]); err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if isRegexpPattern(pattern) {
+			if re, err := regexp.Compile(pattern[1 : len(pattern)-1]); err == nil && re.MatchString(name) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateTablePatterns checks that every pattern is a valid glob or regular
+// expression matching "database.table" names, as in Config.IgnoreTables.
+// Globs must have a dot separating the database from the table part, such
+// as shop.tmp_* or *.audit_log.
+func ValidateTablePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if !isRegexpPattern(pattern) && !strings.Contains(pattern, ".") {
+			return fmt.Errorf("invalid table pattern %q: expected database.table", pattern)
+		}
+	}
+	return ValidatePatterns(patterns)
+}
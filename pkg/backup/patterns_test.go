@@ -0,0 +1,45 @@
+package backup
+
+import "testing"
+
+func TestMatchAny(t *testing.T) {
+	patterns := []string{"sys", "tmp_*", "*_shadow", "/^shard_[0-9]+$/"}
+
+	tests := map[string]bool{
+		"sys":          true,
+		"tmp_orders":   true,
+		"users_shadow": true,
+		"shard_12":     true,
+		"shard_12_old": false,
+		"shop":         false,
+		"system":       false,
+	}
+
+	for name, want := range tests {
+		if got := matchAny(patterns, name); got != want {
+			t.Errorf("matchAny(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestValidatePatterns(t *testing.T) {
+	if err := ValidatePatterns([]string{"tmp_*", "/^a+$/"}); err != nil {
+		t.Errorf("ValidatePatterns rejected valid patterns: %v", err)
+	}
+	for _, pattern := range []string{"tmp_[", "/(/"} {
+		if err := ValidatePatterns([]string{pattern}); err == nil {
+			t.Errorf("ValidatePatterns accepted %q", pattern)
+		}
+	}
+}
+
+func TestValidateTablePatterns(t *testing.T) {
+	if err := ValidateTablePatterns([]string{"shop.tmp_*", "*.audit_log", "/^shard_[0-9]+\\.events$/"}); err != nil {
+		t.Errorf("ValidateTablePatterns rejected valid patterns: %v", err)
+	}
+	for _, pattern := range []string{"events", "shop.tmp_["} {
+		if err := ValidateTablePatterns([]string{pattern}); err == nil {
+			t.Errorf("ValidateTablePatterns accepted %q", pattern)
+		}
+	}
+}
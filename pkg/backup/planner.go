@@ -0,0 +1,273 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Table types as reported by information_schema.
+const (
+	TableTypeBase = "BASE TABLE"
+	TableTypeView = "VIEW"
+)
+
+// TableInfo is a table or view of a database. Engine is empty for views.
+type TableInfo struct {
+	Name   string
+	Type   string
+	Engine string
+	// Size is the data and index length reported by information_schema.
+	Size int64
+	// Collation is the default collation of a table, empty for views.
+	Collation string
+	// ApproximateRows is the row count estimate of information_schema, an
+	// exact count only for engines such as MyISAM.
+	ApproximateRows int64
+}
+
+// Charset returns the character set of the table's default collation, e.g.
+// latin1 for latin1_swedish_ci.
+func (t TableInfo) Charset() string {
+	charset, _, _ := strings.Cut(t.Collation, "_")
+	return charset
+}
+
+// transactional reports whether the table can be dumped consistently without
+// locking it.
+func (t TableInfo) transactional() bool {
+	switch strings.ToLower(t.Engine) {
+	case "", "innodb", "ndb", "ndbcluster", "rocksdb", "tokudb":
+		return true
+	}
+	return false
+}
+
+// DatabaseSize is the size of a database as reported by information_schema.
+type DatabaseSize struct {
+	Data  int64
+	Index int64
+}
+
+// Planner enumerates the databases and tables to back up.
+type Planner interface {
+	Databases() ([]string, error)
+	Tables(database string) ([]TableInfo, error)
+	// Probe reads at most one row of a table, failing for tables that
+	// cannot be read such as FEDERATED tables with an unreachable remote,
+	// corrupt tables, or views referencing missing objects.
+	Probe(database string, table string) error
+	// Partitions returns the partitions of a RANGE or LIST partitioned
+	// table in definition order, or none for other tables.
+	Partitions(database string, table string) ([]string, error)
+	DatabaseSizes(databases []string) (map[string]DatabaseSize, error)
+	Exec(database string, statements []string) error
+}
+
+// MySQLPlanner enumerates databases and tables with queries against
+// information_schema over a driver connection.
+type MySQLPlanner struct {
+	Connection Connection
+	// EnumerationTimeout bounds each query listing databases, tables and
+	// partitions and probing tables, so that a wedged server fails the
+	// enumeration instead of hanging the run; it defaults to
+	// DefaultEnumerationTimeout.
+	EnumerationTimeout time.Duration
+
+	lazyDB
+}
+
+// DefaultEnumerationTimeout is the EnumerationTimeout of a MySQLPlanner
+// unless it is set.
+const DefaultEnumerationTimeout = 5 * time.Minute
+
+func (p *MySQLPlanner) enumerationTimeout() time.Duration {
+	if p.EnumerationTimeout <= 0 {
+		return DefaultEnumerationTimeout
+	}
+	return p.EnumerationTimeout
+}
+
+// queryError describes the failure of an enumeration query, telling
+// timeouts apart from other errors.
+func (p *MySQLPlanner) queryError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("MySQL query timed out after %s: %w", p.enumerationTimeout(), err)
+	}
+	return fmt.Errorf("failed to query MySQL: %w", err)
+}
+
+func (p *MySQLPlanner) queryStrings(query string, args ...any) ([]string, error) {
+	db, err := p.get(p.Connection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.enumerationTimeout())
+	defer cancel()
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, p.queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, fmt.Errorf("failed to read query result: %w", err)
+		}
+		values = append(values, value)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, p.queryError(ctx, err)
+	}
+
+	return values, nil
+}
+
+// Databases returns the databases on the server.
+func (p *MySQLPlanner) Databases() ([]string, error) {
+	return p.queryStrings("SELECT schema_name FROM information_schema.schemata ORDER BY schema_name")
+}
+
+// Tables returns the tables and views of a database with their engine and
+// size.
+func (p *MySQLPlanner) Tables(database string) ([]TableInfo, error) {
+	db, err := p.get(p.Connection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.enumerationTimeout())
+	defer cancel()
+	rows, err := db.QueryContext(ctx,
+		"SELECT table_name, table_type, COALESCE(engine, ''), COALESCE(data_length, 0) + COALESCE(index_length, 0), COALESCE(table_collation, ''), COALESCE(table_rows, 0) "+
+			"FROM information_schema.tables WHERE table_schema = ? ORDER BY table_name", database)
+	if err != nil {
+		return nil, p.queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	var tables []TableInfo
+	for rows.Next() {
+		var table TableInfo
+		if err := rows.Scan(&table.Name, &table.Type, &table.Engine, &table.Size, &table.Collation, &table.ApproximateRows); err != nil {
+			return nil, fmt.Errorf("failed to read query result: %w", err)
+		}
+		tables = append(tables, table)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, p.queryError(ctx, err)
+	}
+
+	return tables, nil
+}
+
+// Probe selects a single row of a table.
+func (p *MySQLPlanner) Probe(database string, table string) error {
+	db, err := p.get(p.Connection)
+	if err != nil {
+		return fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.enumerationTimeout())
+	defer cancel()
+	var one int
+	err = db.QueryRowContext(ctx, "SELECT 1 FROM "+quoteTable(database, table)+" LIMIT 1").Scan(&one)
+	if err != nil && err != sql.ErrNoRows {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return p.queryError(ctx, err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Partitions returns the RANGE and LIST partitions of a table.
+func (p *MySQLPlanner) Partitions(database string, table string) ([]string, error) {
+	return p.queryStrings("SELECT partition_name FROM information_schema.partitions "+
+		"WHERE table_schema = ? AND table_name = ? AND partition_method IN ('RANGE', 'RANGE COLUMNS', 'LIST', 'LIST COLUMNS') "+
+		"GROUP BY partition_name ORDER BY MIN(partition_ordinal_position)", database, table)
+}
+
+// DatabaseSizes returns the data and index length of each of the given
+// databases as reported by information_schema.
+func (p *MySQLPlanner) DatabaseSizes(databases []string) (map[string]DatabaseSize, error) {
+	db, err := p.get(p.Connection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.enumerationTimeout())
+	defer cancel()
+	rows, err := db.QueryContext(ctx, "SELECT table_schema, COALESCE(SUM(data_length), 0), COALESCE(SUM(index_length), 0) FROM information_schema.tables GROUP BY table_schema")
+	if err != nil {
+		return nil, p.queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	sizes := map[string]DatabaseSize{}
+	for rows.Next() {
+		var database string
+		var size DatabaseSize
+		if err := rows.Scan(&database, &size.Data, &size.Index); err != nil {
+			return nil, fmt.Errorf("failed to read query result: %w", err)
+		}
+		if contains(databases, database) {
+			sizes[database] = size
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read query result: %w", err)
+	}
+
+	return sizes, nil
+}
+
+// Exec runs statements in database on a single connection.
+func (p *MySQLPlanner) Exec(database string, statements []string) error {
+	if len(statements) == 0 {
+		return nil
+	}
+
+	db, err := p.get(p.Connection)
+	if err != nil {
+		return fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "USE "+quoteIdentifier(database)); err != nil {
+		return fmt.Errorf("failed to use database %s: %w", database, err)
+	}
+
+	for _, statement := range statements {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("failed to execute %q: %w", statement, err)
+		}
+	}
+
+	return nil
+}
+
+func contains(slice []string, value string) bool {
+	for _, item := range slice {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package backup
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlannerEnumerationTimeout(t *testing.T) {
+	// A server that accepts connections but never answers, like a wedged
+	// one.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	planner := &MySQLPlanner{Connection: Connection{User: "u", Host: host, Port: port}, EnumerationTimeout: 100 * time.Millisecond}
+	defer planner.Close()
+
+	start := time.Now()
+	_, err = planner.Databases()
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("Databases = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Databases returned after %s", elapsed)
+	}
+}
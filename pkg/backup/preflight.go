@@ -0,0 +1,241 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrPreflight is returned by Run and Restore when a check of their
+	// environment failed before anything was dumped or restored. It wraps
+	// the errors of all failed checks.
+	ErrPreflight = errors.New("preflight failed")
+	// ErrPermissions is returned by Run when the credentials lack
+	// permissions a run needs on a destination bucket.
+	ErrPermissions = errors.New("missing bucket permissions")
+)
+
+// preflightTimeout bounds each preflight check.
+const preflightTimeout = 30 * time.Second
+
+// ConnectionChecker connects to the server. A Planner implementing it has
+// the server checked before the run starts.
+type ConnectionChecker interface {
+	// CheckConnection describes the server and the connection to it.
+	CheckConnection(ctx context.Context) (string, error)
+}
+
+// CheckConnection connects to the server and reports its version and
+// whether the connection is encrypted.
+func (p *MySQLPlanner) CheckConnection(ctx context.Context) (string, error) {
+	db, err := p.get(p.Connection)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+
+	var version string
+	if err := db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err != nil {
+		return "", fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+
+	var name string
+	var cipher sql.NullString
+	if err := db.QueryRowContext(ctx, "SHOW SESSION STATUS LIKE 'Ssl_cipher'").Scan(&name, &cipher); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to query the TLS status: %w", err)
+	}
+	if cipher.String == "" {
+		return fmt.Sprintf("MySQL %s, unencrypted", version), nil
+	}
+	return fmt.Sprintf("MySQL %s, TLS with %s", version, cipher.String), nil
+}
+
+// preflight collects the outcome of the checks of an environment, logging
+// one line per check.
+type preflight struct {
+	errs []error
+}
+
+func (p *preflight) check(ctx context.Context, name string, run func(ctx context.Context) (string, error)) bool {
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+
+	detail, err := run(ctx)
+	if err != nil {
+		log.Printf("Preflight check %s failed: %v\n", name, err)
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", name, err))
+		return false
+	}
+	log.Printf("Preflight check %s passed: %s\n", name, detail)
+	return true
+}
+
+func (p *preflight) err() error {
+	if len(p.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrPreflight, errors.Join(p.errs...))
+}
+
+// binary checks that a client binary can be found and run.
+func (p *preflight) binary(ctx context.Context, name string, binary string) bool {
+	return p.check(ctx, name, func(ctx context.Context) (string, error) {
+		path, err := exec.LookPath(binary)
+		if err != nil {
+			return "", err
+		}
+		output, err := exec.CommandContext(ctx, path, "--version").Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to run %s --version: %w", path, err)
+		}
+		version, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+		return fmt.Sprintf("%s (%s)", path, version), nil
+	})
+}
+
+// server checks that the host of conn resolves and accepts connections.
+func (p *preflight) server(ctx context.Context, conn Connection) bool {
+	if conn.socket() {
+		return p.check(ctx, "server", func(ctx context.Context) (string, error) {
+			return dialServer(ctx, "unix", conn.Host)
+		})
+	}
+
+	if net.ParseIP(conn.Host) == nil {
+		resolved := p.check(ctx, "DNS", func(ctx context.Context) (string, error) {
+			addrs, err := net.DefaultResolver.LookupHost(ctx, conn.Host)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s resolves to %s", conn.Host, strings.Join(addrs, ", ")), nil
+		})
+		if !resolved {
+			return false
+		}
+	}
+	return p.check(ctx, "server", func(ctx context.Context) (string, error) {
+		return dialServer(ctx, "tcp", net.JoinHostPort(conn.Host, conn.Port))
+	})
+}
+
+func dialServer(ctx context.Context, network string, address string) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return "", err
+	}
+	conn.Close()
+	return address + " accepts connections", nil
+}
+
+// runPreflight checks the binaries, server and buckets a run uses before
+// anything is dumped, so that a broken environment fails the run at once
+// with a report of everything that is wrong.
+func runPreflight(ctx context.Context, cfg Config, planner Planner, dumper Dumper) error {
+	var p preflight
+
+	if mysqldump, ok := dumper.(*Mysqldump); ok {
+		p.binary(ctx, "mysqldump", mysqldump.binary())
+	}
+	if checker, ok := planner.(ConnectionChecker); ok && p.server(ctx, cfg.Connection) {
+		p.check(ctx, "MySQL connection", checker.CheckConnection)
+	}
+
+	if cfg.UploadWorkers > 0 {
+		p.check(ctx, "spool directory", func(ctx context.Context) (string, error) {
+			stage := newUploadStage(cfg.SpoolDir, 1, 0)
+			file, err := stage.create()
+			if err != nil {
+				return "", err
+			}
+			closeSpool(file)
+			free, err := freeSpace(stage.dir)
+			if err != nil {
+				return "", fmt.Errorf("failed to check free space: %w", err)
+			}
+			if free < cfg.SpoolMinFree {
+				log.Printf("Only %d MiB free in %s, below the minimum of %d MiB: dumps are streamed until space is freed\n", free>>20, stage.dir, cfg.SpoolMinFree>>20)
+			}
+			return fmt.Sprintf("%s is writable, %d MiB free", filepath.Dir(file.Name()), free>>20), nil
+		})
+	}
+
+	if buckets := gcsBuckets(cfg.Store); len(buckets) > 0 {
+		if !cfg.SkipPermissionCheck {
+			p.check(ctx, "bucket permissions", func(ctx context.Context) (string, error) {
+				return fmt.Sprintf("%s granted on %d bucket(s)", strings.Join(requiredPermissions, ", "), len(buckets)), checkPermissions(ctx, cfg.Store)
+			})
+		}
+		if cfg.BucketPolicy.enabled() {
+			p.check(ctx, "bucket policy", func(ctx context.Context) (string, error) {
+				return "checked", checkBucketPolicy(ctx, cfg.Store, cfg.BucketPolicy)
+			})
+		}
+	}
+
+	return p.err()
+}
+
+// restorePreflight checks the mysql binary and the server a restore uses.
+func restorePreflight(ctx context.Context, applier *MySQLApplier) error {
+	var p preflight
+	p.binary(ctx, "mysql", applier.binary())
+	p.server(ctx, applier.Connection)
+	return p.err()
+}
+
+// requiredPermissions are needed to upload and confirm the objects of a run.
+var requiredPermissions = []string{"storage.objects.create", "storage.objects.get", "storage.objects.list"}
+
+// deletePermission is needed to delete failed dumps and stale parts, and to
+// overwrite objects of an earlier run in the same generation.
+const deletePermission = "storage.objects.delete"
+
+// updatePermission is needed to record the checksums of dumps in their
+// metadata.
+const updatePermission = "storage.objects.update"
+
+// checkPermissions tests the permissions of the credentials on every GCS
+// bucket of store, so that a run fails at startup rather than on its first
+// upload.
+func checkPermissions(ctx context.Context, store ObjectStore) error {
+	var missing []string
+	for _, bucket := range gcsBuckets(store) {
+		granted, err := bucket.IAM().TestPermissions(ctx, append([]string{deletePermission, updatePermission}, requiredPermissions...))
+		if err != nil {
+			return fmt.Errorf("failed to test bucket permissions: %w", err)
+		}
+
+		has := map[string]bool{}
+		for _, permission := range granted {
+			has[permission] = true
+		}
+		var lacking []string
+		for _, permission := range requiredPermissions {
+			if !has[permission] {
+				lacking = append(lacking, permission)
+			}
+		}
+		if len(lacking) > 0 {
+			missing = append(missing, fmt.Sprintf("gs://%s lacks %s", bucketName(bucket), strings.Join(lacking, ", ")))
+		}
+		if !has[deletePermission] {
+			log.Printf("Credentials lack %s on gs://%s: failed dumps cannot be deleted and objects of earlier runs in the same generation cannot be overwritten\n", deletePermission, bucketName(bucket))
+		}
+		if !has[updatePermission] {
+			log.Printf("Credentials lack %s on gs://%s: checksums of dumps are not recorded in their metadata\n", updatePermission, bucketName(bucket))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrPermissions, strings.Join(missing, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// permissionsServer answers testIamPermissions with granted.
+func permissionsServer(t *testing.T, granted []string) *storage.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/iam/testPermissions") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]string{"permissions": granted})
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(server.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestRunFailsWithoutBucketPermissions(t *testing.T) {
+	client := permissionsServer(t, []string{"storage.objects.get", "storage.objects.list"})
+
+	planner := &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"orders"}}}
+	dumper := &fakeDumper{}
+	cfg := testConfig(NewGCSStore(client.Bucket("backups")), planner, dumper)
+
+	_, err := Run(context.Background(), cfg)
+	if !errors.Is(err, ErrPermissions) || !strings.Contains(err.Error(), "gs://backups lacks storage.objects.create") {
+		t.Fatalf("Run = %v, want ErrPermissions for storage.objects.create on gs://backups", err)
+	}
+	if len(dumper.dumped) != 0 {
+		t.Errorf("dumped %v without permission to upload", dumper.dumped)
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	client := permissionsServer(t, []string{"storage.objects.create", "storage.objects.get", "storage.objects.list"})
+
+	if err := checkPermissions(context.Background(), NewMirrorStore(NewMemoryStore(), NewGCSStore(client.Bucket("backups")))); err != nil {
+		t.Errorf("checkPermissions = %v, want no error without the optional delete permission", err)
+	}
+	if err := checkPermissions(context.Background(), NewMemoryStore()); err != nil {
+		t.Errorf("checkPermissions of a MemoryStore = %v", err)
+	}
+}
+
+type connectionPlanner struct {
+	*fakePlanner
+	checked bool
+}
+
+func (p *connectionPlanner) CheckConnection(ctx context.Context) (string, error) {
+	p.checked = true
+	return "MySQL 8.0.36, unencrypted", nil
+}
+
+func TestRunPreflightReportsAllFailures(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	planner := &connectionPlanner{fakePlanner: &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"orders"}}}}
+	cfg := testConfig(NewMemoryStore(), planner, &Mysqldump{Path: filepath.Join(t.TempDir(), "mysqldump")})
+	cfg.Connection = Connection{Host: "127.0.0.1", Port: port}
+
+	_, err = Run(context.Background(), cfg)
+	if !errors.Is(err, ErrPreflight) {
+		t.Fatalf("Run = %v, want ErrPreflight", err)
+	}
+	for _, want := range []string{"mysqldump: ", "server: "} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not report %s", err, want)
+		}
+	}
+	if planner.checked {
+		t.Error("MySQL connection checked although the server is unreachable")
+	}
+}
+
+func TestRunPreflightPasses(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	binary := filepath.Join(t.TempDir(), "mysqldump")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\necho 'mysqldump  Ver 8.0.36 for Linux on x86_64'\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	planner := &connectionPlanner{fakePlanner: &fakePlanner{}}
+	cfg := Config{Store: NewMemoryStore(), Connection: Connection{Host: "localhost", Port: port}}
+	if err := runPreflight(context.Background(), cfg, planner, &Mysqldump{Path: binary}); err != nil {
+		t.Fatalf("runPreflight = %v", err)
+	}
+	if !planner.checked {
+		t.Error("MySQL connection was not checked")
+	}
+}
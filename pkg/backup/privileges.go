@@ -0,0 +1,202 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// ErrPrivileges is returned by Run when the MySQL account lacks privileges
+// the run needs.
+var ErrPrivileges = errors.New("missing MySQL privileges")
+
+// GrantLister lists the grants of the connected account. A Planner
+// implementing it has the privileges a run needs checked before any table
+// is dumped.
+type GrantLister interface {
+	// Grants returns the grants as SHOW GRANTS prints them.
+	Grants() ([]string, error)
+}
+
+func (p *MySQLPlanner) Grants() ([]string, error) {
+	return p.queryStrings("SHOW GRANTS")
+}
+
+type requiredPrivilege struct {
+	privilege string
+	// global privileges can only be granted ON *.*.
+	global bool
+	// optional privileges are only needed by some tables or for optional
+	// parts of a run, and are only warned about.
+	optional bool
+	reason   string
+}
+
+// requiredPrivileges returns the privileges a run with cfg needs.
+func requiredPrivileges(cfg Config) []requiredPrivilege {
+	privileges := []requiredPrivilege{
+		{privilege: "SELECT", reason: "to dump tables"},
+		{privilege: "SHOW VIEW", reason: "to dump views"},
+	}
+	if !cfg.PureGo {
+		privileges = append(privileges, requiredPrivilege{privilege: "PROCESS", global: true, reason: "for mysqldump to dump tablespaces"})
+	}
+	if cfg.ConsistentSnapshot {
+		privileges = append(privileges,
+			requiredPrivilege{privilege: "RELOAD", global: true, reason: "to lock the server while the consistent snapshot is taken"},
+			requiredPrivilege{privilege: "BACKUP_ADMIN", global: true, optional: true, reason: "to block DDL with LOCK INSTANCE FOR BACKUP"})
+	}
+	if !cfg.SkipTriggers {
+		privileges = append(privileges, requiredPrivilege{privilege: "TRIGGER", reason: "to dump triggers"})
+	}
+	if cfg.Routines == "" || cfg.Routines == RoutinesDatabase {
+		privileges = append(privileges, requiredPrivilege{privilege: "EVENT", reason: "to dump events"})
+	}
+	if cfg.NonTransactional == "" || cfg.NonTransactional == NonTransactionalLock {
+		privileges = append(privileges, requiredPrivilege{privilege: "LOCK TABLES", optional: true, reason: "to lock tables of non-transactional engines"})
+	}
+	if !cfg.SkipServerInfo {
+		privileges = append(privileges, requiredPrivilege{privilege: "REPLICATION CLIENT", global: true, optional: true, reason: "to record the binary log and replica status"})
+	}
+	return privileges
+}
+
+// checkPrivileges verifies that the account holds the privileges required
+// by cfg on every database. Privileges granted through roles are not seen,
+// so for accounts with roles missing privileges are only logged.
+func checkPrivileges(lister GrantLister, cfg Config, databases []string) error {
+	lines, err := lister.Grants()
+	if err != nil {
+		log.Printf("Failed to list grants, privileges are not checked: %v\n", err)
+		return nil
+	}
+	grants := parseGrants(lines)
+	if grants.roles {
+		log.Printf("The account has roles, whose privileges are not checked\n")
+	}
+
+	var missing []string
+	for _, required := range requiredPrivileges(cfg) {
+		var lacking string
+		if required.global {
+			if !grants.has(required.privilege, "") {
+				lacking = fmt.Sprintf("%s (%s)", required.privilege, required.reason)
+			}
+		} else {
+			var without []string
+			for _, database := range databases {
+				if !grants.has(required.privilege, database) {
+					without = append(without, database)
+				}
+			}
+			if len(without) > 0 {
+				lacking = fmt.Sprintf("%s on %s (%s)", required.privilege, strings.Join(without, ", "), required.reason)
+			}
+		}
+
+		switch {
+		case lacking == "":
+		case required.optional || grants.roles:
+			log.Printf("Missing MySQL privilege %s\n", lacking)
+		default:
+			missing = append(missing, lacking)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrPrivileges, strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+type grants struct {
+	global    map[string]bool
+	databases []databaseGrant
+	roles     bool
+}
+
+type databaseGrant struct {
+	pattern    *regexp.Regexp
+	privileges map[string]bool
+}
+
+var grantPattern = regexp.MustCompile("^GRANT (.+?) ON (.+?) TO ")
+
+// parseGrants parses the global and database grants printed by SHOW
+// GRANTS. Table, column and routine grants are ignored.
+func parseGrants(lines []string) grants {
+	g := grants{global: map[string]bool{}}
+	for _, line := range lines {
+		match := grantPattern.FindStringSubmatch(line)
+		if match == nil {
+			if strings.HasPrefix(line, "GRANT ") {
+				g.roles = true
+			}
+			continue
+		}
+
+		privileges := map[string]bool{}
+		for _, privilege := range strings.Split(match[1], ",") {
+			privileges[strings.ToUpper(strings.TrimSpace(privilege))] = true
+		}
+
+		scope := match[2]
+		if scope == "*.*" {
+			for privilege := range privileges {
+				g.global[privilege] = true
+			}
+			continue
+		}
+		database, ok := strings.CutSuffix(scope, ".*")
+		if !ok || !strings.HasPrefix(database, "`") || !strings.HasSuffix(database, "`") {
+			continue
+		}
+		database = strings.ReplaceAll(database[1:len(database)-1], "``", "`")
+		g.databases = append(g.databases, databaseGrant{pattern: grantDatabasePattern(database), privileges: privileges})
+	}
+	return g
+}
+
+// grantDatabasePattern compiles a database name of a grant, in which % and
+// _ are wildcards unless escaped by a backslash.
+func grantDatabasePattern(name string) *regexp.Regexp {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	escaped := false
+	for _, r := range name {
+		switch {
+		case escaped:
+			pattern.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			pattern.WriteString(".*")
+		case r == '_':
+			pattern.WriteString(".")
+		default:
+			pattern.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	pattern.WriteString("$")
+	return regexp.MustCompile(pattern.String())
+}
+
+// has reports whether privilege is granted globally or, for a database
+// other than "", on it.
+func (g grants) has(privilege string, database string) bool {
+	if g.global[privilege] || g.global["ALL PRIVILEGES"] {
+		return true
+	}
+	if database == "" {
+		return false
+	}
+	for _, grant := range g.databases {
+		if (grant.privileges[privilege] || grant.privileges["ALL PRIVILEGES"]) && grant.pattern.MatchString(database) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type grantsPlanner struct {
+	*fakePlanner
+	grants []string
+}
+
+func (p *grantsPlanner) Grants() ([]string, error) {
+	return p.grants, nil
+}
+
+func TestRunFailsWithoutPrivileges(t *testing.T) {
+	planner := &grantsPlanner{
+		fakePlanner: &fakePlanner{
+			databases: []string{"shop", "shop_archive", "billing"},
+			tables:    map[string][]string{"shop": {"orders"}, "shop_archive": {"orders"}, "billing": {"invoices"}},
+		},
+		grants: []string{
+			"GRANT PROCESS, REPLICATION CLIENT ON *.* TO `backup`@`%`",
+			"GRANT SELECT, SHOW VIEW, TRIGGER, EVENT, LOCK TABLES ON `shop%`.* TO `backup`@`%`",
+			"GRANT SELECT, SHOW VIEW ON `billing`.* TO `backup`@`%`",
+		},
+	}
+	dumper := &fakeDumper{}
+
+	cfg := testConfig(NewMemoryStore(), planner, dumper)
+	cfg.Routines = RoutinesDatabase
+	_, err := Run(context.Background(), cfg)
+	if !errors.Is(err, ErrPrivileges) {
+		t.Fatalf("Run = %v, want ErrPrivileges", err)
+	}
+	for _, want := range []string{"TRIGGER on billing", "EVENT on billing"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not report %s", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "shop") || strings.Contains(err.Error(), "LOCK TABLES") {
+		t.Errorf("error %q reports privileges that are granted or optional", err)
+	}
+	if len(dumper.dumped) != 0 {
+		t.Errorf("dumped %v despite missing privileges", dumper.dumped)
+	}
+}
+
+func TestCheckPrivileges(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    Config
+		grants []string
+		want   string
+	}{
+		{
+			name:   "all privileges",
+			grants: []string{"GRANT ALL PRIVILEGES ON *.* TO `root`@`localhost` WITH GRANT OPTION"},
+		},
+		{
+			name:   "escaped wildcard",
+			grants: []string{"GRANT PROCESS ON *.* TO `backup`@`%`", "GRANT ALL PRIVILEGES ON `shop\\_db`.* TO `backup`@`%`"},
+			want:   "SELECT on shopxdb",
+		},
+		{
+			name:   "flags drop privileges",
+			cfg:    Config{SkipTriggers: true, Routines: RoutinesNone},
+			grants: []string{"GRANT SELECT, SHOW VIEW, PROCESS ON *.* TO `backup`@`%`"},
+		},
+		{
+			name:   "pure go needs no PROCESS",
+			cfg:    Config{PureGo: true, SkipTriggers: true, Routines: RoutinesNone},
+			grants: []string{"GRANT SELECT, SHOW VIEW ON *.* TO `backup`@`%`"},
+		},
+		{
+			name:   "consistent snapshot needs RELOAD",
+			cfg:    Config{PureGo: true, ConsistentSnapshot: true, SkipTriggers: true, Routines: RoutinesNone},
+			grants: []string{"GRANT SELECT, SHOW VIEW ON *.* TO `backup`@`%`"},
+			want:   "RELOAD",
+		},
+		{
+			name:   "global privilege granted per database",
+			grants: []string{"GRANT ALL PRIVILEGES ON `shop\\_db`.* TO `backup`@`%`", "GRANT ALL PRIVILEGES ON `shopxdb`.* TO `backup`@`%`"},
+			want:   "PROCESS",
+		},
+		{
+			name:   "roles are not checked",
+			grants: []string{"GRANT USAGE ON *.* TO `backup`@`%`", "GRANT `backup_role`@`%` TO `backup`@`%`"},
+		},
+	}
+
+	for _, test := range tests {
+		planner := &grantsPlanner{grants: test.grants}
+		err := checkPrivileges(planner, test.cfg, []string{"shop_db", "shopxdb"})
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("%s: checkPrivileges = %v, want no error", test.name, err)
+		case test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)):
+			t.Errorf("%s: checkPrivileges = %v, want missing %s", test.name, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Progress tracks the run progress across concurrent table backups.
+type Progress struct {
+	started        time.Time
+	tablesTotal    atomic.Int64
+	tablesDone     atomic.Int64
+	bytesRead      atomic.Int64
+	bytesUploaded  atomic.Int64
+	estimatedBytes atomic.Int64
+}
+
+func newProgress(started time.Time) *Progress {
+	return &Progress{started: started}
+}
+
+func (p *Progress) String() string {
+	line := fmt.Sprintf("%d/%d tables, %s dumped, %s uploaded",
+		p.tablesDone.Load(),
+		p.tablesTotal.Load(),
+		FormatBytes(p.bytesRead.Load()),
+		FormatBytes(p.bytesUploaded.Load()),
+	)
+
+	if eta, ok := p.eta(time.Now()); ok {
+		line += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+
+	return line
+}
+
+func (p *Progress) eta(now time.Time) (time.Duration, bool) {
+	estimated := p.estimatedBytes.Load()
+	read := p.bytesRead.Load()
+	if estimated <= 0 || read <= 0 {
+		return 0, false
+	}
+
+	remaining := estimated - read
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	elapsed := now.Sub(p.started)
+	return time.Duration(float64(elapsed) * float64(remaining) / float64(read)), true
+}
+
+// pastDeadline reports whether the run is estimated to finish after
+// deadline, or deadline has passed already.
+func (p *Progress) pastDeadline(now time.Time, deadline time.Time) bool {
+	if !now.Before(deadline) {
+		return true
+	}
+	eta, ok := p.eta(now)
+	return ok && now.Add(eta).After(deadline)
+}
+
+func (p *Progress) report(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				log.Printf("Progress: %s\n", p)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+type countingReader struct {
+	reader io.Reader
+	counts []*atomic.Int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	for _, count := range r.counts {
+		count.Add(int64(n))
+	}
+	return n, err
+}
+
+type countingWriter struct {
+	writer io.Writer
+	counts []*atomic.Int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.writer.Write(b)
+	for _, count := range w.counts {
+		count.Add(int64(n))
+	}
+	return n, err
+}
+
+func throughputMBps(bytes int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(bytes) / 1e6 / elapsed.Seconds()
+}
+
+// FormatBytes formats a byte count with a binary unit, e.g. 1.5 GiB.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,42 @@
+package backup
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{48 << 30, "48.0 GiB"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatBytes(tt.n); got != tt.want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestProgressETA(t *testing.T) {
+	started := time.Now()
+	p := newProgress(started)
+
+	if _, ok := p.eta(started.Add(time.Minute)); ok {
+		t.Errorf("eta reported without an estimate")
+	}
+
+	p.estimatedBytes.Store(400)
+	p.bytesRead.Store(100)
+
+	eta, ok := p.eta(started.Add(time.Minute))
+	if !ok || eta != 3*time.Minute {
+		t.Errorf("eta = %s, %t, want 3m0s, true", eta, ok)
+	}
+}
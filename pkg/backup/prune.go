@@ -0,0 +1,173 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Protection rules selecting generations by their position in the calendar
+// rather than by name.
+const (
+	// ProtectMonthly protects the first generation of each month.
+	ProtectMonthly = "@monthly"
+	// ProtectWeekly protects the first generation of each ISO week.
+	ProtectWeekly = "@weekly"
+	// ProtectDaily protects the first generation of each day.
+	ProtectDaily = "@daily"
+)
+
+// PruneConfig configures the deletion of old generations of a host.
+type PruneConfig struct {
+	Store ObjectStore
+	Host  string
+	// Before is the cutoff: generations started before it are deleted.
+	Before time.Time
+	// Keep is the number of latest generations kept regardless of their
+	// age.
+	Keep int
+	// Protect are rules, see ValidateProtect, selecting generations that
+	// are never deleted.
+	Protect []string
+	// DryRun only reports the generations that would be deleted.
+	DryRun bool
+}
+
+// PrunedGeneration is a generation past the cutoff, deleted unless it was
+// protected or the prune was a dry run.
+type PrunedGeneration struct {
+	Generation string
+	Objects    int
+	Bytes      int64
+	// Protected is the rule that protected the generation, if any.
+	Protected string
+}
+
+// ValidateProtect checks that every protection rule is one of ProtectMonthly,
+// ProtectWeekly or ProtectDaily or a valid generation name pattern.
+func ValidateProtect(rules []string) error {
+	var patterns []string
+	for _, rule := range rules {
+		switch rule {
+		case ProtectMonthly, ProtectWeekly, ProtectDaily:
+			continue
+		}
+		if strings.HasPrefix(rule, "@") {
+			return fmt.Errorf("unknown protection rule %q", rule)
+		}
+		patterns = append(patterns, rule)
+	}
+	return ValidatePatterns(patterns)
+}
+
+type generationObjects struct {
+	name    string
+	started time.Time
+	objects []ObjectAttrs
+}
+
+// Prune deletes the generations of cfg.Host older than cfg.Before except for
+// the cfg.Keep latest and the protected ones. Directories whose name is not
+// a generation are never touched.
+func Prune(ctx context.Context, cfg PruneConfig) ([]PrunedGeneration, error) {
+	prefix := cfg.Host + "/"
+	objects, err := cfg.Store.List(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects under %s: %w", prefix, err)
+	}
+
+	byName := map[string]*generationObjects{}
+	for _, attrs := range objects {
+		name, _, ok := strings.Cut(strings.TrimPrefix(attrs.Name, prefix), "/")
+		if !ok {
+			continue
+		}
+		started, ok := parseGeneration(name)
+		if !ok {
+			continue
+		}
+		generation, ok := byName[name]
+		if !ok {
+			generation = &generationObjects{name: name, started: started}
+			byName[name] = generation
+		}
+		generation.objects = append(generation.objects, attrs)
+	}
+
+	var generations []*generationObjects
+	for _, generation := range byName {
+		generations = append(generations, generation)
+	}
+	sort.Slice(generations, func(i, j int) bool {
+		return generations[i].started.Before(generations[j].started)
+	})
+
+	protected := protectedGenerations(generations, cfg.Protect)
+
+	var pruned []PrunedGeneration
+	var errs []error
+	for i, generation := range generations {
+		if !generation.started.Before(cfg.Before) || i >= len(generations)-cfg.Keep {
+			continue
+		}
+
+		result := PrunedGeneration{Generation: generation.name, Objects: len(generation.objects), Protected: protected[generation.name]}
+		for _, attrs := range generation.objects {
+			result.Bytes += attrs.Size
+		}
+		pruned = append(pruned, result)
+
+		if result.Protected != "" || cfg.DryRun {
+			continue
+		}
+
+		log.Printf("Deleting generation %s%s (%d objects, %s)\n", prefix, generation.name, result.Objects, FormatBytes(result.Bytes))
+		for _, attrs := range generation.objects {
+			if err := cfg.Store.Delete(ctx, attrs.Name); err != nil && !errors.Is(err, ErrObjectNotExist) {
+				errs = append(errs, fmt.Errorf("failed to delete %s: %w", attrs.Name, err))
+			}
+		}
+	}
+
+	return pruned, errors.Join(errs...)
+}
+
+// protectedGenerations maps each protected generation to the first rule
+// protecting it. generations must be sorted by start time.
+func protectedGenerations(generations []*generationObjects, rules []string) map[string]string {
+	protected := map[string]string{}
+	for _, rule := range rules {
+		var period func(time.Time) string
+		switch rule {
+		case ProtectMonthly:
+			period = func(t time.Time) string { return t.Format("2006-01") }
+		case ProtectWeekly:
+			period = func(t time.Time) string {
+				year, week := t.ISOWeek()
+				return fmt.Sprintf("%d-%d", year, week)
+			}
+		case ProtectDaily:
+			period = func(t time.Time) string { return t.Format("2006-01-02") }
+		}
+
+		seen := map[string]bool{}
+		for _, generation := range generations {
+			var matched bool
+			if period != nil {
+				key := period(generation.started)
+				matched = !seen[key]
+				seen[key] = true
+			} else {
+				matched = matchAny([]string{rule}, generation.name)
+			}
+			if matched && protected[generation.name] == "" {
+				protected[generation.name] = rule
+			}
+		}
+	}
+	return protected
+}
@@ -0,0 +1,111 @@
+package backup
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPrune(t *testing.T) {
+	generations := []string{"2024-01-01-00", "2024-01-01-12", "2024-01-15-00", "2024-02-03-00", "2024-02-04-00", "2024-03-01-00"}
+
+	newStore := func() *MemoryStore {
+		store := NewMemoryStore()
+		for _, generation := range generations {
+			putGzipObject(t, store, "db1/"+generation+"/shop/orders.sql.gz", "orders")
+			putGzipObject(t, store, "db1/"+generation+"/manifest.json", "{}")
+		}
+		putGzipObject(t, store, "db1/notes/readme.txt", "keep")
+		return store
+	}
+
+	before, _ := time.ParseInLocation(GenerationLayout, "2024-03-01-00", time.Local)
+
+	t.Run("dry run", func(t *testing.T) {
+		store := newStore()
+		pruned, err := Prune(context.Background(), PruneConfig{Store: store, Host: "db1", Before: before, DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pruned) != 5 {
+			t.Errorf("pruned %d generations, want 5", len(pruned))
+		}
+		if pruned[0].Objects != 2 || pruned[0].Bytes == 0 {
+			t.Errorf("pruned[0] = %+v", pruned[0])
+		}
+		objects, _ := store.List(context.Background(), "db1/")
+		if len(objects) != 13 {
+			t.Errorf("dry run deleted objects, %d left", len(objects))
+		}
+	})
+
+	t.Run("protect", func(t *testing.T) {
+		store := newStore()
+		pruned, err := Prune(context.Background(), PruneConfig{
+			Store:   store,
+			Host:    "db1",
+			Before:  before,
+			Keep:    3,
+			Protect: []string{ProtectMonthly, "2024-01-15-*"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		protected := map[string]string{}
+		for _, generation := range pruned {
+			protected[generation.Generation] = generation.Protected
+		}
+		want := map[string]string{"2024-01-01-00": ProtectMonthly, "2024-01-01-12": "", "2024-01-15-00": "2024-01-15-*"}
+		if !reflect.DeepEqual(protected, want) {
+			t.Errorf("pruned = %v, want %v", protected, want)
+		}
+
+		var left []string
+		objects, _ := store.List(context.Background(), "db1/")
+		for _, attrs := range objects {
+			if table, ok := ParseTableObject(attrs.Name); ok {
+				left = append(left, table.Generation)
+			}
+		}
+		if want := []string{"2024-01-01-00", "2024-01-15-00", "2024-02-03-00", "2024-02-04-00", "2024-03-01-00"}; !reflect.DeepEqual(left, want) {
+			t.Errorf("generations left = %v, want %v", left, want)
+		}
+		if _, ok := store.Data("db1/notes/readme.txt"); !ok {
+			t.Error("non-generation object was deleted")
+		}
+	})
+}
+
+func TestPruneRecognizesEveryGranularity(t *testing.T) {
+	store := NewMemoryStore()
+	for _, generation := range []string{"2024-01-01", "2024-01-02-12", "2024-01-03-120000", "2024-01-04"} {
+		putGzipObject(t, store, "db1/"+generation+"/shop/orders.sql.gz", "orders")
+	}
+
+	before, _ := time.ParseInLocation(generationLayouts[GranularityDay], "2024-01-04", time.Local)
+	pruned, err := Prune(context.Background(), PruneConfig{Store: store, Host: "db1", Before: before})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, generation := range pruned {
+		names = append(names, generation.Generation)
+	}
+	if want := []string{"2024-01-01", "2024-01-02-12", "2024-01-03-120000"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("pruned %v, want %v", names, want)
+	}
+}
+
+func TestValidateProtect(t *testing.T) {
+	if err := ValidateProtect([]string{ProtectMonthly, ProtectWeekly, ProtectDaily, "2024-*", "/-00$/"}); err != nil {
+		t.Errorf("ValidateProtect failed: %v", err)
+	}
+	for _, rule := range []string{"@yearly", "[", "/(/"} {
+		if err := ValidateProtect([]string{rule}); err == nil {
+			t.Errorf("ValidateProtect(%q) succeeded, want error", rule)
+		}
+	}
+}
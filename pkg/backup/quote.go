@@ -0,0 +1,14 @@
+package backup
+
+import "strings"
+
+// quoteIdentifier quotes a database, table or routine name for use in SQL,
+// doubling the backticks in it as MySQL requires.
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// quoteTable quotes the qualified name of a table.
+func quoteTable(database string, table string) string {
+	return quoteIdentifier(database) + "." + quoteIdentifier(table)
+}
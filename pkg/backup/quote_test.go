@@ -0,0 +1,19 @@
+package backup
+
+import "testing"
+
+func TestQuoteIdentifier(t *testing.T) {
+	for name, want := range map[string]string{
+		"orders":        "`orders`",
+		"order items":   "`order items`",
+		"sales-eu":      "`sales-eu`",
+		"x`; DROP t; `": "`x``; DROP t; ```",
+	} {
+		if got := quoteIdentifier(name); got != want {
+			t.Errorf("quoteIdentifier(%q) = %s, want %s", name, got, want)
+		}
+	}
+	if got := quoteTable("shop", "a`b"); got != "`shop`.`a``b`" {
+		t.Errorf("quoteTable = %s", got)
+	}
+}
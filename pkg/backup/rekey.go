@@ -0,0 +1,208 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/sync/errgroup"
+)
+
+// Rekeyer is implemented by ObjectStores that can re-encrypt objects
+// server-side under a Cloud KMS key.
+type Rekeyer interface {
+	// Rekey rewrites an object under key, keeping its content and
+	// metadata, and returns the attributes of the new generation.
+	Rekey(ctx context.Context, name string, key string) (*ObjectAttrs, error)
+}
+
+func (s *GCSStore) Rekey(ctx context.Context, name string, key string) (*ObjectAttrs, error) {
+	object := s.Bucket.Object(name)
+	copier := object.CopierFrom(object)
+	copier.DestinationKMSKeyName = key
+	attrs, err := copier.Run(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotExist, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gcsObjectAttrs(attrs), nil
+}
+
+func (s *MemoryStore) Rekey(ctx context.Context, name string, key string) (*ObjectAttrs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	object, ok := s.objects[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotExist, name)
+	}
+	s.generation++
+	attrs := object.attrs
+	attrs.Generation = s.generation
+	attrs.Etag = fmt.Sprintf("%x", s.generation)
+	attrs.KMSKeyName = key + "/cryptoKeyVersions/1"
+	s.objects[name] = &memoryObject{attrs: attrs, data: object.data}
+	return &attrs, nil
+}
+
+// RekeyConfig configures Rekey.
+type RekeyConfig struct {
+	// KMSKey is the Cloud KMS key to encrypt the objects with,
+	// projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>.
+	KMSKey string
+	// Force rewrites objects already encrypted with KMSKey, which moves
+	// them to its current primary version.
+	Force   bool
+	Workers int
+	// Signer signs the updated manifest. It is required for runs whose
+	// manifest is signed.
+	Signer ManifestSigner
+}
+
+// Rekey re-encrypts the objects of the run stored under path, including its
+// run index, under cfg.KMSKey without downloading them, and updates the
+// generations and etags recorded in the manifest, which is re-signed with
+// cfg.Signer. It returns the number of objects rewritten.
+//
+// Noncurrent generations of versioned buckets remain encrypted with the
+// keys they were written with.
+func Rekey(ctx context.Context, store ObjectStore, path string, cfg RekeyConfig) (int, error) {
+	rekeyer, ok := store.(Rekeyer)
+	if !ok {
+		return 0, errors.New("store does not support rekeying objects")
+	}
+
+	m, err := LoadManifest(ctx, store, path)
+	if err != nil {
+		return 0, err
+	}
+	_, err = store.Attrs(ctx, signatureName(path))
+	signed := err == nil
+	if err != nil && !errors.Is(err, ErrObjectNotExist) {
+		return 0, fmt.Errorf("failed to look up the manifest signature: %w", err)
+	}
+	if signed && cfg.Signer == nil {
+		return 0, fmt.Errorf("the manifest of %s is signed and needs a signer to be re-signed after rekeying", path)
+	}
+
+	objects, err := store.List(ctx, path+"/")
+	if err != nil {
+		return 0, fmt.Errorf("failed to list objects of %s: %w", path, err)
+	}
+	if m.RunID != "" {
+		index, err := store.Attrs(ctx, indexName(m.RunID))
+		switch {
+		case err == nil:
+			objects = append(objects, *index)
+		case !errors.Is(err, ErrObjectNotExist):
+			return 0, fmt.Errorf("failed to look up the run index: %w", err)
+		}
+	}
+
+	var (
+		mu       sync.Mutex
+		rekeyed  = map[string]*ObjectAttrs{}
+		manifest *ObjectAttrs
+	)
+	group, groupCtx := errgroup.WithContext(ctx)
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	group.SetLimit(workers)
+	for _, object := range objects {
+		object := object
+		switch {
+		case object.Name == manifestName(path):
+			manifest = &object
+			continue
+		case object.Name == signatureName(path):
+			continue
+		case !cfg.Force && sameKMSKey(object.KMSKeyName, cfg.KMSKey):
+			continue
+		}
+
+		group.Go(func() error {
+			attrs, err := rekeyer.Rekey(groupCtx, object.Name, cfg.KMSKey)
+			if err != nil {
+				return fmt.Errorf("failed to rekey %s: %w", object.Name, err)
+			}
+			log.Printf("Rekeyed %s\n", object.Name)
+
+			mu.Lock()
+			defer mu.Unlock()
+			rekeyed[object.Name] = attrs
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		// The manifest still records the generations of the objects
+		// rewritten so far, which reads of the current generation ignore.
+		return len(rekeyed), err
+	}
+
+	if len(rekeyed) == 0 && manifest != nil && !cfg.Force && sameKMSKey(manifest.KMSKeyName, cfg.KMSKey) {
+		return 0, nil
+	}
+
+	m.updateGenerations(rekeyed)
+	if !signed {
+		cfg.Signer = nil
+	}
+	if err := m.upload(ctx, &Uploader{Store: store}, cfg.Signer); err != nil {
+		return len(rekeyed), fmt.Errorf("failed to upload the updated manifest: %w", err)
+	}
+	names := []string{manifestName(path)}
+	if signed {
+		names = append(names, signatureName(path))
+	}
+	for _, name := range names {
+		if _, err := rekeyer.Rekey(ctx, name, cfg.KMSKey); err != nil {
+			return len(rekeyed), fmt.Errorf("failed to rekey %s: %w", name, err)
+		}
+	}
+
+	return len(rekeyed) + len(names), nil
+}
+
+// sameKMSKey reports whether the key an object is encrypted with, which GCS
+// reports with its version, is key.
+func sameKMSKey(objectKey string, key string) bool {
+	objectKey, _, _ = strings.Cut(objectKey, "/cryptoKeyVersions/")
+	return objectKey == key
+}
+
+// updateGenerations records the generations and etags of rewritten objects.
+func (m *Manifest) updateGenerations(objects map[string]*ObjectAttrs) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	update := func(object string, generation *int64, etag *string, parts []string, partGenerations []int64) {
+		if attrs, ok := objects[object]; ok {
+			*generation = attrs.Generation
+			*etag = attrs.Etag
+		}
+		if len(partGenerations) != len(parts) {
+			return
+		}
+		for i, part := range parts {
+			if attrs, ok := objects[part]; ok {
+				partGenerations[i] = attrs.Generation
+			}
+		}
+	}
+	for i := range m.Tables {
+		table := &m.Tables[i]
+		update(table.Object, &table.ObjectGeneration, &table.Etag, table.Parts, table.PartGenerations)
+		for j := range table.Partitions {
+			partition := &table.Partitions[j]
+			update(partition.Object, &partition.ObjectGeneration, &partition.Etag, partition.Parts, partition.PartGenerations)
+		}
+	}
+}
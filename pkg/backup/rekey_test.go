@@ -0,0 +1,75 @@
+package backup
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+const testKMSKey = "projects/p/locations/global/keyRings/backups/cryptoKeys/2024"
+
+func TestRekey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	store := NewMemoryStore()
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders", "customers"}},
+	}
+	cfg := testConfig(store, planner, &fakeDumper{})
+	cfg.Signer = &KeySigner{Key: key}
+	m, err := Run(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Rekey(ctx, store, m.Path, RekeyConfig{KMSKey: testKMSKey}); err == nil || !strings.Contains(err.Error(), "signed") {
+		t.Fatalf("Rekey of a signed run without signer = %v, want error", err)
+	}
+
+	rekeyConfig := RekeyConfig{KMSKey: testKMSKey, Workers: 2, Signer: cfg.Signer}
+	rewritten, err := Rekey(ctx, store, m.Path, rekeyConfig)
+	if err != nil {
+		t.Fatalf("Rekey failed: %v", err)
+	}
+	if rewritten == 0 {
+		t.Fatal("Rekey rewrote no objects")
+	}
+
+	objects, err := store.List(ctx, m.Path+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, object := range objects {
+		if !sameKMSKey(object.KMSKeyName, testKMSKey) {
+			t.Errorf("%s is encrypted with %q after rekeying", object.Name, object.KMSKeyName)
+		}
+	}
+
+	rekeyed, err := LoadManifest(ctx, store, m.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range rekeyed.Tables {
+		attrs, err := store.Attrs(ctx, table.Object)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if table.ObjectGeneration != attrs.Generation || table.Etag != attrs.Etag {
+			t.Errorf("manifest records generation %d of %s, want %d", table.ObjectGeneration, table.Object, attrs.Generation)
+		}
+	}
+	if err := VerifyManifest(ctx, store, m.Path, key.Public()); err != nil {
+		t.Errorf("VerifyManifest after rekeying = %v", err)
+	}
+
+	if rewritten, err := Rekey(ctx, store, m.Path, rekeyConfig); err != nil || rewritten != 0 {
+		t.Errorf("second Rekey = %d, %v, want nothing rewritten", rewritten, err)
+	}
+}
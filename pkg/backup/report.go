@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"time"
+)
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"duration": func(from time.Time, to time.Time) string {
+		return to.Sub(from).Round(time.Millisecond).String()
+	},
+	"bytes": FormatBytes,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Backup report {{.Path}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #eee; cursor: pointer; }
+tr.failed td { background: #fdd; }
+tr.skipped td { background: #ffd; }
+</style>
+</head>
+<body>
+<h1>Backup report</h1>
+<table>
+<tr><td>Host</td><td>{{.Hostname}}</td></tr>
+<tr><td>Path</td><td>{{.Path}}</td></tr>
+<tr><td>Started</td><td>{{.Started.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><td>Duration</td><td>{{duration .Started .Finished}}</td></tr>
+<tr><td>Tables</td><td>{{len .Tables}}</td></tr>
+<tr><td>Failed</td><td>{{.Failed}}</td></tr>
+<tr><td>Uncompressed</td><td>{{bytes .UncompressedBytes}}</td></tr>
+<tr><td>Compressed</td><td>{{bytes .CompressedBytes}}</td></tr>
+<tr><td>Compression ratio</td><td>{{printf "%.2f" .CompressionRatio}}</td></tr>
+</table>
+{{if .Errors}}
+<h2>Errors</h2>
+<ul>
+{{range .Errors}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}
+<h2>Tables</h2>
+<table id="tables">
+<thead>
+<tr><th>Database</th><th>Table</th><th>Status</th><th>Duration</th><th>Uncompressed bytes</th><th>Compressed bytes</th><th>Ratio</th><th>MB/s</th><th>Object</th><th>Error</th></tr>
+</thead>
+<tbody>
+{{range .Tables}}<tr class="{{.Status}}"><td>{{.Database}}</td><td>{{.Table}}</td><td>{{.Status}}</td><td>{{duration .Started .Finished}}</td><td>{{.UncompressedBytes}}</td><td>{{.CompressedBytes}}</td><td>{{printf "%.2f" .CompressionRatio}}</td><td>{{printf "%.1f" .ThroughputMBps}}</td><td>{{.Object}}</td><td>{{.Error}}</td></tr>
+{{end}}</tbody>
+</table>
+<script>
+document.querySelectorAll("#tables th").forEach(function (th, column) {
+  var ascending = true;
+  th.addEventListener("click", function () {
+    var tbody = document.querySelector("#tables tbody");
+    var rows = Array.prototype.slice.call(tbody.rows);
+    rows.sort(function (a, b) {
+      var x = a.cells[column].textContent, y = b.cells[column].textContent;
+      return ascending ? x.localeCompare(y, undefined, {numeric: true}) : y.localeCompare(x, undefined, {numeric: true});
+    });
+    ascending = !ascending;
+    rows.forEach(function (row) { tbody.appendChild(row); });
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+func (m *Manifest) renderHTML() ([]byte, error) {
+	failed := m.FailedTables()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var buf bytes.Buffer
+	err := reportTemplate.Execute(&buf, struct {
+		*Manifest
+		Failed int
+	}{m, failed})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render HTML report: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (m *Manifest) uploadHTMLReport(ctx context.Context, uploader *Uploader) error {
+	data, err := m.renderHTML()
+	if err != nil {
+		return err
+	}
+
+	return uploader.UploadObject(ctx, fmt.Sprintf("%s/report.html", m.Path), "text/html; charset=utf-8", data)
+}
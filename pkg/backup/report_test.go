@@ -0,0 +1,27 @@
+package backup
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderHTMLHighlightsFailures(t *testing.T) {
+	started := time.Now()
+	m := newManifest("host", "host/2024-01-01-00", started)
+	m.addTable(TableResult{Database: "shop", Table: "orders", Status: StatusSucceeded, Started: started, Finished: started})
+	m.addTable(TableResult{Database: "shop", Table: "<users>", Status: StatusFailed, Error: "boom", Started: started, Finished: started})
+	m.addError(errors.New("failed to list crm"))
+
+	html, err := m.renderHTML()
+	if err != nil {
+		t.Fatalf("renderHTML failed: %v", err)
+	}
+
+	for _, want := range []string{`<tr class="failed"><td>shop</td><td>&lt;users&gt;</td>`, "failed to list crm", "<td>Failed</td><td>1</td>"} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("report does not contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,507 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Applier applies a SQL dump to a database.
+type Applier interface {
+	Apply(ctx context.Context, database string, dump io.Reader) error
+	ServerVersion(ctx context.Context) (string, error)
+	CountRows(ctx context.Context, database string, table string) (int64, error)
+}
+
+// MySQLApplier applies dumps with the mysql client.
+type MySQLApplier struct {
+	Connection Connection
+	// Path is the mysql binary, looked up in PATH if empty.
+	Path string
+}
+
+func (a *MySQLApplier) binary() string {
+	if a.Path == "" {
+		return "mysql"
+	}
+	return a.Path
+}
+
+// Apply pipes dump into mysql connected to database; an empty database
+// applies the dump without a default database.
+func (a *MySQLApplier) Apply(ctx context.Context, database string, dump io.Reader) error {
+	args := a.Connection.args()
+	if database != "" {
+		args = append(args, "--database="+database)
+	}
+
+	cmd := exec.CommandContext(ctx, a.binary(), args...)
+	cmd.Stdin = dump
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to execute mysql command: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// ServerVersion returns the version reported by the server.
+func (a *MySQLApplier) ServerVersion(ctx context.Context) (string, error) {
+	args := append(a.Connection.args(), "--skip-column-names", "-e", "SELECT VERSION()")
+	cmd := exec.CommandContext(ctx, a.binary(), args...)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to execute mysql command: %w", err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// CountRows returns the number of rows in a table.
+func (a *MySQLApplier) CountRows(ctx context.Context, database string, table string) (int64, error) {
+	query := "SELECT COUNT(*) FROM " + quoteTable(database, table)
+	args := append(a.Connection.args(), "--skip-column-names", "-e", query)
+	cmd := exec.CommandContext(ctx, a.binary(), args...)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute mysql command: %w", err)
+	}
+
+	rows, err := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse row count of %s.%s: %w", database, table, err)
+	}
+
+	return rows, nil
+}
+
+// RestoreConfig configures a restore of one backup generation.
+type RestoreConfig struct {
+	Connection Connection
+	Store      ObjectStore
+	Host       string
+	// Generation defaults to the latest generation of Host.
+	Generation string
+
+	// Tables limits the restore to tables matching any of the patterns. A
+	// pattern containing a dot matches "database.table", otherwise it matches
+	// the table name in every database; see path.Match for the syntax.
+	Tables []string
+
+	// DatabaseMap renames source databases; TableMap renames source tables
+	// keyed by "database.table".
+	DatabaseMap map[string]string
+	TableMap    map[string]string
+
+	// Concurrency bounds the number of tables restored in parallel; it
+	// defaults to 1.
+	Concurrency int
+	// DisableForeignKeyChecks turns off foreign key checks in each restore
+	// session so tables can be loaded in any order.
+	DisableForeignKeyChecks bool
+	// DryRun only validates the selected dumps and the target server
+	// version; nothing is applied.
+	DryRun bool
+	// Verify compares the row count of every restored table with the count
+	// recorded in the manifest of the generation.
+	Verify bool
+
+	// SkipRoutines does not restore the routines and events dumped once
+	// per database of the restored tables.
+	SkipRoutines bool
+
+	// Applier defaults to the mysql binary at MysqlPath using Connection.
+	Applier   Applier
+	MysqlPath string
+}
+
+// RestoreResult is the outcome of restoring a single table.
+type RestoreResult struct {
+	Object         string
+	Database       string
+	Table          string
+	TargetDatabase string
+	TargetTable    string
+	Partitions     []string
+	Duration       time.Duration
+	Err            error
+
+	// ExpectedRows and Rows are set when the restore was verified.
+	ExpectedRows int64
+	Rows         int64
+}
+
+// Restore applies the table dumps of a generation to the server. It returns
+// one result per table and an error if any table failed.
+func Restore(ctx context.Context, cfg RestoreConfig) ([]RestoreResult, error) {
+	applier := cfg.Applier
+	if applier == nil {
+		applier = &MySQLApplier{Connection: cfg.Connection, Path: cfg.MysqlPath}
+	}
+	if mysql, ok := applier.(*MySQLApplier); ok {
+		if err := restorePreflight(ctx, mysql); err != nil {
+			return nil, err
+		}
+	}
+
+	tables, err := generationTables(ctx, cfg.Store, cfg.Host, cfg.Generation)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Tables) > 0 {
+		tables = selectTables(tables, cfg.Tables)
+		if len(tables) == 0 {
+			return nil, fmt.Errorf("%w: no table backups match %s", ErrObjectNotExist, strings.Join(cfg.Tables, ","))
+		}
+	}
+
+	if cfg.DryRun {
+		return validateRestore(ctx, &cfg, applier, tables)
+	}
+
+	// With foreign key checks enabled a table can only be loaded once the
+	// tables it references are, so restore in dependency order.
+	var dependencies map[string][]string
+	if !cfg.DisableForeignKeyChecks {
+		if dependencies, err = tableDependencies(ctx, cfg.Store, tables); err != nil {
+			return nil, err
+		}
+
+		var cyclic []string
+		tables, cyclic = orderTables(tables, dependencies)
+		for _, key := range cyclic {
+			delete(dependencies, key)
+		}
+		if len(cyclic) > 0 {
+			log.Printf("Foreign key cycle between %s, these tables may fail to restore with foreign key checks enabled\n", strings.Join(cyclic, ", "))
+		}
+	}
+
+	log.Printf("Restoring %d table(s) from %s/%s\n", len(tables), cfg.Host, tables[0].Generation)
+
+	results := make([]RestoreResult, len(tables))
+	done := map[string]chan struct{}{}
+	created := map[string]bool{}
+	for i, table := range tables {
+		results[i] = cfg.target(table)
+		done[table.Database+"."+table.Table] = make(chan struct{})
+
+		database := results[i].TargetDatabase
+		if created[database] {
+			continue
+		}
+		statement := "CREATE DATABASE IF NOT EXISTS " + quoteIdentifier(database)
+		if err := applier.Apply(ctx, "", strings.NewReader(statement)); err != nil {
+			return nil, fmt.Errorf("failed to create database %s: %w", database, err)
+		}
+		created[database] = true
+	}
+
+	concurrency := cfg.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	group := new(errgroup.Group)
+	group.SetLimit(concurrency)
+
+	// Tables are started in dependency order, so the parents a table waits
+	// for already hold a worker or have finished.
+	for i := range results {
+		result := &results[i]
+
+		group.Go(func() error {
+			key := result.Database + "." + result.Table
+			defer close(done[key])
+
+			for _, parent := range dependencies[key] {
+				if parentDone, ok := done[parent]; ok {
+					<-parentDone
+				}
+			}
+
+			started := time.Now()
+			log.Printf("Restoring table \"%s.%s\" into \"%s.%s\"\n", result.Database, result.Table, result.TargetDatabase, result.TargetTable)
+
+			result.Err = restoreTable(ctx, cfg.Store, applier, *result, cfg.DisableForeignKeyChecks)
+			result.Duration = time.Since(started)
+			if result.Err != nil {
+				log.Printf("Restore of table \"%s.%s\" failed: %v\n", result.Database, result.Table, result.Err)
+			} else {
+				log.Printf("Restore of table \"%s.%s\" completed in %s.\n", result.Database, result.Table, result.Duration.Round(time.Millisecond))
+			}
+
+			return nil
+		})
+	}
+	group.Wait()
+
+	var errs []error
+	if !cfg.SkipRoutines {
+		restored := map[string]bool{}
+		for _, result := range results {
+			if restored[result.Database] {
+				continue
+			}
+			restored[result.Database] = true
+			path := cfg.Host + "/" + tables[0].Generation
+			if err := restoreRoutines(ctx, cfg.Store, applier, path, result.Database, result.TargetDatabase); err != nil {
+				log.Println(err)
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if cfg.Verify {
+		if err := verifyRestore(ctx, &cfg, applier, tables[0].Generation, results); err != nil {
+			return results, err
+		}
+	}
+
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+
+	return results, errors.Join(errs...)
+}
+
+// RestoreStream applies a single dump read from r, gzip-compressed or not,
+// to database, which is created if it does not exist, with the applier and
+// foreign key settings of cfg. The other settings of cfg, which select and
+// rename the tables of a generation, do not apply.
+func RestoreStream(ctx context.Context, cfg RestoreConfig, database string, r io.Reader) error {
+	applier := cfg.Applier
+	if applier == nil {
+		applier = &MySQLApplier{Connection: cfg.Connection, Path: cfg.MysqlPath}
+	}
+	if mysql, ok := applier.(*MySQLApplier); ok {
+		if err := restorePreflight(ctx, mysql); err != nil {
+			return err
+		}
+	}
+
+	reader := bufio.NewReaderSize(r, chunkSize)
+	var dump io.Reader = reader
+	if magic, _ := reader.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("failed to decompress the dump: %w", err)
+		}
+		dump = gzipReader
+	}
+	if cfg.DisableForeignKeyChecks {
+		dump = io.MultiReader(
+			strings.NewReader("SET FOREIGN_KEY_CHECKS=0;\n"),
+			dump,
+			strings.NewReader("\nSET FOREIGN_KEY_CHECKS=1;\n"),
+		)
+	}
+
+	if err := applier.Apply(ctx, "", strings.NewReader("CREATE DATABASE IF NOT EXISTS "+quoteIdentifier(database))); err != nil {
+		return fmt.Errorf("failed to create database %s: %w", database, err)
+	}
+	if err := applier.Apply(ctx, database, dump); err != nil {
+		return fmt.Errorf("failed to apply the dump to database %s: %w", database, err)
+	}
+	return nil
+}
+
+func generationTables(ctx context.Context, store ObjectStore, host string, generation string) ([]TableObject, error) {
+	prefix := host + "/"
+	if generation != "" {
+		prefix += generation + "/"
+	}
+
+	tables, err := ListTableObjects(ctx, store, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("%w: no table backups under %s", ErrObjectNotExist, prefix)
+	}
+
+	if generation == "" {
+		latest := tables[len(tables)-1].Generation
+		var selected []TableObject
+		for _, table := range tables {
+			if table.Generation == latest {
+				selected = append(selected, table)
+			}
+		}
+		tables = selected
+	}
+
+	return tables, nil
+}
+
+func selectTables(tables []TableObject, patterns []string) []TableObject {
+	var selected []TableObject
+	for _, table := range tables {
+		if matchTable(patterns, table.Database, table.Table) {
+			selected = append(selected, table)
+		}
+	}
+	return selected
+}
+
+func matchTable(patterns []string, database string, table string) bool {
+	for _, pattern := range patterns {
+		name := table
+		if strings.Contains(pattern, ".") {
+			name = database + "." + table
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *RestoreConfig) target(table TableObject) RestoreResult {
+	result := RestoreResult{
+		Object:         table.Name(),
+		Database:       table.Database,
+		Table:          table.Table,
+		TargetDatabase: table.Database,
+		TargetTable:    table.Table,
+		Partitions:     table.Partitions,
+	}
+
+	if database, ok := cfg.DatabaseMap[table.Database]; ok {
+		result.TargetDatabase = database
+	}
+	if name, ok := cfg.TableMap[table.Database+"."+table.Table]; ok {
+		result.TargetTable = name
+	}
+
+	return result
+}
+
+// restoreTable applies the dump of a table followed by the dumps of its
+// partitions, if it was dumped per partition.
+func restoreTable(ctx context.Context, store ObjectStore, applier Applier, result RestoreResult, disableForeignKeyChecks bool) error {
+	for _, name := range append([]string{result.Object}, result.Partitions...) {
+		if err := applyObject(ctx, store, applier, name, result, disableForeignKeyChecks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyObject(ctx context.Context, store ObjectStore, applier Applier, name string, result RestoreResult, disableForeignKeyChecks bool) error {
+	reader, err := openDump(ctx, store, name)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	decompressed, err := decompress(ctx, store, name, reader)
+	if err != nil {
+		return fmt.Errorf("failed to decompress %s: %w", name, err)
+	}
+	defer decompressed.Close()
+
+	var dump io.Reader = decompressed
+	if result.TargetTable != result.Table {
+		dump = renameTableReader(decompressed, result.Table, result.TargetTable)
+	}
+
+	if disableForeignKeyChecks {
+		dump = io.MultiReader(
+			strings.NewReader("SET FOREIGN_KEY_CHECKS=0;\n"),
+			dump,
+			strings.NewReader("\nSET FOREIGN_KEY_CHECKS=1;\n"),
+		)
+	}
+
+	return applier.Apply(ctx, result.TargetDatabase, dump)
+}
+
+// Statement prefixes mysqldump emits directly before the table identifier.
+var tableStatementPrefixes = []string{
+	"-- Table structure for table ",
+	"-- Dumping data for table ",
+	"DROP TABLE IF EXISTS ",
+	"CREATE TABLE ",
+	"LOCK TABLES ",
+	"INSERT INTO ",
+	"/*!40000 ALTER TABLE ",
+}
+
+// renameTableReader rewrites references to table in a mysqldump stream of
+// that single table. Only the identifiers in statement heads and trigger
+// definitions are rewritten, so row data is never touched.
+func renameTableReader(r io.Reader, from string, to string) io.Reader {
+	pr, pw := io.Pipe()
+
+	go func() {
+		pw.CloseWithError(renameTable(r, pw, from, to))
+	}()
+
+	return pr
+}
+
+func renameTable(r io.Reader, w io.Writer, from string, to string) error {
+	oldName := quoteIdentifier(from)
+	newName := quoteIdentifier(to)
+	triggerOld := " ON " + oldName + " FOR EACH ROW"
+	triggerNew := " ON " + newName + " FOR EACH ROW"
+
+	reader := bufio.NewReaderSize(r, chunkSize)
+	writer := bufio.NewWriterSize(w, chunkSize)
+
+	for {
+		line, readErr := reader.ReadBytes('\n')
+
+		if len(line) > 0 {
+			line = renameTableLine(line, oldName, newName, triggerOld, triggerNew)
+			if _, err := writer.Write(line); err != nil {
+				return err
+			}
+		}
+
+		if readErr == io.EOF {
+			return writer.Flush()
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+func renameTableLine(line []byte, oldName string, newName string, triggerOld string, triggerNew string) []byte {
+	for _, prefix := range tableStatementPrefixes {
+		head := prefix + oldName
+		if bytes.HasPrefix(line, []byte(head)) {
+			return append([]byte(prefix+newName), line[len(head):]...)
+		}
+	}
+
+	if bytes.Contains(line, []byte("TRIGGER")) {
+		if i := bytes.Index(line, []byte(triggerOld)); i >= 0 {
+			return append(append(append([]byte{}, line[:i]...), triggerNew...), line[i+len(triggerOld):]...)
+		}
+	}
+
+	return line
+}
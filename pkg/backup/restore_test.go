@@ -0,0 +1,415 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+const ordersDump = "-- Table structure for table `orders`\n" +
+	"DROP TABLE IF EXISTS `orders`;\n" +
+	"CREATE TABLE `orders` (\n  `id` int NOT NULL\n);\n" +
+	"LOCK TABLES `orders` WRITE;\n" +
+	"/*!40000 ALTER TABLE `orders` DISABLE KEYS */;\n" +
+	"INSERT INTO `orders` VALUES (1,'INSERT INTO `orders`');\n" +
+	"/*!50003 CREATE*/ /*!50003 TRIGGER `orders_ai` AFTER INSERT ON `orders` FOR EACH ROW SET @x = 1 */;;\n"
+
+func TestRenameTable(t *testing.T) {
+	var out strings.Builder
+	if err := renameTable(strings.NewReader(ordersDump), &out, "orders", "orders_copy"); err != nil {
+		t.Fatalf("renameTable failed: %v", err)
+	}
+
+	want := "-- Table structure for table `orders_copy`\n" +
+		"DROP TABLE IF EXISTS `orders_copy`;\n" +
+		"CREATE TABLE `orders_copy` (\n  `id` int NOT NULL\n);\n" +
+		"LOCK TABLES `orders_copy` WRITE;\n" +
+		"/*!40000 ALTER TABLE `orders_copy` DISABLE KEYS */;\n" +
+		"INSERT INTO `orders_copy` VALUES (1,'INSERT INTO `orders`');\n" +
+		"/*!50003 CREATE*/ /*!50003 TRIGGER `orders_ai` AFTER INSERT ON `orders_copy` FOR EACH ROW SET @x = 1 */;;\n"
+	if out.String() != want {
+		t.Errorf("renameTable =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestRenameQuotedTable(t *testing.T) {
+	var out strings.Builder
+	dump := "DROP TABLE IF EXISTS `order``s`;\nINSERT INTO `order``s` VALUES (1);\n"
+	if err := renameTable(strings.NewReader(dump), &out, "order`s", "order items"); err != nil {
+		t.Fatalf("renameTable failed: %v", err)
+	}
+	if want := "DROP TABLE IF EXISTS `order items`;\nINSERT INTO `order items` VALUES (1);\n"; out.String() != want {
+		t.Errorf("renameTable = %q, want %q", out.String(), want)
+	}
+}
+
+func TestRestoreMapsDatabasesAndTables(t *testing.T) {
+	store := NewMemoryStore()
+	putGzipObject(t, store, "db1/2024-01-01-00/shop/orders.sql.gz", "stale")
+	putGzipObject(t, store, "db1/2024-01-02-00/shop/orders.sql.gz", ordersDump)
+	putGzipObject(t, store, "db1/2024-01-02-00/crm/leads.sql.gz", "INSERT INTO `leads` VALUES (1);\n")
+
+	applier := &fakeApplier{}
+	results, err := Restore(context.Background(), RestoreConfig{
+		Store:       store,
+		Host:        "db1",
+		DatabaseMap: map[string]string{"shop": "staging_shop"},
+		TableMap:    map[string]string{"shop.orders": "orders_copy"},
+		Applier:     applier,
+	})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Restore returned %d results, want 2", len(results))
+	}
+
+	if got := applier.applied["staging_shop"]; !strings.Contains(got, "CREATE TABLE `orders_copy`") || strings.Contains(got, "stale") {
+		t.Errorf("staging_shop received %q", got)
+	}
+	if got := applier.applied["crm"]; got != "INSERT INTO `leads` VALUES (1);\n" {
+		t.Errorf("crm received %q", got)
+	}
+	if got := applier.applied[""]; !strings.Contains(got, "CREATE DATABASE IF NOT EXISTS `staging_shop`") {
+		t.Errorf("target database was not created: %q", got)
+	}
+}
+
+func TestRestoreReportsFailedTables(t *testing.T) {
+	store := NewMemoryStore()
+	putGzipObject(t, store, "db1/2024-01-02-00/shop/orders.sql.gz", ordersDump)
+	putGzipObject(t, store, "db1/2024-01-02-00/crm/leads.sql.gz", "")
+
+	results, err := Restore(context.Background(), RestoreConfig{
+		Store:   store,
+		Host:    "db1",
+		Applier: &fakeApplier{failed: map[string]error{"crm": errFake}},
+	})
+	if err == nil {
+		t.Fatalf("Restore succeeded, want failure")
+	}
+
+	for _, result := range results {
+		if (result.Err != nil) != (result.Database == "crm") {
+			t.Errorf("result for %s.%s: err = %v", result.Database, result.Table, result.Err)
+		}
+	}
+}
+
+func TestRestoreSkipsTablesWithFailedPartitions(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	planner := &fakePlanner{
+		databases:  []string{"shop"},
+		tables:     map[string][]string{"shop": {"events", "orders"}},
+		partitions: map[string][]string{"shop.events": {"p2023", "p2024"}},
+	}
+	dumper := &fakeDumper{dumps: map[string]string{
+		"shop.events": "CREATE TABLE `events` (`id` int);\n",
+		"shop.orders": ordersDump,
+	}}
+	partitionDumper := &fakeDumper{dumps: map[string]string{
+		"shop.events#p2023": "INSERT INTO `events` VALUES (1);\n",
+		"shop.events#p2024": "INSERT INTO `events` VALUES (2);\n",
+	}}
+	cfg := testConfig(store, planner, dumper)
+	cfg.SplitPartitions = true
+	cfg.PartitionDumper = partitionDumper
+	cfg.started = time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	if _, err := Run(ctx, cfg); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	// The next run fails the second partition after the definition and
+	// the first partition were uploaded.
+	partitionDumper.dumps["shop.events#p2023"] = "INSERT INTO `events` VALUES (10);\n"
+	partitionDumper.failed = map[string]error{"shop.events#p2024": errFake}
+	cfg.started = time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)
+	if _, err := Run(ctx, cfg); err == nil {
+		t.Fatal("Run succeeded, want the failure of partition p2024")
+	}
+	if _, ok := store.Data("host/2024-01-01-11/shop/events/p2023.sql.gz"); !ok {
+		t.Fatal("the first partition of the failed table was not uploaded")
+	}
+
+	applier := &fakeApplier{}
+	results, err := Restore(ctx, RestoreConfig{Store: store, Host: "host", Applier: applier})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Table != "orders" {
+		t.Errorf("Restore = %+v, want only orders", results)
+	}
+	if got := applier.applied["shop"]; strings.Contains(got, "`events`") {
+		t.Errorf("the failed table was restored: %q", got)
+	}
+
+	object, err := FindTableObject(ctx, store, "host", "", "shop", "events")
+	if err != nil {
+		t.Fatalf("FindTableObject failed: %v", err)
+	}
+	if object.Generation != "2024-01-01-10" || len(object.Partitions) != 2 {
+		t.Errorf("FindTableObject = %s with partitions %v, want the complete dump of 2024-01-01-10", object.Name(), object.Partitions)
+	}
+}
+
+func TestRestoreInParallelWithoutForeignKeyChecks(t *testing.T) {
+	store := NewMemoryStore()
+	for _, table := range []string{"a", "b", "c", "d"} {
+		putGzipObject(t, store, "db1/2024-01-02-00/db_"+table+"/"+table+".sql.gz", "INSERT INTO `"+table+"` VALUES (1);")
+	}
+
+	applier := &fakeApplier{}
+	results, err := Restore(context.Background(), RestoreConfig{
+		Store:                   store,
+		Host:                    "db1",
+		Concurrency:             3,
+		DisableForeignKeyChecks: true,
+		Applier:                 applier,
+	})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("Restore returned %d results, want 4", len(results))
+	}
+
+	want := "SET FOREIGN_KEY_CHECKS=0;\nINSERT INTO `b` VALUES (1);\nSET FOREIGN_KEY_CHECKS=1;\n"
+	if got := applier.applied["db_b"]; got != want {
+		t.Errorf("db_b received %q, want %q", got, want)
+	}
+}
+
+func TestRestoreDryRunValidatesWithoutApplying(t *testing.T) {
+	store := NewMemoryStore()
+	putGzipObject(t, store, "db1/2024-01-02-00/shop/orders.sql.gz", "-- MySQL dump 10.13\n--\n-- Host: localhost\n"+serverVersionComment+"\t8.0.35\n"+ordersDump)
+	putGzipObject(t, store, "db1/2024-01-02-00/shop/users.sql.gz", ordersDump)
+	if err := store.NewWriter(context.Background(), "db1/2024-01-02-00/crm/leads.sql.gz", "application/gzip", nil).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	applier := &fakeApplier{version: "8.0.36-log"}
+	results, err := Restore(context.Background(), RestoreConfig{
+		Store:   store,
+		Host:    "db1",
+		DryRun:  true,
+		Applier: applier,
+	})
+	if err == nil {
+		t.Fatalf("Restore succeeded, want failure for the corrupt dump")
+	}
+	if len(results) != 3 {
+		t.Fatalf("Restore returned %d results, want 3", len(results))
+	}
+	for _, result := range results {
+		if (result.Err != nil) != (result.Table == "leads") {
+			t.Errorf("result for %s.%s: err = %v", result.Database, result.Table, result.Err)
+		}
+	}
+	if len(applier.applied) != 0 {
+		t.Errorf("dry run applied %v", applier.applied)
+	}
+
+	applier.version = "5.7.44"
+	results, _ = Restore(context.Background(), RestoreConfig{Store: store, Host: "db1", Generation: "2024-01-02-00", DryRun: true, Applier: applier})
+	for _, result := range results {
+		if result.Table == "orders" && result.Err == nil {
+			t.Errorf("restoring an 8.0 dump into 5.7 was not rejected")
+		}
+	}
+}
+
+func TestServerVersionCompatible(t *testing.T) {
+	tests := []struct {
+		source string
+		target string
+		want   bool
+	}{
+		{"8.0.35", "8.0.20", true},
+		{"8.0.35", "8.4.0", true},
+		{"5.7.44-log", "8.0.36", true},
+		{"8.0.35", "5.7.44", false},
+		{"8.4.0", "8.0.36", false},
+		{"unknown", "8.0.36", true},
+	}
+
+	for _, test := range tests {
+		if got := serverVersionCompatible(test.source, test.target); got != test.want {
+			t.Errorf("serverVersionCompatible(%q, %q) = %v, want %v", test.source, test.target, got, test.want)
+		}
+	}
+}
+
+func TestRestoreSelectedTables(t *testing.T) {
+	store := NewMemoryStore()
+	for _, name := range []string{"shop/orders", "shop/users", "crm/orders", "payments/cards", "payments/refunds"} {
+		putGzipObject(t, store, "db1/2024-01-02-00/"+name+".sql.gz", name+"\n")
+	}
+
+	results, err := Restore(context.Background(), RestoreConfig{
+		Store:   store,
+		Host:    "db1",
+		Tables:  []string{"orders", "payments.*"},
+		Applier: &fakeApplier{},
+	})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	var restored []string
+	for _, result := range results {
+		restored = append(restored, result.Database+"."+result.Table)
+	}
+	if got, want := strings.Join(restored, ","), "crm.orders,payments.cards,payments.refunds,shop.orders"; got != want {
+		t.Errorf("restored %s, want %s", got, want)
+	}
+
+	if _, err := Restore(context.Background(), RestoreConfig{Store: store, Host: "db1", Tables: []string{"missing"}, Applier: &fakeApplier{}}); !errors.Is(err, ErrObjectNotExist) {
+		t.Errorf("Restore of unmatched tables: err = %v, want ErrObjectNotExist", err)
+	}
+}
+
+func TestSchemaReferences(t *testing.T) {
+	dump := "CREATE TABLE `payments` (\n" +
+		"  `id` int NOT NULL,\n" +
+		"  CONSTRAINT `payments_order` FOREIGN KEY (`order_id`) REFERENCES `orders` (`id`),\n" +
+		"  CONSTRAINT `payments_user` FOREIGN KEY (`user_id`) REFERENCES `crm`.`users` (`id`),\n" +
+		"  CONSTRAINT `payments_parent` FOREIGN KEY (`parent_id`) REFERENCES `payments` (`id`),\n" +
+		"  CONSTRAINT `payments_refund` FOREIGN KEY (`refund_id`) REFERENCES `sales-eu`.`re``funds` (`id`)\n" +
+		");\n" +
+		"INSERT INTO `payments` VALUES (1,' FOREIGN KEY (`x`) REFERENCES `ignored` (`id`)');\n"
+
+	parents, err := schemaReferences(strings.NewReader(dump), "shop", "payments")
+	if err != nil {
+		t.Fatalf("schemaReferences failed: %v", err)
+	}
+	if got, want := strings.Join(parents, ","), "shop.orders,crm.users,sales-eu.re`funds"; got != want {
+		t.Errorf("schemaReferences = %s, want %s", got, want)
+	}
+}
+
+func TestRestoreOrdersParentsFirstWithForeignKeyChecks(t *testing.T) {
+	store := NewMemoryStore()
+	putGzipObject(t, store, "db1/2024-01-02-00/shop/a_payments.sql.gz", "CREATE TABLE `a_payments` (\n  CONSTRAINT `fk` FOREIGN KEY (`order_id`) REFERENCES `orders` (`id`)\n);\n")
+	putGzipObject(t, store, "db1/2024-01-02-00/shop/orders.sql.gz", "CREATE TABLE `orders` (\n  CONSTRAINT `fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`)\n);\n")
+	putGzipObject(t, store, "db1/2024-01-02-00/shop/users.sql.gz", "CREATE TABLE `users` (\n  `id` int\n);\n")
+	putGzipObject(t, store, "db1/2024-01-02-00/shop/x.sql.gz", "CREATE TABLE `x` (\n  CONSTRAINT `fk` FOREIGN KEY (`y_id`) REFERENCES `y` (`id`)\n);\n")
+	putGzipObject(t, store, "db1/2024-01-02-00/shop/y.sql.gz", "CREATE TABLE `y` (\n  CONSTRAINT `fk` FOREIGN KEY (`x_id`) REFERENCES `x` (`id`)\n);\n")
+
+	applier := &fakeApplier{}
+	results, err := Restore(context.Background(), RestoreConfig{
+		Store:       store,
+		Host:        "db1",
+		Concurrency: 4,
+		Applier:     applier,
+	})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	var order []string
+	for _, result := range results {
+		order = append(order, result.Table)
+	}
+	if got, want := strings.Join(order, ","), "users,orders,a_payments,x,y"; got != want {
+		t.Errorf("restore order = %s, want %s", got, want)
+	}
+
+	got := applier.applied["shop"]
+	if !(strings.Index(got, "`users`") < strings.Index(got, "`orders` (") && strings.Index(got, "`orders` (") < strings.Index(got, "`a_payments`")) {
+		t.Errorf("tables were applied out of dependency order:\n%s", got)
+	}
+}
+
+func TestRestoreVerifiesRowCounts(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	putGzipObject(t, store, "db1/2024-01-02-00/shop/orders.sql.gz", ordersDump)
+	putGzipObject(t, store, "db1/2024-01-02-00/shop/users.sql.gz", "")
+
+	manifest := &Manifest{Tables: []TableResult{
+		{Database: "shop", Table: "orders", Status: StatusSucceeded, Rows: 1},
+		{Database: "shop", Table: "users", Status: StatusSucceeded, Rows: 3},
+	}}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Uploader{Store: store}).UploadObject(ctx, "db1/2024-01-02-00/manifest.json", "application/json", data); err != nil {
+		t.Fatal(err)
+	}
+
+	applier := &fakeApplier{rows: map[string]int64{"copy.orders": 1, "copy.users": 2}}
+	results, err := Restore(ctx, RestoreConfig{
+		Store:       store,
+		Host:        "db1",
+		DatabaseMap: map[string]string{"shop": "copy"},
+		Verify:      true,
+		Applier:     applier,
+	})
+	if !errors.Is(err, ErrVerification) {
+		t.Fatalf("Restore err = %v, want ErrVerification", err)
+	}
+
+	for _, result := range results {
+		if (result.Err != nil) != (result.Table == "users") {
+			t.Errorf("result for %s.%s: err = %v", result.Database, result.Table, result.Err)
+		}
+		if result.Table == "users" && (result.Rows != 2 || result.ExpectedRows != 3) {
+			t.Errorf("users rows = %d, expected %d", result.Rows, result.ExpectedRows)
+		}
+	}
+}
+
+func TestRestoreAppliesPartitions(t *testing.T) {
+	store := NewMemoryStore()
+	putGzipObject(t, store, "db1/2024-01-02-00/shop/events.sql.gz", "CREATE TABLE `events` (`id` int);\n")
+	putGzipObject(t, store, "db1/2024-01-02-00/shop/events/p2023.sql.gz", "INSERT INTO `events` VALUES (1);\n")
+	putGzipObject(t, store, "db1/2024-01-02-00/shop/events/p2024.sql.gz", "INSERT INTO `events` VALUES (2);\n")
+
+	applier := &fakeApplier{}
+	results, err := Restore(context.Background(), RestoreConfig{
+		Store:    store,
+		Host:     "db1",
+		TableMap: map[string]string{"shop.events": "events_copy"},
+		Applier:  applier,
+	})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Restore returned %d results, want 1", len(results))
+	}
+
+	want := "CREATE TABLE `events_copy` (`id` int);\nINSERT INTO `events_copy` VALUES (1);\nINSERT INTO `events_copy` VALUES (2);\n"
+	if got := applier.applied["shop"]; got != want {
+		t.Errorf("shop received %q, want %q", got, want)
+	}
+}
+
+func TestRestoreStream(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(ordersDump))
+	gz.Close()
+
+	for name, input := range map[string]io.Reader{"gzip": &compressed, "plain": strings.NewReader(ordersDump)} {
+		applier := &fakeApplier{}
+		err := RestoreStream(context.Background(), RestoreConfig{Applier: applier, DisableForeignKeyChecks: true}, "staging", input)
+		if err != nil {
+			t.Fatalf("RestoreStream of a %s dump failed: %v", name, err)
+		}
+		if got := applier.applied[""]; got != "CREATE DATABASE IF NOT EXISTS `staging`" {
+			t.Errorf("%s: applied without a database %q", name, got)
+		}
+		if got := applier.applied["staging"]; got != "SET FOREIGN_KEY_CHECKS=0;\n"+ordersDump+"\nSET FOREIGN_KEY_CHECKS=1;\n" {
+			t.Errorf("%s: staging received %q", name, got)
+		}
+	}
+}
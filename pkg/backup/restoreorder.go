@@ -0,0 +1,175 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// tableDependencies reads the schema part of each dump and returns, keyed by
+// "database.table", the tables it references through foreign keys.
+func tableDependencies(ctx context.Context, store ObjectStore, tables []TableObject) (map[string][]string, error) {
+	dependencies := map[string][]string{}
+
+	for _, table := range tables {
+		parents, err := readTableReferences(ctx, store, table)
+		if err != nil {
+			return nil, err
+		}
+		if len(parents) > 0 {
+			dependencies[table.Database+"."+table.Table] = parents
+		}
+	}
+
+	return dependencies, nil
+}
+
+func readTableReferences(ctx context.Context, store ObjectStore, table TableObject) ([]string, error) {
+	name := table.Name()
+
+	reader, err := openDump(ctx, store, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	dump, err := decompress(ctx, store, name, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", name, err)
+	}
+	defer dump.Close()
+
+	parents, err := schemaReferences(dump, table.Database, table.Table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema of %s: %w", name, err)
+	}
+
+	return parents, nil
+}
+
+// schemaReferences returns the tables referenced by FOREIGN KEY constraints
+// in a mysqldump stream. Reading stops at the first data statement.
+func schemaReferences(r io.Reader, database string, table string) ([]string, error) {
+	reader := bufio.NewReaderSize(r, chunkSize)
+	self := database + "." + table
+
+	var parents []string
+	for {
+		line, err := reader.ReadString('\n')
+
+		if strings.HasPrefix(line, "INSERT INTO ") || strings.HasPrefix(line, "LOCK TABLES ") {
+			return parents, nil
+		}
+		if parent, ok := referencedTable(line, database); ok && parent != self && !contains(parents, parent) {
+			parents = append(parents, parent)
+		}
+
+		if err == io.EOF {
+			return parents, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// referencedTable parses "... REFERENCES `table` (...)" or
+// "... REFERENCES `database`.`table` (...)" into "database.table".
+func referencedTable(line string, database string) (string, bool) {
+	i := strings.Index(line, " REFERENCES `")
+	if i < 0 || !strings.Contains(line[:i], "FOREIGN KEY") {
+		return "", false
+	}
+
+	first, rest, ok := cutIdentifier(line[i+len(" REFERENCES "):])
+	if !ok {
+		return "", false
+	}
+	if strings.HasPrefix(rest, ".") {
+		second, _, ok := cutIdentifier(rest[1:])
+		if !ok {
+			return "", false
+		}
+		return first + "." + second, true
+	}
+
+	return database + "." + first, true
+}
+
+func cutIdentifier(s string) (string, string, bool) {
+	if !strings.HasPrefix(s, "`") {
+		return "", "", false
+	}
+
+	var name strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] != '`' {
+			name.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '`' {
+			name.WriteByte('`')
+			i++
+			continue
+		}
+		return name.String(), s[i+1:], true
+	}
+	return "", "", false
+}
+
+// orderTables sorts tables so that referenced tables come before the tables
+// referencing them, keeping the original order otherwise. Tables that are
+// part of a reference cycle are appended in their original order and
+// reported as cyclic.
+func orderTables(tables []TableObject, dependencies map[string][]string) ([]TableObject, []string) {
+	present := map[string]bool{}
+	for _, table := range tables {
+		present[table.Database+"."+table.Table] = true
+	}
+
+	placed := map[string]bool{}
+	ordered := make([]TableObject, 0, len(tables))
+
+	for len(ordered) < len(tables) {
+		progress := false
+
+		for _, table := range tables {
+			key := table.Database + "." + table.Table
+			if placed[key] {
+				continue
+			}
+
+			ready := true
+			for _, parent := range dependencies[key] {
+				if present[parent] && !placed[parent] {
+					ready = false
+					break
+				}
+			}
+			if !ready {
+				continue
+			}
+
+			ordered = append(ordered, table)
+			placed[key] = true
+			progress = true
+		}
+
+		if !progress {
+			break
+		}
+	}
+
+	var cyclic []string
+	for _, table := range tables {
+		key := table.Database + "." + table.Table
+		if !placed[key] {
+			ordered = append(ordered, table)
+			cyclic = append(cyclic, key)
+		}
+	}
+
+	return ordered, cyclic
+}
@@ -0,0 +1,222 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxObjectSize is the GCS object size limit of 5 TiB.
+const DefaultMaxObjectSize = 5 << 40
+
+// rolloverWriter writes a stream to name until maxSize bytes were written
+// and continues in name.part001, name.part002 and so on, so that no object
+// exceeds maxSize. The objects concatenated form the stream, see openDump.
+type rolloverWriter struct {
+	newWriter func(name string) ObjectWriter
+	name      string
+	maxSize   int64
+
+	current ObjectWriter
+	written int64
+	// parts are the continuation objects, completed is every object closed
+	// successfully so far.
+	parts     []string
+	completed []string
+	buckets   []string
+}
+
+func newRolloverWriter(name string, maxSize int64, newWriter func(name string) ObjectWriter) *rolloverWriter {
+	return &rolloverWriter{newWriter: newWriter, name: name, maxSize: maxSize, current: newWriter(name)}
+}
+
+func partName(name string, part int) string {
+	return fmt.Sprintf("%s.part%03d", name, part)
+}
+
+func (w *rolloverWriter) currentName() string {
+	if len(w.parts) == 0 {
+		return w.name
+	}
+	return w.parts[len(w.parts)-1]
+}
+
+func (w *rolloverWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if w.written == w.maxSize {
+			if err := w.closeCurrent(); err != nil {
+				return 0, err
+			}
+			next := partName(w.name, len(w.parts)+1)
+			w.parts = append(w.parts, next)
+			w.current = w.newWriter(next)
+			w.written = 0
+		}
+
+		chunk := p
+		if int64(len(chunk)) > w.maxSize-w.written {
+			chunk = chunk[:w.maxSize-w.written]
+		}
+		if _, err := w.current.Write(chunk); err != nil {
+			return 0, err
+		}
+		w.written += int64(len(chunk))
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+func (w *rolloverWriter) closeCurrent() error {
+	name := w.currentName()
+	if err := w.current.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", name, err)
+	}
+	w.completed = append(w.completed, name)
+	for _, bucket := range writerBuckets(w.current) {
+		if !contains(w.buckets, bucket) {
+			w.buckets = append(w.buckets, bucket)
+		}
+	}
+	return nil
+}
+
+func (w *rolloverWriter) Close() error {
+	return w.closeCurrent()
+}
+
+func (w *rolloverWriter) Buckets() []string {
+	return w.buckets
+}
+
+// objects returns the objects written so far, including the current one,
+// which a mirror may have completed in some of its buckets.
+func (w *rolloverWriter) objects() []string {
+	names := append([]string(nil), w.completed...)
+	if current := w.currentName(); !contains(names, current) {
+		names = append(names, current)
+	}
+	return names
+}
+
+// deleteStaleParts deletes continuation objects of name beyond those in
+// parts, left behind by an earlier, larger dump to the same object.
+func deleteStaleParts(ctx context.Context, store ObjectStore, name string, parts []string) error {
+	existing, err := listParts(ctx, store, name)
+	if err != nil {
+		return err
+	}
+	for _, part := range existing {
+		if contains(parts, part) {
+			continue
+		}
+		if err := store.Delete(ctx, part); err != nil && !errors.Is(err, ErrObjectNotExist) {
+			return fmt.Errorf("failed to delete stale part %s: %w", part, err)
+		}
+	}
+	return nil
+}
+
+// partOf returns the object a continuation object belongs to.
+func partOf(name string) (string, bool) {
+	object, _, ok := parsePartName(name)
+	return object, ok
+}
+
+// parsePartName returns the object a continuation object belongs to and
+// the number of the part, which has more than three digits from part 1000
+// on.
+func parsePartName(name string) (string, int, bool) {
+	i := strings.LastIndex(name, ".part")
+	if i < 0 {
+		return "", 0, false
+	}
+	part, err := strconv.Atoi(name[i+len(".part"):])
+	if err != nil || part < 1 || partName(name[:i], part) != name {
+		return "", 0, false
+	}
+	return name[:i], part, true
+}
+
+// listParts returns the continuation objects of name in order.
+func listParts(ctx context.Context, store ObjectStore, name string) ([]string, error) {
+	objects, err := store.List(ctx, name+".part")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list parts of %s: %w", name, err)
+	}
+
+	var parts []string
+	numbers := map[string]int{}
+	for _, attrs := range objects {
+		if object, part, ok := parsePartName(attrs.Name); ok && object == name {
+			parts = append(parts, attrs.Name)
+			numbers[attrs.Name] = part
+		}
+	}
+	// Sorted by number, as name.part1000 sorts before name.part999.
+	sort.Slice(parts, func(i, j int) bool {
+		return numbers[parts[i]] < numbers[parts[j]]
+	})
+	return parts, nil
+}
+
+// openDump opens a dump object followed by its continuation objects, if it
+// was rolled over.
+func openDump(ctx context.Context, store ObjectStore, name string) (io.ReadCloser, error) {
+	if strings.HasSuffix(name, dedupIndexSuffix) {
+		return openDeduplicated(ctx, store, name)
+	}
+	if archive, entry, ok := splitArchiveEntry(name); ok {
+		return openArchiveEntry(ctx, store, archive, entry)
+	}
+
+	parts, err := listParts(ctx, store, name)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := store.NewReader(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return reader, nil
+	}
+	return &partsReader{ctx: ctx, store: store, current: reader, parts: parts}, nil
+}
+
+// partsReader reads a sequence of objects, opening each once the previous
+// one is exhausted.
+type partsReader struct {
+	ctx     context.Context
+	store   ObjectStore
+	current io.ReadCloser
+	parts   []string
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.current.Read(p)
+		if err != io.EOF || len(r.parts) == 0 {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+
+		r.current.Close()
+		next, err := r.store.NewReader(r.ctx, r.parts[0])
+		if err != nil {
+			return 0, fmt.Errorf("failed to open %s: %w", r.parts[0], err)
+		}
+		r.current, r.parts = next, r.parts[1:]
+	}
+}
+
+func (r *partsReader) Close() error {
+	return r.current.Close()
+}
@@ -0,0 +1,153 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func randomDump(lines int) string {
+	random := rand.New(rand.NewSource(1))
+	var dump strings.Builder
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&dump, "INSERT INTO t VALUES (%d, '%x');\n", i, random.Int63())
+	}
+	return dump.String()
+}
+
+func TestUploadRollsOverLargeDumps(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	putGzipObject(t, store, "db/t.sql.gz.part099", "stale")
+
+	content := randomDump(2000)
+	uploader := &Uploader{Store: store, Progress: newProgress(time.Now()), MaxObjectSize: 4096}
+	stats, err := uploader.Upload(ctx, "db/t.sql.gz", strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	if len(stats.Parts) < 2 {
+		t.Fatalf("Parts = %v, want the dump rolled over", stats.Parts)
+	}
+	if stats.Parts[0] != "db/t.sql.gz.part001" {
+		t.Errorf("first part = %s, want db/t.sql.gz.part001", stats.Parts[0])
+	}
+
+	if len(stats.PartGenerations) != len(stats.Parts) {
+		t.Fatalf("PartGenerations = %v, want one per part of %v", stats.PartGenerations, stats.Parts)
+	}
+	var size int64
+	for i, name := range append([]string{"db/t.sql.gz"}, stats.Parts...) {
+		attrs, err := store.Attrs(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && attrs.Generation != stats.PartGenerations[i-1] {
+			t.Errorf("%s generation = %d, recorded %d", name, attrs.Generation, stats.PartGenerations[i-1])
+		}
+		if attrs.Size > 4096 {
+			t.Errorf("%s is %d bytes, more than the maximum object size", name, attrs.Size)
+		}
+		size += attrs.Size
+	}
+	if size != stats.CompressedBytes {
+		t.Errorf("objects add up to %d bytes, want %d", size, stats.CompressedBytes)
+	}
+
+	if _, ok := store.Data("db/t.sql.gz.part099"); ok && !contains(stats.Parts, "db/t.sql.gz.part099") {
+		t.Error("stale part was not deleted")
+	}
+
+	var buf bytes.Buffer
+	if _, err := Download(ctx, store, "db/t.sql.gz", &buf); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if buf.String() != content {
+		t.Error("rolled over dump does not round-trip")
+	}
+}
+
+func TestUploadRollsOverPastPart999(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	content := randomDump(3000)
+	uploader := &Uploader{Store: store, Progress: newProgress(time.Now()), MaxObjectSize: 32}
+	stats, err := uploader.Upload(ctx, "db/t.sql.gz", strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if len(stats.Parts) < 1000 {
+		t.Fatalf("%d parts, want at least 1000", len(stats.Parts))
+	}
+
+	parts, err := listParts(ctx, store, "db/t.sql.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parts, stats.Parts) {
+		t.Errorf("listParts() returned %d parts out of order, want the %d uploaded", len(parts), len(stats.Parts))
+	}
+	var buf bytes.Buffer
+	if _, err := Download(ctx, store, "db/t.sql.gz", &buf); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if buf.String() != content {
+		t.Errorf("Download = %d bytes, want %d", buf.Len(), len(content))
+	}
+}
+
+func TestUploadDiscardsPartsOnFailure(t *testing.T) {
+	store := NewMemoryStore()
+	uploader := &Uploader{Store: store, Progress: newProgress(time.Now()), MaxObjectSize: 256}
+
+	if _, err := uploader.Upload(context.Background(), "db/t.sql.gz", &failAfterReader{data: randomDump(500)}); err == nil {
+		t.Fatal("Upload succeeded, want error")
+	}
+
+	if objects, _ := store.List(context.Background(), ""); len(objects) != 0 {
+		t.Errorf("%d objects left after a failed upload", len(objects))
+	}
+}
+
+func TestPartOf(t *testing.T) {
+	tests := map[string]string{
+		"db/t.sql.gz.part001":  "db/t.sql.gz",
+		"db/t.sql.gz.part123":  "db/t.sql.gz",
+		"db/t.sql.gz.part1000": "db/t.sql.gz",
+		"db/t.sql.gz.part0999": "",
+		"db/t.sql.gz.part000":  "",
+		"db/t.sql.gz.part-01":  "",
+		"db/t.sql.gz":          "",
+		"db/t.sql.gz.part1":    "",
+		"db/t.sql.gz.partial":  "",
+	}
+	for name, want := range tests {
+		got, ok := partOf(name)
+		if got != want || ok != (want != "") {
+			t.Errorf("partOf(%s) = %q, %v, want %q", name, got, ok, want)
+		}
+	}
+}
+
+func TestListDatabaseBackupsCountsParts(t *testing.T) {
+	store := NewMemoryStore()
+	putGzipObject(t, store, "db1/2024-01-01-00/shop/orders.sql.gz", "orders")
+	putGzipObject(t, store, "db1/2024-01-01-00/shop/orders.sql.gz.part001", "more")
+
+	backups, err := ListDatabaseBackups(context.Background(), store, "db1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := store.Data("db1/2024-01-01-00/shop/orders.sql.gz")
+	part, _ := store.Data("db1/2024-01-01-00/shop/orders.sql.gz.part001")
+	want := []DatabaseBackup{{Host: "db1", Generation: "2024-01-01-00", Database: "shop", Tables: 1, Bytes: int64(len(first) + len(part)), Created: backups[0].Created}}
+	if !reflect.DeepEqual(backups, want) {
+		t.Errorf("backups = %+v, want %+v", backups, want)
+	}
+}
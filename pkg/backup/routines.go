@@ -0,0 +1,92 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Where stored procedures, functions and events are dumped.
+const (
+	// RoutinesDatabase dumps them once per database, together with the
+	// events of the database, to <database>.routines.sql.gz.
+	RoutinesDatabase = "database"
+	// RoutinesTable dumps them with every table of the database, as
+	// mysqldump --routines does.
+	RoutinesTable = "table"
+	// RoutinesNone does not dump them.
+	RoutinesNone = "none"
+)
+
+const routinesObjectSuffix = ".routines.sql.gz"
+
+// routinesObject returns the name of the routines dump of a database in the
+// run stored under path. It sits next to the database's directory, so it is
+// never mistaken for a table dump.
+func routinesObject(path string, database string) string {
+	return path + "/" + database + routinesObjectSuffix
+}
+
+// DatabaseResult is the outcome of dumping the routines and events of a
+// database.
+type DatabaseResult struct {
+	Database          string    `json:"database"`
+	Object            string    `json:"object"`
+	Status            string    `json:"status"`
+	Error             string    `json:"error,omitempty"`
+	Started           time.Time `json:"started"`
+	Finished          time.Time `json:"finished"`
+	UncompressedBytes int64     `json:"uncompressedBytes"`
+	CompressedBytes   int64     `json:"compressedBytes"`
+}
+
+func (m *Manifest) addDatabase(result DatabaseResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Databases = append(m.Databases, result)
+}
+
+// backupRoutines dumps the routines and events of a database.
+func backupRoutines(ctx context.Context, dumper Dumper, uploader *Uploader, path string, database string) (DatabaseResult, error) {
+	result := DatabaseResult{
+		Database: database,
+		Object:   routinesObject(path, database),
+		Status:   StatusSucceeded,
+		Started:  time.Now(),
+	}
+
+	stats, err := dumpObject(ctx, dumper, uploader, DumpOptions{Routines: true}, database, "", result.Object)
+	result.Finished = time.Now()
+	result.UncompressedBytes = stats.UncompressedBytes
+	result.CompressedBytes = stats.CompressedBytes
+	if err != nil {
+		err = fmt.Errorf("failed to back up routines of database %s: %w", database, err)
+		result.Status = StatusFailed
+		result.Error = err.Error()
+	}
+
+	return result, err
+}
+
+// restoreRoutines applies the routines dump of a database, if the
+// generation has one.
+func restoreRoutines(ctx context.Context, store ObjectStore, applier Applier, path string, database string, target string) error {
+	name := routinesObject(path, database)
+	if _, err := store.Attrs(ctx, name); err != nil {
+		if errors.Is(err, ErrObjectNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to look up %s: %w", name, err)
+	}
+
+	log.Printf("Restoring routines of database %s into %s\n", database, target)
+
+	result := RestoreResult{Object: name, Database: database, TargetDatabase: target}
+	if err := applyObject(ctx, store, applier, name, result, false); err != nil {
+		return fmt.Errorf("failed to restore routines of database %s: %w", database, err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package backup
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRunDumpsRoutinesOncePerDatabase(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders", "users"}},
+	}
+	dumper := &fakeDumper{dumps: map[string]string{"shop.": "CREATE PROCEDURE p() SELECT 1;\n"}}
+
+	cfg := testConfig(store, planner, dumper)
+	cfg.Routines = RoutinesDatabase
+	cfg.SkipTriggers = true
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	routines := 0
+	for _, key := range dumper.dumped {
+		if key == "shop." {
+			routines++
+		}
+	}
+	if routines != 1 {
+		t.Errorf("routines dumped %d times, want once", routines)
+	}
+	if opts := dumper.opts["shop.orders"]; opts.Routines || !opts.SkipTriggers {
+		t.Errorf("table dump options = %+v, want routines and triggers left out", opts)
+	}
+
+	if got := readGzipObject(t, store, m.Path+"/shop.routines.sql.gz"); got != "CREATE PROCEDURE p() SELECT 1;\n" {
+		t.Errorf("routines object = %q", got)
+	}
+	if len(m.Databases) != 1 || m.Databases[0].Status != StatusSucceeded || m.Databases[0].Object != m.Path+"/shop.routines.sql.gz" {
+		t.Errorf("manifest databases = %+v", m.Databases)
+	}
+
+	cfg = testConfig(NewMemoryStore(), planner, &fakeDumper{})
+	cfg.Routines = RoutinesTable
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	tableDumper := cfg.Dumper.(*fakeDumper)
+	if len(tableDumper.dumped) != 2 || !tableDumper.opts["shop.orders"].Routines {
+		t.Errorf("dumped %v with %+v, want routines with every table", tableDumper.dumped, tableDumper.opts)
+	}
+}
+
+func TestRestoreAppliesRoutinesAfterTables(t *testing.T) {
+	store := NewMemoryStore()
+	putGzipObject(t, store, "db1/2024-01-02-00/shop/orders.sql.gz", ordersDump)
+	putGzipObject(t, store, "db1/2024-01-02-00/shop.routines.sql.gz", "CREATE PROCEDURE p() SELECT 1;\n")
+
+	applier := &fakeApplier{}
+	results, err := Restore(context.Background(), RestoreConfig{
+		Store:       store,
+		Host:        "db1",
+		DatabaseMap: map[string]string{"shop": "staging_shop"},
+		Applier:     applier,
+	})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("Restore returned %d results, want only the table", len(results))
+	}
+
+	got := applier.applied["staging_shop"]
+	if !strings.HasSuffix(got, "CREATE PROCEDURE p() SELECT 1;\n") || !strings.Contains(got, "CREATE TABLE `orders`") {
+		t.Errorf("staging_shop received %q, want the table followed by the routines", got)
+	}
+
+	applier = &fakeApplier{}
+	if _, err := Restore(context.Background(), RestoreConfig{Store: store, Host: "db1", SkipRoutines: true, Applier: applier}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(applier.applied["shop"], "PROCEDURE") {
+		t.Error("routines were restored with SkipRoutines")
+	}
+}
@@ -0,0 +1,104 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrRowCountMismatch is returned for tables whose dump holds fewer or more
+// rows than the table had, with Config.RowCountCheck RowCountExact.
+var ErrRowCountMismatch = errors.New("dumped rows do not match the table")
+
+// Row count checks, which compare the rows of the INSERT statements of a
+// dump with the row count of the table to catch partial dumps.
+const (
+	// RowCountNone does not check row counts.
+	RowCountNone = "none"
+	// RowCountEstimate flags dumps whose rows are far off the estimate of
+	// information_schema taken when the run enumerated the table.
+	RowCountEstimate = "estimate"
+	// RowCountExact counts the rows with COUNT(*) before and after the dump
+	// and fails tables whose dump holds fewer or more rows than both.
+	RowCountExact = "exact"
+)
+
+// Estimates of InnoDB are samples that are commonly off by tens of percents,
+// so only dumps with less than half or more than twice the estimate, and
+// only of tables estimated to hold at least minEstimatedRows rows, are
+// flagged.
+const (
+	estimateTolerance = 2
+	minEstimatedRows  = 1000
+)
+
+// RowCounter counts the rows of tables. A Planner implementing it enables
+// RowCountExact.
+type RowCounter interface {
+	CountRows(database string, table string) (int64, error)
+}
+
+// CountRows runs SELECT COUNT(*), which scans the smallest index of the
+// table.
+func (p *MySQLPlanner) CountRows(database string, table string) (int64, error) {
+	db, err := p.get(p.Connection)
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+
+	var count int64
+	if err := db.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM "+quoteTable(database, table)).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count rows of table \"%s.%s\": %w", database, table, err)
+	}
+	return count, nil
+}
+
+// countRows returns the row count of a base table for RowCountExact, or -1
+// if it is not counted. A failure to count is logged.
+func countRows(planner Planner, mode string, info TableInfo, database string) int64 {
+	counter, ok := planner.(RowCounter)
+	if mode != RowCountExact || !ok || info.Type != TableTypeBase {
+		return -1
+	}
+
+	count, err := counter.CountRows(database, info.Name)
+	if err != nil {
+		log.Printf("Failed to count rows, the dump of table \"%s.%s\" is checked against the estimate: %v\n", database, info.Name, err)
+		return -1
+	}
+	return count
+}
+
+// rowCountWarning describes how the rows of a dump disagree with those of
+// the table, if they do: with counts before and after the dump, the rows
+// must lie between them, since a concurrent writer may have changed the
+// table in between; otherwise they are compared with the estimate.
+func rowCountWarning(mode string, rows int64, info TableInfo, before int64, after int64) string {
+	if mode == "" || mode == RowCountNone || info.Type != TableTypeBase {
+		return ""
+	}
+
+	if before >= 0 && after >= 0 {
+		low, high := before, after
+		if low > high {
+			low, high = high, low
+		}
+		switch {
+		case rows >= low && rows <= high:
+			return ""
+		case before == after:
+			return fmt.Sprintf("%d rows dumped, the table has %d", rows, before)
+		}
+		return fmt.Sprintf("%d rows dumped, the table had %d before and %d after the dump", rows, before, after)
+	}
+
+	estimate := info.ApproximateRows
+	if estimate < minEstimatedRows {
+		return ""
+	}
+	if rows*estimateTolerance < estimate || rows > estimate*estimateTolerance {
+		return fmt.Sprintf("%d rows dumped, information_schema estimates %d", rows, estimate)
+	}
+	return ""
+}
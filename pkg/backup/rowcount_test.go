@@ -0,0 +1,84 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// countingPlanner returns the counts of a table in turn, one per call.
+type countingPlanner struct {
+	*fakePlanner
+	counts map[string][]int64
+}
+
+func (p *countingPlanner) CountRows(database string, table string) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	counts := p.counts[database+"."+table]
+	if len(counts) == 0 {
+		return 0, errFake
+	}
+	p.counts[database+"."+table] = counts[1:]
+	return counts[0], nil
+}
+
+func TestRunFailsTablesWithMissingRows(t *testing.T) {
+	planner := &countingPlanner{
+		fakePlanner: &fakePlanner{
+			databases: []string{"shop"},
+			tables:    map[string][]string{"shop": {"orders", "users", "items"}},
+		},
+		counts: map[string][]int64{"shop.orders": {3, 3}, "shop.users": {1, 3}},
+	}
+	dumper := &fakeDumper{dumps: map[string]string{
+		"shop.orders": "INSERT INTO `orders` VALUES (1),(2);\n",
+		"shop.users":  "INSERT INTO `users` VALUES (1),(2);\n",
+		"shop.items":  "INSERT INTO `items` VALUES (1);\n",
+	}}
+
+	cfg := testConfig(NewMemoryStore(), planner, dumper)
+	cfg.RowCountCheck = RowCountExact
+	m, err := Run(context.Background(), cfg)
+	if !errors.Is(err, ErrPartialFailure) {
+		t.Fatalf("Run = %v, want ErrPartialFailure", err)
+	}
+
+	if result, _ := m.Table("shop", "orders"); result.Status != StatusFailed || result.RowCountWarning != "2 rows dumped, the table has 3" {
+		t.Errorf("orders = %s with warning %q, want failed for its missing row", result.Status, result.RowCountWarning)
+	}
+	if result, _ := m.Table("shop", "users"); result.Status != StatusSucceeded || result.RowCountWarning != "" {
+		t.Errorf("users = %s with warning %q, want succeeded as rows were inserted during the dump", result.Status, result.RowCountWarning)
+	}
+	if result, _ := m.Table("shop", "items"); result.Status != StatusSucceeded {
+		t.Errorf("items = %s, want succeeded when it cannot be counted", result.Status)
+	}
+}
+
+func TestRowCountWarning(t *testing.T) {
+	tests := []struct {
+		name          string
+		mode          string
+		rows          int64
+		estimate      int64
+		before, after int64
+		want          string
+	}{
+		{name: "disabled", mode: RowCountNone, rows: 0, estimate: 5000, before: -1, after: -1},
+		{name: "close to the estimate", mode: RowCountEstimate, rows: 4000, estimate: 5000, before: -1, after: -1},
+		{name: "far below the estimate", mode: RowCountEstimate, rows: 2000, estimate: 5000, before: -1, after: -1, want: "2000 rows dumped, information_schema estimates 5000"},
+		{name: "far above the estimate", mode: RowCountEstimate, rows: 20000, estimate: 5000, before: -1, after: -1, want: "20000 rows dumped, information_schema estimates 5000"},
+		{name: "small table", mode: RowCountEstimate, rows: 0, estimate: 500, before: -1, after: -1},
+		{name: "between counts", mode: RowCountExact, rows: 11, estimate: 5000, before: 12, after: 10},
+		{name: "outside counts", mode: RowCountExact, rows: 9, before: 12, after: 10, want: "9 rows dumped, the table had 12 before and 10 after the dump"},
+		{name: "exact falls back to the estimate", mode: RowCountExact, rows: 100, estimate: 5000, before: -1, after: -1, want: "100 rows dumped, information_schema estimates 5000"},
+	}
+
+	for _, test := range tests {
+		info := TableInfo{Type: TableTypeBase, ApproximateRows: test.estimate}
+		if got := rowCountWarning(test.mode, test.rows, info, test.before, test.after); got != test.want {
+			t.Errorf("%s: rowCountWarning = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package backup
+
+import (
+	"crypto/rand"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// NewRunID returns a new run ID, a ULID, which sorts by the time it was
+// created at.
+func NewRunID(t time.Time) string {
+	return ulid.MustNew(ulid.Timestamp(t), rand.Reader).String()
+}
@@ -0,0 +1,160 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+const serverInfoObject = "server-info.json.gz"
+
+// ServerInfo is a snapshot of the server configuration taken at the start of
+// a run, uploaded as server-info.json.gz to the run prefix, for rebuilding a
+// server like the one the backup was taken from.
+type ServerInfo struct {
+	Taken     time.Time         `json:"taken"`
+	Variables map[string]string `json:"variables"`
+	Status    map[string]string `json:"status"`
+	// BinaryLog is the row of SHOW BINARY LOG STATUS, or SHOW MASTER STATUS
+	// on servers before 8.2, if binary logging is enabled.
+	BinaryLog map[string]string `json:"binaryLog,omitempty"`
+	// Replication holds a row of SHOW REPLICA STATUS, or SHOW SLAVE STATUS
+	// on servers before 8.0.22, per replication channel.
+	Replication []map[string]string `json:"replication,omitempty"`
+}
+
+// ServerInspector takes ServerInfo snapshots. A Planner implementing it
+// enables the snapshot, see Config.SkipServerInfo.
+type ServerInspector interface {
+	ServerInfo() (*ServerInfo, error)
+}
+
+// ServerInfo reads the global variables, global status, binary log position
+// and replication status of the server.
+func (p *MySQLPlanner) ServerInfo() (*ServerInfo, error) {
+	db, err := p.get(p.Connection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+
+	info := &ServerInfo{Taken: time.Now()}
+	if info.Variables, err = queryNameValues(db, "SHOW GLOBAL VARIABLES"); err != nil {
+		return nil, fmt.Errorf("failed to read global variables: %w", err)
+	}
+	if info.Status, err = queryNameValues(db, "SHOW GLOBAL STATUS"); err != nil {
+		return nil, fmt.Errorf("failed to read global status: %w", err)
+	}
+
+	// The binary log and replication status need the REPLICATION CLIENT
+	// privilege, which a backup user may lack, and are left out without it.
+	if binaryLog, err := queryRows(db, "SHOW BINARY LOG STATUS", "SHOW MASTER STATUS"); err != nil {
+		log.Printf("Failed to read binary log status, it is left out of the server info: %v\n", err)
+	} else if len(binaryLog) > 0 {
+		info.BinaryLog = binaryLog[0]
+	}
+	if info.Replication, err = queryRows(db, "SHOW REPLICA STATUS", "SHOW SLAVE STATUS"); err != nil {
+		log.Printf("Failed to read replication status, it is left out of the server info: %v\n", err)
+	}
+
+	return info, nil
+}
+
+// queryNameValues reads the Variable_name and Value columns of a SHOW
+// statement.
+func queryNameValues(db *sql.DB, query string) (map[string]string, error) {
+	rows, err := db.QueryContext(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := map[string]string{}
+	for rows.Next() {
+		var name string
+		var value sql.NullString
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, fmt.Errorf("failed to read query result: %w", err)
+		}
+		values[name] = value.String
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read query result: %w", err)
+	}
+	return values, nil
+}
+
+// queryRows reads every row of the first of queries the server accepts as
+// maps of column names to values, NULL values being left out.
+func queryRows(db *sql.DB, queries ...string) ([]map[string]string, error) {
+	var rows *sql.Rows
+	var err error
+	for _, query := range queries {
+		if rows, err = db.QueryContext(context.Background(), query); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read query result: %w", err)
+	}
+
+	var result []map[string]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]any, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to read query result: %w", err)
+		}
+
+		row := map[string]string{}
+		for i, column := range columns {
+			if values[i].Valid {
+				row[column] = values[i].String
+			}
+		}
+		result = append(result, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read query result: %w", err)
+	}
+	return result, nil
+}
+
+// uploadServerInfo uploads a snapshot of the server configuration to the run
+// prefix and returns its object name.
+func uploadServerInfo(ctx context.Context, inspector ServerInspector, uploader *Uploader, path string) (string, error) {
+	info, err := inspector.ServerInfo()
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gzipWriter).Encode(info); err != nil {
+		return "", fmt.Errorf("failed to marshal server info: %w", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return "", fmt.Errorf("failed to compress server info: %w", err)
+	}
+
+	name := path + "/" + serverInfoObject
+	if err := uploader.UploadObject(ctx, name, "application/gzip", buf.Bytes()); err != nil {
+		return "", err
+	}
+	return name, nil
+}
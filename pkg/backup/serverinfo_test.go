@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+type inspectingPlanner struct {
+	*fakePlanner
+	info *ServerInfo
+}
+
+func (p *inspectingPlanner) ServerInfo() (*ServerInfo, error) {
+	return p.info, nil
+}
+
+func TestRunUploadsServerInfo(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &inspectingPlanner{
+		fakePlanner: &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"orders"}}},
+		info: &ServerInfo{
+			Variables:   map[string]string{"innodb_buffer_pool_size": "134217728"},
+			Status:      map[string]string{"Uptime": "3600"},
+			Replication: []map[string]string{{"Source_Host": "primary"}},
+		},
+	}
+
+	m, err := Run(context.Background(), testConfig(store, planner, &fakeDumper{}))
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if want := m.Path + "/" + serverInfoObject; m.ServerInfo != want {
+		t.Fatalf("manifest server info = %q, want %q", m.ServerInfo, want)
+	}
+	var info ServerInfo
+	if err := json.Unmarshal([]byte(readGzipObject(t, store, m.ServerInfo)), &info); err != nil {
+		t.Fatalf("failed to parse server info: %v", err)
+	}
+	if info.Variables["innodb_buffer_pool_size"] != "134217728" || info.Status["Uptime"] != "3600" {
+		t.Errorf("server info = %+v, want the planner's variables and status", info)
+	}
+	if len(info.Replication) != 1 || info.Replication[0]["Source_Host"] != "primary" {
+		t.Errorf("replication = %v, want the planner's replication status", info.Replication)
+	}
+}
+
+func TestRunSkipsServerInfo(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &inspectingPlanner{
+		fakePlanner: &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"orders"}}},
+		info:        &ServerInfo{},
+	}
+
+	cfg := testConfig(store, planner, &fakeDumper{})
+	cfg.SkipServerInfo = true
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if _, ok := store.Data(m.Path + "/" + serverInfoObject); ok || m.ServerInfo != "" {
+		t.Errorf("server info was uploaded with SkipServerInfo")
+	}
+}
@@ -0,0 +1,130 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// ShardResult is the outcome of the run of one shard of a sharded run, see
+// Config.Shards.
+type ShardResult struct {
+	Name string `json:"name"`
+	// Endpoint is the host and port of the shard, without credentials.
+	Endpoint string `json:"endpoint"`
+	// Path is the <host>/<generation> prefix holding the run of the shard.
+	Path         string `json:"path"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+	Tables       int    `json:"tables"`
+	FailedTables int    `json:"failedTables"`
+
+	UncompressedBytes int64 `json:"uncompressedBytes"`
+	CompressedBytes   int64 `json:"compressedBytes"`
+}
+
+// ShardName is the host name under which shard i of a sharded run is stored,
+// so that the shards are listed, restored and collected like hosts.
+func ShardName(i int) string {
+	return fmt.Sprintf("shard-%d", i)
+}
+
+// runShards backs up every connection of cfg.Shards as its own run, all at
+// the same time and in the same generation, and uploads a combined manifest
+// of their tables to <cfg.Hostname>/<generation>.
+func runShards(ctx context.Context, cfg Config) (*Manifest, error) {
+	started := time.Now()
+	if cfg.RunID == "" {
+		cfg.RunID = NewRunID(started)
+	}
+
+	combined := newManifest(cfg.Hostname, fmt.Sprintf("%s/%s", cfg.Hostname, generationName(started, cfg.Granularity)), started)
+	combined.RunID = cfg.RunID
+	combined.Environment = cfg.Environment
+	combined.Cluster = cfg.Cluster
+	combined.Trigger = cfg.Trigger.recorded()
+	storageStats := trackStorageStats(cfg.Store)
+	reportStarted(ctx, cfg.StatusReporter, combined)
+
+	manifests := make([]*Manifest, len(cfg.Shards))
+	errs := make([]error, len(cfg.Shards))
+	var wg sync.WaitGroup
+	for i, conn := range cfg.Shards {
+		i := i
+		shardCfg := cfg
+		shardCfg.Shards = nil
+		shardCfg.Connection = conn
+		shardCfg.Hostname = ShardName(i)
+		shardCfg.started = started
+		shardCfg.sharedStore = true
+		shardCfg.StatusReporter = nil
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Printf("Backing up %s from %s\n", shardCfg.Hostname, endpoint(shardCfg.Connection))
+			manifests[i], errs[i] = Run(ctx, shardCfg)
+		}()
+	}
+	wg.Wait()
+
+	var failed []error
+	succeeded := 0
+	for i, conn := range cfg.Shards {
+		shard := ShardResult{Name: ShardName(i), Endpoint: endpoint(conn), Status: StatusSucceeded}
+		if errs[i] != nil {
+			shard.Status = StatusFailed
+			shard.Error = errs[i].Error()
+			failed = append(failed, fmt.Errorf("%s: %w", shard.Name, errs[i]))
+		} else {
+			succeeded++
+		}
+		if m := manifests[i]; m != nil {
+			shard.Path = m.Path
+			shard.Tables = len(m.Tables)
+			shard.FailedTables = m.FailedTables()
+			shard.UncompressedBytes = m.UncompressedBytes
+			shard.CompressedBytes = m.CompressedBytes
+			for _, table := range m.Tables {
+				table.Shard = shard.Name
+				combined.Tables = append(combined.Tables, table)
+			}
+			for _, message := range m.Errors {
+				combined.Errors = append(combined.Errors, shard.Name+": "+message)
+			}
+		}
+		combined.Shards = append(combined.Shards, shard)
+		combined.UncompressedBytes += shard.UncompressedBytes
+		combined.CompressedBytes += shard.CompressedBytes
+	}
+	combined.CompressionRatio = compressionRatio(combined.UncompressedBytes, combined.CompressedBytes)
+	combined.Finished = time.Now()
+	combined.Storage = storageStats()
+
+	uploader := &Uploader{Store: cfg.Store, Metadata: runLabels(cfg)}
+	if err := combined.upload(ctx, uploader, cfg.Signer); err != nil {
+		log.Printf("Failed to upload the combined manifest: %v\n", err)
+		failed = append(failed, fmt.Errorf("failed to upload the combined manifest: %w", err))
+	}
+	log.Printf("Sharded run summary: %d of %d shard(s) succeeded, %d tables, %s dumped, %s compressed\n",
+		succeeded, len(cfg.Shards), len(combined.Tables), FormatBytes(combined.UncompressedBytes), FormatBytes(combined.CompressedBytes))
+	if combined.Storage != nil {
+		combined.Storage.log()
+	}
+
+	if len(failed) > 0 {
+		return combined, errors.Join(failed...)
+	}
+	return combined, nil
+}
+
+func endpoint(conn Connection) string {
+	if conn.socket() {
+		return conn.Host
+	}
+	return net.JoinHostPort(conn.Host, conn.Port)
+}
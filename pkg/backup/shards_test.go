@@ -0,0 +1,71 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunShardsWritesCombinedManifest(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders"}},
+	}
+	dumper := &fakeDumper{dumps: map[string]string{"shop.orders": "INSERT INTO orders VALUES (1);\n"}}
+
+	cfg := testConfig(store, planner, dumper)
+	cfg.Shards = []Connection{{Host: "db0", Port: "3306"}, {Host: "db1", Port: "3306"}}
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	generation := strings.TrimPrefix(m.Path, "host/")
+	for _, shard := range []string{"shard-0", "shard-1"} {
+		if got := readGzipObject(t, store, shard+"/"+generation+"/shop/orders.sql.gz"); got != dumper.dumps["shop.orders"] {
+			t.Errorf("%s orders dump = %q, want %q", shard, got, dumper.dumps["shop.orders"])
+		}
+		if _, ok := store.Data(shard + "/" + generation + "/manifest.json"); !ok {
+			t.Errorf("%s has no manifest", shard)
+		}
+	}
+
+	uploaded := readManifest(t, store, m.Path)
+	if len(uploaded.Shards) != 2 || len(uploaded.Tables) != 2 {
+		t.Fatalf("combined manifest has %d shards and %d tables, want 2 and 2", len(uploaded.Shards), len(uploaded.Tables))
+	}
+	for i, shard := range uploaded.Shards {
+		if shard.Name != ShardName(i) || shard.Status != StatusSucceeded || shard.Path != ShardName(i)+"/"+generation || shard.Tables != 1 {
+			t.Errorf("shard %d = %+v", i, shard)
+		}
+		if table := uploaded.Tables[i]; table.Shard != shard.Name || !strings.HasPrefix(table.Object, shard.Path+"/") {
+			t.Errorf("table %d of the combined manifest = %s from %s, want one of %s", i, table.Object, table.Shard, shard.Name)
+		}
+	}
+	if uploaded.Shards[1].Endpoint != "db1:3306" {
+		t.Errorf("shard 1 endpoint = %s, want db1:3306", uploaded.Shards[1].Endpoint)
+	}
+}
+
+func TestRunShardsReportsFailedShard(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders"}},
+	}
+	dumper := &fakeDumper{failed: map[string]error{"shop.orders": errFake}}
+
+	cfg := testConfig(store, planner, dumper)
+	cfg.Shards = []Connection{{Host: "db0", Port: "3306"}}
+	m, err := Run(context.Background(), cfg)
+	if !errors.Is(err, ErrPartialFailure) || !strings.Contains(err.Error(), "shard-0") {
+		t.Fatalf("Run error = %v, want the partial failure of shard-0", err)
+	}
+
+	uploaded := readManifest(t, store, m.Path)
+	if len(uploaded.Shards) != 1 || uploaded.Shards[0].Status != StatusFailed || uploaded.Shards[0].FailedTables != 1 {
+		t.Errorf("combined manifest shards = %+v, want shard-0 failed with 1 table", uploaded.Shards)
+	}
+}
@@ -0,0 +1,253 @@
+package backup
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// ErrInvalidSignature is returned by VerifyManifest for manifests whose
+// signature does not match their content.
+var ErrInvalidSignature = errors.New("manifest signature is invalid")
+
+// ManifestSigner signs the manifest of every run, so that consumers can
+// verify that the inventory was not altered after the fact. The signature
+// is uploaded next to the manifest as manifest.json.sig, in the raw format
+// of the key's algorithm: a DER-encoded ECDSA signature, a PKCS #1 v1.5 or
+// PSS RSA signature, or an Ed25519 signature.
+type ManifestSigner interface {
+	Sign(ctx context.Context, data []byte) ([]byte, error)
+}
+
+func signatureName(path string) string {
+	return manifestName(path) + ".sig"
+}
+
+// KeySigner signs with a private key held locally. ECDSA keys sign the
+// SHA-256 or SHA-384 digest of the manifest by curve, RSA keys its SHA-256
+// digest with PKCS #1 v1.5 and Ed25519 keys the manifest itself.
+type KeySigner struct {
+	Key crypto.Signer
+}
+
+// LoadSigningKey reads a PEM-encoded PKCS #8, SEC 1 or PKCS #1 private key.
+func LoadSigningKey(path string) (*KeySigner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode signing key %s: no PEM block", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %w", path, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported signing key type %T", key)
+	}
+	return &KeySigner{Key: signer}, nil
+}
+
+func (s *KeySigner) Sign(ctx context.Context, data []byte) ([]byte, error) {
+	hash, err := signatureHash(s.Key.Public())
+	if err != nil {
+		return nil, err
+	}
+	if hash == 0 {
+		return s.Key.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	return s.Key.Sign(rand.Reader, digest(hash, data), hash)
+}
+
+// KMSSigner signs with an asymmetric signing key version of Cloud KMS. The
+// credentials need cloudkms.cryptoKeyVersions.useToSign and
+// cloudkms.cryptoKeyVersions.viewPublicKey on it.
+type KMSSigner struct {
+	Service *cloudkms.Service
+	// KeyVersion is projects/<project>/locations/<location>/keyRings/
+	// <key ring>/cryptoKeys/<key>/cryptoKeyVersions/<version>.
+	KeyVersion string
+
+	once      sync.Once
+	algorithm string
+	err       error
+}
+
+func (s *KMSSigner) Sign(ctx context.Context, data []byte) ([]byte, error) {
+	versions := s.Service.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions
+	s.once.Do(func() {
+		key, err := versions.GetPublicKey(s.KeyVersion).Context(ctx).Do()
+		if err != nil {
+			s.err = fmt.Errorf("failed to get public key of %s: %w", s.KeyVersion, err)
+			return
+		}
+		s.algorithm = key.Algorithm
+	})
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	request := &cloudkms.AsymmetricSignRequest{}
+	switch {
+	case strings.HasSuffix(s.algorithm, "_SHA256"):
+		request.Digest = &cloudkms.Digest{Sha256: base64.StdEncoding.EncodeToString(digest(crypto.SHA256, data))}
+	case strings.HasSuffix(s.algorithm, "_SHA384"):
+		request.Digest = &cloudkms.Digest{Sha384: base64.StdEncoding.EncodeToString(digest(crypto.SHA384, data))}
+	case strings.HasSuffix(s.algorithm, "_SHA512"):
+		request.Digest = &cloudkms.Digest{Sha512: base64.StdEncoding.EncodeToString(digest(crypto.SHA512, data))}
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %s of %s", s.algorithm, s.KeyVersion)
+	}
+
+	response, err := versions.AsymmetricSign(s.KeyVersion, request).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign with %s: %w", s.KeyVersion, err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(response.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %w", err)
+	}
+	return signature, nil
+}
+
+// KMSPublicKey returns the public key of a Cloud KMS key version, to verify
+// manifests signed by a KMSSigner.
+func KMSPublicKey(ctx context.Context, service *cloudkms.Service, keyVersion string) (crypto.PublicKey, error) {
+	key, err := service.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.GetPublicKey(keyVersion).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key of %s: %w", keyVersion, err)
+	}
+	return ParsePublicKey([]byte(key.Pem))
+}
+
+// ParsePublicKey parses a PEM-encoded PKIX public key, as printed by
+// openssl pkey -pubout or gcloud kms keys versions get-public-key.
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("failed to decode public key: no PEM block")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	return key, nil
+}
+
+// uploadSignature signs the manifest as uploaded and uploads the signature
+// next to it.
+func uploadSignature(ctx context.Context, uploader *Uploader, signer ManifestSigner, path string, manifest []byte) error {
+	signature, err := signer.Sign(ctx, manifest)
+	if err != nil {
+		return fmt.Errorf("failed to sign manifest: %w", err)
+	}
+	return uploader.UploadObject(ctx, signatureName(path), "application/octet-stream", signature)
+}
+
+// VerifyManifest checks the signature of the manifest of the run stored
+// under path against key.
+func VerifyManifest(ctx context.Context, store ObjectStore, path string, key crypto.PublicKey) error {
+	manifest, err := readObject(ctx, store, manifestName(path))
+	if err != nil {
+		return err
+	}
+	signature, err := readObject(ctx, store, signatureName(path))
+	if err != nil {
+		return err
+	}
+
+	if !verifySignature(key, manifest, signature) {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, manifestName(path))
+	}
+	return nil
+}
+
+func readObject(ctx context.Context, store ObjectStore, name string) ([]byte, error) {
+	reader, err := store.NewReader(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// signatureHash returns the hash signed for a key, or 0 if the data is
+// signed as is.
+func signatureHash(key crypto.PublicKey) (crypto.Hash, error) {
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		return 0, nil
+	case *ecdsa.PublicKey:
+		if key.Curve == elliptic.P384() {
+			return crypto.SHA384, nil
+		}
+		return crypto.SHA256, nil
+	case *rsa.PublicKey:
+		return crypto.SHA256, nil
+	default:
+		return 0, fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+func verifySignature(key crypto.PublicKey, data []byte, signature []byte) bool {
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, signature)
+	case *ecdsa.PublicKey:
+		hash, _ := signatureHash(key)
+		return ecdsa.VerifyASN1(key, digest(hash, data), signature)
+	case *rsa.PublicKey:
+		// KMS RSA keys sign with PKCS #1 v1.5 or PSS and SHA-256 or SHA-512.
+		for _, hash := range []crypto.Hash{crypto.SHA256, crypto.SHA512} {
+			sum := digest(hash, data)
+			if rsa.VerifyPKCS1v15(key, hash, sum, signature) == nil {
+				return true
+			}
+			if rsa.VerifyPSS(key, hash, sum, signature, nil) == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func digest(hash crypto.Hash, data []byte) []byte {
+	h := hash.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
@@ -0,0 +1,115 @@
+package backup
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunSignsManifest(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, key := range map[string]crypto.Signer{"ecdsa": ecKey, "ed25519": edKey, "rsa": rsaKey} {
+		key := key
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := NewMemoryStore()
+			planner := &fakePlanner{
+				databases: []string{"shop"},
+				tables:    map[string][]string{"shop": {"orders"}},
+			}
+
+			cfg := testConfig(store, planner, &fakeDumper{})
+			cfg.Signer = &KeySigner{Key: key}
+			m, err := Run(ctx, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := VerifyManifest(ctx, store, m.Path, key.Public()); err != nil {
+				t.Fatalf("VerifyManifest failed: %v", err)
+			}
+
+			data, _ := store.Data(manifestName(m.Path))
+			tampered := append([]byte{}, data...)
+			tampered[len(tampered)-2] = ' '
+			if err := (&Uploader{Store: store}).UploadObject(ctx, manifestName(m.Path), "application/json", tampered); err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyManifest(ctx, store, m.Path, key.Public()); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("VerifyManifest of tampered manifest = %v, want ErrInvalidSignature", err)
+			}
+		})
+	}
+}
+
+func TestVerifyManifestWithoutSignature(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	m, err := Run(ctx, testConfig(store, &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"orders"}}}, &fakeDumper{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	if err := VerifyManifest(ctx, store, m.Path, key.Public()); !errors.Is(err, ErrObjectNotExist) {
+		t.Errorf("VerifyManifest = %v, want ErrObjectNotExist", err)
+	}
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := LoadSigningKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := signer.Sign(context.Background(), []byte("manifest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifySignature(parsed, []byte("manifest"), signature) {
+		t.Error("signature of loaded key does not verify")
+	}
+}
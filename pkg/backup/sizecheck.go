@@ -0,0 +1,75 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// sizeCheck flags dumps that are suspiciously small, which often means
+// silent truncation or an empty dump: those below the minimum size of their
+// TableConfig, and those below a fraction of their size in the previous run.
+type sizeCheck struct {
+	ratio float64
+	// previous maps "database.table" to the compressed size of the table in
+	// the previous run.
+	previous map[string]int64
+	// zstd holds the tables whose previous dump was compressed with a
+	// dictionary, whose size is not comparable with a gzip dump.
+	zstd map[string]bool
+}
+
+// previousRun loads the manifest of the previous run of host, the latest
+// generation before current with a manifest, or returns nil if there is
+// none.
+func previousRun(ctx context.Context, store ObjectStore, host string, current string) (*Manifest, error) {
+	generations, err := manifestGenerations(ctx, store, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the previous run: %w", err)
+	}
+	previous := ""
+	for _, generation := range generations {
+		if generationLess(generation, strings.TrimPrefix(current, host+"/")) {
+			previous = generation
+		}
+	}
+	if previous == "" {
+		return nil, nil
+	}
+	return LoadManifest(ctx, store, host+"/"+previous)
+}
+
+// newSizeCheck compares dump sizes with those of the previous run, if it is
+// known and ratio is positive.
+func newSizeCheck(m *Manifest, ratio float64) *sizeCheck {
+	check := &sizeCheck{ratio: ratio, previous: map[string]int64{}, zstd: map[string]bool{}}
+	if ratio <= 0 || m == nil {
+		return check
+	}
+	for _, table := range m.Tables {
+		if table.Status == StatusSucceeded {
+			check.previous[table.Database+"."+table.Table] = table.CompressedBytes
+			check.zstd[table.Database+"."+table.Table] = strings.HasSuffix(table.Object, zstdObjectSuffix)
+		}
+	}
+	return check
+}
+
+// warning describes why the dump of result is suspiciously small, if it is.
+func (c *sizeCheck) warning(result TableResult, config TableConfig) string {
+	if config.MinCompressedBytes > 0 && result.CompressedBytes < config.MinCompressedBytes {
+		return fmt.Sprintf("compressed size %s is below the configured minimum of %s",
+			FormatBytes(result.CompressedBytes), FormatBytes(config.MinCompressedBytes))
+	}
+
+	key := result.Database + "." + result.Table
+	previous := c.previous[key]
+	if c.zstd[key] != strings.HasSuffix(result.Object, zstdObjectSuffix) {
+		previous = 0
+	}
+	if c.ratio > 0 && previous > 0 && float64(result.CompressedBytes) < c.ratio*float64(previous) {
+		return fmt.Sprintf("compressed size %s is below %.0f%% of the %s of the previous run",
+			FormatBytes(result.CompressedBytes), 100*c.ratio, FormatBytes(previous))
+	}
+	return ""
+}
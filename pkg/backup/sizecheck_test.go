@@ -0,0 +1,60 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestRunFlagsSuspiciouslySmallDumps(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	previous, err := json.Marshal(&Manifest{Path: "host/2000-01-01-00", Tables: []TableResult{
+		{Database: "shop", Table: "orders", Status: StatusSucceeded, CompressedBytes: 1 << 20},
+		{Database: "shop", Table: "users", Status: StatusSucceeded, CompressedBytes: 1},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Uploader{Store: store}).UploadObject(ctx, "host/2000-01-01-00/manifest.json", "application/json", previous); err != nil {
+		t.Fatal(err)
+	}
+
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders", "users"}},
+	}
+	dumper := &fakeDumper{dumps: map[string]string{
+		"shop.orders": "INSERT INTO `orders` VALUES (1);\n",
+		"shop.users":  "INSERT INTO `users` VALUES (1);\n",
+	}}
+
+	cfg := testConfig(store, planner, dumper)
+	cfg.MinSizeRatio = 0.5
+	m, err := Run(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	orders, _ := m.Table("shop", "orders")
+	users, _ := m.Table("shop", "users")
+	if orders.SizeWarning == "" || orders.Status != StatusSucceeded {
+		t.Errorf("orders = %+v, want a succeeded table with a size warning", orders)
+	}
+	if users.SizeWarning != "" {
+		t.Errorf("users size warning = %q, want none", users.SizeWarning)
+	}
+
+	cfg.FailSmallDumps = true
+	cfg.Tables = map[string]TableConfig{"shop.users": {MinCompressedBytes: 1 << 20}}
+	m, err = Run(ctx, cfg)
+	if !errors.Is(err, ErrPartialFailure) {
+		t.Fatalf("Run error = %v, want ErrPartialFailure", err)
+	}
+	if got := m.FailedTables(); got != 2 {
+		t.Errorf("FailedTables() = %d, want 2", got)
+	}
+	if got := m.smallTables(); len(got) != 2 {
+		t.Errorf("small tables = %v, want orders and users", got)
+	}
+}
@@ -0,0 +1,206 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// errSnapshotLost is returned by dumps that need a connection of a
+// consistent snapshot after one of them was lost, which cannot be replaced
+// as the snapshot cannot be taken again.
+var errSnapshotLost = errors.New("a connection of the consistent snapshot was lost")
+
+// Snapshot describes the consistent snapshot shared by the dumps of a run,
+// see Config.ConsistentSnapshot.
+type Snapshot struct {
+	Taken time.Time `json:"taken"`
+	// BinaryLog is the binary log position of the snapshot, if binary
+	// logging is enabled and the account may read it.
+	BinaryLog map[string]string `json:"binaryLog,omitempty"`
+	// InstanceLocked is set if LOCK INSTANCE FOR BACKUP kept DDL, which
+	// fails dumps of the altered tables from the snapshot, out of the run.
+	InstanceLocked bool `json:"instanceLocked"`
+	// Lock is the lock the snapshot was taken under: FLUSH TABLES WITH READ
+	// LOCK, or the backup locks of Percona Server.
+	Lock string `json:"lock"`
+}
+
+// snapshotLock holds back the commits of the server while the transactions
+// of a snapshot start.
+type snapshotLock struct {
+	name    string
+	acquire []string
+	release []string
+}
+
+var (
+	// globalReadLock blocks every write and waits for running queries,
+	// including long SELECTs, to finish before it is granted.
+	globalReadLock = snapshotLock{
+		name:    "FLUSH TABLES WITH READ LOCK",
+		acquire: []string{"FLUSH TABLES WITH READ LOCK"},
+		release: []string{"UNLOCK TABLES"},
+	}
+	// perconaBackupLocks block DDL, writes to non-transactional tables and
+	// commits, which need to advance the binary log, but neither InnoDB
+	// writes nor running queries.
+	perconaBackupLocks = snapshotLock{
+		name:    "LOCK BINLOG FOR BACKUP",
+		acquire: []string{"LOCK TABLES FOR BACKUP", "LOCK BINLOG FOR BACKUP"},
+		release: []string{"UNLOCK BINLOG", "UNLOCK TABLES"},
+	}
+)
+
+// chooseSnapshotLock returns the backup locks of Percona Server 5.6 and 5.7
+// if the server has them and binary logging is enabled, without which the
+// binary log lock does not block commits; otherwise the global read lock.
+// Percona Server 8.0 has no binary log lock.
+func chooseSnapshotLock(variables map[string]string) snapshotLock {
+	major, _, _ := strings.Cut(variables["version"], ".")
+	if strings.EqualFold(variables["have_backup_locks"], "YES") && strings.EqualFold(variables["log_bin"], "ON") && major == "5" {
+		return perconaBackupLocks
+	}
+	return globalReadLock
+}
+
+// snapshotPool holds connections whose transactions all started at the
+// same point in time, one per dump run at the same time. Tables are read
+// through them in the state of that point, however long the run takes.
+type snapshotPool struct {
+	conns   chan *sql.Conn
+	control *sql.Conn
+	info    Snapshot
+}
+
+// openSnapshot starts size transactions WITH CONSISTENT SNAPSHOT while a
+// snapshotLock holds back commits, which is released as soon as they and
+// the binary log position are taken. On servers supporting it, LOCK
+// INSTANCE FOR BACKUP is held until the pool is closed.
+func openSnapshot(ctx context.Context, db *sql.DB, size int) (*snapshotPool, error) {
+	variables, err := queryNameValues(db, "SHOW GLOBAL VARIABLES WHERE Variable_name IN ('version', 'log_bin', 'have_backup_locks')")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read global variables: %w", err)
+	}
+	lock := chooseSnapshotLock(variables)
+
+	control, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+	pool := &snapshotPool{conns: make(chan *sql.Conn, size), control: control, info: Snapshot{Lock: lock.name}}
+
+	if lock.name == globalReadLock.name {
+		if _, err := control.ExecContext(ctx, "LOCK INSTANCE FOR BACKUP"); err != nil {
+			log.Printf("Failed to lock the instance for backup, DDL during the run fails dumps of the altered tables: %v\n", err)
+		} else {
+			pool.info.InstanceLocked = true
+		}
+	}
+
+	// The lock blocks the commits of the server, so it is held only as long
+	// as it takes to start the transactions.
+	locked := time.Now()
+	err = pool.lock(ctx, lock.acquire)
+	if err == nil {
+		err = pool.start(ctx, db, size)
+	}
+	if unlockErr := pool.lock(context.Background(), lock.release); err == nil {
+		err = unlockErr
+	}
+	if err != nil {
+		pool.close()
+		return nil, err
+	}
+
+	log.Printf("Consistent snapshot taken for %d connections under %s, commits were blocked for %s\n", size, lock.name, time.Since(locked).Round(time.Millisecond))
+	return pool, nil
+}
+
+// lock runs the statements acquiring or releasing a snapshotLock on the
+// control connection.
+func (p *snapshotPool) lock(ctx context.Context, statements []string) error {
+	for _, statement := range statements {
+		if _, err := p.control.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("failed to run %s: %w", statement, err)
+		}
+	}
+	return nil
+}
+
+func (p *snapshotPool) start(ctx context.Context, db *sql.DB, size int) error {
+	for i := 0; i < size; i++ {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to connect to MySQL: %w", err)
+		}
+		p.conns <- conn
+		if _, err := conn.ExecContext(ctx, "SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ"); err != nil {
+			return fmt.Errorf("failed to set the isolation level: %w", err)
+		}
+		if _, err := conn.ExecContext(ctx, "START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY"); err != nil {
+			return fmt.Errorf("failed to start a consistent snapshot: %w", err)
+		}
+	}
+	p.info.Taken = time.Now()
+
+	// Writes are blocked, so the position of the binary log is that of the
+	// snapshot.
+	binaryLog, err := queryRows(db, "SHOW BINARY LOG STATUS", "SHOW MASTER STATUS")
+	if err != nil {
+		log.Printf("Failed to read binary log status, it is left out of the snapshot: %v\n", err)
+	} else if len(binaryLog) > 0 {
+		p.info.BinaryLog = binaryLog[0]
+	}
+	return nil
+}
+
+// acquire waits for a free connection of the snapshot.
+func (p *snapshotPool) acquire(ctx context.Context) (*sql.Conn, error) {
+	select {
+	case conn := <-p.conns:
+		if conn == nil {
+			p.conns <- nil
+			return nil, errSnapshotLost
+		}
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// release returns a connection to the pool once a dump is done with it.
+// A connection that no longer answers lost its transaction, so that the
+// dumps waiting for it fail.
+func (p *snapshotPool) release(conn *sql.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := conn.PingContext(ctx); err != nil {
+		log.Printf("Lost a connection of the consistent snapshot, the dumps waiting for it fail: %v\n", err)
+		conn.Close()
+		p.conns <- nil
+		return
+	}
+	p.conns <- conn
+}
+
+// close ends the transactions and releases the instance lock. Closed
+// connections return to the pool of their sql.DB, so the transactions and
+// the lock are ended explicitly first.
+func (p *snapshotPool) close() {
+	ctx := context.Background()
+	for len(p.conns) > 0 {
+		if conn := <-p.conns; conn != nil {
+			conn.ExecContext(ctx, "ROLLBACK")
+			conn.Close()
+		}
+	}
+	if p.info.InstanceLocked {
+		p.control.ExecContext(ctx, "UNLOCK INSTANCE")
+	}
+	p.control.Close()
+}
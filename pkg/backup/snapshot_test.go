@@ -0,0 +1,79 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestRunNeedsNativeDumperForConsistentSnapshot(t *testing.T) {
+	planner := &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"orders"}}}
+	dumper := &fakeDumper{}
+	cfg := testConfig(NewMemoryStore(), planner, dumper)
+	cfg.ConsistentSnapshot = true
+
+	if _, err := Run(context.Background(), cfg); err == nil {
+		t.Fatal("Run succeeded with a consistent snapshot and a dumper that cannot use it")
+	}
+	if len(dumper.dumped) != 0 {
+		t.Errorf("dumped %v without a snapshot", dumper.dumped)
+	}
+}
+
+func TestChooseSnapshotLock(t *testing.T) {
+	tests := []struct {
+		name      string
+		variables map[string]string
+		want      string
+	}{
+		{name: "MySQL", variables: map[string]string{"version": "8.0.36", "log_bin": "ON"}, want: globalReadLock.name},
+		{name: "Percona Server 5.7", variables: map[string]string{"version": "5.7.44-48", "log_bin": "ON", "have_backup_locks": "YES"}, want: perconaBackupLocks.name},
+		{name: "Percona Server 5.7 without binary log", variables: map[string]string{"version": "5.7.44-48", "log_bin": "OFF", "have_backup_locks": "YES"}, want: globalReadLock.name},
+		{name: "Percona Server 8.0", variables: map[string]string{"version": "8.0.36-28", "log_bin": "ON", "have_backup_locks": "YES"}, want: globalReadLock.name},
+	}
+
+	for _, test := range tests {
+		if got := chooseSnapshotLock(test.variables).name; got != test.want {
+			t.Errorf("%s: chooseSnapshotLock = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSnapshotPoolFailsDumpsAfterLosingConnection(t *testing.T) {
+	pool := &snapshotPool{conns: make(chan *sql.Conn, 2)}
+	pool.conns <- nil
+
+	for i := 0; i < 2; i++ {
+		if _, err := pool.acquire(context.Background()); !errors.Is(err, errSnapshotLost) {
+			t.Errorf("acquire %d = %v, want errSnapshotLost", i, err)
+		}
+	}
+
+	<-pool.conns
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pool.acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("acquire on an empty pool = %v, want context.Canceled", err)
+	}
+}
+
+func TestRunRecordsFailedSnapshot(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"orders", "customers"}}}
+	dumper := &NativeDumper{Connection: Connection{Host: "127.0.0.1", Port: "1", User: "backup"}}
+	defer dumper.Close()
+	cfg := testConfig(store, planner, dumper)
+	cfg.ConsistentSnapshot = true
+
+	m, err := Run(context.Background(), cfg)
+	if !errors.Is(err, ErrPartialFailure) {
+		t.Fatalf("Run() error = %v, want ErrPartialFailure", err)
+	}
+	if m == nil || m.FailedTables() != 2 {
+		t.Fatalf("Run() manifest = %+v, want both tables failed", m)
+	}
+	if got := readManifest(t, store, m.Path); got.FailedTables() != 2 {
+		t.Errorf("uploaded manifest has %d failed tables, want 2", got.FailedTables())
+	}
+}
@@ -0,0 +1,71 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// uploadStage decouples uploads from dumps: dumps are compressed into spool
+// files in dir at the speed of the server, which releases their mysqldump
+// process and connection, and at most workers spool files are uploaded at
+// a time. While less than minFree bytes are free in dir, dumps are streamed
+// to GCS instead, so that spooling cannot fill the disk.
+type uploadStage struct {
+	dir     string
+	minFree int64
+	slots   *semaphore.Weighted
+}
+
+func newUploadStage(dir string, workers int, minFree int64) *uploadStage {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return &uploadStage{dir: dir, minFree: minFree, slots: semaphore.NewWeighted(int64(workers))}
+}
+
+// create creates a spool file, which closeSpool removes. It returns no file
+// if the spool directory is short of free space.
+func (s *uploadStage) create() (*os.File, error) {
+	free, err := freeSpace(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check free space in %s: %w", s.dir, err)
+	}
+	if free < s.minFree {
+		log.Printf("Only %d MiB free in %s, streaming the dump instead of spooling it\n", free>>20, s.dir)
+		return nil, nil
+	}
+
+	file, err := os.CreateTemp(s.dir, "dump-*.sql.gz")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	return file, nil
+}
+
+// upload copies a spool file to w once an upload slot is free.
+func (s *uploadStage) upload(ctx context.Context, file *os.File, w io.Writer) error {
+	if err := s.slots.Acquire(ctx, 1); err != nil {
+		return err
+	}
+	defer s.slots.Release(1)
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind spool file: %w", err)
+	}
+	if _, err := io.CopyBuffer(w, file, make([]byte, chunkSize)); err != nil {
+		return fmt.Errorf("failed to upload spool file: %w", err)
+	}
+	return nil
+}
+
+func closeSpool(file *os.File) {
+	file.Close()
+	if err := os.Remove(file.Name()); err != nil {
+		log.Printf("Failed to remove spool file %s: %v\n", file.Name(), err)
+	}
+}
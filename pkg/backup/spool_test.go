@@ -0,0 +1,91 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// eofReader records when its reader returned io.EOF.
+type eofReader struct {
+	io.Reader
+	done atomic.Bool
+}
+
+func (r *eofReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		r.done.Store(true)
+	}
+	return n, err
+}
+
+// watchedStore records whether the dump was fully read before the first
+// write to an object.
+type watchedStore struct {
+	*MemoryStore
+	source *eofReader
+	early  atomic.Bool
+}
+
+func (s *watchedStore) NewWriter(ctx context.Context, name string, contentType string, metadata map[string]string) ObjectWriter {
+	return &watchedWriter{ObjectWriter: s.MemoryStore.NewWriter(ctx, name, contentType, metadata), store: s}
+}
+
+type watchedWriter struct {
+	ObjectWriter
+	store *watchedStore
+}
+
+func (w *watchedWriter) Write(p []byte) (int, error) {
+	if !w.store.source.done.Load() {
+		w.store.early.Store(true)
+	}
+	return w.ObjectWriter.Write(p)
+}
+
+func TestUploadSpoolsDumpBeforeUploading(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("INSERT INTO t VALUES (1);\n", 100000)
+	source := &eofReader{Reader: strings.NewReader(content)}
+	store := &watchedStore{MemoryStore: NewMemoryStore(), source: source}
+	uploader := &Uploader{Store: store, Progress: newProgress(time.Now()), stage: newUploadStage(dir, 1, 0)}
+
+	stats, err := uploader.Upload(context.Background(), "db/t.sql.gz", source)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if store.early.Load() {
+		t.Error("the object was written to before the dump was read to its end")
+	}
+	if got := readGzipObject(t, store.MemoryStore, "db/t.sql.gz"); got != content {
+		t.Error("uploaded content does not round-trip")
+	}
+	if data, _ := store.Data("db/t.sql.gz"); stats.CompressedBytes != int64(len(data)) || uploader.Progress.bytesUploaded.Load() != int64(len(data)) {
+		t.Errorf("CompressedBytes = %d and %d bytes uploaded, want %d", stats.CompressedBytes, uploader.Progress.bytesUploaded.Load(), len(data))
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("spool files left behind: %v", entries)
+	}
+}
+
+func TestUploadStreamsDumpWhenSpoolIsFull(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("INSERT INTO t VALUES (1);\n", 1000)
+	store := NewMemoryStore()
+	uploader := &Uploader{Store: store, Progress: newProgress(time.Now()), stage: newUploadStage(dir, 1, 1<<62)}
+
+	if _, err := uploader.Upload(context.Background(), "db/t.sql.gz", strings.NewReader(content)); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if got := readGzipObject(t, store, "db/t.sql.gz"); got != content {
+		t.Error("uploaded content does not round-trip")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("spool files created despite the lack of free space: %v", entries)
+	}
+}
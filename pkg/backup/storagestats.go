@@ -0,0 +1,185 @@
+package backup
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StorageStats counts the GCS API errors and retries of a run and the time
+// its uploads waited on GCS, so that a slow run can be told apart from a
+// flaky network path to GCS.
+type StorageStats struct {
+	// Errors are the failed API calls and attempts of calls, of which
+	// RateLimited were answered with 429 Too Many Requests and Unavailable
+	// with 503 Service Unavailable, and Retries are the attempts the client
+	// retried.
+	Errors      int64 `json:"errors"`
+	RateLimited int64 `json:"rateLimited"`
+	Unavailable int64 `json:"unavailable"`
+	Retries     int64 `json:"retries"`
+	// Uploads are the objects written, and UploadSeconds the time writing
+	// and closing them waited on GCS.
+	Uploads              int64   `json:"uploads"`
+	UploadSeconds        float64 `json:"uploadSeconds"`
+	AverageUploadSeconds float64 `json:"averageUploadSeconds"`
+}
+
+// since returns the stats counted after before.
+func (s StorageStats) since(before StorageStats) StorageStats {
+	s.Errors -= before.Errors
+	s.RateLimited -= before.RateLimited
+	s.Unavailable -= before.Unavailable
+	s.Retries -= before.Retries
+	s.Uploads -= before.Uploads
+	s.UploadSeconds -= before.UploadSeconds
+	s.AverageUploadSeconds = 0
+	if s.Uploads > 0 {
+		s.AverageUploadSeconds = s.UploadSeconds / float64(s.Uploads)
+	}
+	return s
+}
+
+func (s StorageStats) add(other StorageStats) StorageStats {
+	s.Errors += other.Errors
+	s.RateLimited += other.RateLimited
+	s.Unavailable += other.Unavailable
+	s.Retries += other.Retries
+	s.Uploads += other.Uploads
+	s.UploadSeconds += other.UploadSeconds
+	return s.since(StorageStats{})
+}
+
+func (s StorageStats) log() {
+	log.Printf("GCS API: %d errors (%d rate limited, %d unavailable), %d retries, %d uploads waiting %.2fs on average\n",
+		s.Errors, s.RateLimited, s.Unavailable, s.Retries, s.Uploads, s.AverageUploadSeconds)
+}
+
+// storageCounters collects the StorageStats of a GCSStore.
+type storageCounters struct {
+	errors      atomic.Int64
+	rateLimited atomic.Int64
+	unavailable atomic.Int64
+	retries     atomic.Int64
+	uploads     atomic.Int64
+	uploadNanos atomic.Int64
+}
+
+func (c *storageCounters) stats() StorageStats {
+	if c == nil {
+		return StorageStats{}
+	}
+	return StorageStats{
+		Errors:        c.errors.Load(),
+		RateLimited:   c.rateLimited.Load(),
+		Unavailable:   c.unavailable.Load(),
+		Retries:       c.retries.Load(),
+		Uploads:       c.uploads.Load(),
+		UploadSeconds: time.Duration(c.uploadNanos.Load()).Seconds(),
+	}.since(StorageStats{})
+}
+
+// failed counts an error of an API call, unless it is that the object does
+// not exist, which callers expect.
+func (c *storageCounters) failed(err error) {
+	if c == nil || err == nil || errors.Is(err, storage.ErrObjectNotExist) {
+		return
+	}
+	c.errors.Add(1)
+	switch statusCode(err) {
+	case http.StatusTooManyRequests:
+		c.rateLimited.Add(1)
+	case http.StatusServiceUnavailable:
+		c.unavailable.Add(1)
+	}
+}
+
+// uploaded counts an upload that waited on GCS for waited.
+func (c *storageCounters) uploaded(waited time.Duration, err error) {
+	if c == nil {
+		return
+	}
+	c.failed(err)
+	if err == nil {
+		c.uploads.Add(1)
+		c.uploadNanos.Add(int64(waited))
+	}
+}
+
+// shouldRetry is the storage.WithErrorFunc of a GCSStore, counting every
+// failed attempt of the calls the retry policy allows retrying, and is
+// otherwise storage.ShouldRetry. Calls it does not allow retrying, such as
+// writes and deletes without preconditions, are counted by the store.
+func (c *storageCounters) shouldRetry(err error) bool {
+	c.failed(err)
+	retry := storage.ShouldRetry(err)
+	if retry {
+		c.retries.Add(1)
+	}
+	return retry
+}
+
+// statusCode returns the HTTP status of a GCS API error, mapping the codes
+// of the gRPC transport to those of the JSON API.
+func statusCode(err error) int {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.ResourceExhausted:
+			return http.StatusTooManyRequests
+		case codes.Unavailable:
+			return http.StatusServiceUnavailable
+		}
+	}
+	return 0
+}
+
+// storeStats returns the StorageStats of the GCS stores behind store, if
+// there are any.
+func storeStats(store ObjectStore) (StorageStats, bool) {
+	switch s := store.(type) {
+	case *GCSStore:
+		return s.counters.stats(), true
+	case *MirrorStore:
+		return combinedStoreStats(s.Stores...)
+	case *FailoverStore:
+		return combinedStoreStats(s.Primary, s.Fallback)
+	}
+	return StorageStats{}, false
+}
+
+func combinedStoreStats(stores ...ObjectStore) (StorageStats, bool) {
+	var total StorageStats
+	found := false
+	for _, store := range stores {
+		if stats, ok := storeStats(store); ok {
+			total, found = total.add(stats), true
+		}
+	}
+	return total, found
+}
+
+// trackStorageStats returns a function returning the StorageStats of store
+// counted since trackStorageStats was called, or nil if store has no GCS
+// stores to count them.
+func trackStorageStats(store ObjectStore) func() *StorageStats {
+	before, ok := storeStats(store)
+	return func() *StorageStats {
+		if !ok {
+			return nil
+		}
+		after, _ := storeStats(store)
+		stats := after.since(before)
+		return &stats
+	}
+}
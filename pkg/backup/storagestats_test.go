@@ -0,0 +1,69 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// flakyServer fails the first attributes request with 503 and uploads of
+// objects named rate-limited with 429.
+func flakyServer(t *testing.T) *storage.Client {
+	var attrsRequests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && attrsRequests.Add(1) == 1:
+			http.Error(w, "backend unavailable", http.StatusServiceUnavailable)
+			return
+		case r.Method == http.MethodPost && strings.Contains(r.URL.RawQuery, "name=rate-limited"):
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"bucket": "backups", "name": "object", "size": "4"})
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(server.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestGCSStoreCountsErrorsAndRetries(t *testing.T) {
+	ctx := context.Background()
+	gcs := NewGCSStore(flakyServer(t).Bucket("backups"))
+	store := NewFailoverStore(NewMirrorStore(gcs, NewMemoryStore()), NewMemoryStore(), 3)
+	track := trackStorageStats(store)
+
+	if _, err := gcs.Attrs(ctx, "object"); err != nil {
+		t.Fatalf("Attrs() error = %v, want it retried", err)
+	}
+	for _, name := range []string{"object", "rate-limited"} {
+		writer := gcs.NewWriter(ctx, name, "", nil)
+		writer.Write([]byte("data"))
+		writer.Close()
+	}
+
+	got := *track()
+	want := StorageStats{Errors: 2, RateLimited: 1, Unavailable: 1, Retries: 1, Uploads: 1}
+	got.UploadSeconds, got.AverageUploadSeconds = 0, 0
+	if got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestTrackStorageStatsWithoutGCS(t *testing.T) {
+	if stats := trackStorageStats(NewMemoryStore())(); stats != nil {
+		t.Errorf("stats = %+v, want none without a GCS store", stats)
+	}
+}
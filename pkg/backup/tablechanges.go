@@ -0,0 +1,80 @@
+package backup
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+)
+
+// TableChanges lists the tables that appeared or disappeared since the
+// previous run, catching accidental drops and new schemas that were not
+// meant to be left out, see Config.SkipTableChangeCheck.
+type TableChanges struct {
+	// Previous is the path of the run compared with.
+	Previous string `json:"previous"`
+	// Added and Removed are "database.table" names.
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// compareTables compares the tables enumerated by a run with those of the
+// previous run, returning nil if they are the same. Tables of databases
+// whose enumeration failed, listed in unknown, and the tables in ignored do
+// not count as removed.
+func compareTables(previous *Manifest, current map[string]bool, unknown map[string]bool, ignored map[string]bool) *TableChanges {
+	changes := &TableChanges{Previous: previous.Path}
+	before := map[string]bool{}
+	for _, table := range previous.Tables {
+		key := table.Database + "." + table.Table
+		before[key] = true
+		if !current[key] && !unknown[table.Database] && !ignored[key] {
+			changes.Removed = append(changes.Removed, key)
+		}
+	}
+	for key := range current {
+		if !before[key] {
+			changes.Added = append(changes.Added, key)
+		}
+	}
+	if len(changes.Added) == 0 && len(changes.Removed) == 0 {
+		return nil
+	}
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	return changes
+}
+
+// env returns the hook environment describing the changes.
+func (c *TableChanges) env() map[string]string {
+	if c == nil {
+		return map[string]string{"BACKUP_TABLES_ADDED": "", "BACKUP_TABLES_REMOVED": ""}
+	}
+	return map[string]string{
+		"BACKUP_TABLES_ADDED":   strings.Join(c.Added, ","),
+		"BACKUP_TABLES_REMOVED": strings.Join(c.Removed, ","),
+	}
+}
+
+// reportTableChanges logs the changes and runs the tables-changed hook.
+func reportTableChanges(ctx context.Context, hooks Hooks, m *Manifest, changes *TableChanges) {
+	if len(changes.Added) > 0 {
+		log.Printf("%d table(s) appeared since the previous run %s: %s\n", len(changes.Added), changes.Previous, strings.Join(changes.Added, ", "))
+	}
+	if len(changes.Removed) > 0 {
+		log.Printf("%d table(s) disappeared since the previous run %s: %s\n", len(changes.Removed), changes.Previous, strings.Join(changes.Removed, ", "))
+	}
+
+	env := m.hookEnv("tables-changed")
+	for key, value := range changes.env() {
+		env[key] = value
+	}
+	env["BACKUP_PREVIOUS_PATH"] = changes.Previous
+	if hooks.TablesChanged != "" {
+		env["BACKUP_MESSAGE"] = renderMessage(hooks.MessageTemplate, m.summary("tables-changed", nil))
+	}
+	if err := runHook(ctx, "tables-changed", hooks.TablesChanged, env); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,79 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompareTables(t *testing.T) {
+	previous := &Manifest{Path: "host/2024-01-01-00", Tables: []TableResult{
+		{Database: "shop", Table: "orders"},
+		{Database: "shop", Table: "carts"},
+		{Database: "billing", Table: "invoices"},
+	}}
+
+	current := map[string]bool{"shop.orders": true, "shop.payments": true}
+	changes := compareTables(previous, current, map[string]bool{"billing": true}, nil)
+	want := &TableChanges{Previous: "host/2024-01-01-00", Added: []string{"shop.payments"}, Removed: []string{"shop.carts"}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("compareTables = %+v, want %+v", changes, want)
+	}
+
+	current = map[string]bool{"shop.orders": true, "shop.carts": true, "billing.invoices": true}
+	if changes := compareTables(previous, current, nil, nil); changes != nil {
+		t.Errorf("compareTables of the same tables = %+v, want nil", changes)
+	}
+
+	current = map[string]bool{"shop.orders": true, "billing.invoices": true}
+	if changes := compareTables(previous, current, nil, map[string]bool{"shop.carts": true}); changes != nil {
+		t.Errorf("compareTables with an ignored table = %+v, want nil", changes)
+	}
+}
+
+func TestRunReportsTableChanges(t *testing.T) {
+	store := NewMemoryStore()
+	previous, err := json.Marshal(&Manifest{Path: "host/2000-01-01-00", Tables: []TableResult{
+		{Database: "shop", Table: "orders", Status: StatusSucceeded},
+		{Database: "shop", Table: "carts", Status: StatusSucceeded},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Uploader{Store: store}).UploadObject(context.Background(), "host/2000-01-01-00/manifest.json", "application/json", previous); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(t.TempDir(), "hooks.log")
+	cfg := testConfig(store, &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders", "payments"}},
+	}, &fakeDumper{})
+	cfg.Hooks.TablesChanged = `echo "$BACKUP_STAGE $BACKUP_PREVIOUS_PATH +$BACKUP_TABLES_ADDED -$BACKUP_TABLES_REMOVED" >> ` + out
+
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	uploaded := readManifest(t, store, m.Path)
+	want := &TableChanges{Previous: "host/2000-01-01-00", Added: []string{"shop.payments"}, Removed: []string{"shop.carts"}}
+	if !reflect.DeepEqual(uploaded.TableChanges, want) {
+		t.Errorf("manifest table changes = %+v, want %+v", uploaded.TableChanges, want)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("tables-changed hook did not run: %v", err)
+	}
+	if got := string(data); got != "tables-changed host/2000-01-01-00 +shop.payments -shop.carts\n" {
+		t.Errorf("hook output = %q", got)
+	}
+
+	cfg.SkipTableChangeCheck = true
+	if m, err = Run(context.Background(), cfg); err != nil || m.TableChanges != nil {
+		t.Errorf("Run with SkipTableChangeCheck = %+v, %v, want no table changes", m.TableChanges, err)
+	}
+}
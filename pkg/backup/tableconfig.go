@@ -0,0 +1,154 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// TableConfig holds per-table settings. In Config.Tables it is keyed by a
+// "database.table" pattern as understood by path.Match; when several
+// patterns match, the longest one wins.
+type TableConfig struct {
+	// PreSQL statements run in the table's database before it is dumped;
+	// PostSQL statements run after a successful dump.
+	PreSQL  []string `json:"preSQL,omitempty"`
+	PostSQL []string `json:"postSQL,omitempty"`
+	// Priority is PriorityHigh, PriorityNormal or PriorityLow; empty means
+	// PriorityNormal.
+	Priority string `json:"priority,omitempty"`
+	// ExtendedInsert and HexBlob override Config.ExtendedInsert and
+	// Config.NoHexBlob for the table if set.
+	ExtendedInsert *bool `json:"extendedInsert,omitempty"`
+	HexBlob        *bool `json:"hexBlob,omitempty"`
+	// Checksum overrides Config.ChecksumTables for the table if set.
+	Checksum *bool `json:"checksum,omitempty"`
+	// MinCompressedBytes is the compressed size below which a dump of the
+	// table is flagged as suspiciously small.
+	MinCompressedBytes int64 `json:"minCompressedBytes,omitempty"`
+	// MysqldumpArgs are added to the mysqldump invocation of the table,
+	// overriding the options of the run, e.g. --where to dump a subset of
+	// the rows. See ValidateMysqldumpArgs.
+	MysqldumpArgs []string `json:"mysqldumpArgs,omitempty"`
+}
+
+// deniedMysqldumpArgs are options that would redirect the connection, the
+// output or the selection of tables of a dump, or drop the trailer by which
+// complete dumps are recognized.
+var deniedMysqldumpArgs = []string{
+	"--all-databases", "--databases", "--tables", "--ignore-table",
+	"--user", "--password", "--host", "--port", "--socket", "--protocol", "--login-path",
+	"--defaults-file", "--defaults-extra-file", "--defaults-group-suffix", "--no-defaults", "--print-defaults",
+	"--result-file", "--tab", "--xml",
+	"--compact", "--skip-comments", "--comments",
+}
+
+// ValidateMysqldumpArgs checks that mysqldump arguments of a TableConfig are
+// long options that leave where and what is dumped to the run.
+func ValidateMysqldumpArgs(args []string) error {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--") {
+			return fmt.Errorf("mysqldump argument %q is not a long option such as --where=...", arg)
+		}
+		name, _, _ := strings.Cut(arg, "=")
+		if contains(deniedMysqldumpArgs, name) {
+			return fmt.Errorf("mysqldump option %s cannot be set per table", name)
+		}
+	}
+	return nil
+}
+
+// filtersRows reports whether the mysqldump arguments dump only some of the
+// rows of the table, whose row count then does not match the dump.
+func (c TableConfig) filtersRows() bool {
+	for _, arg := range c.MysqldumpArgs {
+		if arg == "--where" || strings.HasPrefix(arg, "--where=") {
+			return true
+		}
+	}
+	return false
+}
+
+// Table priorities. High-priority tables are dumped before all others, and
+// low-priority tables after all others and only while the run is expected
+// to finish before Config.Deadline.
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// priorityRank orders priorities, highest first.
+func priorityRank(priority string) int {
+	switch priority {
+	case PriorityHigh:
+		return 0
+	case PriorityLow:
+		return 2
+	}
+	return 1
+}
+
+// FileConfig is the JSON configuration file accepted by the command.
+type FileConfig struct {
+	// SessionVariables are set on driver connections, see
+	// Connection.SessionVariables.
+	SessionVariables map[string]string      `json:"sessionVariables,omitempty"`
+	Tables           map[string]TableConfig `json:"tables,omitempty"`
+}
+
+// LoadFileConfig reads a JSON configuration file.
+func LoadFileConfig(name string) (*FileConfig, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var cfg FileConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", name, err)
+	}
+
+	if err := ValidateSessionVariables(cfg.SessionVariables); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", name, err)
+	}
+
+	for pattern := range cfg.Tables {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid table pattern %q in config file %s: %w", pattern, name, err)
+		}
+		if err := ValidateMysqldumpArgs(cfg.Tables[pattern].MysqldumpArgs); err != nil {
+			return nil, fmt.Errorf("invalid mysqldumpArgs of %q in config file %s: %w", pattern, name, err)
+		}
+		switch priority := cfg.Tables[pattern].Priority; priority {
+		case "", PriorityHigh, PriorityNormal, PriorityLow:
+		default:
+			return nil, fmt.Errorf("invalid priority %q of %q in config file %s: must be high, normal or low", priority, pattern, name)
+		}
+	}
+
+	return &cfg, nil
+}
+
+func tableConfig(tables map[string]TableConfig, database string, table string) TableConfig {
+	name := database + "." + table
+
+	best := ""
+	found := false
+	for pattern := range tables {
+		if ok, _ := path.Match(pattern, name); !ok {
+			continue
+		}
+		if !found || len(pattern) > len(best) || (len(pattern) == len(best) && pattern < best) {
+			best = pattern
+			found = true
+		}
+	}
+
+	if !found {
+		return TableConfig{}
+	}
+	return tables[best]
+}
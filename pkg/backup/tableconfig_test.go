@@ -0,0 +1,236 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTableConfigPrefersLongestPattern(t *testing.T) {
+	tables := map[string]TableConfig{
+		"*.*":         {PreSQL: []string{"any"}},
+		"shop.*":      {PreSQL: []string{"shop"}},
+		"shop.orders": {PreSQL: []string{"orders"}},
+	}
+
+	tests := []struct {
+		database, table string
+		want            string
+	}{
+		{"shop", "orders", "orders"},
+		{"shop", "users", "shop"},
+		{"crm", "leads", "any"},
+	}
+	for _, tt := range tests {
+		if got := tableConfig(tables, tt.database, tt.table).PreSQL[0]; got != tt.want {
+			t.Errorf("tableConfig(%s.%s) = %s, want %s", tt.database, tt.table, got, tt.want)
+		}
+	}
+
+	if got := tableConfig(map[string]TableConfig{"shop.*": {}}, "crm", "leads"); !reflect.DeepEqual(got, TableConfig{}) {
+		t.Errorf("tableConfig without match = %+v, want zero value", got)
+	}
+}
+
+func TestLoadFileConfig(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(name, []byte(`{"tables": {"shop.orders": {"preSQL": ["ANALYZE TABLE orders"]}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadFileConfig(name)
+	if err != nil {
+		t.Fatalf("LoadFileConfig failed: %v", err)
+	}
+	if got := cfg.Tables["shop.orders"].PreSQL; !reflect.DeepEqual(got, []string{"ANALYZE TABLE orders"}) {
+		t.Errorf("preSQL = %v", got)
+	}
+
+	if err := os.WriteFile(name, []byte(`{"tables": {"shop.[": {}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFileConfig(name); err == nil {
+		t.Errorf("LoadFileConfig accepted an invalid pattern")
+	}
+
+	if err := os.WriteFile(name, []byte(`{"tables": {"shop.*": {"priority": "urgent"}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFileConfig(name); err == nil {
+		t.Errorf("LoadFileConfig accepted an invalid priority")
+	}
+
+	if err := os.WriteFile(name, []byte(`{"tables": {"orders.events": {"mysqldumpArgs": ["--where=id>1000000", "--skip-triggers"]}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = LoadFileConfig(name)
+	if err != nil {
+		t.Fatalf("LoadFileConfig failed: %v", err)
+	}
+	if got := cfg.Tables["orders.events"].MysqldumpArgs; !reflect.DeepEqual(got, []string{"--where=id>1000000", "--skip-triggers"}) {
+		t.Errorf("mysqldumpArgs = %v", got)
+	}
+}
+
+func TestValidateMysqldumpArgs(t *testing.T) {
+	if err := ValidateMysqldumpArgs([]string{"--where=id > 10", "--skip-triggers", "--single-transaction"}); err != nil {
+		t.Errorf("ValidateMysqldumpArgs failed: %v", err)
+	}
+	for _, arg := range []string{"-w id > 10", "other_table", "--host=replica", "--result-file=/tmp/dump.sql", "--compact", "--databases"} {
+		if err := ValidateMysqldumpArgs([]string{arg}); err == nil {
+			t.Errorf("ValidateMysqldumpArgs accepted %q", arg)
+		}
+	}
+}
+
+func TestRunPassesMysqldumpArgs(t *testing.T) {
+	planner := &fakePlanner{
+		databases: []string{"orders"},
+		tables:    map[string][]string{"orders": {"events", "items"}},
+	}
+	dumper := &fakeDumper{}
+	cfg := testConfig(NewMemoryStore(), planner, dumper)
+	cfg.Tables = map[string]TableConfig{"orders.events": {MysqldumpArgs: []string{"--where=id>1000000"}}}
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if got := dumper.opts["orders.events"].MysqldumpArgs; !reflect.DeepEqual(got, []string{"--where=id>1000000"}) {
+		t.Errorf("orders.events dumped with mysqldump args %v", got)
+	}
+	if got := dumper.opts["orders.items"].MysqldumpArgs; got != nil {
+		t.Errorf("orders.items dumped with mysqldump args %v, want none", got)
+	}
+}
+
+func TestRunRejectsMysqldumpArgsForNativeDumper(t *testing.T) {
+	planner := &fakePlanner{
+		databases: []string{"orders"},
+		tables:    map[string][]string{"orders": {"events"}},
+	}
+	cfg := testConfig(NewMemoryStore(), planner, nil)
+	cfg.PureGo = true
+	cfg.Tables = map[string]TableConfig{"orders.events": {MysqldumpArgs: []string{"--where=id>1000000"}}}
+	if _, err := Run(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), `"orders.events"`) {
+		t.Errorf("Run() error = %v, want the mysqldumpArgs of orders.events rejected", err)
+	}
+}
+
+func TestRunDumpsTablesWithMysqldumpArgsWhole(t *testing.T) {
+	planner := &fakePlanner{
+		databases:  []string{"orders"},
+		tables:     map[string][]string{"orders": {"events"}},
+		partitions: map[string][]string{"orders.events": {"p2023", "p2024"}},
+	}
+	dumper := &fakeDumper{}
+	cfg := testConfig(NewMemoryStore(), planner, dumper)
+	cfg.SplitPartitions = true
+	cfg.PartitionDumper = &NativeDumper{}
+	cfg.Tables = map[string]TableConfig{"orders.events": {MysqldumpArgs: []string{"--where=id>1000000"}}}
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	result, _ := m.Table("orders", "events")
+	if len(result.Partitions) != 0 || dumper.opts["orders.events"].NoData {
+		t.Errorf("table with mysqldumpArgs was dumped per partition: %+v", result.Partitions)
+	}
+	if got := dumper.opts["orders.events"].MysqldumpArgs; !reflect.DeepEqual(got, []string{"--where=id>1000000"}) {
+		t.Errorf("orders.events dumped with mysqldump args %v", got)
+	}
+}
+
+func TestRunExecutesTableSQL(t *testing.T) {
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders", "users"}},
+	}
+	cfg := testConfig(NewMemoryStore(), planner, &fakeDumper{})
+	cfg.Tables = map[string]TableConfig{
+		"shop.orders": {PreSQL: []string{"ANALYZE TABLE orders"}, PostSQL: []string{"UPDATE bookkeeping SET done = 1"}},
+	}
+
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	want := []string{"shop: ANALYZE TABLE orders", "shop: UPDATE bookkeeping SET done = 1"}
+	if !reflect.DeepEqual(planner.executed, want) {
+		t.Errorf("executed = %v, want %v", planner.executed, want)
+	}
+}
+
+func TestRunSchedulesByPriority(t *testing.T) {
+	planner := &fakePlanner{
+		databases: []string{"a", "b"},
+		tables:    map[string][]string{"a": {"t1", "t2"}, "b": {"t1", "t2"}},
+		sizes:     map[string]DatabaseSize{"a": {Data: 100}, "b": {Data: 10}},
+	}
+	dumper := &fakeDumper{}
+
+	cfg := testConfig(NewMemoryStore(), planner, dumper)
+	cfg.Workers = 1
+	cfg.Tables = map[string]TableConfig{
+		"b.t2": {Priority: PriorityHigh},
+		"a.t1": {Priority: PriorityLow},
+	}
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if got, want := strings.Join(dumper.dumped, ","), "b.t2,a.t2,b.t1,a.t1"; got != want {
+		t.Errorf("dump order = %s, want %s", got, want)
+	}
+}
+
+func TestRunShedsLowPriorityTablesPastDeadline(t *testing.T) {
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders", "audit_log"}},
+	}
+	dumper := &fakeDumper{}
+
+	cfg := testConfig(NewMemoryStore(), planner, dumper)
+	cfg.Deadline = time.Now().Add(-time.Minute)
+	cfg.Tables = map[string]TableConfig{"shop.audit_*": {Priority: PriorityLow}}
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if got := strings.Join(dumper.dumped, ","); got != "shop.orders" {
+		t.Errorf("dumped %s, want only shop.orders", got)
+	}
+	if result, _ := m.Table("shop", "audit_log"); result.Status != StatusSkipped {
+		t.Errorf("audit_log status = %q, want %q", result.Status, StatusSkipped)
+	}
+}
+
+func TestRunAppliesTableDumpFormat(t *testing.T) {
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders", "images"}},
+	}
+	dumper := &fakeDumper{}
+
+	no, yes := false, true
+	cfg := testConfig(NewMemoryStore(), planner, dumper)
+	cfg.ExtendedInsert = true
+	cfg.Tables = map[string]TableConfig{"shop.images": {ExtendedInsert: &no, HexBlob: &yes}}
+	cfg.NoHexBlob = true
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if opts := dumper.opts["shop.orders"]; !opts.ExtendedInsert || !opts.NoHexBlob {
+		t.Errorf("orders dumped with %+v, want the run defaults", opts)
+	}
+	if opts := dumper.opts["shop.images"]; opts.ExtendedInsert || opts.NoHexBlob {
+		t.Errorf("images dumped with %+v, want the table settings", opts)
+	}
+}
@@ -0,0 +1,180 @@
+package backup
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errTarDelete is returned when deleting an object of a TarStore, whose
+// entries cannot be taken back once written.
+var errTarDelete = errors.New("objects written to a tar stream cannot be deleted")
+
+// TarStore writes every object as an entry of a tar stream, so that runs
+// can be piped into other tools instead of being uploaded. Objects are
+// spooled to temporary files in Dir, or the default directory for
+// temporary files if it is empty, as tar headers need their size, and are
+// appended in the order they are closed. Objects of the stream cannot be
+// read back or deleted; Attrs and List describe those written.
+type TarStore struct {
+	Dir string
+
+	mu      sync.Mutex
+	tar     *tar.Writer
+	objects map[string]ObjectAttrs
+	err     error
+}
+
+// NewTarStore returns a TarStore writing to w. Close must be called to end
+// the stream.
+func NewTarStore(w io.Writer) *TarStore {
+	return &TarStore{tar: tar.NewWriter(w), objects: map[string]ObjectAttrs{}}
+}
+
+// Close writes the end of the tar stream.
+func (s *TarStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return s.tar.Close()
+}
+
+func (s *TarStore) NewWriter(ctx context.Context, name string, contentType string, metadata map[string]string) ObjectWriter {
+	file, err := os.CreateTemp(s.Dir, "stream-*")
+	if err != nil {
+		err = fmt.Errorf("failed to spool %s: %w", name, err)
+	}
+	return &tarWriter{
+		ctx:   ctx,
+		store: s,
+		attrs: ObjectAttrs{Name: name, ContentType: contentType, Metadata: metadata},
+		file:  file,
+		err:   err,
+		crc:   crc32.New(crc32.MakeTable(crc32.Castagnoli)),
+		md5:   md5.New(),
+	}
+}
+
+func (s *TarStore) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("%w: %s", ErrObjectNotExist, name)
+}
+
+func (s *TarStore) Attrs(ctx context.Context, name string) (*ObjectAttrs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attrs, ok := s.objects[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotExist, name)
+	}
+	return &attrs, nil
+}
+
+func (s *TarStore) List(ctx context.Context, prefix string) ([]ObjectAttrs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var objects []ObjectAttrs
+	for name, attrs := range s.objects {
+		if strings.HasPrefix(name, prefix) {
+			objects = append(objects, attrs)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Name < objects[j].Name
+	})
+	return objects, nil
+}
+
+func (s *TarStore) Delete(ctx context.Context, name string) error {
+	return fmt.Errorf("%w: %s", errTarDelete, name)
+}
+
+// append writes the object spooled to file as the next entry of the tar.
+func (s *TarStore) append(attrs ObjectAttrs, file *os.File) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+
+	err := s.tar.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     attrs.Name,
+		Size:     attrs.Size,
+		Mode:     0o644,
+		ModTime:  attrs.Created,
+		Format:   tar.FormatPAX,
+	})
+	if err == nil {
+		_, err = io.Copy(s.tar, file)
+	}
+	if err == nil {
+		err = s.tar.Flush()
+	}
+	if err != nil {
+		// A partly written entry breaks the rest of the stream.
+		s.err = fmt.Errorf("failed to write %s to the tar stream: %w", attrs.Name, err)
+		return s.err
+	}
+
+	attrs.Generation = int64(len(s.objects) + 1)
+	s.objects[attrs.Name] = attrs
+	return nil
+}
+
+type tarWriter struct {
+	ctx   context.Context
+	store *TarStore
+	attrs ObjectAttrs
+	file  *os.File
+	err   error
+	crc   hash.Hash32
+	md5   hash.Hash
+}
+
+func (w *tarWriter) Write(b []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := w.file.Write(b)
+	w.crc.Write(b[:n])
+	w.md5.Write(b[:n])
+	w.attrs.Size += int64(n)
+	return n, err
+}
+
+func (w *tarWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	defer os.Remove(w.file.Name())
+	defer w.file.Close()
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+
+	w.attrs.Created = time.Now()
+	w.attrs.CRC32C = w.crc.Sum32()
+	w.attrs.MD5 = w.md5.Sum(nil)
+	return w.store.append(w.attrs, w.file)
+}
@@ -0,0 +1,82 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestRunToTarStore(t *testing.T) {
+	var out bytes.Buffer
+	store := NewTarStore(&out)
+	store.Dir = t.TempDir()
+	planner := &fakePlanner{
+		databases: []string{"shop"},
+		tables:    map[string][]string{"shop": {"orders"}},
+	}
+	dumper := &fakeDumper{dumps: map[string]string{"shop.orders": "INSERT INTO `orders` VALUES (1);\n"}}
+
+	m, err := Run(context.Background(), testConfig(store, planner, dumper))
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	entries := map[string][]byte{}
+	reader := tar.NewReader(&out)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read the tar stream: %v", err)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[header.Name] = data
+	}
+
+	if _, ok := entries[m.Path+"/manifest.json"]; !ok {
+		t.Errorf("tar has no manifest, entries: %d", len(entries))
+	}
+	dump, ok := entries[m.Tables[0].Object]
+	if !ok {
+		t.Fatalf("tar has no %s", m.Tables[0].Object)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(gz); string(data) != "INSERT INTO `orders` VALUES (1);\n" {
+		t.Errorf("dump = %q", data)
+	}
+}
+
+func TestTarStoreDiscardsCanceledObjects(t *testing.T) {
+	var out bytes.Buffer
+	store := NewTarStore(&out)
+	store.Dir = t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	writer := store.NewWriter(ctx, "host/a", "text/plain", nil)
+	writer.Write([]byte("partial"))
+	cancel()
+	if err := writer.Close(); err == nil {
+		t.Error("Close of a canceled object succeeded")
+	}
+	if _, err := store.Attrs(context.Background(), "host/a"); !errors.Is(err, ErrObjectNotExist) {
+		t.Errorf("Attrs of a canceled object = %v, want ErrObjectNotExist", err)
+	}
+	if err := store.Delete(context.Background(), "host/a"); err == nil {
+		t.Error("Delete succeeded")
+	}
+}
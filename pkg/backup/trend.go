@@ -0,0 +1,199 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultDropThreshold is the fraction by which the dumped size of a
+// database has to shrink from one run to the next to be reported as an
+// anomaly.
+const DefaultDropThreshold = 0.2
+
+// TrendConfig selects the runs a SizeTrend is computed from.
+type TrendConfig struct {
+	Store ObjectStore
+	Host  string
+	// Last is the number of latest generations with a manifest included.
+	Last int
+	// DropThreshold defaults to DefaultDropThreshold.
+	DropThreshold float64
+}
+
+// DatabaseTrend is the size history of a database over recent runs.
+type DatabaseTrend struct {
+	Database string       `json:"database"`
+	Points   []TrendPoint `json:"points"`
+
+	// GrowthBytes and GrowthRatio compare the uncompressed size of the
+	// first run with that of the last one.
+	GrowthBytes int64   `json:"growthBytes"`
+	GrowthRatio float64 `json:"growthRatio"`
+	// GrowthBytesPerDay is GrowthBytes spread over the time between them.
+	GrowthBytesPerDay float64 `json:"growthBytesPerDay"`
+
+	Anomalies []TrendAnomaly `json:"anomalies,omitempty"`
+}
+
+// TrendPoint is the size of a database in one run, summed over the tables
+// that were backed up successfully.
+type TrendPoint struct {
+	Generation        string    `json:"generation"`
+	Started           time.Time `json:"started"`
+	Tables            int       `json:"tables"`
+	FailedTables      int       `json:"failedTables,omitempty"`
+	UncompressedBytes int64     `json:"uncompressedBytes"`
+	CompressedBytes   int64     `json:"compressedBytes"`
+	Rows              int64     `json:"rows"`
+}
+
+// TrendAnomaly is a run in which a database shrank suddenly, was missing or
+// had failed tables, which may point at truncated dumps.
+type TrendAnomaly struct {
+	Generation string `json:"generation"`
+	Reason     string `json:"reason"`
+}
+
+// SizeTrend aggregates the manifests of the cfg.Last latest generations of
+// cfg.Host into the size history of each database, ordered by database.
+func SizeTrend(ctx context.Context, cfg TrendConfig) ([]DatabaseTrend, error) {
+	manifests, err := recentManifests(ctx, cfg.Store, cfg.Host, cfg.Last)
+	if err != nil {
+		return nil, err
+	}
+
+	threshold := cfg.DropThreshold
+	if threshold <= 0 {
+		threshold = DefaultDropThreshold
+	}
+
+	trends := map[string]*DatabaseTrend{}
+	for _, m := range manifests {
+		generation := strings.TrimPrefix(m.Path, cfg.Host+"/")
+		for _, table := range m.Tables {
+			trend, ok := trends[table.Database]
+			if !ok {
+				trend = &DatabaseTrend{Database: table.Database}
+				trends[table.Database] = trend
+			}
+			if n := len(trend.Points); n == 0 || trend.Points[n-1].Generation != generation {
+				trend.Points = append(trend.Points, TrendPoint{Generation: generation, Started: m.Started})
+			}
+
+			point := &trend.Points[len(trend.Points)-1]
+			switch table.Status {
+			case StatusSucceeded:
+				point.Tables++
+				point.UncompressedBytes += table.UncompressedBytes
+				point.CompressedBytes += table.CompressedBytes
+				point.Rows += table.Rows
+			case StatusFailed:
+				point.FailedTables++
+			}
+		}
+
+		// Databases backed up before but missing from this run.
+		for _, trend := range trends {
+			if trend.Points[len(trend.Points)-1].Generation != generation {
+				trend.addAnomaly(generation, "database missing from the run")
+			}
+		}
+	}
+
+	var result []DatabaseTrend
+	for _, trend := range trends {
+		trend.analyze(threshold)
+		result = append(result, *trend)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Database < result[j].Database
+	})
+	return result, nil
+}
+
+func (t *DatabaseTrend) analyze(threshold float64) {
+	for i, point := range t.Points {
+		if point.FailedTables > 0 {
+			t.addAnomaly(point.Generation, fmt.Sprintf("%d table(s) failed", point.FailedTables))
+		}
+		if i == 0 {
+			continue
+		}
+		previous := t.Points[i-1]
+		if previous.UncompressedBytes > 0 && float64(previous.UncompressedBytes-point.UncompressedBytes) > threshold*float64(previous.UncompressedBytes) {
+			t.addAnomaly(point.Generation, fmt.Sprintf("size dropped by %.0f%% from %s to %s",
+				100*float64(previous.UncompressedBytes-point.UncompressedBytes)/float64(previous.UncompressedBytes),
+				FormatBytes(previous.UncompressedBytes), FormatBytes(point.UncompressedBytes)))
+		}
+	}
+	sort.SliceStable(t.Anomalies, func(i, j int) bool {
+		return generationLess(t.Anomalies[i].Generation, t.Anomalies[j].Generation)
+	})
+
+	if len(t.Points) < 2 {
+		return
+	}
+	first, last := t.Points[0], t.Points[len(t.Points)-1]
+	t.GrowthBytes = last.UncompressedBytes - first.UncompressedBytes
+	if first.UncompressedBytes > 0 {
+		t.GrowthRatio = float64(t.GrowthBytes) / float64(first.UncompressedBytes)
+	}
+	if days := last.Started.Sub(first.Started).Hours() / 24; days > 0 {
+		t.GrowthBytesPerDay = float64(t.GrowthBytes) / days
+	}
+}
+
+func (t *DatabaseTrend) addAnomaly(generation string, reason string) {
+	t.Anomalies = append(t.Anomalies, TrendAnomaly{Generation: generation, Reason: reason})
+}
+
+// manifestGenerations returns the generations of a host with a manifest in
+// order.
+func manifestGenerations(ctx context.Context, store ObjectStore, host string) ([]string, error) {
+	prefix := host + "/"
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects under %s: %w", prefix, err)
+	}
+
+	var generations []string
+	for _, attrs := range objects {
+		generation, rest, ok := strings.Cut(strings.TrimPrefix(attrs.Name, prefix), "/")
+		if !ok || rest != "manifest.json" {
+			continue
+		}
+		if _, ok := parseGeneration(generation); ok {
+			generations = append(generations, generation)
+		}
+	}
+	sort.Slice(generations, func(i, j int) bool {
+		return generationLess(generations[i], generations[j])
+	})
+	return generations, nil
+}
+
+// recentManifests loads the manifests of the last latest generations of a
+// host, oldest first.
+func recentManifests(ctx context.Context, store ObjectStore, host string, last int) ([]*Manifest, error) {
+	generations, err := manifestGenerations(ctx, store, host)
+	if err != nil {
+		return nil, err
+	}
+	prefix := host + "/"
+	if last > 0 && len(generations) > last {
+		generations = generations[len(generations)-last:]
+	}
+
+	manifests := make([]*Manifest, 0, len(generations))
+	for _, generation := range generations {
+		m, err := LoadManifest(ctx, store, prefix+generation)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, m)
+	}
+	return manifests, nil
+}
@@ -0,0 +1,60 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSizeTrend(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	uploader := &Uploader{Store: store}
+	started := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	putManifest := func(generation string, day int, tables ...TableResult) {
+		m := &Manifest{Path: "db1/" + generation, Started: started.AddDate(0, 0, day), Tables: tables}
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := uploader.UploadObject(ctx, manifestName(m.Path), "application/json", data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	table := func(database string, size int64, status string) TableResult {
+		return TableResult{Database: database, Table: "t", Status: status, UncompressedBytes: size}
+	}
+
+	putManifest("2024-02-28-00", -2, table("shop", 10, StatusSucceeded))
+	putManifest("2024-03-01-00", 0, table("shop", 1000, StatusSucceeded), table("billing", 500, StatusSucceeded))
+	putManifest("2024-03-02-00", 1, table("shop", 1100, StatusSucceeded), table("billing", 100, StatusSucceeded))
+	putManifest("2024-03-03-00", 2, table("shop", 1200, StatusSucceeded), table("shop", 0, StatusFailed))
+	putGzipObject(t, store, "db1/notes/manifest.json", "{}")
+
+	trends, err := SizeTrend(ctx, TrendConfig{Store: store, Host: "db1", Last: 3})
+	if err != nil {
+		t.Fatalf("SizeTrend failed: %v", err)
+	}
+	if len(trends) != 2 || trends[0].Database != "billing" || trends[1].Database != "shop" {
+		t.Fatalf("trends = %+v, want billing and shop", trends)
+	}
+
+	billing, shop := trends[0], trends[1]
+	if len(shop.Points) != 3 || shop.GrowthBytes != 200 || shop.GrowthBytesPerDay != 100 {
+		t.Errorf("shop = %+v, want 3 runs growing by 200 bytes, 100 per day", shop)
+	}
+	if len(shop.Anomalies) != 1 || !strings.Contains(shop.Anomalies[0].Reason, "failed") {
+		t.Errorf("shop anomalies = %+v, want the failed table", shop.Anomalies)
+	}
+
+	var reasons []string
+	for _, anomaly := range billing.Anomalies {
+		reasons = append(reasons, anomaly.Generation+": "+anomaly.Reason)
+	}
+	if len(reasons) != 2 || !strings.HasPrefix(reasons[0], "2024-03-02-00: size dropped by 80%") || reasons[1] != "2024-03-03-00: database missing from the run" {
+		t.Errorf("billing anomalies = %q, want the size drop and the missing run", reasons)
+	}
+}
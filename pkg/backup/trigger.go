@@ -0,0 +1,96 @@
+package backup
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// Trigger identifies who or what started a run, for audit trails.
+type Trigger struct {
+	// User is the operating system user the run ran as.
+	User string `json:"user,omitempty"`
+	// ServiceAccount is the Kubernetes service account of the pod the run
+	// ran in, as <namespace>/<name>, and Pod the name of the pod.
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+	Pod            string `json:"pod,omitempty"`
+	// Caller is who requested the run, e.g. the API client or pipeline
+	// that invoked it, and Schedule the cron schedule that started it, as
+	// they are given to the run.
+	Caller   string `json:"caller,omitempty"`
+	Schedule string `json:"schedule,omitempty"`
+	// ConfigHash is the hex SHA-256 of the settings of the run, telling
+	// runs with different settings apart.
+	ConfigHash string `json:"configHash,omitempty"`
+}
+
+// DetectTrigger returns the user the process runs as and, in a Kubernetes
+// pod, its service account and name.
+func DetectTrigger() Trigger {
+	var trigger Trigger
+	if current, err := user.Current(); err == nil {
+		trigger.User = current.Username
+	}
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+		return trigger
+	}
+	if token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token")); err == nil {
+		trigger.ServiceAccount = tokenServiceAccount(string(token))
+	}
+	trigger.Pod, _ = os.Hostname()
+	return trigger
+}
+
+// tokenServiceAccount returns the service account a Kubernetes token was
+// issued to, from its system:serviceaccount:<namespace>:<name> subject. The
+// token is not verified; it is only read to describe the run.
+func tokenServiceAccount(token string) string {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	account, ok := strings.CutPrefix(claims.Subject, "system:serviceaccount:")
+	if !ok {
+		return ""
+	}
+	return strings.Replace(account, ":", "/", 1)
+}
+
+// recorded returns the trigger to record in a manifest, nil if nothing is
+// known about it.
+func (t Trigger) recorded() *Trigger {
+	if t == (Trigger{}) {
+		return nil
+	}
+	return &t
+}
+
+// labels returns the object metadata recording the trigger.
+func (t Trigger) labels() map[string]string {
+	labels := map[string]string{}
+	for key, value := range map[string]string{
+		triggerUserMetadataKey:           t.User,
+		triggerServiceAccountMetadataKey: t.ServiceAccount,
+		triggerCallerMetadataKey:         t.Caller,
+		triggerScheduleMetadataKey:       t.Schedule,
+		configHashMetadataKey:            t.ConfigHash,
+	} {
+		if value != "" {
+			labels[key] = value
+		}
+	}
+	return labels
+}
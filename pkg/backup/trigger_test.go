@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func TestTokenServiceAccount(t *testing.T) {
+	token := func(payload string) string {
+		return "header." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".signature"
+	}
+	for token, want := range map[string]string{
+		token(`{"sub":"system:serviceaccount:backups:mysql-backup"}`): "backups/mysql-backup",
+		token(`{"sub":"admin"}`): "",
+		"not-a-token":            "",
+	} {
+		if got := tokenServiceAccount(token); got != want {
+			t.Errorf("tokenServiceAccount(%q) = %q, want %q", token, got, want)
+		}
+	}
+}
+
+func TestRunRecordsTrigger(t *testing.T) {
+	store := NewMemoryStore()
+	planner := &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"orders"}}}
+	dumper := &fakeDumper{dumps: map[string]string{"shop.orders": ordersDump}}
+	cfg := testConfig(store, planner, dumper)
+	cfg.Trigger = Trigger{User: "backup", Caller: "ci", Schedule: "0 2 * * *", ConfigHash: "abc"}
+
+	m, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := readManifest(t, store, m.Path).Trigger; got == nil || *got != cfg.Trigger {
+		t.Errorf("manifest trigger = %+v, want %+v", got, cfg.Trigger)
+	}
+
+	attrs, err := store.Attrs(context.Background(), m.Tables[0].Object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		triggerUserMetadataKey:     "backup",
+		triggerCallerMetadataKey:   "ci",
+		triggerScheduleMetadataKey: "0 2 * * *",
+		configHashMetadataKey:      "abc",
+	}
+	got := map[string]string{}
+	for key := range want {
+		got[key] = attrs.Metadata[key]
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("metadata = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,429 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/sync/semaphore"
+)
+
+const (
+	chunkSize = 16 * 1024
+)
+
+// Uploader compresses streams and writes them to an ObjectStore.
+type Uploader struct {
+	Store    ObjectStore
+	Progress *Progress
+	// Metadata is added to the metadata of every object.
+	Metadata map[string]string
+
+	// CompositePartSize enables parallel composite uploads of dumps larger
+	// than it, in parts of that size uploaded CompositeParallelism at a
+	// time, for stores implementing Composer.
+	CompositePartSize    int
+	CompositeParallelism int
+
+	// MaxObjectSize, if set, rolls dumps over to continuation objects
+	// before their compressed size exceeds it, see rolloverWriter.
+	MaxObjectSize int64
+
+	// ChunkSize, if set, is the buffer size of GCS writers. Memory, if set,
+	// is the memory budget reserved by dumps before they start, streamBytes
+	// per dump; see budgetMemory.
+	ChunkSize   int
+	Memory      *semaphore.Weighted
+	streamBytes int64
+
+	// stage, if set, spools dumps to local files before uploading them,
+	// see Config.UploadWorkers.
+	stage *uploadStage
+
+	// dictionary, if set, compresses with zstd and it instead of gzip, and
+	// samples, if set, collects the start of each dump to train one.
+	dictionary *dictionary
+	samples    *dictionarySamples
+	// plain, if set, writes dumps uncompressed, for archives compressed as
+	// a whole.
+	plain bool
+}
+
+func (u *Uploader) metadata() map[string]string {
+	metadata := objectMetadata()
+	for key, value := range u.Metadata {
+		metadata[key] = value
+	}
+	return metadata
+}
+
+// withMetadata returns an Uploader sharing u's store and progress that adds
+// metadata on top of u's.
+func (u *Uploader) withMetadata(metadata map[string]string) *Uploader {
+	merged := map[string]string{}
+	for key, value := range u.Metadata {
+		merged[key] = value
+	}
+	for key, value := range metadata {
+		merged[key] = value
+	}
+	upload := *u
+	upload.Metadata = merged
+	return &upload
+}
+
+// newWriter returns a writer for a dump, uploading it in composed parts
+// when enabled.
+func (u *Uploader) newWriter(ctx context.Context, name string, metadata map[string]string) ObjectWriter {
+	composer, ok := u.Store.(Composer)
+	if !ok || u.CompositePartSize <= 0 {
+		return u.Store.NewWriter(ctx, name, "", metadata)
+	}
+
+	return newCompositeWriter(ctx, u.Store, composer, name, "", metadata, u.CompositePartSize, u.compositeParallelism())
+}
+
+func (u *Uploader) compositeParallelism() int {
+	if u.CompositeParallelism < 1 {
+		return 1
+	}
+	return u.CompositeParallelism
+}
+
+// UploadStats describes a completed upload.
+type UploadStats struct {
+	UncompressedBytes int64
+	CompressedBytes   int64
+	// Rows is the number of rows inserted by the INSERT statements in the
+	// stream.
+	Rows int64
+	// ReusedBytes is the compressed size of the chunks of a deduplicated
+	// dump that were already stored.
+	ReusedBytes int64
+	// Buckets are the buckets the object was written to, if known.
+	Buckets []string
+	// Parts are the continuation objects of a dump that was rolled over.
+	Parts []string
+	// CRC32C, MD5 and SHA256 are the checksums of the object, or of its
+	// first part if it was rolled over, computed while it was uploaded.
+	CRC32C uint32
+	MD5    []byte
+	SHA256 []byte
+	// Generation and Etag identify the version of the object that was
+	// written, in the first bucket if it was mirrored.
+	Generation int64
+	Etag       string
+	// PartGenerations are the generations of Parts, in order.
+	PartGenerations []int64
+}
+
+// Upload gzip-compresses reader into the named object, or compresses it
+// with zstd if the Uploader has a dictionary.
+func (u *Uploader) Upload(ctx context.Context, name string, reader io.Reader) (UploadStats, error) {
+	if strings.HasSuffix(name, dedupIndexSuffix) {
+		return u.uploadDeduplicated(ctx, name, reader)
+	}
+
+	var uncompressed, compressed atomic.Int64
+	var rows rowCounter
+	stats := func() UploadStats {
+		return UploadStats{UncompressedBytes: uncompressed.Load(), CompressedBytes: compressed.Load(), Rows: rows.rows}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if u.ChunkSize > 0 {
+		ctx = withChunkSize(ctx, u.ChunkSize)
+	}
+
+	metadata := u.metadata()
+	var composites []*compositeWriter
+	hashers := map[string]*hashingWriter{}
+	newWriter := func(name string) ObjectWriter {
+		writer := u.newWriter(ctx, name, metadata)
+		if composite, ok := writer.(*compositeWriter); ok {
+			composites = append(composites, composite)
+		}
+		hashers[name] = newHashingWriter(writer)
+		return hashers[name]
+	}
+
+	var writer ObjectWriter
+	var rollover *rolloverWriter
+	if u.MaxObjectSize > 0 {
+		rollover = newRolloverWriter(name, u.MaxObjectSize, newWriter)
+		writer = rollover
+	} else {
+		writer = newWriter(name)
+	}
+
+	succeeded := false
+	defer func() {
+		cancel()
+		for _, composite := range composites {
+			composite.cleanup()
+		}
+		if succeeded {
+			return
+		}
+		if rollover != nil {
+			u.discard(rollover.objects())
+		} else {
+			u.discard([]string{name})
+		}
+	}()
+
+	var compressedWriter io.Writer = &countingWriter{writer: writer, counts: []*atomic.Int64{&compressed, &u.Progress.bytesUploaded}}
+	var spool *os.File
+	if u.stage != nil {
+		var err error
+		if spool, err = u.stage.create(); err != nil {
+			return stats(), err
+		}
+	}
+	if spool != nil {
+		defer closeSpool(spool)
+		compressedWriter = &countingWriter{writer: spool, counts: []*atomic.Int64{&compressed}}
+	}
+
+	var compressor io.WriteCloser = nopWriteCloser{compressedWriter}
+	if !u.plain {
+		var err error
+		if compressor, err = newCompressor(compressedWriter, u.dictionary); err != nil {
+			return stats(), err
+		}
+	}
+	bufWriter := bufio.NewWriterSize(compressor, chunkSize)
+
+	source := io.TeeReader(&countingReader{reader: reader, counts: []*atomic.Int64{&uncompressed, &u.Progress.bytesRead}}, &rows)
+	var sample *sampleWriter
+	if u.samples != nil {
+		sample = &sampleWriter{max: dictionarySampleSize}
+		source = io.TeeReader(source, sample)
+	}
+	if _, err := io.Copy(bufWriter, source); err != nil {
+		return stats(), fmt.Errorf("failed to upload %s: %w", name, err)
+	}
+
+	if err := bufWriter.Flush(); err != nil {
+		return stats(), fmt.Errorf("failed to close bufWriter: %w", err)
+	}
+
+	if err := compressor.Close(); err != nil {
+		return stats(), fmt.Errorf("failed to close compressor: %w", err)
+	}
+
+	if spool != nil {
+		if err := u.stage.upload(ctx, spool, &countingWriter{writer: writer, counts: []*atomic.Int64{&u.Progress.bytesUploaded}}); err != nil {
+			return stats(), fmt.Errorf("failed to upload %s: %w", name, err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return stats(), fmt.Errorf("failed to close writer: %w", err)
+	}
+
+	hashes := hashers[name].sum()
+	attrs, err := u.verify(ctx, name, hashes)
+	if err != nil {
+		return stats(), err
+	}
+
+	result := stats()
+	result.CRC32C = hashes.CRC32C
+	result.MD5 = hashes.MD5
+	result.SHA256 = hashes.SHA256
+	result.Generation = attrs.Generation
+	result.Etag = attrs.Etag
+	result.Buckets = writerBuckets(writer)
+	if rollover != nil {
+		if err := deleteStaleParts(ctx, u.Store, name, rollover.parts); err != nil {
+			return result, err
+		}
+		result.Parts = rollover.parts
+		for _, part := range rollover.parts {
+			attrs, err := u.verify(ctx, part, hashers[part].sum())
+			if err != nil {
+				return result, err
+			}
+			result.PartGenerations = append(result.PartGenerations, attrs.Generation)
+		}
+	}
+	if sample != nil {
+		u.samples.add(sample.data)
+	}
+	succeeded = true
+	return result, nil
+}
+
+// verify checks a written object against the checksums computed while it
+// was uploaded and records them in its metadata, returning its attributes.
+// Failing to record them, e.g. for lack of storage.objects.update, is only
+// logged.
+func (u *Uploader) verify(ctx context.Context, name string, hashes objectHashes) (*ObjectAttrs, error) {
+	attrs, err := u.Store.Attrs(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve attributes for %s: %w", name, err)
+	}
+	if err := hashes.verify(attrs); err != nil {
+		return nil, err
+	}
+
+	updater, ok := u.Store.(MetadataUpdater)
+	if !ok {
+		return attrs, nil
+	}
+	updated, err := updater.UpdateMetadata(ctx, name, hashes.metadata())
+	if err != nil {
+		log.Printf("Failed to record the checksums of %s in its metadata: %v\n", name, err)
+		return u.Store.Attrs(ctx, name)
+	}
+	return updated, nil
+}
+
+// discard deletes the objects of a failed upload, so that listings and
+// restores never see a truncated dump. Objects of another run, such as
+// the dump an earlier run in the same hour wrote to the same name, are
+// kept. It runs on a fresh context as the upload's may be cancelled.
+func (u *Uploader) discard(names []string) {
+	discardObjects(u.Store, names, u.metadata()[runIDMetadataKey])
+}
+
+func discardObjects(store ObjectStore, names []string, runID string) {
+	if mirror, ok := store.(*MirrorStore); ok {
+		for _, store := range mirror.Stores {
+			discardObjects(store, names, runID)
+		}
+		return
+	}
+
+	ctx := context.Background()
+	for _, name := range names {
+		attrs, err := store.Attrs(ctx, name)
+		if errors.Is(err, ErrObjectNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to check %s of a failed upload: %v\n", name, err)
+			continue
+		}
+		if runID != "" && attrs.Metadata[runIDMetadataKey] != runID {
+			continue
+		}
+		if err := store.Delete(ctx, name); err != nil && !errors.Is(err, ErrObjectNotExist) {
+			log.Printf("Failed to delete %s of a failed upload: %v\n", name, err)
+		}
+	}
+}
+
+// UploadObject writes data as a single uncompressed object.
+func (u *Uploader) UploadObject(ctx context.Context, name string, contentType string, data []byte) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	writer := u.Store.NewWriter(ctx, name, contentType, u.metadata())
+
+	if _, err := writer.Write(data); err != nil {
+		return fmt.Errorf("failed to write object %s: %w", name, err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to close writer for object %s: %w", name, err)
+	}
+
+	return nil
+}
+
+var (
+	insertPrefix  = []byte("INSERT INTO ")
+	valuesKeyword = []byte("VALUES")
+)
+
+// rowCounter counts the rows of the INSERT statements of a SQL stream, one
+// per value tuple, so that extended inserts holding many rows are counted
+// correctly.
+type rowCounter struct {
+	rows int64
+	// matched is the length of the INSERT prefix matched at the start of
+	// the current line, or -1 once the line cannot start with it.
+	matched int
+	// insert is set for the rest of a line starting with an INSERT
+	// statement. depth is the parenthesis nesting in it, and quote the
+	// quote character of the string or identifier being read, if any.
+	insert  bool
+	depth   int
+	quote   byte
+	escaped bool
+	// values is the length of the VALUES keyword matched so far, so that
+	// the column list of a complete insert is not counted as a row.
+	values int
+}
+
+func (c *rowCounter) Write(p []byte) (int, error) {
+	for i := 0; i < len(p); i++ {
+		b := p[i]
+
+		switch {
+		case c.insert:
+			c.scanInsert(b)
+		case c.matched < 0:
+			newline := bytes.IndexByte(p[i:], '\n')
+			if newline < 0 {
+				return len(p), nil
+			}
+			i += newline
+			c.matched = 0
+		case b == '\n':
+			c.matched = 0
+		case b == insertPrefix[c.matched]:
+			c.matched++
+			if c.matched == len(insertPrefix) {
+				c.insert = true
+				c.matched = 0
+			}
+		default:
+			c.matched = -1
+		}
+	}
+
+	return len(p), nil
+}
+
+func (c *rowCounter) scanInsert(b byte) {
+	switch {
+	case c.escaped:
+		c.escaped = false
+	case c.quote != 0:
+		if b == '\\' && c.quote != '`' {
+			c.escaped = true
+		} else if b == c.quote {
+			c.quote = 0
+		}
+	case b == '\'' || b == '"' || b == '`':
+		c.quote = b
+	case b == '(':
+		if c.depth == 0 && c.values == len(valuesKeyword) {
+			c.rows++
+		}
+		c.depth++
+	case b == ')':
+		c.depth--
+	case b == '\n':
+		c.insert = false
+		c.depth = 0
+		c.values = 0
+	case c.depth == 0 && c.values < len(valuesKeyword):
+		if b == valuesKeyword[c.values] {
+			c.values++
+		} else {
+			c.values = 0
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadCompressesAndCounts(t *testing.T) {
+	store := NewMemoryStore()
+	uploader := &Uploader{Store: store, Progress: newProgress(time.Now())}
+	content := strings.Repeat("INSERT INTO t VALUES (1);\n", 1000)
+
+	stats, err := uploader.Upload(context.Background(), "db/t.sql.gz", strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	if got := readGzipObject(t, store, "db/t.sql.gz"); got != content {
+		t.Errorf("uploaded content does not round-trip")
+	}
+	if stats.UncompressedBytes != int64(len(content)) {
+		t.Errorf("UncompressedBytes = %d, want %d", stats.UncompressedBytes, len(content))
+	}
+	data, _ := store.Data("db/t.sql.gz")
+	if stats.CompressedBytes != int64(len(data)) {
+		t.Errorf("CompressedBytes = %d, want %d", stats.CompressedBytes, len(data))
+	}
+	if want := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)); stats.CRC32C != want {
+		t.Errorf("CRC32C = %08x, want %08x", stats.CRC32C, want)
+	}
+	attrs, err := store.Attrs(context.Background(), "db/t.sql.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Generation != attrs.Generation || stats.Etag != attrs.Etag || stats.Etag == "" {
+		t.Errorf("generation %d and etag %q, want %d and %q", stats.Generation, stats.Etag, attrs.Generation, attrs.Etag)
+	}
+	if uploader.Progress.bytesRead.Load() != stats.UncompressedBytes {
+		t.Errorf("progress bytesRead = %d, want %d", uploader.Progress.bytesRead.Load(), stats.UncompressedBytes)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errFake
+}
+
+func TestUploadDiscardsObjectOnReadError(t *testing.T) {
+	store := NewMemoryStore()
+	uploader := &Uploader{Store: store, Progress: newProgress(time.Now())}
+
+	if _, err := uploader.Upload(context.Background(), "db/t.sql.gz", failingReader{}); !errors.Is(err, errFake) {
+		t.Fatalf("Upload error = %v, want %v", err, errFake)
+	}
+
+	if _, ok := store.Data("db/t.sql.gz"); ok {
+		t.Errorf("incomplete object was stored")
+	}
+}
+
+// unclosableStore is a MemoryStore whose objects fail to close.
+type unclosableStore struct {
+	*MemoryStore
+}
+
+func (s unclosableStore) NewWriter(ctx context.Context, name string, contentType string, metadata map[string]string) ObjectWriter {
+	return unclosableWriter{}
+}
+
+type unclosableWriter struct{}
+
+func (unclosableWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (unclosableWriter) Close() error {
+	return errFake
+}
+
+func TestUploadDeletesMirrorCopiesOnFailure(t *testing.T) {
+	first := NewMemoryStore()
+	uploader := &Uploader{Store: NewMirrorStore(first, unclosableStore{NewMemoryStore()}), Progress: newProgress(time.Now())}
+
+	if _, err := uploader.Upload(context.Background(), "db/t.sql.gz", strings.NewReader("INSERT INTO t VALUES (1);\n")); !errors.Is(err, errFake) {
+		t.Fatalf("Upload error = %v, want %v", err, errFake)
+	}
+
+	if _, ok := first.Data("db/t.sql.gz"); ok {
+		t.Errorf("the copy of the failed upload was left in the first mirror")
+	}
+}
+
+func TestDiscardKeepsObjectsOfOtherRuns(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	earlier := &Uploader{Store: store, Metadata: map[string]string{runIDMetadataKey: "earlier"}}
+	current := &Uploader{Store: store, Metadata: map[string]string{runIDMetadataKey: "current"}}
+	if err := earlier.UploadObject(ctx, "a", "", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := current.UploadObject(ctx, "b", "", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+
+	current.discard([]string{"a", "b", "c"})
+
+	if _, ok := store.Data("a"); !ok {
+		t.Errorf("the object of the earlier run was deleted")
+	}
+	if _, ok := store.Data("b"); ok {
+		t.Errorf("the object of the failed upload was kept")
+	}
+}
+
+func TestRowCounterAcrossWrites(t *testing.T) {
+	dump := "-- INSERT INTO comment\nINSERT INTO `t` VALUES (1,'INSERT INTO `t`');\n" +
+		"INSERT INTO `t(1)` VALUES (2,'a),(b'),(3,'it\\'s (');\nUNLOCK TABLES;\nINSERT INTO `t` VALUES (4);\n" +
+		"INSERT INTO `t` (`id`,`VALUES`) VALUES (5,'x'),(6,'y');"
+
+	for size := 1; size <= len(dump); size++ {
+		var counter rowCounter
+		for i := 0; i < len(dump); i += size {
+			end := i + size
+			if end > len(dump) {
+				end = len(dump)
+			}
+			counter.Write([]byte(dump[i:end]))
+		}
+		if counter.rows != 6 {
+			t.Fatalf("rows with %d byte writes = %d, want 6", size, counter.rows)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrVerification is wrapped by the errors of restored tables whose content
+// does not match the backup.
+var ErrVerification = errors.New("restore verification failed")
+
+// verifyRestore compares the restored tables with the row counts recorded at
+// backup time and sets the error of every mismatching result. It only fails
+// itself if the manifest cannot be loaded.
+func verifyRestore(ctx context.Context, cfg *RestoreConfig, applier Applier, generation string, results []RestoreResult) error {
+	manifest, err := LoadManifest(ctx, cfg.Store, cfg.Host+"/"+generation)
+	if errors.Is(err, ErrObjectNotExist) {
+		// Releases before the manifest recorded no row counts.
+		log.Printf("Generation %s has no manifest, skipping verification\n", generation)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load manifest for verification: %w", err)
+	}
+
+	for i := range results {
+		result := &results[i]
+		if result.Err != nil {
+			continue
+		}
+
+		recorded, ok := manifest.Table(result.Database, result.Table)
+		if !ok || recorded.Status != StatusSucceeded {
+			log.Printf("No backup record for table \"%s.%s\", skipping verification\n", result.Database, result.Table)
+			continue
+		}
+
+		rows, err := applier.CountRows(ctx, result.TargetDatabase, result.TargetTable)
+		if err != nil {
+			result.Err = fmt.Errorf("%w: failed to count rows of %s.%s: %w", ErrVerification, result.TargetDatabase, result.TargetTable, err)
+			continue
+		}
+
+		result.ExpectedRows = recorded.Rows
+		result.Rows = rows
+		if rows != recorded.Rows {
+			result.Err = fmt.Errorf("%w: %s.%s has %d rows, the backup has %d", ErrVerification, result.TargetDatabase, result.TargetTable, rows, recorded.Rows)
+			log.Printf("Verification of table \"%s.%s\" failed: %v\n", result.TargetDatabase, result.TargetTable, result.Err)
+			continue
+		}
+
+		log.Printf("Verified table \"%s.%s\": %d rows\n", result.TargetDatabase, result.TargetTable, rows)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package backup
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information reported by VersionString and recorded in object
+// metadata. Binaries set these from their -ldflags; when left empty they are
+// derived from the embedded module build info.
+var (
+	Version = ""
+	Commit  = ""
+	Date    = ""
+)
+
+func buildVersion() (string, string, string) {
+	v, c, d := Version, Commit, Date
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "" && info.Main.Version != "" {
+			v = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if c == "" {
+					c = setting.Value
+				}
+			case "vcs.time":
+				if d == "" {
+					d = setting.Value
+				}
+			}
+		}
+	}
+
+	if v == "" {
+		v = "(devel)"
+	}
+	if c == "" {
+		c = "unknown"
+	}
+	if d == "" {
+		d = "unknown"
+	}
+
+	return v, c, d
+}
+
+// VersionString describes the tool version, commit and build date.
+func VersionString() string {
+	v, c, d := buildVersion()
+	return fmt.Sprintf("mysql-backup-tables-to-gcs %s (commit %s, built %s, %s)", v, c, d, runtime.Version())
+}
+
+// UserAgent is the user agent sent to GCS.
+func UserAgent() string {
+	v, _, _ := buildVersion()
+	return fmt.Sprintf("mysql-backup-tables-to-gcs/%s", v)
+}
+
+func objectMetadata() map[string]string {
+	v, c, _ := buildVersion()
+	return map[string]string{
+		"backup-tool-version": v,
+		"backup-tool-commit":  c,
+	}
+}
@@ -0,0 +1,274 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ThreadKiller finds and kills the server-side threads of running dumps. A
+// Planner implementing it enables Config.DumpTimeout and Config.KillOnCancel.
+type ThreadKiller interface {
+	// DumpThreads returns the IDs of the server threads running a query
+	// that dumps the table, or one of its partitions if partition is set.
+	DumpThreads(database string, table string, partition string) ([]int64, error)
+	KillQuery(id int64) error
+}
+
+// DumpThreads matches the processlist entries of the connection user against
+// the SELECT queries of mysqldump and NativeDumper.
+func (p *MySQLPlanner) DumpThreads(database string, table string, partition string) ([]int64, error) {
+	db, err := p.get(p.Connection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+
+	query := "%FROM %`" + escapeLike(table) + "`%"
+	if partition != "" {
+		query += "PARTITION (`" + escapeLike(partition) + "`)%"
+	}
+	qualified := "%`" + escapeLike(database) + "`.`" + escapeLike(table) + "`%"
+
+	rows, err := db.QueryContext(context.Background(),
+		"SELECT id FROM information_schema.processlist WHERE user = ? AND command = 'Query' AND id <> CONNECTION_ID() "+
+			"AND info LIKE ? AND (db = ? OR info LIKE ?)", p.Connection.User, query, database, qualified)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query MySQL: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to read query result: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read query result: %w", err)
+	}
+
+	return ids, nil
+}
+
+// KillQuery kills the statement a server thread is running.
+func (p *MySQLPlanner) KillQuery(id int64) error {
+	db, err := p.get(p.Connection)
+	if err != nil {
+		return fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+
+	if _, err := db.ExecContext(context.Background(), fmt.Sprintf("KILL QUERY %d", id)); err != nil {
+		return fmt.Errorf("failed to kill query of thread %d: %w", id, err)
+	}
+	return nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// watchdog tracks the running dumps of a run. Dumps running longer than
+// timeout are logged with their server threads and, if kill is set, have
+// their queries killed. With killOnCancel the queries of all running dumps
+// are killed once the run is cancelled, as cancelling a client does not stop
+// a query the server is running.
+type watchdog struct {
+	threads      ThreadKiller
+	timeout      time.Duration
+	kill         bool
+	killOnCancel bool
+
+	mu    sync.Mutex
+	next  int
+	dumps map[int]*runningDump
+}
+
+type runningDump struct {
+	database  string
+	table     string
+	partition string
+	started   time.Time
+	reported  bool
+}
+
+func (r *runningDump) String() string {
+	if r.partition != "" {
+		return fmt.Sprintf("partition %s of table \"%s.%s\"", r.partition, r.database, r.table)
+	}
+	return fmt.Sprintf("table \"%s.%s\"", r.database, r.table)
+}
+
+func newWatchdog(threads ThreadKiller, timeout time.Duration, kill bool, killOnCancel bool) *watchdog {
+	return &watchdog{threads: threads, timeout: timeout, kill: kill, killOnCancel: killOnCancel, dumps: map[int]*runningDump{}}
+}
+
+// wrap returns a Dumper registering the dumps of dumper with the watchdog.
+func (w *watchdog) wrap(dumper Dumper) Dumper {
+	if dumper == nil {
+		return nil
+	}
+	return &watchedDumper{Dumper: dumper, watchdog: w}
+}
+
+func (w *watchdog) add(database string, table string, partition string) (int, *runningDump) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.next++
+	dump := &runningDump{database: database, table: table, partition: partition, started: time.Now()}
+	w.dumps[w.next] = dump
+	return w.next, dump
+}
+
+func (w *watchdog) remove(id int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.dumps, id)
+}
+
+// running returns the dumps running for at least age.
+func (w *watchdog) running(now time.Time, age time.Duration) []*runningDump {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var dumps []*runningDump
+	for _, dump := range w.dumps {
+		if now.Sub(dump.started) >= age {
+			dumps = append(dumps, dump)
+		}
+	}
+	return dumps
+}
+
+// run checks the running dumps until stop is called, and kills them when ctx
+// is cancelled before.
+func (w *watchdog) run(ctx context.Context) (stop func()) {
+	interval := w.timeout / 4
+	if interval > 10*time.Second {
+		interval = 10 * time.Second
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		var tick <-chan time.Time
+		if w.timeout > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		for {
+			select {
+			case <-tick:
+				w.check(time.Now())
+			case <-ctx.Done():
+				if w.killOnCancel {
+					w.killAll()
+				}
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func (w *watchdog) check(now time.Time) {
+	for _, dump := range w.running(now, w.timeout) {
+		// Without killing, each dump is reported once; killing is retried
+		// while the dump keeps running.
+		if dump.reported && !w.kill {
+			continue
+		}
+		dump.reported = true
+
+		ids, err := w.threads.DumpThreads(dump.database, dump.table, dump.partition)
+		if err != nil {
+			log.Printf("Failed to find the server threads of the dump of %s: %v\n", dump, err)
+			continue
+		}
+		log.Printf("Dump of %s has been running for %s, server threads %v\n", dump, now.Sub(dump.started).Round(time.Second), ids)
+
+		if w.kill {
+			w.killThreads(dump, ids)
+		}
+	}
+}
+
+func (w *watchdog) killAll() {
+	for _, dump := range w.running(time.Now(), 0) {
+		w.killDump(dump)
+	}
+}
+
+func (w *watchdog) killDump(dump *runningDump) {
+	ids, err := w.threads.DumpThreads(dump.database, dump.table, dump.partition)
+	if err != nil {
+		log.Printf("Failed to find the server threads of the dump of %s: %v\n", dump, err)
+		return
+	}
+	w.killThreads(dump, ids)
+}
+
+func (w *watchdog) killThreads(dump *runningDump, ids []int64) {
+	for _, id := range ids {
+		if err := w.threads.KillQuery(id); err != nil {
+			log.Println(err)
+			continue
+		}
+		log.Printf("Killed the query of server thread %d dumping %s\n", id, dump)
+	}
+}
+
+// watchedDumper registers each dump with its watchdog until the dump is
+// closed. Dumps of database routines have no long-running query and are not
+// registered. A dump closed after its context was cancelled has its queries
+// killed first with killOnCancel, as the dump may have been aborted before
+// the watchdog noticed the cancellation.
+type watchedDumper struct {
+	Dumper
+	watchdog *watchdog
+}
+
+func (d *watchedDumper) Dump(ctx context.Context, database string, table string, opts DumpOptions) (io.ReadCloser, error) {
+	output, err := d.Dumper.Dump(ctx, database, table, opts)
+	if err != nil || table == "" {
+		return output, err
+	}
+
+	id, dump := d.watchdog.add(database, table, opts.Partition)
+	return &watchedDump{ReadCloser: output, ctx: ctx, watchdog: d.watchdog, id: id, dump: dump}, nil
+}
+
+type watchedDump struct {
+	io.ReadCloser
+	ctx      context.Context
+	watchdog *watchdog
+	id       int
+	dump     *runningDump
+}
+
+func (d *watchedDump) Close() error {
+	err := d.ReadCloser.Close()
+	if d.watchdog.killOnCancel && d.ctx.Err() != nil {
+		d.watchdog.killDump(d.dump)
+	}
+	d.watchdog.remove(d.id)
+	return err
+}
@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// killPlanner reports thread 42 for every dump and unblocks blockingDumper
+// dumps when it is killed.
+type killPlanner struct {
+	*fakePlanner
+	mu      sync.Mutex
+	killed  []int64
+	unblock chan struct{}
+}
+
+func (p *killPlanner) DumpThreads(database string, table string, partition string) ([]int64, error) {
+	return []int64{42}, nil
+}
+
+func (p *killPlanner) KillQuery(id int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.killed) == 0 {
+		close(p.unblock)
+	}
+	p.killed = append(p.killed, id)
+	return nil
+}
+
+// blockingDumper returns dumps that block until unblock is closed and then
+// fail like a killed query.
+type blockingDumper struct {
+	unblock chan struct{}
+}
+
+func (d *blockingDumper) Dump(ctx context.Context, database string, table string, opts DumpOptions) (io.ReadCloser, error) {
+	return io.NopCloser(&blockingReader{unblock: d.unblock}), nil
+}
+
+type blockingReader struct {
+	unblock chan struct{}
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	<-r.unblock
+	return 0, errors.New("query execution was interrupted")
+}
+
+func TestRunKillsLongDumps(t *testing.T) {
+	unblock := make(chan struct{})
+	planner := &killPlanner{
+		fakePlanner: &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"orders"}}},
+		unblock:     unblock,
+	}
+
+	cfg := testConfig(NewMemoryStore(), planner, &blockingDumper{unblock: unblock})
+	cfg.Planner = planner
+	cfg.DumpTimeout = 20 * time.Millisecond
+	cfg.KillLongDumps = true
+	m, err := Run(context.Background(), cfg)
+	if !errors.Is(err, ErrPartialFailure) {
+		t.Fatalf("Run error = %v, want %v", err, ErrPartialFailure)
+	}
+
+	planner.mu.Lock()
+	defer planner.mu.Unlock()
+	if len(planner.killed) == 0 || planner.killed[0] != 42 {
+		t.Errorf("killed threads %v, want 42", planner.killed)
+	}
+	if result, _ := m.Table("shop", "orders"); result.Status != StatusFailed {
+		t.Errorf("orders status = %q, want %q", result.Status, StatusFailed)
+	}
+}
+
+func TestRunKillsDumpsOnCancel(t *testing.T) {
+	unblock := make(chan struct{})
+	planner := &killPlanner{
+		fakePlanner: &fakePlanner{databases: []string{"shop"}, tables: map[string][]string{"shop": {"orders"}}},
+		unblock:     unblock,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	cfg := testConfig(NewMemoryStore(), planner, &blockingDumper{unblock: unblock})
+	cfg.Planner = planner
+	cfg.KillOnCancel = true
+	if _, err := Run(ctx, cfg); err == nil {
+		t.Fatal("cancelled Run succeeded")
+	}
+
+	planner.mu.Lock()
+	defer planner.mu.Unlock()
+	if len(planner.killed) == 0 || planner.killed[0] != 42 {
+		t.Errorf("killed threads %v, want 42", planner.killed)
+	}
+}
@@ -0,0 +1,107 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Window is a daily maintenance window in local time, such as 22:00-06:00.
+// A window whose end is before its start spans midnight.
+type Window struct {
+	// Start and End are offsets from midnight.
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseWindow parses a window given as HH:MM-HH:MM.
+func ParseWindow(s string) (Window, error) {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("invalid window %q: must be HH:MM-HH:MM", s)
+	}
+
+	var w Window
+	var err error
+	if w.Start, err = parseTimeOfDay(start); err != nil {
+		return Window{}, fmt.Errorf("invalid window %q: %w", s, err)
+	}
+	if w.End, err = parseTimeOfDay(end); err != nil {
+		return Window{}, fmt.Errorf("invalid window %q: %w", s, err)
+	}
+	if w.Start == w.End {
+		return Window{}, fmt.Errorf("invalid window %q: start and end are the same", s)
+	}
+
+	return w, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (w Window) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", int(w.Start.Hours()), int(w.Start.Minutes())%60, int(w.End.Hours()), int(w.End.Minutes())%60)
+}
+
+// Contains reports whether the window is open at t.
+func (w Window) Contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// Opens returns when the window next opens, or t if it is open at t.
+func (w Window) Opens(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+	return nextTimeOfDay(t, w.Start)
+}
+
+// Closes returns when the window open at t closes.
+func (w Window) Closes(t time.Time) time.Time {
+	return nextTimeOfDay(t, w.End)
+}
+
+// nextTimeOfDay returns the first time after t at offset from midnight.
+func nextTimeOfDay(t time.Time, offset time.Duration) time.Time {
+	at := func(day int) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day()+day, int(offset/time.Hour), int(offset%time.Hour/time.Minute), 0, 0, t.Location())
+	}
+	if next := at(0); next.After(t) {
+		return next
+	}
+	return at(1)
+}
+
+// waitForWindow blocks until the window is open or ctx is done.
+func waitForWindow(ctx context.Context, window Window) error {
+	now := time.Now()
+	opens := window.Opens(now)
+	if !opens.After(now) {
+		return nil
+	}
+
+	log.Printf("Waiting for the maintenance window %s, which opens at %s\n", window, opens.Format(time.RFC3339))
+
+	timer := time.NewTimer(opens.Sub(now))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
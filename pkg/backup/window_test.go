@@ -0,0 +1,75 @@
+package backup
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	w, err := ParseWindow("22:00-06:30")
+	if err != nil {
+		t.Fatalf("ParseWindow failed: %v", err)
+	}
+	if w.Start != 22*time.Hour || w.End != 6*time.Hour+30*time.Minute {
+		t.Errorf("ParseWindow = %+v", w)
+	}
+	if got := w.String(); got != "22:00-06:30" {
+		t.Errorf("String() = %s", got)
+	}
+
+	for _, s := range []string{"", "22:00", "22:00-25:00", "03:00-03:00"} {
+		if _, err := ParseWindow(s); err == nil {
+			t.Errorf("ParseWindow(%q) succeeded", s)
+		}
+	}
+}
+
+func TestWindow(t *testing.T) {
+	overnight := Window{Start: 22 * time.Hour, End: 6 * time.Hour}
+	daytime := Window{Start: 9 * time.Hour, End: 17 * time.Hour}
+	day := func(hour int, minute int) time.Time {
+		return time.Date(2024, 3, 1, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		window Window
+		at     time.Time
+		open   bool
+		opens  time.Time
+		closes time.Time
+	}{
+		{overnight, day(23, 0), true, day(23, 0), day(30, 0)},
+		{overnight, day(5, 59), true, day(5, 59), day(6, 0)},
+		{overnight, day(6, 0), false, day(22, 0), day(30, 0)},
+		{daytime, day(8, 0), false, day(9, 0), day(17, 0)},
+		{daytime, day(17, 0), false, day(33, 0), day(41, 0)},
+		{daytime, day(12, 0), true, day(12, 0), day(17, 0)},
+	}
+	for _, tt := range tests {
+		if got := tt.window.Contains(tt.at); got != tt.open {
+			t.Errorf("%s Contains(%s) = %v, want %v", tt.window, tt.at.Format(time.Kitchen), got, tt.open)
+		}
+		if got := tt.window.Opens(tt.at); !got.Equal(tt.opens) {
+			t.Errorf("%s Opens(%s) = %s, want %s", tt.window, tt.at.Format(time.Kitchen), got, tt.opens)
+		}
+		if got := tt.window.Closes(tt.at); !got.Equal(tt.closes) {
+			t.Errorf("%s Closes(%s) = %s, want %s", tt.window, tt.at.Format(time.Kitchen), got, tt.closes)
+		}
+	}
+}
+
+func TestRunWaitsForWindow(t *testing.T) {
+	now := time.Now()
+	offset := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute()+2)*time.Minute
+	closed := Window{Start: offset % (24 * time.Hour), End: (offset + time.Hour) % (24 * time.Hour)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	cfg := testConfig(NewMemoryStore(), &fakePlanner{databases: []string{"shop"}}, &fakeDumper{})
+	cfg.Window = &closed
+	if _, err := Run(ctx, cfg); err == nil {
+		t.Error("Run started outside the maintenance window")
+	}
+}